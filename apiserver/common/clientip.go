@@ -0,0 +1,95 @@
+package common
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TrustedProxies (TRUSTED_PROXIES, comma-separated IPs or CIDRs) are the
+// proxies in front of the API server. Only the X-Forwarded-For hops they add
+// are trusted, since clients can send the header with anything they like.
+var TrustedProxies = envNetworks("TRUSTED_PROXIES")
+
+// ClientIP returns the IP address of the client that made the request. It is
+// the address the request came from, unless that is a trusted proxy, in which
+// case it is the last X-Forwarded-For hop that was not added by one.
+func ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, h := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+
+	// Proxies append the address they received the request from, so the hops
+	// are walked from the nearest one back to the first untrusted address.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range TrustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func envNetworks(key string) []*net.IPNet {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+
+	var networks []*net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Warnf("Ignoring %q in %s, not a valid IP address or CIDR!", s, key)
+			continue
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
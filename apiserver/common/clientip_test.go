@@ -0,0 +1,65 @@
+package common_test
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClientIP", func() {
+	var (
+		req *http.Request
+
+		origTrustedProxies []*net.IPNet
+	)
+
+	BeforeEach(func() {
+		origTrustedProxies = common.TrustedProxies
+
+		var err error
+		req, err = http.NewRequest("GET", "http://example.com/ping", nil)
+		Expect(err).To(BeNil())
+		req.RemoteAddr = "10.0.0.2:51234"
+	})
+
+	AfterEach(func() {
+		common.TrustedProxies = origTrustedProxies
+	})
+
+	Context("when the request does not come from a trusted proxy", func() {
+		BeforeEach(func() {
+			common.TrustedProxies = nil
+		})
+
+		It("returns the address the request came from, ignoring X-Forwarded-For", func() {
+			req.Header.Set("X-Forwarded-For", "203.0.113.9")
+			Expect(common.ClientIP(req)).To(Equal("10.0.0.2"))
+		})
+	})
+
+	Context("when the request comes from a trusted proxy", func() {
+		BeforeEach(func() {
+			_, n, err := net.ParseCIDR("10.0.0.0/24")
+			Expect(err).To(BeNil())
+			common.TrustedProxies = []*net.IPNet{n}
+		})
+
+		It("returns the last hop that was not added by a trusted proxy", func() {
+			req.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.9, 10.0.0.1")
+			Expect(common.ClientIP(req)).To(Equal("203.0.113.9"))
+		})
+
+		It("returns the address of the proxy if there is no X-Forwarded-For", func() {
+			Expect(common.ClientIP(req)).To(Equal("10.0.0.2"))
+		})
+
+		It("stops at hops that are not IP addresses", func() {
+			req.Header.Set("X-Forwarded-For", "203.0.113.9, not-an-ip")
+			Expect(common.ClientIP(req)).To(Equal("10.0.0.2"))
+		})
+	})
+})
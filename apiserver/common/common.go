@@ -3,8 +3,12 @@ package common
 import (
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
 var (
@@ -17,11 +21,85 @@ var (
 	WebhookHost    = os.Getenv("WEBHOOK_HOST")
 )
 
+var (
+	RateLimit       = 0           // RATE_LIMIT - max # of requests per client in a window, 0 for no limit
+	RateLimitWindow = time.Minute // length of the window the rate limit applies to
+)
+
+// MultipartMemoryLimit (MULTIPART_MEMORY_LIMIT) is the max # of bytes of an
+// uploaded payload held in memory, larger payloads are written to temp files.
+var MultipartMemoryLimit = int64(10 * 1024 * 1024) // 10 MiB
+
+// BundleUploadTimeout (BUNDLE_UPLOAD_TIMEOUT, e.g. "5m") is how long uploading
+// the bundle of a new deployment to S3 may take.
+var BundleUploadTimeout = 10 * time.Minute
+
+// BundleUploadConcurrency (BUNDLE_UPLOAD_CONCURRENCY) is how many parts of
+// the bundle of a new deployment are uploaded to S3 at a time.
+var BundleUploadConcurrency = 5
+
+// AllowedBundleContentTypes (ALLOWED_BUNDLE_CONTENT_TYPES, comma-separated)
+// are the content types the payload of a new deployment may be declared as.
+var AllowedBundleContentTypes = []string{
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-tar",
+	"application/zip",
+	"application/x-zip-compressed",
+	"application/octet-stream",
+}
+
 func init() {
 	if MailerEmail == "" {
 		MailerEmail = "PubStorm <support@pubstorm.com>"
 	}
 
+	if rateLimitEnv := os.Getenv("RATE_LIMIT"); rateLimitEnv != "" {
+		n, err := strconv.Atoi(rateLimitEnv)
+		if err != nil {
+			log.Warn("Ignoring RATE_LIMIT, not a valid numeric value!")
+		} else {
+			RateLimit = n
+		}
+	}
+
+	if memLimitEnv := os.Getenv("MULTIPART_MEMORY_LIMIT"); memLimitEnv != "" {
+		n, err := strconv.ParseInt(memLimitEnv, 10, 64)
+		if err != nil {
+			log.Warn("Ignoring MULTIPART_MEMORY_LIMIT, not a valid numeric value!")
+		} else {
+			MultipartMemoryLimit = n
+		}
+	}
+
+	if timeoutEnv := os.Getenv("BUNDLE_UPLOAD_TIMEOUT"); timeoutEnv != "" {
+		d, err := time.ParseDuration(timeoutEnv)
+		if err != nil || d <= 0 {
+			log.Warn("Ignoring BUNDLE_UPLOAD_TIMEOUT, not a valid duration!")
+		} else {
+			BundleUploadTimeout = d
+		}
+	}
+
+	if concurrencyEnv := os.Getenv("BUNDLE_UPLOAD_CONCURRENCY"); concurrencyEnv != "" {
+		n, err := strconv.Atoi(concurrencyEnv)
+		if err != nil || n <= 0 {
+			log.Warn("Ignoring BUNDLE_UPLOAD_CONCURRENCY, not a valid positive number!")
+		} else {
+			BundleUploadConcurrency = n
+		}
+	}
+
+	if contentTypesEnv := os.Getenv("ALLOWED_BUNDLE_CONTENT_TYPES"); contentTypesEnv != "" {
+		var contentTypes []string
+		for _, contentType := range strings.Split(contentTypesEnv, ",") {
+			if contentType = strings.TrimSpace(contentType); contentType != "" {
+				contentTypes = append(contentTypes, strings.ToLower(contentType))
+			}
+		}
+		AllowedBundleContentTypes = contentTypes
+	}
+
 	riseEnv := os.Getenv("RISE_ENV")
 	if riseEnv == "" {
 		riseEnv = "development"
@@ -47,7 +125,7 @@ func init() {
 	}
 
 	if riseEnv != "test" {
-		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+		if s3client.Backend == "s3" && (os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "") {
 			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
 		}
 
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Limits of the outbound HTTP requests made with HTTPClient.
+var (
+	HTTPConnectTimeout  = envDuration("HTTP_CONNECT_TIMEOUT", 5*time.Second) // HTTP_CONNECT_TIMEOUT - e.g. "5s"
+	HTTPTimeout         = envDuration("HTTP_TIMEOUT", 30*time.Second)        // HTTP_TIMEOUT - total time of a request, including reading the response body
+	HTTPMaxResponseSize = envInt64("HTTP_MAX_RESPONSE_SIZE", 10*1024*1024)   // HTTP_MAX_RESPONSE_SIZE - in bytes
+)
+
+// ErrResponseTooLarge is returned when reading the body of a response that is
+// larger than HTTPMaxResponseSize.
+var ErrResponseTooLarge = errors.New("response body is too large")
+
+// httpTransport is shared by the clients returned by HTTPClient, so that
+// connections are pooled between them.
+var httpTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := &net.Dialer{Timeout: HTTPConnectTimeout, KeepAlive: 30 * time.Second}
+		return d.DialContext(ctx, network, addr)
+	},
+	TLSHandshakeTimeout: 10 * time.Second,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 10,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// HTTPClient returns a client for requests to servers outside of PubStorm,
+// e.g. publish gates and GitHub. Requests time out after HTTPTimeout unless
+// the Timeout of the client is changed, and response bodies larger than
+// HTTPMaxResponseSize fail to be read with ErrResponseTooLarge.
+func HTTPClient() *http.Client {
+	return NewHTTPClient(HTTPTimeout, HTTPMaxResponseSize)
+}
+
+// NewHTTPClient returns a client like HTTPClient with its own limits, for
+// requests that are expected to take longer or return more, e.g. downloads.
+func NewHTTPClient(timeout time.Duration, maxResponseSize int64) *http.Client {
+	return &http.Client{
+		Transport: &limitedTransport{base: httpTransport, maxSize: maxResponseSize},
+		Timeout:   timeout,
+	}
+}
+
+type limitedTransport struct {
+	base    http.RoundTripper
+	maxSize int64
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ContentLength > t.maxSize {
+		resp.Body.Close()
+		return nil, ErrResponseTooLarge
+	}
+
+	resp.Body = &limitedBody{rc: resp.Body, remaining: t.maxSize}
+	return resp, nil
+}
+
+// limitedBody fails reads once more than the remaining number of bytes have
+// been read from it.
+type limitedBody struct {
+	rc        io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+
+	// Read one byte more than remaining to tell whether the body is over the
+	// limit, rather than exactly at it.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.rc.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.rc.Close()
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Warnf("Ignoring %s, not a valid duration!", key)
+		return def
+	}
+	return d
+}
+
+func envInt64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		log.Warnf("Ignoring %s, not a valid number!", key)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,96 @@
+package common_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "common")
+}
+
+var _ = Describe("HTTPClient", func() {
+	var (
+		srv     *httptest.Server
+		delay   time.Duration
+		content string
+
+		origTimeout         time.Duration
+		origMaxResponseSize int64
+	)
+
+	BeforeEach(func() {
+		delay = 0
+		content = "ok"
+
+		origTimeout = common.HTTPTimeout
+		origMaxResponseSize = common.HTTPMaxResponseSize
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			w.Write([]byte(content))
+		}))
+	})
+
+	AfterEach(func() {
+		srv.Close()
+		common.HTTPTimeout = origTimeout
+		common.HTTPMaxResponseSize = origMaxResponseSize
+	})
+
+	It("makes requests", func() {
+		resp, err := common.HTTPClient().Get(srv.URL)
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("ok"))
+	})
+
+	It("times out requests to slow servers instead of waiting for them", func() {
+		common.HTTPTimeout = 50 * time.Millisecond
+		delay = 500 * time.Millisecond
+
+		start := time.Now()
+		_, err := common.HTTPClient().Get(srv.URL)
+		Expect(err).NotTo(BeNil())
+		Expect(time.Since(start)).To(BeNumerically("<", delay))
+	})
+
+	It("fails to read responses that are too large", func() {
+		common.HTTPMaxResponseSize = 1024
+		content = strings.Repeat("a", 1025)
+
+		resp, err := common.HTTPClient().Get(srv.URL)
+		if err == nil {
+			defer resp.Body.Close()
+			_, err = ioutil.ReadAll(resp.Body)
+		}
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring(common.ErrResponseTooLarge.Error()))
+	})
+
+	It("reads responses that are exactly as large as the limit", func() {
+		common.HTTPMaxResponseSize = 1024
+		content = strings.Repeat("a", 1024)
+
+		resp, err := common.HTTPClient().Get(srv.URL)
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		Expect(err).To(BeNil())
+		Expect(b).To(HaveLen(1024))
+	})
+})
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// maxDeploymentsPerPage is the maximum number of deployments that are
+// returned by Deployments at a time.
+const maxDeploymentsPerPage = 100
+
+type deploymentJSON struct {
+	*deployment.JSON
+	CreatedAt time.Time `json:"created_at"`
+
+	Project struct {
+		ID   uint   `json:"id"`
+		Name string `json:"name"`
+	} `json:"project"`
+
+	User struct {
+		ID    uint   `json:"id"`
+		Email string `json:"email"`
+	} `json:"user"`
+}
+
+// Deployments lists the deployments of all projects, newest first, along with
+// their projects and the users who created them. They can be limited to a
+// state with the "state" param, and to those created in a range with the
+// "created_after" and "created_before" params, which are RFC 3339 timestamps.
+// Deployments are paginated with the "before" and "limit" params;
+// "next_before" is returned while there are more deployments.
+func Deployments(c *gin.Context) {
+	errs := map[string]string{}
+
+	var filter deployment.FeedFilter
+
+	if s := c.Query("state"); s != "" {
+		state, err := deployment.ParsePublicState(s)
+		if err != nil {
+			errs["state"] = "is invalid"
+		}
+		filter.State = state
+	}
+
+	var ok bool
+	if filter.Created.After, ok = controllers.TimeParam(c, "created_after"); !ok {
+		errs["created_after"] = "is not a valid RFC 3339 timestamp"
+	}
+	if filter.Created.Before, ok = controllers.TimeParam(c, "created_before"); !ok {
+		errs["created_before"] = "is not a valid RFC 3339 timestamp"
+	}
+
+	if b := c.Query("before"); b != "" {
+		id, err := strconv.ParseUint(b, 10, 64)
+		if err != nil || id == 0 {
+			errs["before"] = "is invalid"
+		}
+		filter.BeforeID = uint(id)
+	}
+
+	limit := maxDeploymentsPerPage
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxDeploymentsPerPage {
+			errs["limit"] = "must be between 1 and " + strconv.Itoa(maxDeploymentsPerPage)
+		}
+		limit = n
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// One more deployment than asked for is fetched to tell whether there is
+	// another page.
+	depls, err := deployment.Feed(db, filter, limit+1)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	res := gin.H{}
+	if len(depls) > limit {
+		depls = depls[:limit]
+		res["next_before"] = depls[limit-1].ID
+	}
+
+	deplsJSON := make([]*deploymentJSON, len(depls))
+	for i, depl := range depls {
+		j := &deploymentJSON{
+			JSON:      depl.AsJSON(),
+			CreatedAt: depl.CreatedAt,
+		}
+		j.Project.ID = depl.ProjectID
+		j.Project.Name = depl.ProjectName
+		j.User.ID = depl.UserID
+		j.User.Email = depl.UserEmail
+		deplsJSON[i] = j
+	}
+	res["deployments"] = deplsJSON
+
+	c.JSON(http.StatusOK, res)
+}
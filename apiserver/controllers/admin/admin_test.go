@@ -0,0 +1,243 @@
+package admin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "admin")
+}
+
+var _ = Describe("Admin", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u *user.User
+		t *oauthtoken.OauthToken
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+		Expect(db.Model(u).Update("admin", true).Error).To(BeNil())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /admin/deployments", func() {
+		type feedJSON struct {
+			Deployments []struct {
+				ID      uint   `json:"id"`
+				State   string `json:"state"`
+				Project struct {
+					ID   uint   `json:"id"`
+					Name string `json:"name"`
+				} `json:"project"`
+				User struct {
+					ID    uint   `json:"id"`
+					Email string `json:"email"`
+				} `json:"user"`
+			} `json:"deployments"`
+			NextBefore *uint `json:"next_before"`
+		}
+
+		var (
+			headers http.Header
+			params  url.Values
+
+			u1, u2       *user.User
+			proj1, proj2 *project.Project
+
+			d1, d2, d3, d4 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+			params = url.Values{}
+
+			u1 = factories.User(db)
+			u2 = factories.User(db)
+			proj1 = factories.Project(db, u1)
+			proj2 = factories.Project(db, u2)
+
+			d1 = factories.Deployment(db, proj1, u1, deployment.StateDeployed)
+			d2 = factories.Deployment(db, proj2, u2, deployment.StateDeployFailed)
+			d3 = factories.Deployment(db, proj1, u1, deployment.StateDeployed)
+			d4 = factories.Deployment(db, proj2, u2, deployment.StateDeployed)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/deployments?"+params.Encode(), nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		feed := func() *feedJSON {
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			f := &feedJSON{}
+			Expect(json.Unmarshal(b.Bytes(), f)).To(BeNil())
+			return f
+		}
+
+		feedIDs := func(f *feedJSON) []uint {
+			ids := []uint{}
+			for _, d := range f.Deployments {
+				ids = append(ids, d.ID)
+			}
+			return ids
+		}
+
+		It("returns the deployments of all users, newest first, with their projects and users", func() {
+			doRequest()
+
+			f := feed()
+			Expect(feedIDs(f)).To(Equal([]uint{d4.ID, d3.ID, d2.ID, d1.ID}))
+			Expect(f.NextBefore).To(BeNil())
+
+			Expect(f.Deployments[0].State).To(Equal("deployed"))
+			Expect(f.Deployments[0].Project.ID).To(Equal(proj2.ID))
+			Expect(f.Deployments[0].Project.Name).To(Equal(proj2.Name))
+			Expect(f.Deployments[0].User.ID).To(Equal(u2.ID))
+			Expect(f.Deployments[0].User.Email).To(Equal(u2.Email))
+
+			Expect(f.Deployments[1].Project.Name).To(Equal(proj1.Name))
+			Expect(f.Deployments[1].User.Email).To(Equal(u1.Email))
+		})
+
+		It("does not return deleted deployments", func() {
+			Expect(db.Delete(d3).Error).To(BeNil())
+
+			doRequest()
+
+			Expect(feedIDs(feed())).To(Equal([]uint{d4.ID, d2.ID, d1.ID}))
+		})
+
+		It("paginates the deployments with before and limit", func() {
+			params.Set("limit", "3")
+			doRequest()
+
+			f := feed()
+			Expect(feedIDs(f)).To(Equal([]uint{d4.ID, d3.ID, d2.ID}))
+			Expect(f.NextBefore).NotTo(BeNil())
+			Expect(*f.NextBefore).To(Equal(d2.ID))
+
+			res.Body.Close()
+			s.Close()
+
+			params.Set("before", fmt.Sprint(*f.NextBefore))
+			doRequest()
+
+			f = feed()
+			Expect(feedIDs(f)).To(Equal([]uint{d1.ID}))
+			Expect(f.NextBefore).To(BeNil())
+		})
+
+		It("filters the deployments by state", func() {
+			params.Set("state", "deploy_failed")
+			doRequest()
+
+			Expect(feedIDs(feed())).To(Equal([]uint{d2.ID}))
+		})
+
+		It("filters the deployments by the time they were created", func() {
+			Expect(db.Model(d1).Update("created_at", time.Now().Add(-3*time.Hour)).Error).To(BeNil())
+			Expect(db.Model(d2).Update("created_at", time.Now().Add(-2*time.Hour)).Error).To(BeNil())
+
+			params.Set("created_after", time.Now().Add(-150*time.Minute).Format(time.RFC3339))
+			params.Set("created_before", time.Now().Add(-time.Hour).Format(time.RFC3339))
+			doRequest()
+
+			Expect(feedIDs(feed())).To(Equal([]uint{d2.ID}))
+		})
+
+		DescribeTable("invalid params",
+			func(name, value, message string) {
+				params.Set(name, value)
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"error": "invalid_params",
+					"errors": { "%s": "%s" }
+				}`, name, message)))
+			},
+
+			Entry("unknown state", "state", "exploded", "is invalid"),
+			Entry("invalid created_after", "created_after", "yesterday", "is not a valid RFC 3339 timestamp"),
+			Entry("invalid before", "before", "abc", "is invalid"),
+			Entry("limit that is too large", "limit", "101", "must be between 1 and 100"),
+		)
+
+		Context("when the user is not an admin", func() {
+			BeforeEach(func() {
+				Expect(db.Model(u).Update("admin", false).Error).To(BeNil())
+			})
+
+			It("returns 403 forbidden", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "forbidden",
+					"error_description": "admin access is required"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
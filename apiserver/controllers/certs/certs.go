@@ -23,12 +23,15 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
 	"github.com/nitrous-io/rise-server/pkg/certhelper"
+	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
@@ -182,6 +185,11 @@ func Create(c *gin.Context) {
 				"error":             "invalid_params",
 				"error_description": "invalid common name (domain name mismatch)",
 			})
+		} else if err == certhelper.ErrCertExpired {
+			c.JSON(422, gin.H{
+				"error":             "invalid_params",
+				"error_description": "cert has expired",
+			})
 		} else {
 			controllers.InternalServerError(c, err)
 		}
@@ -204,7 +212,12 @@ func Create(c *gin.Context) {
 		return
 	}
 
-	{
+	if err := enqueueMetaUpload(proj); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !proj.AnalyticsOptOut {
 		u := controllers.CurrentUser(c)
 
 		var (
@@ -466,7 +479,12 @@ func LetsEncrypt(c *gin.Context) {
 		return
 	}
 
-	{
+	if err := enqueueMetaUpload(proj); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !proj.AnalyticsOptOut {
 		u := controllers.CurrentUser(c)
 
 		var (
@@ -526,6 +544,24 @@ func uploadCert(domainName string, cert, key []byte) error {
 	return m.Publish()
 }
 
+// enqueueMetaUpload enqueues a deploy job that uploads the meta.json of the
+// domains of proj again, so that it refers to the current cert of each domain.
+func enqueueMetaUpload(proj *project.Project) error {
+	if proj.ActiveDeploymentID == nil {
+		return nil
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      *proj.ActiveDeploymentID,
+		SkipWebrootUpload: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return j.Enqueue()
+}
+
 func Destroy(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 	domainName := c.Param("name")
@@ -606,7 +642,12 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
-	{
+	if err := enqueueMetaUpload(proj); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !proj.AnalyticsOptOut {
 		u := controllers.CurrentUser(c)
 
 		var (
@@ -19,12 +19,12 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
-	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/server"
 	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/pkg/certhelper"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/nitrous-io/rise-server/pkg/tracker"
@@ -94,10 +94,10 @@ var _ = Describe("Certs", func() {
 			invalidationQueueName string
 
 			origAesKey string
+			origNow    func() time.Time
 
-			u  *user.User
-			oc *oauthclient.OauthClient
-			t  *oauthtoken.OauthToken
+			u *user.User
+			t *oauthtoken.OauthToken
 
 			headers http.Header
 			proj    *project.Project
@@ -117,7 +117,7 @@ var _ = Describe("Certs", func() {
 
 			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
 
-			u, oc, t = factories.AuthTrio(db)
+			u, _, t = factories.AuthTrio(db)
 
 			proj = &project.Project{
 				Name:   "foo-bar-express",
@@ -187,11 +187,19 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 
 			origAesKey = common.AesKey
 			common.AesKey = "something-something-something-32"
+
+			origNow = certhelper.Now
+			certhelper.Now = func() time.Time {
+				return time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+			}
+
+			testhelper.DeleteQueue(mq, queues.Deploy)
 		})
 
 		AfterEach(func() {
 			common.AesKey = origAesKey
 			s3client.S3 = origS3
+			certhelper.Now = origNow
 		})
 
 		writePartToBody := func(writer *multipart.Writer, partName string, content []byte) {
@@ -379,6 +387,34 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			Expect(trackCall.ReturnValues[0]).To(BeNil())
 		})
 
+		It("does not enqueue any job", func() {
+			doRequest()
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).To(BeNil())
+		})
+
+		Context("when there is an active deployment", func() {
+			BeforeEach(func() {
+				depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+			})
+
+			It("enqueues a deploy job to upload meta.json with the cert", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}`, *proj.ActiveDeploymentID)))
+			})
+		})
+
 		Context("when given domain does not exist", func() {
 			BeforeEach(func() {
 				Expect(db.Delete(dm).Error).To(BeNil())
@@ -634,6 +670,30 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			})
 		})
 
+		Context("ssl cert has expired", func() {
+			BeforeEach(func() {
+				certhelper.Now = func() time.Time {
+					return time.Date(2017, 4, 20, 8, 50, 16, 0, time.UTC)
+				}
+			})
+
+			It("returns 422 with invalid_params", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"error_description": "cert has expired"
+				}`))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+				ct := &deployment.Deployment{}
+				Expect(db.Last(ct).Error).To(Equal(gorm.RecordNotFound))
+			})
+		})
+
 		Context("when the domain does not belong to the project", func() {
 			BeforeEach(func() {
 				proj2 := factories.Project(db, nil)
@@ -1165,9 +1225,8 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 
 	Describe("GET /projects/:project_name/domains/:domain_name/cert", func() {
 		var (
-			u  *user.User
-			oc *oauthclient.OauthClient
-			t  *oauthtoken.OauthToken
+			u *user.User
+			t *oauthtoken.OauthToken
 
 			headers http.Header
 			proj    *project.Project
@@ -1176,7 +1235,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 		)
 
 		BeforeEach(func() {
-			u, oc, t = factories.AuthTrio(db)
+			u, _, t = factories.AuthTrio(db)
 
 			proj = &project.Project{
 				Name:   "foo-bar-express",
@@ -1311,9 +1370,8 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			mq    *amqp.Connection
 			qName string
 
-			u  *user.User
-			oc *oauthclient.OauthClient
-			t  *oauthtoken.OauthToken
+			u *user.User
+			t *oauthtoken.OauthToken
 
 			headers http.Header
 			proj    *project.Project
@@ -1333,7 +1391,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			testhelper.DeleteQueue(mq, queues.All...)
 			testhelper.DeleteExchange(mq, exchanges.All...)
 
-			u, oc, t = factories.AuthTrio(db)
+			u, _, t = factories.AuthTrio(db)
 
 			proj = factories.Project(db, u, "foo-bar-express")
 			dm = factories.Domain(db, proj, "www.foo-bar-express.com")
@@ -1353,6 +1411,10 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			qName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
 		})
 
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
 		doRequest := func() {
 			s = httptest.NewServer(server.New())
 			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/foo-bar-express/domains/www.foo-bar-express.com/cert", nil, headers, nil)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
@@ -60,6 +61,21 @@ func CurrentProject(c *gin.Context) *project.Project {
 	return p
 }
 
+// TimeParam returns the RFC 3339 timestamp in a query param, or nil if the
+// param is not given. It returns false if the timestamp is invalid.
+func TimeParam(c *gin.Context, name string) (*time.Time, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, true
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, false
+	}
+	return &t, true
+}
+
 func InternalServerError(c *gin.Context, err error, msg ...string) {
 	var (
 		errMsg  = "internal server error"
@@ -0,0 +1,218 @@
+package deployments
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// SetCanary makes a deployment of the project its canary, which edges serve a
+// percentage of the traffic to the domains of the project with instead of the
+// active deployment. The deployment and the percentage of an existing canary
+// can also be changed on their own.
+func SetCanary(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if proj.ActiveDeploymentID == nil {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "project has no active deployment",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var (
+		errs     = map[string]string{}
+		canaryID = proj.CanaryDeploymentID
+		percent  = proj.CanaryPercent
+	)
+
+	if id := c.PostForm("deployment_id"); id != "" {
+		depl := &deployment.Deployment{}
+		if err := db.Where("id = ? AND project_id = ?", id, proj.ID).First(depl).Error; err != nil {
+			if err != gorm.RecordNotFound {
+				controllers.InternalServerError(c, err)
+				return
+			}
+			errs["deployment_id"] = "is not that of a deployment of the project"
+		} else if depl.ID == *proj.ActiveDeploymentID {
+			errs["deployment_id"] = "is that of the active deployment"
+		} else if depl.State != deployment.StateDeployed && depl.State != deployment.StateUnpublished {
+			errs["deployment_id"] = "is not that of a completed deployment"
+		} else {
+			canaryID = &depl.ID
+		}
+	} else if canaryID == nil {
+		errs["deployment_id"] = "is required"
+	}
+
+	if p := c.PostForm("percent"); p != "" {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || n < 1 || n > 99 {
+			errs["percent"] = "must be between 1 and 99"
+		} else {
+			percent = uint(n)
+		}
+	} else if percent == 0 {
+		errs["percent"] = "is required"
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	if err := db.Model(project.Project{}).Where("id = ?", proj.ID).Updates(map[string]interface{}{
+		"canary_deployment_id": canaryID,
+		"canary_percent":       percent,
+	}).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := enqueueMetaUpdate(*proj.ActiveDeploymentID); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"canary": gin.H{
+			"deployment_id": *canaryID,
+			"percent":       percent,
+		},
+	})
+}
+
+// ClearCanary stops edges from serving the canary of the project, so that all
+// traffic goes to the active deployment again.
+func ClearCanary(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if proj.CanaryDeploymentID == nil {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "project has no canary",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Model(project.Project{}).Where("id = ?", proj.ID).Updates(map[string]interface{}{
+		"canary_deployment_id": nil,
+		"canary_percent":       0,
+	}).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if proj.ActiveDeploymentID != nil {
+		if err := enqueueMetaUpdate(*proj.ActiveDeploymentID); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"canary": nil,
+	})
+}
+
+// PromoteCanary makes the canary of the project its active deployment. The
+// canary is cleared by the deployer once the deployment is live on all of the
+// project's domains, so that it keeps its share of the traffic until then.
+func PromoteCanary(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if proj.CanaryDeploymentID == nil {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "project has no canary",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.First(depl, *proj.CanaryDeploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(422, gin.H{
+				"error":             "invalid_request",
+				"error_description": "canary deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      depl.ID,
+		SkipWebrootUpload: true,
+	})
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := j.Enqueue(); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Promoting a canary that was live before is a rollback to it.
+	state := deployment.StatePendingDeploy
+	if depl.State == deployment.StateDeployed {
+		state = deployment.StatePendingRollback
+	}
+
+	if err := depl.UpdateState(db, state); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
+
+// enqueueMetaUpdate enqueues a deploy job that re-uploads the meta.json of
+// the domains of a project that the given deployment is active on.
+func enqueueMetaUpdate(activeDeploymentID uint) error {
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      activeDeploymentID,
+		SkipWebrootUpload: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return j.Enqueue()
+}
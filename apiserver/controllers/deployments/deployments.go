@@ -2,11 +2,16 @@ package deployments
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -16,11 +21,16 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/template"
 	"github.com/nitrous-io/rise-server/pkg/hasher"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/spillbuffer"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/meta"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
@@ -34,11 +44,177 @@ const (
 
 const presignExpiryDuration = 1 * time.Minute
 
+const (
+	// defaultDeploymentsPerPage and maxDeploymentsPerPage are the default and
+	// maximum numbers of deployments that are listed by Index at a time.
+	defaultDeploymentsPerPage = 30
+	maxDeploymentsPerPage     = 100
+)
+
+// errBundleUploadTimeout is returned by uploadBundle when uploading a bundle
+// takes longer than common.BundleUploadTimeout.
+var errBundleUploadTimeout = errors.New("timed out uploading bundle to S3")
+
+// errBundleUploadCanceled is returned from reading a bundle whose upload was
+// canceled.
+var errBundleUploadCanceled = errors.New("bundle upload was canceled")
+
+// bundleBody is the content of a raw bundle, which the S3 uploader reads parts
+// of at a time.
+type bundleBody interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// cancelableBody passes reads through to a bundle until it is canceled, after
+// which they fail, so that an upload that has timed out stops reading the
+// bundle before it is cleaned up.
+type cancelableBody struct {
+	mu       sync.RWMutex
+	body     bundleBody
+	canceled bool
+}
+
+func (b *cancelableBody) Read(p []byte) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.canceled {
+		return 0, errBundleUploadCanceled
+	}
+	return b.body.Read(p)
+}
+
+func (b *cancelableBody) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.canceled {
+		return 0, errBundleUploadCanceled
+	}
+	return b.body.ReadAt(p, off)
+}
+
+func (b *cancelableBody) Seek(offset int64, whence int) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.canceled {
+		return 0, errBundleUploadCanceled
+	}
+	return b.body.Seek(offset, whence)
+}
+
+// cancel fails the reads that follow, once the reads in progress are done.
+func (b *cancelableBody) cancel() {
+	b.mu.Lock()
+	b.canceled = true
+	b.mu.Unlock()
+}
+
+// uploadBundle uploads the raw bundle of a new deployment to S3, up to
+// common.BundleUploadConcurrency parts at a time, giving up after
+// common.BundleUploadTimeout. An upload that is given up on is canceled, so
+// that it no longer reads body, which can then be cleaned up once uploadBundle
+// returns. The upload itself is not waited for, as it may be stuck on the
+// network.
+func uploadBundle(key string, body bundleBody) error {
+	cb := &cancelableBody{body: body}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s3client.UploadWithConcurrency(key, cb, "", "private", common.BundleUploadConcurrency)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(common.BundleUploadTimeout):
+		cb.cancel()
+		return errBundleUploadTimeout
+	}
+}
+
+// maxAnnotationLength is the maximum length of each deployment annotation
+// (label, branch and commit).
+const maxAnnotationLength = 255
+
+// allowedPayloadContentType returns whether a payload declared as contentType
+// may be a bundle. Payloads that do not declare a content type are allowed, as
+// their content is sniffed anyway.
+func allowedPayloadContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range common.AllowedBundleContentTypes {
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// annotate sets the annotation of a deployment named by a request param.
+// Params that are not annotations are ignored.
+func annotate(depl *deployment.Deployment, name, value string) {
+	var field **string
+	switch name {
+	case "label":
+		field = &depl.Label
+	case "branch":
+		field = &depl.Branch
+	case "commit":
+		field = &depl.CommitSHA
+	default:
+		return
+	}
+
+	if value = strings.TrimSpace(value); value != "" {
+		if len(value) > maxAnnotationLength {
+			value = value[:maxAnnotationLength]
+		}
+		*field = &value
+	}
+}
+
+// parsePriority returns the priority of a deploy job from a request param, in
+// the form it is stored in the job. It returns false if the param is invalid.
+func parsePriority(param string) (string, bool) {
+	switch strings.TrimSpace(param) {
+	case "", messages.PriorityNormal:
+		return "", true
+	case messages.PriorityHigh:
+		return messages.PriorityHigh, true
+	}
+	return "", false
+}
+
 // Create deploys a project.
 func Create(c *gin.Context) {
 	u := controllers.CurrentUser(c)
 	proj := controllers.CurrentProject(c)
 
+	// Deploy tokens can only deploy the project they were created for.
+	if t := controllers.CurrentToken(c); t != nil && !t.Allows(oauthtoken.ScopeDeploy, proj.ID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "insufficient_scope",
+			"error_description": "access token is not allowed to perform this action",
+		})
+		return
+	}
+
+	if proj.DeploysPaused {
+		c.JSON(423, gin.H{
+			"error":             "deploys_paused",
+			"error_description": "deploys of the project are paused",
+		})
+		return
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err, "deployments: failed to get a db connection")
@@ -46,8 +222,16 @@ func Create(c *gin.Context) {
 	}
 
 	depl := &deployment.Deployment{
-		ProjectID: proj.ID,
-		UserID:    u.ID,
+		ProjectID:    proj.ID,
+		UserID:       u.ID,
+		OpaquePrefix: shared.OpaqueDeploymentPrefixes,
+	}
+
+	if userAgent := c.Request.UserAgent(); userAgent != "" {
+		depl.CreatedByUserAgent = &userAgent
+	}
+	if ip := common.ClientIP(c.Request); ip != "" {
+		depl.CreatedByIP = &ip
 	}
 
 	// Get js environment variables from previous deployment.
@@ -61,9 +245,32 @@ func Create(c *gin.Context) {
 		depl.JsEnvVars = prevDepl.JsEnvVars
 	}
 
+	// The deployer would have to deploy without the environment, so invalid
+	// variables are rejected before they can reach it.
+	if _, err := depl.ParsedJsEnvVars(); err != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": map[string]string{"js_env_vars": "must be a JSON object of strings"},
+		})
+		return
+	}
+
+	// Options that the request does not set are taken from the default deploy
+	// options of the project.
+	defaults, err := proj.DeployDefaults()
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to parse default deploy options")
+		return
+	}
+	depl.SkipJsEnv = defaults.SkipJsEnv
+
 	var (
-		archiveFormat string
-		strategy      = viaUnknown
+		archiveFormat    string
+		bundleChecksum   string
+		priority, _      = parsePriority(defaults.Priority)
+		skipInvalidation = defaults.SkipInvalidation
+		forceFullUpload  = defaults.ForceFullUpload
+		strategy         = viaUnknown
 	)
 
 	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "multipart/form-data; boundary=") {
@@ -74,6 +281,36 @@ func Create(c *gin.Context) {
 		strategy = viaTemplate
 	}
 
+	// Parsing the form of a multipart request would consume the payload, so
+	// annotations are read from their parts instead.
+	if strategy != viaPayload {
+		for _, name := range []string{"label", "branch", "commit"} {
+			annotate(depl, name, c.PostForm(name))
+		}
+
+		if v, ok := c.GetPostForm("skip_js_env"); ok {
+			depl.SkipJsEnv, _ = strconv.ParseBool(v)
+		}
+		if v, ok := c.GetPostForm("skip_invalidation"); ok {
+			skipInvalidation, _ = strconv.ParseBool(v)
+		}
+		if v, ok := c.GetPostForm("force_full_upload"); ok {
+			forceFullUpload, _ = strconv.ParseBool(v)
+		}
+
+		if v, ok := c.GetPostForm("priority"); ok {
+			if priority, ok = parsePriority(v); !ok {
+				c.JSON(422, gin.H{
+					"error": "invalid_params",
+					"errors": map[string]string{
+						"priority": "is invalid",
+					},
+				})
+				return
+			}
+		}
+	}
+
 	switch strategy {
 	case viaPayload:
 		reader, err := c.Request.MultipartReader()
@@ -114,15 +351,15 @@ func Create(c *gin.Context) {
 			}
 
 			if part.FormName() == "payload" {
-				ver, err := proj.NextVersion(db)
-				if err != nil {
-					controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
-					return
-				}
-
-				depl.Version = ver
-				if err := db.Create(depl).Error; err != nil {
-					controllers.InternalServerError(c, err, "deployments: failed to create a deployment record in DB")
+				// Payloads that are obviously not bundles are rejected
+				// before a deployment is recorded for them.
+				if !allowedPayloadContentType(part.Header.Get("Content-Type")) {
+					c.JSON(422, gin.H{
+						"error": "invalid_params",
+						"errors": map[string]interface{}{
+							"payload": "is not an allowed content type",
+						},
+					})
 					return
 				}
 
@@ -141,13 +378,13 @@ func Create(c *gin.Context) {
 				}
 
 				mimeType := http.DetectContentType(partHead)
-				var uploadKey string
+				var bundleName string
 				switch mimeType {
 				case "application/zip":
-					uploadKey = fmt.Sprintf("deployments/%s/raw-bundle.zip", depl.PrefixID())
+					bundleName = "raw-bundle.zip"
 					archiveFormat = "zip"
 				case "application/x-gzip":
-					uploadKey = fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())
+					bundleName = "raw-bundle.tar.gz"
 					archiveFormat = "tar.gz"
 				default:
 					// By default, it returns "application/octet-stream"
@@ -158,8 +395,51 @@ func Create(c *gin.Context) {
 					return
 				}
 
+				ver, err := proj.NextVersion(db)
+				if err != nil {
+					controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
+					return
+				}
+
+				depl.Version = ver
+				if err := db.Create(depl).Error; err != nil {
+					controllers.InternalServerError(c, err, "deployments: failed to create a deployment record in DB")
+					return
+				}
+
+				// The deployment is only recorded to name the bundle, so it is
+				// removed rather than left without one if the bundle does not
+				// make it to S3.
+				discardDeployment := func() {
+					if err := db.Unscoped().Delete(depl).Error; err != nil {
+						log.Errorf("failed to delete deployment %d after its bundle failed to upload, err: %v", depl.ID, err)
+					}
+				}
+
+				uploadKey := shared.DeploymentKey(depl.PrefixID(), bundleName)
+
+				// Buffer the payload before uploading it, as the S3 uploader
+				// otherwise holds whole parts of it in memory at a time.
 				hr := hasher.NewReader(br)
-				if err := s3client.Upload(uploadKey, hr, "", "private"); err != nil {
+				payload, err := spillbuffer.New(hr, common.MultipartMemoryLimit)
+				if err != nil {
+					discardDeployment()
+					controllers.InternalServerError(c, err, "deployments: failed to read payload")
+					return
+				}
+				defer payload.Close()
+
+				if err := uploadBundle(uploadKey, payload); err != nil {
+					discardDeployment()
+
+					if err == errBundleUploadTimeout {
+						c.JSON(http.StatusGatewayTimeout, gin.H{
+							"error":             "upload_timeout",
+							"error_description": "uploading the bundle timed out, please try again",
+						})
+						return
+					}
+
 					controllers.InternalServerError(c, err, "deployments: failed to upload to S3")
 					return
 				}
@@ -168,6 +448,7 @@ func Create(c *gin.Context) {
 					ProjectID:    proj.ID,
 					Checksum:     hr.Checksum(),
 					UploadedPath: uploadKey,
+					Size:         hr.Size(),
 				}
 				if err := db.Create(bun).Error; err != nil {
 					controllers.InternalServerError(c, err, "deployments: failed to create a raw bundle record in DB")
@@ -175,8 +456,43 @@ func Create(c *gin.Context) {
 				}
 
 				depl.RawBundleID = &bun.ID
+				bundleChecksum = bun.Checksum
 				break
 			}
+
+			// Other params are only picked up if they are sent before the payload.
+			v, err := ioutil.ReadAll(io.LimitReader(part, maxAnnotationLength))
+			if err != nil {
+				controllers.InternalServerError(c, err, "deployments: failed to read form data")
+				return
+			}
+
+			if part.FormName() == "priority" {
+				var ok bool
+				if priority, ok = parsePriority(string(v)); !ok {
+					c.JSON(422, gin.H{
+						"error": "invalid_params",
+						"errors": map[string]string{
+							"priority": "is invalid",
+						},
+					})
+					return
+				}
+				continue
+			}
+			if part.FormName() == "skip_js_env" {
+				depl.SkipJsEnv, _ = strconv.ParseBool(string(v))
+				continue
+			}
+			if part.FormName() == "skip_invalidation" {
+				skipInvalidation, _ = strconv.ParseBool(string(v))
+				continue
+			}
+			if part.FormName() == "force_full_upload" {
+				forceFullUpload, _ = strconv.ParseBool(string(v))
+				continue
+			}
+			annotate(depl, part.FormName(), string(v))
 		}
 
 	case viaCachedBundle:
@@ -218,6 +534,7 @@ func Create(c *gin.Context) {
 			return
 		}
 		depl.RawBundleID = &bun.ID
+		bundleChecksum = bun.Checksum
 
 		// Currently bundle from CLI is always tar.gz
 		archiveFormat = "tar.gz"
@@ -272,7 +589,7 @@ func Create(c *gin.Context) {
 			return
 		}
 
-		bundlePath := "deployments/" + depl.PrefixID() + "/raw-bundle." + archiveFormat
+		bundlePath := shared.DeploymentKey(depl.PrefixID(), "raw-bundle."+archiveFormat)
 		if err := s3client.Copy(tmpl.DownloadURL, bundlePath); err != nil {
 			controllers.InternalServerError(c, err, fmt.Sprintf("failed to make a copy of template %q to %q in S3", tmpl.DownloadURL, bundlePath))
 			return
@@ -297,6 +614,29 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	// The prefix is only known once the bundle is, so the random prefix the
+	// deployment was created with is replaced. The deployment ID is left out
+	// of content hash prefixes, so that deployments of identical bundles get
+	// the same URL. The JS environment is part of the webroot, so it is
+	// hashed too.
+	if proj.ContentHashPrefixes && bundleChecksum != "" {
+		var jsEnvVars map[string]string
+		if !depl.SkipJsEnv {
+			// The variables were checked to be valid above.
+			jsEnvVars, _ = depl.ParsedJsEnvVars()
+		}
+
+		depl.Prefix = deployment.ContentHashPrefix(proj.ID, bundleChecksum, jsEnvVars)
+		depl.OpaquePrefix = true
+		if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Updates(map[string]interface{}{
+			"prefix":        depl.Prefix,
+			"opaque_prefix": true,
+		}).Error; err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to update deployment prefix")
+			return
+		}
+	}
+
 	if err := depl.UpdateState(db, deployment.StateUploaded); err != nil {
 		controllers.InternalServerError(c, err, "deployments: failed to update deployment state to be uploaded")
 		return
@@ -304,15 +644,22 @@ func Create(c *gin.Context) {
 
 	var j *job.Job
 	if proj.SkipBuild {
-		j, err = job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
-			DeploymentID:  depl.ID,
-			UseRawBundle:  true,
-			ArchiveFormat: archiveFormat,
-		})
+		data := &messages.DeployJobData{
+			DeploymentID:     depl.ID,
+			SkipInvalidation: skipInvalidation,
+			UseRawBundle:     true,
+			ArchiveFormat:    archiveFormat,
+			Priority:         priority,
+			ForceFullUpload:  forceFullUpload,
+		}
+		j, err = job.NewWithJSON(data.QueueName(), data)
 	} else {
 		j, err = job.NewWithJSON(queues.Build, &messages.BuildJobData{
-			DeploymentID:  depl.ID,
-			ArchiveFormat: archiveFormat,
+			DeploymentID:     depl.ID,
+			ArchiveFormat:    archiveFormat,
+			Priority:         priority,
+			SkipInvalidation: skipInvalidation,
+			ForceFullUpload:  forceFullUpload,
 		})
 	}
 
@@ -336,7 +683,13 @@ func Create(c *gin.Context) {
 		return
 	}
 
-	{
+	if t := controllers.CurrentToken(c); t != nil && t.IsScoped() {
+		if err := t.Touch(db); err != nil {
+			log.Errorf("failed to record use of deploy token ID %d, err: %v", t.ID, err)
+		}
+	}
+
+	if !proj.AnalyticsOptOut {
 		var (
 			event = "Initiated Project Deployment"
 			props = map[string]interface{}{
@@ -356,6 +709,9 @@ func Create(c *gin.Context) {
 		}
 	}
 
+	// Clients can poll the deployment at the URL in the Location header
+	// until it is deployed.
+	c.Header("Location", fmt.Sprintf("/projects/%s/deployments/%d", proj.Name, depl.ID))
 	c.JSON(http.StatusAccepted, gin.H{
 		"deployment": depl.AsJSON(),
 	})
@@ -372,7 +728,7 @@ func Show(c *gin.Context) {
 		return
 	}
 
-	db, err := dbconn.DB()
+	db, err := dbconn.ReplicaDB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -392,8 +748,164 @@ func Show(c *gin.Context) {
 		return
 	}
 
+	deplJSON := depl.AsJSON()
+	if depl.State == deployment.StateDeployed || depl.State == deployment.StateUnpublished {
+		deplJSON.PreviewURL = depl.PreviewURL()
+	}
+
+	changes, err := depl.ChangeSummary(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	deplJSON.Changes = changes
+
+	manifest, err := depl.ParsedManifest()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	deplJSON.Egress = manifest.Egress()
+
+	if controllers.CurrentUser(c).ID == controllers.CurrentProject(c).UserID {
+		deplJSON.CreatedByUserAgent = depl.CreatedByUserAgent
+		deplJSON.CreatedByIP = depl.CreatedByIP
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"deployment": depl.AsJSON(),
+		"deployment": deplJSON,
+	})
+}
+
+// MetaDiff compares the meta of a deployment against that of another
+// deployment of the project.
+func MetaDiff(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	findDeployment := func(id string) (*deployment.Deployment, error) {
+		deploymentID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, nil
+		}
+
+		depl := &deployment.Deployment{}
+		if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return depl, nil
+	}
+
+	depl, err := findDeployment(c.Param("id"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if depl == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	againstDepl, err := findDeployment(c.Query("against"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if againstDepl == nil {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"against": "is not that of a deployment of the project",
+			},
+		})
+		return
+	}
+
+	m, err := meta.Snapshotted(proj, depl)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	againstMeta, err := meta.Snapshotted(proj, againstDepl)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	changes, err := meta.Diff(againstMeta, m)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"meta_diff": gin.H{
+			"deployment": m,
+			"against":    againstMeta,
+			"changes":    changes,
+		},
+	})
+}
+
+// VanityURL returns the URL that a deployment can be shared at on the public
+// base domain.
+func VanityURL(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Only deployments whose webroot has been uploaded have their meta at
+	// the vanity path.
+	if depl.State != deployment.StateDeployed && depl.State != deployment.StateUnpublished {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment has not been deployed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vanity_url": depl.VanityURL(proj.Name),
 	})
 }
 
@@ -476,28 +988,68 @@ func Download(c *gin.Context) {
 	})
 }
 
-// Rollback either rolls back a project to the previous deployment, or to a
-// given version.
-func Rollback(c *gin.Context) {
+// Logs responds with the log of a deployment as a plain text file, one line
+// per entry, e.g. to attach to CI artifacts or support tickets.
+func Logs(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
-	if proj.ActiveDeploymentID == nil {
-		c.JSON(http.StatusPreconditionFailed, gin.H{
-			"error":             "precondition_failed",
-			"error_description": "active deployment could not be found",
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
 		})
 		return
 	}
 
-	db, err := dbconn.DB()
+	db, err := dbconn.ReplicaDB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	var currentDepl deployment.Deployment
-	if err := db.First(&currentDepl, *proj.ActiveDeploymentID).Error; err != nil {
-		controllers.InternalServerError(c, err)
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	entries, err := depl.Logs(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		fmt.Fprintf(&buf, "%s %s\n", entry.CreatedAt.UTC().Format(time.RFC3339), entry.Message)
+	}
+
+	fileName := fmt.Sprintf("%s-v%d-logs.txt", proj.Name, depl.Version)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+}
+
+// Rollback either rolls back a project to the previous deployment, or to a
+// given version.
+func Rollback(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	currentDepl, ok := activeDeployment(c, db, proj)
+	if !ok {
 		return
 	}
 
@@ -549,6 +1101,136 @@ func Rollback(c *gin.Context) {
 		}
 	}
 
+	rollbackTo(c, db, proj, currentDepl, depl)
+}
+
+// RollbackToDeployment rolls back a project to a given deployment of it,
+// which must have been deployed and not deleted since.
+func RollbackToDeployment(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Deployments deleted to keep the last N of them are soft deleted, so
+	// they are not found here.
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	currentDepl, ok := activeDeployment(c, db, proj)
+	if !ok {
+		return
+	}
+
+	if depl.State != deployment.StateDeployed {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "the specified deployment has not been deployed",
+		})
+		return
+	}
+
+	if depl.ID == currentDepl.ID {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "the specified deployment is already active",
+		})
+		return
+	}
+
+	rollbackTo(c, db, proj, currentDepl, depl)
+}
+
+// activeDeployment returns the active deployment of a project, or responds
+// with 412 and returns false if it does not have one.
+func activeDeployment(c *gin.Context, db *gorm.DB, proj *project.Project) (*deployment.Deployment, bool) {
+	if proj.ActiveDeploymentID == nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":             "precondition_failed",
+			"error_description": "active deployment could not be found",
+		})
+		return nil, false
+	}
+
+	currentDepl := &deployment.Deployment{}
+	if err := db.First(currentDepl, *proj.ActiveDeploymentID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return nil, false
+	}
+	return currentDepl, true
+}
+
+// rollbackTo enqueues a job that makes depl the active deployment of the
+// project again. Its webroot is still on S3, so the deployer only uploads the
+// meta of the project's domains and invalidates them, and makes it active
+// once it has.
+func rollbackTo(c *gin.Context, db *gorm.DB, proj *project.Project, currentDepl, depl *deployment.Deployment) {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Restore the settings that were in effect when the deployment was
+	// deployed so that the meta uploaded by the rollback is built from them.
+	// Deployments that predate settings snapshots only have their content
+	// rolled back.
+	if restoreSettings, _ := strconv.ParseBool(c.PostForm("restore_settings")); restoreSettings {
+		settings, err := project.SnapshottedSettings(depl)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if settings != nil {
+			proj.ApplySettings(settings)
+			if err := tx.Model(project.Project{}).Where("id = ?", proj.ID).Updates(map[string]interface{}{
+				"force_https":                   proj.ForceHTTPS,
+				"watermark":                     proj.Watermark,
+				"basic_auth_username":           proj.BasicAuthUsername,
+				"encrypted_basic_auth_password": proj.EncryptedBasicAuthPassword,
+			}).Error; err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+		}
+	}
+
+	if err := depl.UpdateState(tx, deployment.StatePendingRollback); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// The job is enqueued once the restored settings are committed, so that
+	// the deployer builds the meta from them.
 	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
 		DeploymentID:      depl.ID,
 		SkipWebrootUpload: true,
@@ -564,12 +1246,7 @@ func Rollback(c *gin.Context) {
 		return
 	}
 
-	if err := depl.UpdateState(db, deployment.StatePendingRollback); err != nil {
-		controllers.InternalServerError(c, err)
-		return
-	}
-
-	{
+	if !proj.AnalyticsOptOut {
 		u := controllers.CurrentUser(c)
 
 		var (
@@ -595,30 +1272,285 @@ func Rollback(c *gin.Context) {
 	})
 }
 
-// Index lists all deployments of a project.
-func Index(c *gin.Context) {
+// Publish makes an unpublished deployment live on the project's domains.
+func Publish(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if depl.State != deployment.StateUnpublished {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "the specified deployment is not unpublished",
+		})
+		return
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      depl.ID,
+		SkipWebrootUpload: true,
+	})
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := j.Enqueue(); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := depl.UpdateState(db, deployment.StatePendingDeploy); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !proj.AnalyticsOptOut {
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Initiated Project Publish"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentVersion": depl.Version,
+			}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
+
+// Destroy deletes a deployment of a project. The active deployment is not
+// deleted, as that would take the project's site down, unless the
+// "repoint_active" param is true, in which case the project is pointed at the
+// deployment that was deployed before it first.
+func Destroy(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	depls, err := deployment.CompletedDeployments(db, proj.ID, proj.MaxDeploysKept)
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if proj.CanaryDeploymentID != nil && *proj.CanaryDeploymentID == depl.ID {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "the specified deployment is the canary",
+		})
+		return
+	}
+
+	isActive := proj.ActiveDeploymentID != nil && *proj.ActiveDeploymentID == depl.ID
+	if !isActive {
+		if err := db.Delete(depl).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"deleted": true,
+		})
+		return
+	}
+
+	if repointActive, _ := strconv.ParseBool(c.Query("repoint_active")); !repointActive {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "the specified deployment is active",
+		})
+		return
+	}
+
+	prevDepl, err := depl.PreviousCompletedDeployment(db)
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	var deplsToJSON []interface{}
-	for _, depl := range depls {
+	if prevDepl == nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":             "precondition_failed",
+			"error_description": "previous completed deployment could not be found",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.Model(project.Project{}).Where("id = ?", proj.ID).Update("active_deployment_id", prevDepl.ID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Delete(depl).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// The domains of the project are served from the deployment that is now
+	// active once its meta.json is uploaded again.
+	if err := enqueueMetaUpdate(prevDepl.ID); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deleted":    true,
+		"deployment": prevDepl.AsJSON(),
+	})
+}
+
+// indexJSON is a deployment as it is listed by Index.
+type indexJSON struct {
+	*deployment.JSON
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Index lists the completed deployments of a project, most recently deployed
+// first. The deployments can be limited to those created in a range with the
+// "created_after" and "created_before" params, which are RFC 3339 timestamps.
+// They are paginated with the "page" and "per_page" params, and "total" is the
+// number of deployments on all pages.
+func Index(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	var (
+		created deployment.TimeRange
+		errs    = map[string]string{}
+		ok      bool
+	)
+
+	if created.After, ok = controllers.TimeParam(c, "created_after"); !ok {
+		errs["created_after"] = "is not a valid RFC 3339 timestamp"
+	}
+	if created.Before, ok = controllers.TimeParam(c, "created_before"); !ok {
+		errs["created_before"] = "is not a valid RFC 3339 timestamp"
+	}
+
+	if created.After != nil && created.Before != nil && !created.Before.After(*created.After) {
+		errs["created_before"] = "must be later than created_after"
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			errs["page"] = "must be a positive integer"
+		}
+		page = n
+	}
+
+	perPage := defaultDeploymentsPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		n, err := strconv.Atoi(pp)
+		if err != nil || n < 1 || n > maxDeploymentsPerPage {
+			errs["per_page"] = "must be between 1 and " + strconv.Itoa(maxDeploymentsPerPage)
+		}
+		perPage = n
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depls, total, err := deployment.CompletedDeploymentsPage(db, proj.ID, proj.MaxDeploysKept, created, page, perPage)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	deplsToJSON := make([]*indexJSON, len(depls))
+	for i, depl := range depls {
 		deplJSON := depl.AsJSON()
-		deplJSON.Active = depl.ID == *proj.ActiveDeploymentID
-		deplsToJSON = append(deplsToJSON, deplJSON)
+		deplJSON.Active = proj.ActiveDeploymentID != nil && depl.ID == *proj.ActiveDeploymentID
+		deplsToJSON[i] = &indexJSON{
+			JSON:      deplJSON,
+			CreatedAt: depl.CreatedAt,
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"deployments": deplsToJSON,
+		"total":       total,
 	})
 }
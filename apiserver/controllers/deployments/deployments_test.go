@@ -3,15 +3,20 @@ package deployments_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,7 +24,6 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
-	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
@@ -29,6 +33,7 @@ import (
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 	"github.com/nitrous-io/rise-server/testhelper"
@@ -99,9 +104,19 @@ var _ = Describe("Deployments", func() {
 
 			headers http.Header
 			proj    *project.Project
+
+			// fields are sent before the payload in multipart requests.
+			fields url.Values
+
+			// payloadContentType is the content type the payload part is
+			// declared as, if set.
+			payloadContentType string
 		)
 
 		BeforeEach(func() {
+			fields = nil
+			payloadContentType = ""
+
 			origS3 = s3client.S3
 			fakeS3 = &fake.S3{}
 			s3client.S3 = fakeS3
@@ -131,10 +146,24 @@ var _ = Describe("Deployments", func() {
 			body := &bytes.Buffer{}
 			writer := multipart.NewWriter(body)
 
+			for name, values := range fields {
+				for _, v := range values {
+					Expect(writer.WriteField(name, v)).To(BeNil())
+				}
+			}
+
 			f, err := os.Open(filename)
 			Expect(err).To(BeNil())
 
-			part, err := writer.CreateFormFile(partName, filename)
+			var part io.Writer
+			if payloadContentType == "" {
+				part, err = writer.CreateFormFile(partName, filename)
+			} else {
+				h := textproto.MIMEHeader{}
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, partName, filename))
+				h.Set("Content-Type", payloadContentType)
+				part, err = writer.CreatePart(h)
+			}
 			Expect(err).To(BeNil())
 
 			_, err = io.Copy(part, f)
@@ -215,6 +244,45 @@ var _ = Describe("Deployments", func() {
 			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
 		})
 
+		Context("when deploys of the project are paused", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("deploys_paused", true).Error).To(BeNil())
+			})
+
+			It("returns 423 with deploys_paused", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(423))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "deploys_paused",
+					"error_description": "deploys of the project are paused"
+				}`))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+				depl := &deployment.Deployment{}
+				Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+			})
+
+			It("accepts deploys again once the project is unpaused", func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/foo-bar-express/unpause", nil, headers, nil)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				res.Body.Close()
+				s.Close()
+
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				depl := &deployment.Deployment{}
+				Expect(db.Last(depl).Error).To(BeNil())
+				Expect(depl.ProjectID).To(Equal(proj.ID))
+			})
+		})
+
 		Context("when the project belongs to current user", func() {
 			Context("when the request does not contain payload part", func() {
 				It("returns 422 with invalid_params", func() {
@@ -281,6 +349,7 @@ var _ = Describe("Deployments", func() {
 					Expect(call.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s-%d/raw-bundle.tar.gz", depl.Prefix, depl.ID)))
 					Expect(call.Arguments[4]).To(Equal(""))
 					Expect(call.Arguments[5]).To(Equal("private"))
+					Expect(call.SideEffects["concurrency"]).To(Equal(common.BundleUploadConcurrency))
 
 					b, err := ioutil.ReadFile("../../../testhelper/fixtures/small-website.tar.gz")
 					Expect(err).To(BeNil())
@@ -288,6 +357,249 @@ var _ = Describe("Deployments", func() {
 				})
 			})
 
+			Context("when the bundle upload concurrency is configured", func() {
+				var origBundleUploadConcurrency int
+
+				BeforeEach(func() {
+					origBundleUploadConcurrency = common.BundleUploadConcurrency
+					common.BundleUploadConcurrency = 12
+				})
+
+				AfterEach(func() {
+					common.BundleUploadConcurrency = origBundleUploadConcurrency
+				})
+
+				It("uploads the bundle with the configured concurrency", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+					Expect(fakeS3.UploadCalls.NthCall(1).SideEffects["concurrency"]).To(Equal(12))
+				})
+			})
+
+			Context("when uploading the bundle to S3 times out", func() {
+				var origBundleUploadTimeout time.Duration
+
+				BeforeEach(func() {
+					origBundleUploadTimeout = common.BundleUploadTimeout
+					common.BundleUploadTimeout = 50 * time.Millisecond
+					fakeS3.UploadTimeout = 500 * time.Millisecond
+				})
+
+				AfterEach(func() {
+					common.BundleUploadTimeout = origBundleUploadTimeout
+				})
+
+				It("returns 504 gateway timeout without leaving a deployment behind", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusGatewayTimeout))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "upload_timeout",
+						"error_description": "uploading the bundle timed out, please try again"
+					}`))
+
+					var count int
+					Expect(db.Unscoped().Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+
+					Expect(db.Model(rawbundle.RawBundle{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+				})
+
+				Context("when the upload has not read the bundle yet", func() {
+					BeforeEach(func() {
+						fakeS3.UploadTimeout = 0
+						fakeS3.UploadReadDelay = 200 * time.Millisecond
+					})
+
+					It("cancels the upload, so that it fails to read the bundle", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusGatewayTimeout))
+
+						Eventually(fakeS3.UploadCalls.Count).Should(Equal(1))
+						call := fakeS3.UploadCalls.NthCall(1)
+						Expect(call.ReturnValues[0]).NotTo(BeNil())
+						Expect(call.SideEffects["uploaded_content"]).To(BeEmpty())
+					})
+				})
+
+				Context("when the upload is stuck", func() {
+					BeforeEach(func() {
+						fakeS3.UploadTimeout = 2 * time.Second
+					})
+
+					It("responds without waiting for the upload to return", func() {
+						start := time.Now()
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusGatewayTimeout))
+						Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+					})
+				})
+			})
+
+			Context("when the payload is cut short", func() {
+				It("returns 500 internal server error without leaving a deployment behind", func() {
+					s = httptest.NewServer(server.New())
+
+					bundle, err := ioutil.ReadFile("../../../testhelper/fixtures/website.tar.gz")
+					Expect(err).To(BeNil())
+
+					// The payload part is not closed by a boundary.
+					body := &bytes.Buffer{}
+					body.WriteString("--boundary\r\n")
+					body.WriteString(`Content-Disposition: form-data; name="payload"; filename="website.tar.gz"` + "\r\n\r\n")
+					body.Write(bundle[:len(bundle)/2])
+
+					req, err := http.NewRequest("POST", s.URL+"/projects/foo-bar-express/deployments", body)
+					Expect(err).To(BeNil())
+					req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+					req.Header.Set("Authorization", "Bearer "+t.Token)
+
+					res, err = http.DefaultClient.Do(req)
+					Expect(err).To(BeNil())
+					Expect(res.StatusCode).To(Equal(http.StatusInternalServerError))
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+					var count int
+					Expect(db.Unscoped().Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+				})
+			})
+
+			Context("when uploading the bundle to S3 fails", func() {
+				BeforeEach(func() {
+					fakeS3.UploadError = errors.New("connection reset by peer")
+				})
+
+				It("returns 500 internal server error without leaving a deployment behind", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusInternalServerError))
+
+					var count int
+					Expect(db.Unscoped().Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+				})
+			})
+
+			Context("when the payload is larger than the multipart memory limit", func() {
+				var origMultipartMemoryLimit int64
+
+				BeforeEach(func() {
+					origMultipartMemoryLimit = common.MultipartMemoryLimit
+					common.MultipartMemoryLimit = 100
+				})
+
+				AfterEach(func() {
+					common.MultipartMemoryLimit = origMultipartMemoryLimit
+				})
+
+				It("uploads the payload in full", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					b, err := ioutil.ReadFile("../../../testhelper/fixtures/website.tar.gz")
+					Expect(err).To(BeNil())
+					Expect(int64(len(b))).To(BeNumerically(">", common.MultipartMemoryLimit))
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+					call := fakeS3.UploadCalls.NthCall(1)
+					Expect(call).NotTo(BeNil())
+					Expect(call.SideEffects["uploaded_content"]).To(Equal(b))
+
+					bun := &rawbundle.RawBundle{}
+					Expect(db.Last(bun).Error).To(BeNil())
+					Expect(bun.Size).To(Equal(int64(len(b))))
+				})
+			})
+
+			Context("when the payload is declared as a disallowed content type", func() {
+				BeforeEach(func() {
+					payloadContentType = "text/html; charset=utf-8"
+				})
+
+				It("returns 422 with invalid_params", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"payload": "is not an allowed content type"
+						}
+					}`))
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+					depl := &deployment.Deployment{}
+					Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+				})
+
+				Context("when the content type is allowed by configuration", func() {
+					var origAllowedBundleContentTypes []string
+
+					BeforeEach(func() {
+						origAllowedBundleContentTypes = common.AllowedBundleContentTypes
+						common.AllowedBundleContentTypes = []string{"text/html"}
+					})
+
+					AfterEach(func() {
+						common.AllowedBundleContentTypes = origAllowedBundleContentTypes
+					})
+
+					It("accepts the payload", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+					})
+				})
+			})
+
+			Context("when the payload is declared as an allowed content type but is not a bundle", func() {
+				var payloadPath string
+
+				BeforeEach(func() {
+					payloadContentType = "application/octet-stream"
+
+					f, err := ioutil.TempFile("", "payload-")
+					Expect(err).To(BeNil())
+					defer f.Close()
+					_, err = f.WriteString("<h1>this is not a bundle</h1>")
+					Expect(err).To(BeNil())
+					payloadPath = f.Name()
+				})
+
+				AfterEach(func() {
+					os.Remove(payloadPath)
+				})
+
+				It("returns 400 bad request without leaving a deployment behind", func() {
+					doRequestWithMultipart("payload", payloadPath)
+
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_request",
+						"error_description": "payload is in an unsupported format"
+					}`))
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+					var count int
+					Expect(db.Unscoped().Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+				})
+			})
+
 			Context("when the request is valid", func() {
 				var depl *deployment.Deployment
 
@@ -312,6 +624,26 @@ var _ = Describe("Deployments", func() {
 					Expect(b.String()).To(MatchJSON(expectedJSON))
 				})
 
+				It("accepts payloads declared as a gzipped tarball", func() {
+					payloadContentType = "application/gzip"
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					depl = &deployment.Deployment{}
+					Expect(db.Last(depl).Error).To(BeNil())
+					Expect(depl.ProjectID).To(Equal(proj.ID))
+				})
+
+				It("sets the Location header to the URL of the deployment", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					depl = &deployment.Deployment{}
+					Expect(db.Last(depl).Error).To(BeNil())
+
+					Expect(res.Header.Get("Location")).To(Equal(fmt.Sprintf("/projects/foo-bar-express/deployments/%d", depl.ID)))
+				})
+
 				It("uploads zip bundle", func() {
 					doRequestWithZipFile()
 
@@ -432,6 +764,18 @@ var _ = Describe("Deployments", func() {
 					Expect(trackCall.ReturnValues[0]).To(BeNil())
 				})
 
+				Context("when the project opted out of analytics", func() {
+					BeforeEach(func() {
+						Expect(db.Model(proj).Update("analytics_opt_out", true).Error).To(BeNil())
+					})
+
+					It("does not track the deployment", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+						Expect(fakeTracker.TrackCalls.Count()).To(Equal(0))
+					})
+				})
+
 				Describe("when deploying again", func() {
 					BeforeEach(func() {
 						doRequest()
@@ -510,61 +854,371 @@ var _ = Describe("Deployments", func() {
 
 						Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
 					})
-				})
-			})
-
-			Context("when bundle_checksum is specified", func() {
-				Context("when raw bundle exists", func() {
-					var (
-						depl              *deployment.Deployment
-						existingRawBundle *rawbundle.RawBundle
-						checksum          string
-					)
-
-					BeforeEach(func() {
-						checksum = "db39e098913eee20e5371139022e4431ffe7b01baa524bd87e08f2763de3ea55"
-						existingRawBundle = &rawbundle.RawBundle{
-							ProjectID:    proj.ID,
-							Checksum:     checksum,
-							UploadedPath: "deployments/pr3f1x-1234/raw-bundle.tar.gz",
-						}
-						Expect(db.Create(existingRawBundle).Error).To(BeNil())
-					})
 
-					It("returns 202 accepted", func() {
-						doRequestWithBundleChecksum(checksum)
-						depl = &deployment.Deployment{}
-						db.Last(depl)
+					Context("when the deploy has high priority", func() {
+						BeforeEach(func() {
+							fields = url.Values{"priority": {"high"}}
+						})
 
-						b := &bytes.Buffer{}
-						_, err = b.ReadFrom(res.Body)
+						It("enqueues a deploy job to the priority queue", func() {
+							doRequest()
+							depl = &deployment.Deployment{}
+							db.Last(depl)
 
-						j := map[string]interface{}{
-							"deployment": map[string]interface{}{
-								"id":      depl.ID,
-								"state":   deployment.StatePendingBuild,
-								"version": 1,
-							},
-						}
-						expectedJSON, err := json.Marshal(j)
-						Expect(err).To(BeNil())
-						Expect(b.String()).To(MatchJSON(expectedJSON))
+							Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+							d := testhelper.ConsumeQueue(mq, queues.DeployPriority)
+							Expect(d).NotTo(BeNil())
+							Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+								{
+									"deployment_id": %d,
+									"skip_webroot_upload": false,
+									"skip_invalidation": false,
+									"use_raw_bundle": true,
+									"archive_format": "tar.gz",
+									"priority": "high"
+								}
+							`, depl.ID)))
+						})
 					})
 
-					It("creates a deployment record", func() {
-						doRequestWithBundleChecksum(checksum)
-						depl = &deployment.Deployment{}
-						db.Last(depl)
+					Context("when a full upload is forced", func() {
+						BeforeEach(func() {
+							fields = url.Values{"force_full_upload": {"true"}}
+						})
 
-						Expect(depl).NotTo(BeNil())
-						Expect(depl.ProjectID).To(Equal(proj.ID))
-						Expect(depl.UserID).To(Equal(u.ID))
-						Expect(depl.State).To(Equal(deployment.StatePendingBuild))
-						Expect(depl.Prefix).NotTo(HaveLen(0))
-						Expect(depl.Version).To(Equal(int64(1)))
+						It("enqueues a deploy job that uploads every file", func() {
+							doRequest()
+							depl = &deployment.Deployment{}
+							db.Last(depl)
 
-						Expect(existingRawBundle).NotTo(BeNil())
-						Expect(*depl.RawBundleID).To(Equal(existingRawBundle.ID))
+							d := testhelper.ConsumeQueue(mq, queues.Deploy)
+							Expect(d).NotTo(BeNil())
+							Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+								{
+									"deployment_id": %d,
+									"skip_webroot_upload": false,
+									"skip_invalidation": false,
+									"use_raw_bundle": true,
+									"archive_format": "tar.gz",
+									"force_full_upload": true
+								}
+							`, depl.ID)))
+						})
+					})
+
+					Context("when the project has default deploy options", func() {
+						BeforeEach(func() {
+							proj.DefaultDeployOptions = []byte(`{"priority": "high", "skip_invalidation": true, "skip_js_env": true}`)
+							Expect(db.Save(proj).Error).To(BeNil())
+						})
+
+						It("enqueues a deploy job with the default options", func() {
+							doRequest()
+							depl = &deployment.Deployment{}
+							db.Last(depl)
+							Expect(depl.SkipJsEnv).To(BeTrue())
+
+							Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+							d := testhelper.ConsumeQueue(mq, queues.DeployPriority)
+							Expect(d).NotTo(BeNil())
+							Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+								{
+									"deployment_id": %d,
+									"skip_webroot_upload": false,
+									"skip_invalidation": true,
+									"use_raw_bundle": true,
+									"archive_format": "tar.gz",
+									"priority": "high"
+								}
+							`, depl.ID)))
+						})
+
+						Context("when the request sets the options", func() {
+							BeforeEach(func() {
+								fields = url.Values{
+									"priority":          {"normal"},
+									"skip_invalidation": {"false"},
+									"skip_js_env":       {"false"},
+								}
+							})
+
+							It("enqueues a deploy job with the options of the request", func() {
+								doRequest()
+								depl = &deployment.Deployment{}
+								db.Last(depl)
+								Expect(depl.SkipJsEnv).To(BeFalse())
+
+								Expect(testhelper.ConsumeQueue(mq, queues.DeployPriority)).To(BeNil())
+
+								d := testhelper.ConsumeQueue(mq, queues.Deploy)
+								Expect(d).NotTo(BeNil())
+								Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+									{
+										"deployment_id": %d,
+										"skip_webroot_upload": false,
+										"skip_invalidation": false,
+										"use_raw_bundle": true,
+										"archive_format": "tar.gz"
+									}
+								`, depl.ID)))
+							})
+						})
+
+						Context("when the request sets only some of the options", func() {
+							BeforeEach(func() {
+								fields = url.Values{"force_full_upload": {"true"}}
+							})
+
+							It("merges them with the default options", func() {
+								doRequest()
+								depl = &deployment.Deployment{}
+								db.Last(depl)
+
+								d := testhelper.ConsumeQueue(mq, queues.DeployPriority)
+								Expect(d).NotTo(BeNil())
+								Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+									{
+										"deployment_id": %d,
+										"skip_webroot_upload": false,
+										"skip_invalidation": true,
+										"use_raw_bundle": true,
+										"archive_format": "tar.gz",
+										"priority": "high",
+										"force_full_upload": true
+									}
+								`, depl.ID)))
+							})
+						})
+					})
+				})
+
+				Context("when the project has default deploy options", func() {
+					BeforeEach(func() {
+						proj.DefaultDeployOptions = []byte(`{"priority": "high", "skip_invalidation": true}`)
+						Expect(db.Save(proj).Error).To(BeNil())
+					})
+
+					It("passes them on to the build job", func() {
+						doRequest()
+						depl = &deployment.Deployment{}
+						db.Last(depl)
+
+						d := testhelper.ConsumeQueue(mq, queues.Build)
+						Expect(d).NotTo(BeNil())
+						Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+							{
+								"deployment_id": %d,
+								"archive_format": "tar.gz",
+								"priority": "high",
+								"skip_invalidation": true
+							}
+						`, depl.ID)))
+					})
+				})
+
+				Context("when a full upload is forced", func() {
+					BeforeEach(func() {
+						fields = url.Values{"force_full_upload": {"true"}}
+					})
+
+					It("passes it on to the build job", func() {
+						doRequest()
+						depl = &deployment.Deployment{}
+						db.Last(depl)
+
+						d := testhelper.ConsumeQueue(mq, queues.Build)
+						Expect(d).NotTo(BeNil())
+						Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+							{
+								"deployment_id": %d,
+								"archive_format": "tar.gz",
+								"force_full_upload": true
+							}
+						`, depl.ID)))
+					})
+				})
+
+				Context("when the deploy has high priority", func() {
+					BeforeEach(func() {
+						fields = url.Values{"priority": {"high"}}
+					})
+
+					It("passes the priority on to the build job", func() {
+						doRequest()
+						depl = &deployment.Deployment{}
+						db.Last(depl)
+
+						d := testhelper.ConsumeQueue(mq, queues.Build)
+						Expect(d).NotTo(BeNil())
+						Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+							{
+								"deployment_id": %d,
+								"archive_format": "tar.gz",
+								"priority": "high"
+							}
+						`, depl.ID)))
+					})
+				})
+
+				Context("when the priority is invalid", func() {
+					BeforeEach(func() {
+						fields = url.Values{"priority": {"urgent"}}
+					})
+
+					It("returns 422 and does not create a deployment", func() {
+						doRequest()
+
+						b := &bytes.Buffer{}
+						_, err := b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(422))
+						Expect(b.String()).To(MatchJSON(`{
+							"error": "invalid_params",
+							"errors": {
+								"priority": "is invalid"
+							}
+						}`))
+
+						count := 0
+						Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+						Expect(count).To(BeZero())
+					})
+				})
+
+				Context("when skip_js_env is set", func() {
+					BeforeEach(func() {
+						fields = url.Values{"skip_js_env": {"true"}}
+					})
+
+					It("creates a deployment that skips the JS environment", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+						depl = &deployment.Deployment{}
+						Expect(db.Last(depl).Error).To(BeNil())
+						Expect(depl.SkipJsEnv).To(BeTrue())
+					})
+				})
+			})
+
+			Context("when bundle_checksum is specified", func() {
+				Context("when raw bundle exists", func() {
+					var (
+						depl              *deployment.Deployment
+						existingRawBundle *rawbundle.RawBundle
+						checksum          string
+					)
+
+					BeforeEach(func() {
+						checksum = "db39e098913eee20e5371139022e4431ffe7b01baa524bd87e08f2763de3ea55"
+						existingRawBundle = &rawbundle.RawBundle{
+							ProjectID:    proj.ID,
+							Checksum:     checksum,
+							UploadedPath: "deployments/pr3f1x-1234/raw-bundle.tar.gz",
+						}
+						Expect(db.Create(existingRawBundle).Error).To(BeNil())
+					})
+
+					It("returns 202 accepted", func() {
+						doRequestWithBundleChecksum(checksum)
+						depl = &deployment.Deployment{}
+						db.Last(depl)
+
+						b := &bytes.Buffer{}
+						_, err = b.ReadFrom(res.Body)
+
+						j := map[string]interface{}{
+							"deployment": map[string]interface{}{
+								"id":      depl.ID,
+								"state":   deployment.StatePendingBuild,
+								"version": 1,
+							},
+						}
+						expectedJSON, err := json.Marshal(j)
+						Expect(err).To(BeNil())
+						Expect(b.String()).To(MatchJSON(expectedJSON))
+					})
+
+					It("creates a deployment record", func() {
+						doRequestWithBundleChecksum(checksum)
+						depl = &deployment.Deployment{}
+						db.Last(depl)
+
+						Expect(depl).NotTo(BeNil())
+						Expect(depl.ProjectID).To(Equal(proj.ID))
+						Expect(depl.UserID).To(Equal(u.ID))
+						Expect(depl.State).To(Equal(deployment.StatePendingBuild))
+						Expect(depl.Prefix).NotTo(HaveLen(0))
+						Expect(depl.Version).To(Equal(int64(1)))
+
+						Expect(existingRawBundle).NotTo(BeNil())
+						Expect(*depl.RawBundleID).To(Equal(existingRawBundle.ID))
+					})
+
+					It("saves the annotations of the deployment", func() {
+						doRequestWithForm(url.Values{
+							"bundle_checksum": {checksum},
+							"label":           {"  launch day "},
+							"branch":          {"master"},
+							"commit":          {"5e908dc1f01e"},
+						})
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+						depl = &deployment.Deployment{}
+						db.Last(depl)
+
+						Expect(depl.Label).NotTo(BeNil())
+						Expect(*depl.Label).To(Equal("launch day"))
+						Expect(depl.Branch).NotTo(BeNil())
+						Expect(*depl.Branch).To(Equal("master"))
+						Expect(depl.CommitSHA).NotTo(BeNil())
+						Expect(*depl.CommitSHA).To(Equal("5e908dc1f01e"))
+					})
+
+					Context("when the request comes through a trusted proxy", func() {
+						var origTrustedProxies []*net.IPNet
+
+						BeforeEach(func() {
+							origTrustedProxies = common.TrustedProxies
+
+							_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+							Expect(err).To(BeNil())
+							common.TrustedProxies = []*net.IPNet{loopback}
+						})
+
+						AfterEach(func() {
+							common.TrustedProxies = origTrustedProxies
+						})
+
+						It("records the client the deployment was created from", func() {
+							headers.Set("User-Agent", "PubStorm CLI/1.2.0")
+							headers.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.9")
+							doRequestWithBundleChecksum(checksum)
+							Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+							depl = &deployment.Deployment{}
+							db.Last(depl)
+
+							Expect(depl.CreatedByUserAgent).NotTo(BeNil())
+							Expect(*depl.CreatedByUserAgent).To(Equal("PubStorm CLI/1.2.0"))
+							Expect(depl.CreatedByIP).NotTo(BeNil())
+							Expect(*depl.CreatedByIP).To(Equal("203.0.113.9"))
+						})
+					})
+
+					It("does not record a spoofed forwarded address of the client", func() {
+						headers.Set("X-Forwarded-For", "203.0.113.9")
+						doRequestWithBundleChecksum(checksum)
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+						depl = &deployment.Deployment{}
+						db.Last(depl)
+
+						Expect(depl.CreatedByIP).NotTo(BeNil())
+						Expect(*depl.CreatedByIP).NotTo(Equal("203.0.113.9"))
 					})
 
 					It("does not upload bundle to s3", func() {
@@ -590,6 +1244,98 @@ var _ = Describe("Deployments", func() {
 						`, depl.ID)))
 					})
 
+					Context("when the project derives prefixes from content", func() {
+						var otherChecksum string
+
+						BeforeEach(func() {
+							Expect(db.Model(proj).Update("content_hash_prefixes", true).Error).To(BeNil())
+
+							otherChecksum = "5c3a8d5a2c4e41bd8a2a0a3c42df5ea6e1b17f6c19c1ba4dbbc0c6e4f9a3e0d2"
+							Expect(db.Create(&rawbundle.RawBundle{
+								ProjectID:    proj.ID,
+								Checksum:     otherChecksum,
+								UploadedPath: "deployments/pr3f1x-5678/raw-bundle.tar.gz",
+							}).Error).To(BeNil())
+						})
+
+						deploy := func(checksum string) *deployment.Deployment {
+							doRequestWithBundleChecksum(checksum)
+							Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+							res.Body.Close()
+							s.Close()
+
+							d := &deployment.Deployment{}
+							Expect(db.Last(d).Error).To(BeNil())
+							return d
+						}
+
+						It("gives deployments of identical bundles the same prefix", func() {
+							d1 := deploy(checksum)
+							d2 := deploy(checksum)
+
+							Expect(d1.ID).NotTo(Equal(d2.ID))
+							Expect(d1.Prefix).To(Equal(deployment.ContentHashPrefix(proj.ID, checksum, nil)))
+							Expect(d2.Prefix).To(Equal(d1.Prefix))
+						})
+
+						It("leaves the deployment ID out of the preview URL", func() {
+							d1 := deploy(checksum)
+							d2 := deploy(checksum)
+
+							Expect(d1.OpaquePrefix).To(BeTrue())
+							Expect(d1.PrefixID()).To(Equal(d1.Prefix))
+							Expect(d1.PreviewURL()).To(Equal("https://" + d1.Prefix + "." + shared.PublicBaseDomain))
+							Expect(d2.PreviewURL()).To(Equal(d1.PreviewURL()))
+						})
+
+						It("gives deployments of identical bundles with different JS environments different prefixes", func() {
+							d1 := deploy(checksum)
+							Expect(db.Model(d1).Update("js_env_vars", []byte(`{"API_URL": "https://api.example.com"}`)).Error).To(BeNil())
+							Expect(db.Model(proj).Update("active_deployment_id", d1.ID).Error).To(BeNil())
+
+							d2 := deploy(checksum)
+							Expect(d2.Prefix).To(Equal(deployment.ContentHashPrefix(proj.ID, checksum, map[string]string{
+								"API_URL": "https://api.example.com",
+							})))
+							Expect(d2.Prefix).NotTo(Equal(d1.Prefix))
+						})
+
+						It("gives deployments of different bundles different prefixes", func() {
+							d1 := deploy(checksum)
+							d2 := deploy(otherChecksum)
+
+							Expect(d1.Prefix).To(Equal(deployment.ContentHashPrefix(proj.ID, checksum, nil)))
+							Expect(d2.Prefix).To(Equal(deployment.ContentHashPrefix(proj.ID, otherChecksum, nil)))
+							Expect(d2.Prefix).NotTo(Equal(d1.Prefix))
+						})
+					})
+
+					Context("when deployment prefixes are opaque", func() {
+						var origOpaque bool
+
+						BeforeEach(func() {
+							origOpaque = shared.OpaqueDeploymentPrefixes
+							shared.OpaqueDeploymentPrefixes = true
+						})
+
+						AfterEach(func() {
+							shared.OpaqueDeploymentPrefixes = origOpaque
+						})
+
+						It("leaves the deployment ID out of the prefix", func() {
+							doRequestWithBundleChecksum(checksum)
+							Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+							depl = &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(BeNil())
+
+							Expect(depl.OpaquePrefix).To(BeTrue())
+							Expect(depl.Prefix).To(MatchRegexp(`\A[0-9a-f]{16}\z`))
+							Expect(depl.PrefixID()).To(Equal(depl.Prefix))
+							Expect(depl.PreviewDomainName()).To(Equal(depl.Prefix + "." + shared.PublicBaseDomain))
+						})
+					})
+
 					Context("when the raw bundle is not associated with the project", func() {
 						BeforeEach(func() {
 							proj2 := factories.Project(db, u)
@@ -757,6 +1503,7 @@ var _ = Describe("Deployments", func() {
 						db.Last(depl)
 
 						Expect(call.Arguments[3]).To(Equal("deployments/" + depl.PrefixID() + "/raw-bundle.zip"))
+						Expect(call.Arguments[4]).To(Equal("private"))
 					})
 
 					It("enqueues a build job", func() {
@@ -822,6 +1569,77 @@ var _ = Describe("Deployments", func() {
 				})
 			})
 
+			Context("when authenticated with a deploy token of the project", func() {
+				var dt *oauthtoken.OauthToken
+
+				BeforeEach(func() {
+					dt = factories.DeployToken(db, t, proj)
+					headers = http.Header{
+						"Authorization": {"Bearer " + dt.Token},
+					}
+				})
+
+				It("creates a deployment and records when the token was used", func() {
+					Expect(dt.LastUsedAt).To(BeNil())
+
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					depl := &deployment.Deployment{}
+					Expect(db.Last(depl).Error).To(BeNil())
+					Expect(depl.ProjectID).To(Equal(proj.ID))
+					Expect(depl.UserID).To(Equal(u.ID))
+
+					Expect(db.First(dt, dt.ID).Error).To(BeNil())
+					Expect(dt.LastUsedAt).NotTo(BeNil())
+				})
+
+				Context("when the token has been revoked", func() {
+					BeforeEach(func() {
+						Expect(db.Delete(dt).Error).To(BeNil())
+					})
+
+					It("returns 401 unauthorized and does not create a deployment", func() {
+						doRequest()
+
+						Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+
+						count := 0
+						Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+						Expect(count).To(BeZero())
+					})
+				})
+			})
+
+			Context("when authenticated with a deploy token of another project", func() {
+				BeforeEach(func() {
+					otherProj := factories.Project(db, u)
+					dt := factories.DeployToken(db, t, otherProj)
+					headers = http.Header{
+						"Authorization": {"Bearer " + dt.Token},
+					}
+				})
+
+				It("returns 403 forbidden and does not create a deployment", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "insufficient_scope",
+						"error_description": "access token is not allowed to perform this action"
+					}`))
+
+					count := 0
+					Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+					Expect(count).To(BeZero())
+				})
+			})
+
 			Context("when the request is valid and previous active deployment exists", func() {
 				var depl *deployment.Deployment
 
@@ -848,8 +1666,41 @@ var _ = Describe("Deployments", func() {
 					Expect(depl.JsEnvVars).To(Equal([]byte(`{"foo":"bar","express":"com"}`)))
 				})
 			})
-		})
-	})
+
+			Context("when the js env vars of the previous active deployment are invalid", func() {
+				BeforeEach(func() {
+					prevDepl := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+						JsEnvVars: []byte(`{"foo":1}`),
+						State:     deployment.StateDeployed,
+					})
+					proj.ActiveDeploymentID = &prevDepl.ID
+					Expect(db.Save(proj).Error).To(BeNil())
+				})
+
+				It("returns 422 and does not create a deployment", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"js_env_vars": "must be a JSON object of strings"
+						}
+					}`))
+
+					count := 0
+					Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(1))
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+				})
+			})
+		})
+	})
 
 	Describe("GET /projects/:project_name/deployments/:id", func() {
 		var (
@@ -929,6 +1780,210 @@ var _ = Describe("Deployments", func() {
 				Expect(err).To(BeNil())
 				Expect(b.String()).To(MatchJSON(expectedJSON))
 			})
+
+			Context("when the deployment has been deployed", func() {
+				var origPublicBaseDomain string
+
+				BeforeEach(func() {
+					Expect(depl.UpdateState(db, deployment.StateDeployed)).To(BeNil())
+
+					origPublicBaseDomain = shared.PublicBaseDomain
+					shared.PublicBaseDomain = "preview.example.com"
+				})
+
+				AfterEach(func() {
+					shared.PublicBaseDomain = origPublicBaseDomain
+				})
+
+				It("includes the preview URL under the configured base domain", func() {
+					doRequest()
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					var d deployment.Deployment
+					Expect(db.First(&d, depl.ID).Error).To(BeNil())
+					j := map[string]interface{}{
+						"deployment": map[string]interface{}{
+							"id":            d.ID,
+							"state":         deployment.StateDeployed,
+							"deployed_at":   d.DeployedAt,
+							"version":       d.Version,
+							"error_message": d.ErrorMessage,
+							"preview_url":   fmt.Sprintf("https://a1b2c3-%d.preview.example.com", d.ID),
+						},
+					}
+					expectedJSON, err := json.Marshal(j)
+					Expect(err).To(BeNil())
+					Expect(b.String()).To(MatchJSON(expectedJSON))
+				})
+
+				Context("when it and the previous deployment have manifests", func() {
+					BeforeEach(func() {
+						prevDepl := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+							State:      deployment.StateDeployed,
+							DeployedAt: timeAgo(-2 * time.Hour),
+						})
+						Expect(prevDepl.UpdateManifest(db, deployment.Manifest{
+							"index.html": {Size: 10, ETag: "a"},
+							"app.js":     {Size: 20, ETag: "b"},
+							"old.css":    {Size: 30, ETag: "c"},
+						})).To(BeNil())
+
+						Expect(depl.UpdateManifest(db, deployment.Manifest{
+							"index.html": {Size: 10, ETag: "a"},
+							"app.js":     {Size: 21, ETag: "d"},
+							"new.css":    {Size: 30, ETag: "e", CompressedSize: 12},
+							"logo.png":   {Size: 40, ETag: "f"},
+						})).To(BeNil())
+					})
+
+					It("includes the bytes that serving each of its files once transfers", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+						var j struct {
+							Deployment struct {
+								Egress map[string]int64 `json:"egress"`
+							} `json:"deployment"`
+						}
+						Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+						Expect(j.Deployment.Egress).To(Equal(map[string]int64{
+							"transferable_bytes":         10 + 21 + 30 + 40,
+							"gzipped_transferable_bytes": 10 + 21 + 12 + 40,
+						}))
+					})
+
+					It("includes a summary of the changes since the previous deployment", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+						var j struct {
+							Deployment struct {
+								Changes map[string]int `json:"changes"`
+							} `json:"deployment"`
+						}
+						Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+						Expect(j.Deployment.Changes).To(Equal(map[string]int{
+							"added":   2,
+							"removed": 1,
+							"changed": 1,
+						}))
+					})
+				})
+			})
+		})
+
+		Context("when the deployment failed because its bundle is corrupt", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Updates(map[string]interface{}{
+					"state":         deployment.StateDeployFailed,
+					"error_message": "bundle is corrupt",
+					"error_code":    deployment.ErrorCodeCorruptBundle,
+				}).Error).To(BeNil())
+			})
+
+			It("includes the error message and code", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var j struct {
+					Deployment map[string]interface{} `json:"deployment"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+				Expect(j.Deployment["state"]).To(Equal("deploy_failed"))
+				Expect(j.Deployment["error_message"]).To(Equal("bundle is corrupt"))
+				Expect(j.Deployment["error_code"]).To(Equal("corrupt_bundle"))
+			})
+		})
+
+		Context("when a deployer has picked up the deployment", func() {
+			BeforeEach(func() {
+				createdAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+				Expect(db.Model(depl).Updates(map[string]interface{}{
+					"created_at":        createdAt,
+					"deploy_started_at": createdAt.Add(90 * time.Second),
+				}).Error).To(BeNil())
+			})
+
+			It("includes when it was picked up and how long it waited", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var j struct {
+					Deployment map[string]interface{} `json:"deployment"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+				Expect(j.Deployment["deploy_started_at"]).NotTo(BeNil())
+				Expect(j.Deployment["queue_wait_seconds"]).To(Equal(90.0))
+			})
+		})
+
+		Context("when the client the deployment was created from is recorded", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Updates(map[string]interface{}{
+					"created_by_user_agent": "PubStorm CLI/1.2.0",
+					"created_by_ip":         "203.0.113.9",
+				}).Error).To(BeNil())
+			})
+
+			It("includes the client for the owner of the project", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var j struct {
+					Deployment map[string]interface{} `json:"deployment"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+				Expect(j.Deployment["created_by_user_agent"]).To(Equal("PubStorm CLI/1.2.0"))
+				Expect(j.Deployment["created_by_ip"]).To(Equal("203.0.113.9"))
+			})
+
+			Context("when the current user is a collaborator", func() {
+				BeforeEach(func() {
+					collab, _, collabToken := factories.AuthTrio(db)
+					Expect(proj.AddCollaborator(db, collab)).To(BeNil())
+
+					headers = http.Header{
+						"Authorization": {"Bearer " + collabToken.Token},
+					}
+				})
+
+				It("leaves the client out", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					var j struct {
+						Deployment map[string]interface{} `json:"deployment"`
+					}
+					Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+					Expect(j.Deployment).NotTo(HaveKey("created_by_user_agent"))
+					Expect(j.Deployment).NotTo(HaveKey("created_by_ip"))
+				})
+			})
+		})
+
+		Context("when a read replica is configured", func() {
+			var (
+				replica  *gorm.DB
+				recorder *queryRecorder
+			)
+
+			BeforeEach(func() {
+				replica, recorder = useReplica()
+			})
+
+			AfterEach(func() {
+				stopUsingReplica(replica)
+			})
+
+			It("fetches the deployment from the replica", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(recorder.Queries()).To(ContainElement(ContainSubstring(`FROM "deployments"`)))
+			})
 		})
 
 		Context("the deployment does not exist", func() {
@@ -970,222 +2025,287 @@ var _ = Describe("Deployments", func() {
 		})
 	})
 
-	Describe("GET /projects/:project_name/deployments/:id/download", func() {
+	Describe("GET /projects/:project_name/deployments/:id/meta_diff", func() {
 		var (
 			err error
 
-			fakeS3 *fake.S3
-			origS3 filetransfer.FileTransfer
-
 			u *user.User
 			t *oauthtoken.OauthToken
 
 			headers http.Header
 			proj    *project.Project
-			depl    *deployment.Deployment
-			bun     *rawbundle.RawBundle
+			depl1   *deployment.Deployment
+			depl2   *deployment.Deployment
+
+			against string
 		)
 
 		BeforeEach(func() {
-			origS3 = s3client.S3
-			fakeS3 = &fake.S3{}
-			s3client.S3 = fakeS3
-
 			u, _, t = factories.AuthTrio(db)
 
-			headers = http.Header{
-				"Authorization": {"Bearer " + t.Token},
-			}
-
 			proj = &project.Project{
 				Name:   "foo-bar-express",
 				UserID: u.ID,
 			}
 			Expect(db.Create(proj).Error).To(BeNil())
 
-			bun = factories.RawBundle(db, proj)
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
 
-			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
-				Prefix:      "a1b2c3",
-				State:       deployment.StateDeployed,
-				DeployedAt:  timeAgo(-1 * time.Hour),
-				RawBundleID: &bun.ID,
+			depl1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:          "a1b2c3",
+				State:           deployment.StateDeployed,
+				ProjectSettings: []byte(`{"force_https": false}`),
+			})
+			depl2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:          "d4e5f6",
+				State:           deployment.StateDeployed,
+				ProjectSettings: []byte(`{"force_https": true}`),
 			})
-		})
 
-		AfterEach(func() {
-			s3client.S3 = origS3
+			against = fmt.Sprintf("%d", depl1.ID)
 		})
 
 		doRequest := func() {
 			s = httptest.NewServer(server.New())
-			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/download", s.URL, depl.ID)
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/meta_diff?against=%s", s.URL, depl2.ID, against)
 			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
 			Expect(err).To(BeNil())
 		}
 
-		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
-			return db, u, &headers
-		}, func() *http.Response {
+		It("returns the differences between the metas of the deployments", func() {
 			doRequest()
-			return res
-		}, nil)
 
-		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
-			return db, u, proj
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
 
-		Context("when the deployment does not exist", func() {
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"meta_diff": {
+					"deployment": {
+						"prefix": "%[2]s",
+						"force_https": true
+					},
+					"against": {
+						"prefix": "%[1]s"
+					},
+					"changes": [
+						{
+							"field": "force_https",
+							"from": null,
+							"to": true
+						},
+						{
+							"field": "prefix",
+							"from": "%[1]s",
+							"to": "%[2]s"
+						}
+					]
+				}
+			}`, depl1.PrefixID(), depl2.PrefixID())))
+		})
+
+		Context("when a deployment has no snapshot of the project settings", func() {
 			BeforeEach(func() {
-				Expect(db.Delete(depl).Error).To(BeNil())
+				Expect(db.Model(depl1).Update("project_settings", nil).Error).To(BeNil())
+				Expect(db.Model(proj).Update("force_https", true).Error).To(BeNil())
 			})
 
-			It("responds with 404 Not Found", func() {
+			It("uses the current settings of the project", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
 				_, err = b.ReadFrom(res.Body)
-
-				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
-				Expect(b.String()).To(MatchJSON(`{
-					"error": "not_found",
-					"error_description": "deployment could not be found"
-				}`))
-			})
-		})
-
-		Context("when the deployment id is not a number", func() {
-			BeforeEach(func() {
-				s = httptest.NewServer(server.New())
-				url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/cafebabe", s.URL)
-				res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
 				Expect(err).To(BeNil())
-			})
-
-			It("responds with 404 Not Found", func() {
-				b := &bytes.Buffer{}
-				_, err = b.ReadFrom(res.Body)
 
-				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
-				Expect(b.String()).To(MatchJSON(`{
-					"error": "not_found",
-					"error_description": "deployment could not be found"
-				}`))
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"meta_diff": {
+						"deployment": {
+							"prefix": "%[2]s",
+							"force_https": true
+						},
+						"against": {
+							"prefix": "%[1]s",
+							"force_https": true
+						},
+						"changes": [
+							{
+								"field": "prefix",
+								"from": "%[1]s",
+								"to": "%[2]s"
+							}
+						]
+					}
+				}`, depl1.PrefixID(), depl2.PrefixID())))
 			})
 		})
 
-		Context("when the deployment does not have an associated RawBundle", func() {
+		Context("when the deployment to compare against is of another project", func() {
 			BeforeEach(func() {
-				depl.RawBundleID = nil
-				Expect(db.Save(depl).Error).To(BeNil())
+				other := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+				against = fmt.Sprintf("%d", other.ID)
 			})
 
-			It("responds with 404 Not Found", func() {
+			It("returns 422 with invalid_params", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
 				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(res.StatusCode).To(Equal(422))
 				Expect(b.String()).To(MatchJSON(`{
-					"error": "not_found",
-					"error_description": "deployment cannot be downloaded"
+					"error": "invalid_params",
+					"errors": {
+						"against": "is not that of a deployment of the project"
+					}
 				}`))
 			})
 		})
 
-		Context("when the deployment's associated RawBundle does not exist", func() {
-			BeforeEach(func() {
-				Expect(db.Delete(bun).Error).To(BeNil())
-			})
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-			It("responds with 410 Gone", func() {
-				doRequest()
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
 
-				b := &bytes.Buffer{}
-				_, err = b.ReadFrom(res.Body)
+	Describe("GET /projects/:project_name/deployments/:id/vanity_url", func() {
+		var (
+			err error
 
-				Expect(res.StatusCode).To(Equal(http.StatusGone))
-				Expect(b.String()).To(MatchJSON(`{
-					"error": "gone",
-					"error_description": "deployment can no longer be downloaded"
-				}`))
-			})
-		})
+			u *user.User
+			t *oauthtoken.OauthToken
 
-		Context("when raw bundle does not exist on S3", func() {
-			BeforeEach(func() {
-				fakeS3.ExistsReturn = false
-			})
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
 
-			It("responds with 410 Gone", func() {
-				doRequest()
+			origPublicBaseDomain string
+		)
 
-				b := &bytes.Buffer{}
-				_, err = b.ReadFrom(res.Body)
+		BeforeEach(func() {
+			origPublicBaseDomain = shared.PublicBaseDomain
+			shared.PublicBaseDomain = "preview.example.com"
 
-				Expect(res.StatusCode).To(Equal(http.StatusGone))
-				Expect(b.String()).To(MatchJSON(`{
-					"error": "gone",
-					"error_description": "deployment can no longer be downloaded"
-				}`))
+			u, _, t = factories.AuthTrio(db)
 
-				Expect(fakeS3.ExistsCalls.Count()).To(Equal(1))
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a1b2c3",
+				State:  deployment.StateDeployed,
 			})
 		})
 
-		Context("when raw bundle exists on S3", func() {
+		AfterEach(func() {
+			shared.PublicBaseDomain = origPublicBaseDomain
+		})
+
+		doRequest := func(id uint) {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/vanity_url", s.URL, id)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the vanity URL of the deployment", func() {
+			doRequest(depl.ID)
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"vanity_url": "https://preview.example.com/foo-bar-express/a1b2c3-%d"
+			}`, depl.ID)))
+		})
+
+		Context("when the deployment has not been deployed", func() {
 			BeforeEach(func() {
-				fakeS3.ExistsReturn = true
-				fakeS3.PresignedURLReturn = "https://s3-us-west-2.amazonaws.com/deployments/abcd/raw-bundle.zip?abc=123"
+				Expect(db.Model(depl).Update("state", deployment.StatePendingDeploy).Error).To(BeNil())
 			})
 
-			It("responds with a pre-signed download URL of the raw bundle in S3", func() {
-				doRequest()
+			It("returns 404 not found", func() {
+				doRequest(depl.ID)
 
-				Expect(fakeS3.PresignedURLCalls.Count()).To(Equal(1))
-				call := fakeS3.PresignedURLCalls.NthCall(1)
-				Expect(call).NotTo(BeNil())
-				Expect(call.Arguments[0]).To(Equal(s3client.BucketRegion))
-				Expect(call.Arguments[1]).To(Equal(s3client.BucketName))
-				Expect(call.Arguments[2]).To(Equal(bun.UploadedPath))
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment has not been deployed"
+				}`))
+			})
+		})
+
+		Context("when the deployment is of another project", func() {
+			It("returns 404 not found", func() {
+				other := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+				doRequest(other.ID)
 
 				b := &bytes.Buffer{}
 				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"url": "%s"
-				}`, fakeS3.PresignedURLReturn)))
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
 			})
 		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(depl.ID)
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest(depl.ID)
+			return res
+		}, nil)
 	})
 
-	Describe("POST /projects/:project_name/rollback", func() {
+	Describe("GET /projects/:project_name/deployments/:id/files", func() {
 		var (
 			err error
 
 			fakeS3 *fake.S3
 			origS3 filetransfer.FileTransfer
 
-			mq *amqp.Connection
-
 			u *user.User
 			t *oauthtoken.OauthToken
 
-			params  url.Values
 			headers http.Header
 			proj    *project.Project
-
-			dm1 *domain.Domain
-			dm2 *domain.Domain
-
-			depl1 *deployment.Deployment
-			depl2 *deployment.Deployment
-			depl3 *deployment.Deployment
+			depl    *deployment.Deployment
 		)
 
 		BeforeEach(func() {
@@ -1193,11 +2313,6 @@ var _ = Describe("Deployments", func() {
 			fakeS3 = &fake.S3{}
 			s3client.S3 = fakeS3
 
-			mq, err = mqconn.MQ()
-			Expect(err).To(BeNil())
-
-			testhelper.DeleteQueue(mq, queues.All...)
-
 			u, _, t = factories.AuthTrio(db)
 
 			proj = &project.Project{
@@ -1206,363 +2321,2356 @@ var _ = Describe("Deployments", func() {
 			}
 			Expect(db.Create(proj).Error).To(BeNil())
 
-			dm1 = factories.Domain(db, proj)
-			dm2 = factories.Domain(db, proj)
-
 			headers = http.Header{
 				"Authorization": {"Bearer " + t.Token},
 			}
 
-			depl1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
-				Prefix:     "a1b2c3",
-				State:      deployment.StateDeployed,
-				DeployedAt: timeAgo(3 * time.Hour),
-			})
-
-			depl2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
-				Prefix: "a7b8c9",
-				State:  deployment.StateUploaded,
-			})
-
-			depl3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
-				Prefix:     "d1e2f3",
-				State:      deployment.StateDeployed,
-				DeployedAt: timeAgo(1 * time.Hour),
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a1b2c3",
+				State:  deployment.StateDeployed,
 			})
-
-			var currentDeplID = depl3.ID
-			proj.ActiveDeploymentID = &currentDeplID
-			Expect(db.Save(proj).Error).To(BeNil())
 		})
 
 		AfterEach(func() {
 			s3client.S3 = origS3
 		})
 
-		doRequest := func() {
+		doRequest := func(id uint, params url.Values) {
 			s = httptest.NewServer(server.New())
-			url := fmt.Sprintf("%s/projects/foo-bar-express/rollback", s.URL)
-			res, err = testhelper.MakeRequest("POST", url, params, headers, nil)
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/files?%s", s.URL, id, params.Encode())
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
 			Expect(err).To(BeNil())
 		}
 
-		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
-			return db, u, &headers
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
-
-		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
+		Context("when the deployment has a manifest", func() {
+			BeforeEach(func() {
+				Expect(depl.UpdateManifest(db, deployment.Manifest{
+					"index.html":      {Size: 12, ETag: "a"},
+					"css/app.css":     {Size: 34, ETag: "b", Encodings: []string{"gzip"}},
+					"images/logo.png": {Size: 56, ETag: "c"},
+				})).To(BeNil())
+			})
 
-		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
+			It("returns the paths of the files in the manifest", func() {
+				doRequest(depl.ID, nil)
 
-		Context("when the version is not specified", func() {
-			It("returns 202 accepted", func() {
-				doRequest()
 				b := &bytes.Buffer{}
 				_, err = b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "manifest",
+					"files": ["css/app.css", "images/logo.png", "index.html"]
+				}`))
 
-				var d deployment.Deployment
-				Expect(db.First(&d, depl1.ID).Error).To(BeNil())
-				j := map[string]interface{}{
-					"deployment": map[string]interface{}{
-						"id":          d.ID,
-						"state":       deployment.StatePendingRollback,
-						"deployed_at": d.DeployedAt,
-						"version":     d.Version,
-					},
-				}
-				expectedJSON, err := json.Marshal(j)
-				Expect(err).To(BeNil())
-				Expect(b.String()).To(MatchJSON(expectedJSON))
+				Expect(fakeS3.ListCalls.Count()).To(Equal(0))
 			})
 
-			It("enqueues a build job", func() {
-				doRequest()
+			It("paginates the paths", func() {
+				doRequest(depl.ID, url.Values{"limit": {"2"}})
 
-				d := testhelper.ConsumeQueue(mq, queues.Deploy)
-				Expect(d).NotTo(BeNil())
-				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
-					{
-						"deployment_id": %d,
-						"skip_webroot_upload": true,
-						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}
-				`, depl1.ID)))
-			})
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-			It("marks the deployment as 'pending_rollback'", func() {
-				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "manifest",
+					"files": ["css/app.css", "images/logo.png"],
+					"next_after": "images/logo.png"
+				}`))
 
-				var updatedDeployment deployment.Deployment
-				Expect(db.First(&updatedDeployment, depl1.ID).Error).To(BeNil())
-				Expect(updatedDeployment.State).To(Equal(deployment.StatePendingRollback))
-			})
+				res.Body.Close()
+				s.Close()
+				doRequest(depl.ID, url.Values{"limit": {"2"}, "after": {"images/logo.png"}})
 
-			It("tracks an 'Initiated Project Rollback' event", func() {
-				doRequest()
+				b = &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-				trackCall := fakeTracker.TrackCalls.NthCall(1)
-				Expect(trackCall).NotTo(BeNil())
-				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
-				Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Rollback"))
-				Expect(trackCall.Arguments[2]).To(Equal(""))
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "manifest",
+					"files": ["index.html"]
+				}`))
+			})
 
-				t := trackCall.Arguments[3]
-				props, ok := t.(map[string]interface{})
-				Expect(ok).To(BeTrue())
-				Expect(props["projectName"]).To(Equal(proj.Name))
-				Expect(props["deployedVersion"]).To(Equal(depl3.Version))
-				Expect(props["targetVersion"]).To(Equal(depl1.Version))
+			It("returns 422 when the limit is invalid", func() {
+				doRequest(depl.ID, url.Values{"limit": {"0"}})
 
-				c := trackCall.Arguments[4]
-				context, ok := c.(map[string]interface{})
-				Expect(ok).To(BeTrue())
-				Expect(context["ip"]).ToNot(BeNil())
-				Expect(context["user_agent"]).ToNot(BeNil())
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-				Expect(trackCall.ReturnValues[0]).To(BeNil())
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"limit": "must be between 1 and 1000"
+					}
+				}`))
 			})
 		})
 
-		Context("when the version is specified", func() {
-			var depl4 *deployment.Deployment
-
+		Context("when the deployment has no manifest", func() {
 			BeforeEach(func() {
-				depl4 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
-					Prefix:     "x0y1z2",
-					State:      deployment.StateDeployed,
-					DeployedAt: timeAgo(2 * time.Hour),
-				})
-
-				params = url.Values{
-					"version": {strconv.FormatInt(depl4.Version, 10)},
+				target := s3client.WebrootTargets[0]
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				for _, key := range []string{webroot + "index.html", webroot + "js/app.js", webroot + "js/app.js.gz"} {
+					Expect(fakeS3.Upload(target.Region, target.Bucket, key, bytes.NewReader([]byte("x")), "", "public-read")).To(BeNil())
 				}
+				Expect(fakeS3.Upload(target.Region, target.Bucket, "deployments/"+depl.PrefixID()+"/raw-bundle.tar.gz", bytes.NewReader([]byte("x")), "", "private")).To(BeNil())
 			})
 
-			AfterEach(func() {
-				params = url.Values{}
-			})
+			It("returns the paths of the objects stored under the webroot", func() {
+				doRequest(depl.ID, nil)
 
-			It("returns 202 accepted", func() {
-				doRequest()
 				b := &bytes.Buffer{}
 				_, err = b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "storage",
+					"files": ["index.html", "js/app.js", "js/app.js.gz"]
+				}`))
+			})
+		})
 
-				var d deployment.Deployment
-				Expect(db.First(&d, depl4.ID).Error).To(BeNil())
-				j := map[string]interface{}{
-					"deployment": map[string]interface{}{
-						"id":          d.ID,
-						"state":       deployment.StatePendingRollback,
-						"deployed_at": d.DeployedAt,
-						"version":     d.Version,
-					},
-				}
-				expectedJSON, err := json.Marshal(j)
+		Context("when the deployment has not been deployed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("state", deployment.StatePendingDeploy).Error).To(BeNil())
+			})
+
+			It("returns 404 not found", func() {
+				doRequest(depl.ID, nil)
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
-				Expect(b.String()).To(MatchJSON(expectedJSON))
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment has not been deployed"
+				}`))
 			})
+		})
 
-			It("enqueues a deploy job", func() {
-				doRequest()
+		Context("when the deployment is of another project", func() {
+			It("returns 404 not found", func() {
+				other := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+				doRequest(other.ID, nil)
 
-				d := testhelper.ConsumeQueue(mq, queues.Deploy)
-				Expect(d).NotTo(BeNil())
-				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
-					{
-						"deployment_id": %d,
-						"skip_webroot_upload": true,
-						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}
-				`, depl4.ID)))
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
 			})
+		})
 
-			It("marks the deployment as 'pending_rollback'", func() {
-				doRequest()
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(depl.ID, nil)
+			return res
+		}, nil)
 
-				var updatedDeployment deployment.Deployment
-				Expect(db.First(&updatedDeployment, depl4.ID).Error).To(BeNil())
-				Expect(updatedDeployment.State).To(Equal(deployment.StatePendingRollback))
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest(depl.ID, nil)
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects/:project_name/deployments/:id/tree", func() {
+		var (
+			err error
+
+			fakeS3 *fake.S3
+			origS3 filetransfer.FileTransfer
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a1b2c3",
+				State:  deployment.StateDeployed,
 			})
+		})
 
-			It("tracks an 'Initiated Project Rollback' event", func() {
-				doRequest()
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
 
-				trackCall := fakeTracker.TrackCalls.NthCall(1)
-				Expect(trackCall).NotTo(BeNil())
-				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
-				Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Rollback"))
-				Expect(trackCall.Arguments[2]).To(Equal(""))
+		doRequest := func(id uint, params url.Values) {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/tree?%s", s.URL, id, params.Encode())
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
 
-				t := trackCall.Arguments[3]
-				props, ok := t.(map[string]interface{})
-				Expect(ok).To(BeTrue())
-				Expect(props["projectName"]).To(Equal(proj.Name))
-				Expect(props["deployedVersion"]).To(Equal(depl3.Version))
-				Expect(props["targetVersion"]).To(Equal(depl4.Version))
+		Context("when the deployment has a manifest", func() {
+			BeforeEach(func() {
+				Expect(depl.UpdateManifest(db, deployment.Manifest{
+					"index.html":             {Size: 12, ETag: "a"},
+					"about.html":             {Size: 21, ETag: "b"},
+					"css/app.css":            {Size: 34, ETag: "c", Encodings: []string{"gzip"}},
+					"images/logo.png":        {Size: 56, ETag: "d"},
+					"images/icons/arrow.svg": {Size: 78, ETag: "e"},
+				})).To(BeNil())
+			})
 
-				c := trackCall.Arguments[4]
-				context, ok := c.(map[string]interface{})
-				Expect(ok).To(BeTrue())
-				Expect(context["ip"]).ToNot(BeNil())
-				Expect(context["user_agent"]).ToNot(BeNil())
+			It("returns the files in the manifest as a tree", func() {
+				doRequest(depl.ID, nil)
 
-				Expect(trackCall.ReturnValues[0]).To(BeNil())
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "manifest",
+					"tree": {
+						"name": "",
+						"path": "",
+						"type": "directory",
+						"children": [
+							{
+								"name": "css",
+								"path": "css",
+								"type": "directory",
+								"children": [
+									{"name": "app.css", "path": "css/app.css", "type": "file", "size": 34}
+								]
+							},
+							{
+								"name": "images",
+								"path": "images",
+								"type": "directory",
+								"children": [
+									{
+										"name": "icons",
+										"path": "images/icons",
+										"type": "directory",
+										"children": [
+											{"name": "arrow.svg", "path": "images/icons/arrow.svg", "type": "file", "size": 78}
+										]
+									},
+									{"name": "logo.png", "path": "images/logo.png", "type": "file", "size": 56}
+								]
+							},
+							{"name": "about.html", "path": "about.html", "type": "file", "size": 21},
+							{"name": "index.html", "path": "index.html", "type": "file", "size": 12}
+						]
+					}
+				}`))
+
+				Expect(fakeS3.ListCalls.Count()).To(Equal(0))
 			})
 
-			Context("when the deployment does not exist", func() {
-				BeforeEach(func() {
-					Expect(db.Delete(depl4).Error).To(BeNil())
-				})
+			It("returns the subtree at the given path", func() {
+				doRequest(depl.ID, url.Values{"path": {"/images/"}})
 
-				It("returns 422 with invalid_request", func() {
-					doRequest()
-					b := &bytes.Buffer{}
-					_, err = b.ReadFrom(res.Body)
-					Expect(err).To(BeNil())
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-					Expect(res.StatusCode).To(Equal(422))
-					Expect(b.String()).To(MatchJSON(`{
-						"error": "invalid_request",
-						"error_description": "completed deployment with a given version could not be found"
-					}`))
-				})
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "manifest",
+					"tree": {
+						"name": "images",
+						"path": "images",
+						"type": "directory",
+						"children": [
+							{
+								"name": "icons",
+								"path": "images/icons",
+								"type": "directory",
+								"children": [
+									{"name": "arrow.svg", "path": "images/icons/arrow.svg", "type": "file", "size": 78}
+								]
+							},
+							{"name": "logo.png", "path": "images/logo.png", "type": "file", "size": 56}
+						]
+					}
+				}`))
+			})
 
-				It("does not track any 'Initiated Project Rollback' event", func() {
-					doRequest()
+			It("returns the file at the given path", func() {
+				doRequest(depl.ID, url.Values{"path": {"images/icons/arrow.svg"}})
 
-					trackCall := fakeTracker.TrackCalls.NthCall(1)
-					Expect(trackCall).To(BeNil())
-				})
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "manifest",
+					"tree": {"name": "arrow.svg", "path": "images/icons/arrow.svg", "type": "file", "size": 78}
+				}`))
 			})
 
-			Context("when the deployment is already active", func() {
-				BeforeEach(func() {
-					proj.ActiveDeploymentID = &depl4.ID
-					Expect(db.Save(proj).Error).To(BeNil())
-				})
+			It("returns 404 when nothing is at the given path", func() {
+				doRequest(depl.ID, url.Values{"path": {"img"}})
 
-				It("returns 422 with invalid_request", func() {
-					doRequest()
-					b := &bytes.Buffer{}
-					_, err = b.ReadFrom(res.Body)
-					Expect(err).To(BeNil())
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-					Expect(res.StatusCode).To(Equal(422))
-					Expect(b.String()).To(MatchJSON(`{
-						"error": "invalid_request",
-						"error_description": "the specified deployment is already active"
-					}`))
-				})
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "path could not be found"
+				}`))
+			})
+		})
 
-				It("does not track any 'Initiated Project Rollback' event", func() {
-					doRequest()
+		Context("when the deployment has no manifest", func() {
+			BeforeEach(func() {
+				target := s3client.WebrootTargets[0]
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				for _, key := range []string{webroot + "index.html", webroot + "js/app.js"} {
+					Expect(fakeS3.Upload(target.Region, target.Bucket, key, bytes.NewReader([]byte("x")), "", "public-read")).To(BeNil())
+				}
+			})
 
-					trackCall := fakeTracker.TrackCalls.NthCall(1)
-					Expect(trackCall).To(BeNil())
-				})
+			It("returns the objects stored under the webroot as a tree without sizes", func() {
+				doRequest(depl.ID, nil)
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"source": "storage",
+					"tree": {
+						"name": "",
+						"path": "",
+						"type": "directory",
+						"children": [
+							{
+								"name": "js",
+								"path": "js",
+								"type": "directory",
+								"children": [
+									{"name": "app.js", "path": "js/app.js", "type": "file"}
+								]
+							},
+							{"name": "index.html", "path": "index.html", "type": "file"}
+						]
+					}
+				}`))
 			})
+		})
 
-			Context("when the deployment is not in deployed state", func() {
-				BeforeEach(func() {
-					depl4.State = deployment.StatePendingUpload
-					Expect(db.Save(depl4).Error).To(BeNil())
-				})
+		Context("when the deployment has not been deployed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("state", deployment.StatePendingDeploy).Error).To(BeNil())
+			})
 
-				It("returns 422 with invalid_request", func() {
-					doRequest()
-					b := &bytes.Buffer{}
-					_, err = b.ReadFrom(res.Body)
-					Expect(err).To(BeNil())
+			It("returns 404 not found", func() {
+				doRequest(depl.ID, nil)
 
-					Expect(res.StatusCode).To(Equal(422))
-					Expect(b.String()).To(MatchJSON(`{
-						"error": "invalid_request",
-						"error_description": "completed deployment with a given version could not be found"
-					}`))
-				})
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment has not been deployed"
+				}`))
+			})
+		})
+
+		Context("when the deployment is of another project", func() {
+			It("returns 404 not found", func() {
+				other := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+				doRequest(other.ID, nil)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
 			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(depl.ID, nil)
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest(depl.ID, nil)
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects/:project_name/deployments/:id/checksum", func() {
+		var (
+			err error
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+
+			manifest deployment.Manifest
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			manifest = deployment.Manifest{
+				"index.html":  {Size: 12, ETag: "0cc175b9c0f1b6a831c399e269772661"},
+				"css/app.css": {Size: 34, ETag: "92eb5ffee6ae2fec3ad71c777531578f", Encodings: []string{"gzip"}},
+			}
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a1b2c3",
+				State:  deployment.StateDeployed,
+			})
+			Expect(depl.UpdateManifest(db, manifest)).To(BeNil())
+		})
+
+		doRequest := func(id uint) {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/checksum", s.URL, id)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		checksumOf := func(id uint) string {
+			doRequest(id)
+			defer func() {
+				res.Body.Close()
+				s.Close()
+			}()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Checksum string `json:"checksum"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+			return j.Checksum
+		}
+
+		It("returns the checksum of the manifest of the deployment", func() {
+			doRequest(depl.ID)
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"algorithm": "sha256",
+				"checksum": "%s",
+				"file_count": 2
+			}`, manifest.Checksum())))
+		})
+
+		It("returns the same checksum for deployments of identical content", func() {
+			other := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "d4e5f6",
+				State:  deployment.StateDeployed,
+			})
+			Expect(other.UpdateManifest(db, deployment.Manifest{
+				"index.html":  {Size: 12, ETag: "0cc175b9c0f1b6a831c399e269772661"},
+				"css/app.css": {Size: 34, ETag: "92eb5ffee6ae2fec3ad71c777531578f", Encodings: []string{"gzip"}},
+			})).To(BeNil())
+
+			Expect(checksumOf(other.ID)).To(Equal(checksumOf(depl.ID)))
+		})
+
+		It("returns different checksums for deployments of different content", func() {
+			other := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "d4e5f6",
+				State:  deployment.StateDeployed,
+			})
+			Expect(other.UpdateManifest(db, deployment.Manifest{
+				"index.html":  {Size: 12, ETag: "4a8a08f09d37b73795649038408b5f33"},
+				"css/app.css": {Size: 34, ETag: "92eb5ffee6ae2fec3ad71c777531578f"},
+			})).To(BeNil())
+
+			Expect(checksumOf(other.ID)).NotTo(Equal(checksumOf(depl.ID)))
+		})
+
+		Context("when the deployment has no manifest", func() {
+			BeforeEach(func() {
+				Expect(depl.UpdateManifest(db, deployment.Manifest{})).To(BeNil())
+			})
+
+			It("returns 404 not found", func() {
+				doRequest(depl.ID)
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment has no manifest"
+				}`))
+			})
+		})
+
+		Context("when the deployment has not been deployed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("state", deployment.StatePendingDeploy).Error).To(BeNil())
+			})
+
+			It("returns 404 not found", func() {
+				doRequest(depl.ID)
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("when the deployment is of another project", func() {
+			It("returns 404 not found", func() {
+				other := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+				doRequest(other.ID)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(depl.ID)
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest(depl.ID)
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects/:project_name/deployments/:id/logs.txt", func() {
+		var (
+			err error
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:   deployment.StatePendingDeploy,
+				Version: 3,
+			})
+			Expect(depl.UpdateState(db, deployment.StatePendingDeploy)).To(BeNil())
+			Expect(depl.AppendLog(db, "Uploaded 12 files")).To(BeNil())
+
+			errorMessage := "Deployment is missing required files: index.html"
+			depl.ErrorMessage = &errorMessage
+			Expect(depl.UpdateState(db, deployment.StateDeployFailed)).To(BeNil())
+		})
+
+		doRequest := func(id uint) {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/logs.txt", s.URL, id)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the log of the deployment as a plain text attachment, oldest line first", func() {
+			doRequest(depl.ID)
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+			Expect(res.Header.Get("Content-Disposition")).To(Equal(`attachment; filename="foo-bar-express-v3-logs.txt"`))
+
+			lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+			Expect(lines).To(HaveLen(3))
+
+			var messages []string
+			for _, line := range lines {
+				fields := strings.SplitN(line, " ", 2)
+				Expect(fields).To(HaveLen(2))
+
+				_, err := time.Parse(time.RFC3339, fields[0])
+				Expect(err).To(BeNil())
+				messages = append(messages, fields[1])
+			}
+			Expect(messages).To(Equal([]string{
+				"Deployment is pending_deploy",
+				"Uploaded 12 files",
+				"Deployment is deploy_failed: Deployment is missing required files: index.html",
+			}))
+		})
+
+		Context("when the deployment is of another project", func() {
+			It("returns 404 not found", func() {
+				other := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+				doRequest(other.ID)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(depl.ID)
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest(depl.ID)
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects/:project_name/deployments/:id/download", func() {
+		var (
+			err error
+
+			fakeS3 *fake.S3
+			origS3 filetransfer.FileTransfer
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+			bun     *rawbundle.RawBundle
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			u, _, t = factories.AuthTrio(db)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			bun = factories.RawBundle(db, proj)
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:      "a1b2c3",
+				State:       deployment.StateDeployed,
+				DeployedAt:  timeAgo(-1 * time.Hour),
+				RawBundleID: &bun.ID,
+			})
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/download", s.URL, depl.ID)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		Context("when the deployment does not exist", func() {
+			BeforeEach(func() {
+				Expect(db.Delete(depl).Error).To(BeNil())
+			})
+
+			It("responds with 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+
+		Context("when the deployment id is not a number", func() {
+			BeforeEach(func() {
+				s = httptest.NewServer(server.New())
+				url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/cafebabe", s.URL)
+				res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+				Expect(err).To(BeNil())
+			})
+
+			It("responds with 404 Not Found", func() {
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+
+		Context("when the deployment does not have an associated RawBundle", func() {
+			BeforeEach(func() {
+				depl.RawBundleID = nil
+				Expect(db.Save(depl).Error).To(BeNil())
+			})
+
+			It("responds with 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment cannot be downloaded"
+				}`))
+			})
+		})
+
+		Context("when the deployment's associated RawBundle does not exist", func() {
+			BeforeEach(func() {
+				Expect(db.Delete(bun).Error).To(BeNil())
+			})
+
+			It("responds with 410 Gone", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusGone))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "gone",
+					"error_description": "deployment can no longer be downloaded"
+				}`))
+			})
+		})
+
+		Context("when raw bundle does not exist on S3", func() {
+			BeforeEach(func() {
+				fakeS3.ExistsReturn = false
+			})
+
+			It("responds with 410 Gone", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusGone))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "gone",
+					"error_description": "deployment can no longer be downloaded"
+				}`))
+
+				Expect(fakeS3.ExistsCalls.Count()).To(Equal(1))
+			})
+		})
+
+		Context("when raw bundle exists on S3", func() {
+			BeforeEach(func() {
+				fakeS3.ExistsReturn = true
+				fakeS3.PresignedURLReturn = "https://s3-us-west-2.amazonaws.com/deployments/abcd/raw-bundle.zip?abc=123"
+			})
+
+			It("responds with a pre-signed download URL of the raw bundle in S3", func() {
+				doRequest()
+
+				Expect(fakeS3.PresignedURLCalls.Count()).To(Equal(1))
+				call := fakeS3.PresignedURLCalls.NthCall(1)
+				Expect(call).NotTo(BeNil())
+				Expect(call.Arguments[0]).To(Equal(s3client.BucketRegion))
+				Expect(call.Arguments[1]).To(Equal(s3client.BucketName))
+				Expect(call.Arguments[2]).To(Equal(bun.UploadedPath))
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"url": "%s"
+				}`, fakeS3.PresignedURLReturn)))
+			})
+		})
+	})
+
+	Describe("POST /projects/:project_name/rollback", func() {
+		var (
+			err error
+
+			fakeS3 *fake.S3
+			origS3 filetransfer.FileTransfer
+
+			mq *amqp.Connection
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			params  url.Values
+			headers http.Header
+			proj    *project.Project
+
+			depl1 *deployment.Deployment
+			depl3 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			factories.Domain(db, proj)
+			factories.Domain(db, proj)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:     "a1b2c3",
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(3 * time.Hour),
+			})
+
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a7b8c9",
+				State:  deployment.StateUploaded,
+			})
+
+			depl3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:     "d1e2f3",
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(1 * time.Hour),
+			})
+
+			var currentDeplID = depl3.ID
+			proj.ActiveDeploymentID = &currentDeplID
+			Expect(db.Save(proj).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/rollback", s.URL)
+			res, err = testhelper.MakeRequest("POST", url, params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		Context("when the version is not specified", func() {
+			It("returns 202 accepted", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				var d deployment.Deployment
+				Expect(db.First(&d, depl1.ID).Error).To(BeNil())
+				j := map[string]interface{}{
+					"deployment": map[string]interface{}{
+						"id":          d.ID,
+						"state":       deployment.StatePendingRollback,
+						"deployed_at": d.DeployedAt,
+						"version":     d.Version,
+					},
+				}
+				expectedJSON, err := json.Marshal(j)
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(expectedJSON))
+			})
+
+			It("enqueues a build job", func() {
+				doRequest()
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+					{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}
+				`, depl1.ID)))
+			})
+
+			It("marks the deployment as 'pending_rollback'", func() {
+				doRequest()
+
+				var updatedDeployment deployment.Deployment
+				Expect(db.First(&updatedDeployment, depl1.ID).Error).To(BeNil())
+				Expect(updatedDeployment.State).To(Equal(deployment.StatePendingRollback))
+			})
+
+			It("tracks an 'Initiated Project Rollback' event", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Rollback"))
+				Expect(trackCall.Arguments[2]).To(Equal(""))
+
+				t := trackCall.Arguments[3]
+				props, ok := t.(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal(proj.Name))
+				Expect(props["deployedVersion"]).To(Equal(depl3.Version))
+				Expect(props["targetVersion"]).To(Equal(depl1.Version))
+
+				c := trackCall.Arguments[4]
+				context, ok := c.(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(context["ip"]).ToNot(BeNil())
+				Expect(context["user_agent"]).ToNot(BeNil())
+
+				Expect(trackCall.ReturnValues[0]).To(BeNil())
+			})
+		})
+
+		Context("when restore_settings is true", func() {
+			BeforeEach(func() {
+				params = url.Values{"restore_settings": {"true"}}
+			})
+
+			AfterEach(func() {
+				params = nil
+			})
+
+			Context("when the deployment has a settings snapshot", func() {
+				BeforeEach(func() {
+					Expect(db.Model(depl1).Update("project_settings", []byte(`{
+						"force_https": true,
+						"watermark": false,
+						"basic_auth_username": "alice"
+					}`)).Error).To(BeNil())
+				})
+
+				It("restores the settings of the project from the snapshot", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					var updatedProj project.Project
+					Expect(db.First(&updatedProj, proj.ID).Error).To(BeNil())
+					Expect(updatedProj.ForceHTTPS).To(BeTrue())
+					Expect(updatedProj.Watermark).To(BeFalse())
+					Expect(updatedProj.BasicAuthUsername).NotTo(BeNil())
+					Expect(*updatedProj.BasicAuthUsername).To(Equal("alice"))
+					Expect(updatedProj.EncryptedBasicAuthPassword).To(BeNil())
+				})
+
+				It("enqueues a deploy job for the deployment", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+						{
+							"deployment_id": %d,
+							"skip_webroot_upload": true,
+							"skip_invalidation": false,
+							"use_raw_bundle": false
+						}
+					`, depl1.ID)))
+				})
+			})
+
+			Context("when the deployment has no settings snapshot", func() {
+				BeforeEach(func() {
+					Expect(db.Model(proj).Update("force_https", true).Error).To(BeNil())
+				})
+
+				It("only rolls back the content of the project", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					var updatedProj project.Project
+					Expect(db.First(&updatedProj, proj.ID).Error).To(BeNil())
+					Expect(updatedProj.ForceHTTPS).To(BeTrue())
+
+					var updatedDeployment deployment.Deployment
+					Expect(db.First(&updatedDeployment, depl1.ID).Error).To(BeNil())
+					Expect(updatedDeployment.State).To(Equal(deployment.StatePendingRollback))
+				})
+			})
+		})
+
+		Context("when the version is specified", func() {
+			var depl4 *deployment.Deployment
+
+			BeforeEach(func() {
+				depl4 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+					Prefix:     "x0y1z2",
+					State:      deployment.StateDeployed,
+					DeployedAt: timeAgo(2 * time.Hour),
+				})
+
+				params = url.Values{
+					"version": {strconv.FormatInt(depl4.Version, 10)},
+				}
+			})
+
+			AfterEach(func() {
+				params = url.Values{}
+			})
+
+			It("returns 202 accepted", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				var d deployment.Deployment
+				Expect(db.First(&d, depl4.ID).Error).To(BeNil())
+				j := map[string]interface{}{
+					"deployment": map[string]interface{}{
+						"id":          d.ID,
+						"state":       deployment.StatePendingRollback,
+						"deployed_at": d.DeployedAt,
+						"version":     d.Version,
+					},
+				}
+				expectedJSON, err := json.Marshal(j)
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(expectedJSON))
+			})
+
+			It("enqueues a deploy job", func() {
+				doRequest()
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+					{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}
+				`, depl4.ID)))
+			})
+
+			It("marks the deployment as 'pending_rollback'", func() {
+				doRequest()
+
+				var updatedDeployment deployment.Deployment
+				Expect(db.First(&updatedDeployment, depl4.ID).Error).To(BeNil())
+				Expect(updatedDeployment.State).To(Equal(deployment.StatePendingRollback))
+			})
+
+			It("tracks an 'Initiated Project Rollback' event", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Rollback"))
+				Expect(trackCall.Arguments[2]).To(Equal(""))
+
+				t := trackCall.Arguments[3]
+				props, ok := t.(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal(proj.Name))
+				Expect(props["deployedVersion"]).To(Equal(depl3.Version))
+				Expect(props["targetVersion"]).To(Equal(depl4.Version))
+
+				c := trackCall.Arguments[4]
+				context, ok := c.(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(context["ip"]).ToNot(BeNil())
+				Expect(context["user_agent"]).ToNot(BeNil())
+
+				Expect(trackCall.ReturnValues[0]).To(BeNil())
+			})
+
+			Context("when the deployment does not exist", func() {
+				BeforeEach(func() {
+					Expect(db.Delete(depl4).Error).To(BeNil())
+				})
+
+				It("returns 422 with invalid_request", func() {
+					doRequest()
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_request",
+						"error_description": "completed deployment with a given version could not be found"
+					}`))
+				})
+
+				It("does not track any 'Initiated Project Rollback' event", func() {
+					doRequest()
+
+					trackCall := fakeTracker.TrackCalls.NthCall(1)
+					Expect(trackCall).To(BeNil())
+				})
+			})
+
+			Context("when the deployment is already active", func() {
+				BeforeEach(func() {
+					proj.ActiveDeploymentID = &depl4.ID
+					Expect(db.Save(proj).Error).To(BeNil())
+				})
+
+				It("returns 422 with invalid_request", func() {
+					doRequest()
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_request",
+						"error_description": "the specified deployment is already active"
+					}`))
+				})
+
+				It("does not track any 'Initiated Project Rollback' event", func() {
+					doRequest()
+
+					trackCall := fakeTracker.TrackCalls.NthCall(1)
+					Expect(trackCall).To(BeNil())
+				})
+			})
+
+			Context("when the deployment is not in deployed state", func() {
+				BeforeEach(func() {
+					depl4.State = deployment.StatePendingUpload
+					Expect(db.Save(depl4).Error).To(BeNil())
+				})
+
+				It("returns 422 with invalid_request", func() {
+					doRequest()
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_request",
+						"error_description": "completed deployment with a given version could not be found"
+					}`))
+				})
+			})
+
+			Context("when the deployment does not belong to the project", func() {
+				BeforeEach(func() {
+					proj2 := factories.Project(db, u)
+					depl4.ProjectID = proj2.ID
+					Expect(db.Save(depl4).Error).To(BeNil())
+				})
+
+				It("returns 422 with invalid_request", func() {
+					doRequest()
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_request",
+						"error_description": "completed deployment with a given version could not be found"
+					}`))
+				})
+			})
+		})
+
+		Context("when active_deployment_id is nil", func() {
+			BeforeEach(func() {
+				proj.ActiveDeploymentID = nil
+				Expect(db.Save(proj).Error).To(BeNil())
+			})
+
+			It("returns 412 with precondition_failed", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "precondition_failed",
+					"error_description": "active deployment could not be found"
+				}`))
+			})
+		})
+
+		Context("when there is no previous completed deployment", func() {
+			BeforeEach(func() {
+				proj.ActiveDeploymentID = &depl1.ID
+				Expect(db.Save(proj).Error).To(BeNil())
+			})
+
+			It("returns 412 with precondition_failed", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "precondition_failed",
+					"error_description": "previous completed deployment could not be found"
+				}`))
+			})
+		})
+	})
+
+	Describe("POST /projects/:project_name/deployments/:id/rollback", func() {
+		var (
+			err error
+
+			mq *amqp.Connection
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+
+			depl1 *deployment.Deployment
+			depl2 *deployment.Deployment
+			depl3 *deployment.Deployment
+
+			targetID string
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:     "a1b2c3",
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(3 * time.Hour),
+			})
+
+			depl2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a7b8c9",
+				State:  deployment.StateDeployFailed,
+			})
+
+			depl3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:     "d1e2f3",
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(1 * time.Hour),
+			})
+
+			var currentDeplID = depl3.ID
+			proj.ActiveDeploymentID = &currentDeplID
+			Expect(db.Save(proj).Error).To(BeNil())
+
+			targetID = strconv.Itoa(int(depl1.ID))
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%s/rollback", s.URL, targetID)
+			res, err = testhelper.MakeRequest("POST", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		expectNotFound := func() {
+			doRequest()
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "not_found",
+				"error_description": "deployment could not be found"
+			}`))
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("returns 202 accepted", func() {
+			doRequest()
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+			var d deployment.Deployment
+			Expect(db.First(&d, depl1.ID).Error).To(BeNil())
+			j := map[string]interface{}{
+				"deployment": map[string]interface{}{
+					"id":          d.ID,
+					"state":       deployment.StatePendingRollback,
+					"deployed_at": d.DeployedAt,
+					"version":     d.Version,
+				},
+			}
+			expectedJSON, err := json.Marshal(j)
+			Expect(err).To(BeNil())
+			Expect(b.String()).To(MatchJSON(expectedJSON))
+		})
+
+		It("enqueues a deploy job that skips the webroot upload", func() {
+			doRequest()
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+				{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}
+			`, depl1.ID)))
+		})
+
+		It("marks the deployment as 'pending_rollback' and leaves the active deployment to the deployer", func() {
+			doRequest()
+
+			var updatedDeployment deployment.Deployment
+			Expect(db.First(&updatedDeployment, depl1.ID).Error).To(BeNil())
+			Expect(updatedDeployment.State).To(Equal(deployment.StatePendingRollback))
+
+			var updatedProj project.Project
+			Expect(db.First(&updatedProj, proj.ID).Error).To(BeNil())
+			Expect(*updatedProj.ActiveDeploymentID).To(Equal(depl3.ID))
+		})
+
+		It("tracks an 'Initiated Project Rollback' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Rollback"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["deployedVersion"]).To(Equal(depl3.Version))
+			Expect(props["targetVersion"]).To(Equal(depl1.Version))
+		})
+
+		Context("when the deployment id is not a number", func() {
+			BeforeEach(func() {
+				targetID = "foo"
+			})
+
+			It("returns 404 not found", expectNotFound)
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				proj2 := factories.Project(db, u, "other-project")
+				other := factories.Deployment(db, proj2, u, deployment.StateDeployed)
+				targetID = strconv.Itoa(int(other.ID))
+			})
+
+			It("returns 404 not found", expectNotFound)
+		})
+
+		Context("when the deployment has been deleted to keep the last N deployments", func() {
+			BeforeEach(func() {
+				Expect(deployment.DeleteExceptLastN(db, proj.ID, 1)).To(BeNil())
+			})
+
+			It("returns 404 not found", expectNotFound)
+		})
+
+		Context("when the deployment has not been deployed", func() {
+			BeforeEach(func() {
+				targetID = strconv.Itoa(int(depl2.ID))
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "the specified deployment has not been deployed"
+				}`))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			})
+		})
+
+		Context("when the deployment is already active", func() {
+			BeforeEach(func() {
+				targetID = strconv.Itoa(int(depl3.ID))
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "the specified deployment is already active"
+				}`))
+			})
+		})
+
+		Context("when the project has no active deployment", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("active_deployment_id", nil).Error).To(BeNil())
+			})
+
+			It("returns 412 precondition failed", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "precondition_failed",
+					"error_description": "active deployment could not be found"
+				}`))
+			})
+		})
+	})
+
+	Describe("POST /projects/:project_name/deployments/:id/publish", func() {
+		var (
+			err error
+
+			mq *amqp.Connection
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a1b2c3",
+				State:  deployment.StateUnpublished,
+			})
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/publish", s.URL, depl.ID)
+			res, err = testhelper.MakeRequest("POST", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		Context("when the deployment is unpublished", func() {
+			It("returns 202 accepted", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"deployment": {
+						"id": %d,
+						"state": "%s",
+						"version": %d
+					}
+				}`, depl.ID, deployment.StatePendingDeploy, depl.Version)))
+			})
+
+			It("enqueues a deploy job that skips uploading the webroot", func() {
+				doRequest()
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+					{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}
+				`, depl.ID)))
+			})
+
+			It("marks the deployment as 'pending_deploy'", func() {
+				doRequest()
+
+				var updatedDepl deployment.Deployment
+				Expect(db.First(&updatedDepl, depl.ID).Error).To(BeNil())
+				Expect(updatedDepl.State).To(Equal(deployment.StatePendingDeploy))
+			})
+
+			It("tracks an 'Initiated Project Publish' event", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Publish"))
+
+				props, ok := trackCall.Arguments[3].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal(proj.Name))
+				Expect(props["deploymentId"]).To(Equal(depl.ID))
+				Expect(props["deploymentVersion"]).To(Equal(depl.Version))
+			})
+		})
+
+		Context("when the deployment is not unpublished", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("state", deployment.StateDeployed).Error).To(BeNil())
+			})
+
+			It("returns 422 with invalid_request", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "the specified deployment is not unpublished"
+				}`))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).To(BeNil())
+			})
+		})
+
+		Context("when the deployment does not belong to the project", func() {
+			BeforeEach(func() {
+				depl = factories.Deployment(db, nil, u, deployment.StateUnpublished)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+	})
+
+	Describe("DELETE /projects/:project_name/deployments/:id", func() {
+		var (
+			err error
+
+			mq *amqp.Connection
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			prev    *deployment.Deployment
+			active  *deployment.Deployment
+
+			query string
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			prev = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(2 * time.Hour),
+			})
+			active = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(1 * time.Hour),
+			})
+			Expect(db.Model(proj).Update("active_deployment_id", active.ID).Error).To(BeNil())
+
+			query = ""
+		})
+
+		doRequest := func(depl *deployment.Deployment) {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d%s", s.URL, depl.ID, query)
+			res, err = testhelper.MakeRequest("DELETE", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(prev)
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest(prev)
+			return res
+		}, nil)
+
+		It("deletes a deployment that is not active", func() {
+			doRequest(prev)
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{"deleted": true}`))
+
+			Expect(db.First(&deployment.Deployment{}, prev.ID).Error).To(Equal(gorm.RecordNotFound))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(*proj.ActiveDeploymentID).To(Equal(active.ID))
+		})
+
+		It("returns 422 and does not delete the active deployment", func() {
+			doRequest(active)
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "invalid_request",
+				"error_description": "the specified deployment is active"
+			}`))
+
+			Expect(db.First(&deployment.Deployment{}, active.ID).Error).To(BeNil())
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(*proj.ActiveDeploymentID).To(Equal(active.ID))
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).To(BeNil())
+		})
+
+		Context("when repoint_active is true", func() {
+			BeforeEach(func() {
+				query = "?repoint_active=true"
+			})
+
+			It("points the project at the previous deployment, deletes the active deployment and updates the meta", func() {
+				doRequest(active)
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				j := map[string]interface{}{}
+				Expect(json.Unmarshal(b.Bytes(), &j)).To(BeNil())
+				Expect(j["deleted"]).To(Equal(true))
+				deplJSON, ok := j["deployment"].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(deplJSON["id"]).To(Equal(float64(prev.ID)))
+
+				Expect(db.First(&deployment.Deployment{}, active.ID).Error).To(Equal(gorm.RecordNotFound))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(*proj.ActiveDeploymentID).To(Equal(prev.ID))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+					{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}
+				`, prev.ID)))
+			})
+
+			Context("when there is no previous deployment", func() {
+				BeforeEach(func() {
+					Expect(db.Delete(prev).Error).To(BeNil())
+				})
+
+				It("returns 412 and does not delete the active deployment", func() {
+					doRequest(active)
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "precondition_failed",
+						"error_description": "previous completed deployment could not be found"
+					}`))
+
+					Expect(db.First(&deployment.Deployment{}, active.ID).Error).To(BeNil())
+				})
+			})
+		})
+
+		Context("when the deployment is the canary", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("canary_deployment_id", prev.ID).Error).To(BeNil())
+			})
+
+			It("returns 422 and does not delete the deployment", func() {
+				doRequest(prev)
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "the specified deployment is the canary"
+				}`))
+
+				Expect(db.First(&deployment.Deployment{}, prev.ID).Error).To(BeNil())
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			It("returns 404 not found", func() {
+				doRequest(factories.Deployment(db, nil, nil, deployment.StateDeployed))
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("PUT /projects/:project_name/canary", func() {
+		var (
+			err error
+
+			mq *amqp.Connection
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			params  url.Values
+			proj    *project.Project
+			active  *deployment.Deployment
+			canary  *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			active = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "a1b2c3",
+				State:  deployment.StateDeployed,
+			})
+			canary = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "d4e5f6",
+				State:  deployment.StateUnpublished,
+			})
+			Expect(db.Model(proj).Update("active_deployment_id", active.ID).Error).To(BeNil())
+
+			params = url.Values{
+				"deployment_id": {fmt.Sprintf("%d", canary.ID)},
+				"percent":       {"10"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/canary", s.URL)
+			res, err = testhelper.MakeRequest("PUT", url, params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("sets the canary of the project", func() {
+			doRequest()
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"canary": {
+					"deployment_id": %d,
+					"percent": 10
+				}
+			}`, canary.ID)))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.CanaryDeploymentID).NotTo(BeNil())
+			Expect(*proj.CanaryDeploymentID).To(Equal(canary.ID))
+			Expect(proj.CanaryPercent).To(Equal(uint(10)))
+		})
+
+		It("enqueues a deploy job that updates the meta of the active deployment", func() {
+			doRequest()
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+				{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}
+			`, active.ID)))
+		})
+
+		Context("when the project already has a canary", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Updates(map[string]interface{}{
+					"canary_deployment_id": canary.ID,
+					"canary_percent":       10,
+				}).Error).To(BeNil())
+				params = url.Values{"percent": {"50"}}
+			})
+
+			It("adjusts the percentage of traffic it gets", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(*proj.CanaryDeploymentID).To(Equal(canary.ID))
+				Expect(proj.CanaryPercent).To(Equal(uint(50)))
+			})
+		})
+
+		Context("when the params are invalid", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"deployment_id": {fmt.Sprintf("%d", active.ID)},
+					"percent":       {"100"},
+				}
+			})
+
+			It("returns 422 with invalid_params", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"deployment_id": "is that of the active deployment",
+						"percent": "must be between 1 and 99"
+					}
+				}`))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).To(BeNil())
+			})
+		})
+
+		Context("when the deployment does not belong to the project", func() {
+			BeforeEach(func() {
+				other := factories.Deployment(db, nil, u, deployment.StateUnpublished)
+				params.Set("deployment_id", fmt.Sprintf("%d", other.ID))
+			})
+
+			It("returns 422 with invalid_params", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"deployment_id": "is not that of a deployment of the project"
+					}
+				}`))
+			})
+		})
+
+		Context("when the project has no active deployment", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("active_deployment_id", nil).Error).To(BeNil())
+			})
+
+			It("returns 422 with invalid_request", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "project has no active deployment"
+				}`))
+			})
+		})
+	})
+
+	Describe("DELETE /projects/:project_name/canary", func() {
+		var (
+			err error
+
+			mq *amqp.Connection
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			active  *deployment.Deployment
+			canary  *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			active = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			canary = factories.Deployment(db, proj, u, deployment.StateUnpublished)
+			Expect(db.Model(proj).Updates(map[string]interface{}{
+				"active_deployment_id": active.ID,
+				"canary_deployment_id": canary.ID,
+				"canary_percent":       10,
+			}).Error).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/canary", s.URL)
+			res, err = testhelper.MakeRequest("DELETE", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-			Context("when the deployment does not belong to the project", func() {
-				BeforeEach(func() {
-					proj2 := factories.Project(db, u)
-					depl4.ProjectID = proj2.ID
-					Expect(db.Save(depl4).Error).To(BeNil())
-				})
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-				It("returns 422 with invalid_request", func() {
-					doRequest()
-					b := &bytes.Buffer{}
-					_, err = b.ReadFrom(res.Body)
-					Expect(err).To(BeNil())
+		It("clears the canary and updates the meta of the active deployment", func() {
+			doRequest()
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
 
-					Expect(res.StatusCode).To(Equal(422))
-					Expect(b.String()).To(MatchJSON(`{
-						"error": "invalid_request",
-						"error_description": "completed deployment with a given version could not be found"
-					}`))
-				})
-			})
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(b.String()).To(MatchJSON(`{"canary": null}`))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.CanaryDeploymentID).To(BeNil())
+			Expect(proj.CanaryPercent).To(BeZero())
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+				{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}
+			`, active.ID)))
 		})
 
-		Context("when active_deployment_id is nil", func() {
+		Context("when the project has no canary", func() {
 			BeforeEach(func() {
-				proj.ActiveDeploymentID = nil
-				Expect(db.Save(proj).Error).To(BeNil())
+				Expect(db.Model(proj).Update("canary_deployment_id", nil).Error).To(BeNil())
 			})
 
-			It("returns 412 with precondition_failed", func() {
+			It("returns 422 with invalid_request", func() {
 				doRequest()
 				b := &bytes.Buffer{}
 				_, err = b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
-				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(422))
 				Expect(b.String()).To(MatchJSON(`{
-					"error": "precondition_failed",
-					"error_description": "active deployment could not be found"
+					"error": "invalid_request",
+					"error_description": "project has no canary"
 				}`))
 			})
 		})
+	})
 
-		Context("when there is no previous completed deployment", func() {
+	Describe("POST /projects/:project_name/canary/promote", func() {
+		var (
+			err error
+
+			mq *amqp.Connection
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			active  *deployment.Deployment
+			canary  *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			active = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			canary = factories.Deployment(db, proj, u, deployment.StateUnpublished)
+			Expect(db.Model(proj).Updates(map[string]interface{}{
+				"active_deployment_id": active.ID,
+				"canary_deployment_id": canary.ID,
+				"canary_percent":       10,
+			}).Error).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/canary/promote", s.URL)
+			res, err = testhelper.MakeRequest("POST", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("enqueues a deploy job that publishes the canary", func() {
+			doRequest()
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deployment": {
+					"id": %d,
+					"state": "%s",
+					"version": %d
+				}
+			}`, canary.ID, deployment.StatePendingDeploy, canary.Version)))
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+				{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}
+			`, canary.ID)))
+		})
+
+		Context("when the project has no canary", func() {
 			BeforeEach(func() {
-				proj.ActiveDeploymentID = &depl1.ID
-				Expect(db.Save(proj).Error).To(BeNil())
+				Expect(db.Model(proj).Update("canary_deployment_id", nil).Error).To(BeNil())
 			})
 
-			It("returns 412 with precondition_failed", func() {
+			It("returns 422 with invalid_request", func() {
 				doRequest()
 				b := &bytes.Buffer{}
 				_, err = b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
-				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(422))
 				Expect(b.String()).To(MatchJSON(`{
-					"error": "precondition_failed",
-					"error_description": "previous completed deployment could not be found"
+					"error": "invalid_request",
+					"error_description": "project has no canary"
 				}`))
 			})
 		})
@@ -1579,8 +4687,9 @@ var _ = Describe("Deployments", func() {
 			proj    *project.Project
 			depl1   *deployment.Deployment
 			depl2   *deployment.Deployment
-			depl3   *deployment.Deployment
 			depl4   *deployment.Deployment
+
+			query url.Values
 		)
 
 		BeforeEach(func() {
@@ -1608,7 +4717,7 @@ var _ = Describe("Deployments", func() {
 				DeployedAt: timeAgo(2 * time.Hour),
 			})
 
-			depl3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
 				Prefix: "x0y1z2",
 				State:  deployment.StatePendingDeploy,
 			})
@@ -1621,11 +4730,13 @@ var _ = Describe("Deployments", func() {
 
 			proj.ActiveDeploymentID = &depl2.ID
 			Expect(db.Save(proj).Error).To(BeNil())
+
+			query = nil
 		})
 
 		doRequest := func() {
 			s = httptest.NewServer(server.New())
-			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments", s.URL)
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments?%s", s.URL, query.Encode())
 			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
 			Expect(err).To(BeNil())
 		}
@@ -1656,6 +4767,28 @@ var _ = Describe("Deployments", func() {
 			return res
 		}, nil)
 
+		Context("when a read replica is configured", func() {
+			var (
+				replica  *gorm.DB
+				recorder *queryRecorder
+			)
+
+			BeforeEach(func() {
+				replica, recorder = useReplica()
+			})
+
+			AfterEach(func() {
+				stopUsingReplica(replica)
+			})
+
+			It("lists the deployments from the replica", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(recorder.Queries()).To(ContainElement(ContainSubstring(`FROM "deployments"`)))
+			})
+		})
+
 		It("returns all completed deployments", func() {
 			doRequest()
 
@@ -1674,25 +4807,29 @@ var _ = Describe("Deployments", func() {
 						"id": %d,
 						"state": "%s",
 						"active": true,
+						"created_at": %s,
 						"deployed_at": %s,
 						"version": %d
 					},
 					{
 						"id": %d,
 						"state": "%s",
+						"created_at": %s,
 						"deployed_at": %s,
 						"version": %d
 					},
 					{
 						"id": %d,
 						"state": "%s",
+						"created_at": %s,
 						"deployed_at": %s,
 						"version": %d
 					}
-				]
-			}`, depl2.ID, depl2.State, formattedTimeForJSON(depl2.DeployedAt), depl2.Version,
-				depl1.ID, depl1.State, formattedTimeForJSON(depl1.DeployedAt), depl1.Version,
-				depl4.ID, depl4.State, formattedTimeForJSON(depl4.DeployedAt), depl4.Version,
+				],
+				"total": 3
+			}`, depl2.ID, depl2.State, formattedTimeForJSON(&depl2.CreatedAt), formattedTimeForJSON(depl2.DeployedAt), depl2.Version,
+				depl1.ID, depl1.State, formattedTimeForJSON(&depl1.CreatedAt), formattedTimeForJSON(depl1.DeployedAt), depl1.Version,
+				depl4.ID, depl4.State, formattedTimeForJSON(&depl4.CreatedAt), formattedTimeForJSON(depl4.DeployedAt), depl4.Version,
 			)))
 		})
 
@@ -1718,13 +4855,273 @@ var _ = Describe("Deployments", func() {
 							"id": %d,
 							"state": "%s",
 							"active": true,
+							"created_at": %s,
 							"deployed_at": %s,
 							"version": %d
 						}
-					]
-				}`, depl2.ID, depl2.State, formattedTimeForJSON(depl2.DeployedAt), depl2.Version,
+					],
+					"total": 1
+				}`, depl2.ID, depl2.State, formattedTimeForJSON(&depl2.CreatedAt), formattedTimeForJSON(depl2.DeployedAt), depl2.Version,
+				)))
+			})
+		})
+
+		Context("when a page is given", func() {
+			// listedIDs returns the IDs of the listed deployments and the total.
+			listedIDs := func() ([]uint, int) {
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var body struct {
+					Deployments []struct {
+						ID uint `json:"id"`
+					} `json:"deployments"`
+					Total int `json:"total"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&body)).To(BeNil())
+
+				ids := []uint{}
+				for _, d := range body.Deployments {
+					ids = append(ids, d.ID)
+				}
+				return ids, body.Total
+			}
+
+			It("returns the deployments on the page", func() {
+				query = url.Values{"page": {"1"}, "per_page": {"2"}}
+				doRequest()
+				ids, total := listedIDs()
+				Expect(ids).To(Equal([]uint{depl2.ID, depl1.ID}))
+				Expect(total).To(Equal(3))
+
+				query = url.Values{"page": {"2"}, "per_page": {"2"}}
+				doRequest()
+				ids, total = listedIDs()
+				Expect(ids).To(Equal([]uint{depl4.ID}))
+				Expect(total).To(Equal(3))
+			})
+
+			It("returns no deployments past the last page", func() {
+				query = url.Values{"page": {"3"}, "per_page": {"2"}}
+				doRequest()
+				ids, total := listedIDs()
+				Expect(ids).To(BeEmpty())
+				Expect(total).To(Equal(3))
+			})
+
+			It("does not list soft-deleted deployments", func() {
+				Expect(db.Delete(depl1).Error).To(BeNil())
+
+				query = url.Values{"per_page": {"2"}}
+				doRequest()
+				ids, total := listedIDs()
+				Expect(ids).To(Equal([]uint{depl2.ID, depl4.ID}))
+				Expect(total).To(Equal(2))
+			})
+
+			Context("when project has a limit on max deployments kept", func() {
+				BeforeEach(func() {
+					proj.MaxDeploysKept = 2
+					Expect(db.Save(proj).Error).To(BeNil())
+				})
+
+				It("does not list deployments past the limit", func() {
+					query = url.Values{"page": {"2"}, "per_page": {"1"}}
+					doRequest()
+					ids, total := listedIDs()
+					Expect(ids).To(Equal([]uint{depl1.ID}))
+					Expect(total).To(Equal(2))
+
+					query = url.Values{"page": {"2"}, "per_page": {"2"}}
+					doRequest()
+					ids, total = listedIDs()
+					Expect(ids).To(BeEmpty())
+					Expect(total).To(Equal(2))
+				})
+			})
+
+			Context("when there are more deployments than fit on a page by default", func() {
+				BeforeEach(func() {
+					for i := 0; i < 30; i++ {
+						factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+							State:      deployment.StateDeployed,
+							DeployedAt: timeAgo(time.Duration(5+i) * time.Hour),
+						})
+					}
+				})
+
+				It("lists 30 deployments", func() {
+					doRequest()
+					ids, total := listedIDs()
+					Expect(ids).To(HaveLen(30))
+					Expect(total).To(Equal(33))
+				})
+			})
+
+			It("returns 422 when the params are invalid", func() {
+				query = url.Values{"page": {"0"}, "per_page": {"101"}}
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"page": "must be a positive integer",
+						"per_page": "must be between 1 and 100"
+					}
+				}`))
+			})
+		})
+
+		Context("when a creation time range is given", func() {
+			BeforeEach(func() {
+				for _, d := range []struct {
+					depl *deployment.Deployment
+					ago  time.Duration
+				}{
+					{depl1, 3 * time.Hour},
+					{depl2, 2 * time.Hour},
+					{depl4, 4 * time.Hour},
+				} {
+					Expect(db.Model(d.depl).Update("created_at", *timeAgo(d.ago)).Error).To(BeNil())
+				}
+
+				query = url.Values{
+					"created_after":  {timeAgo(210 * time.Minute).Format(time.RFC3339)},
+					"created_before": {timeAgo(150 * time.Minute).Format(time.RFC3339)},
+				}
+			})
+
+			It("returns only deployments created in the range", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				depl1 = reloadDeployment(depl1)
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"deployments": [
+						{
+							"id": %d,
+							"state": "%s",
+							"created_at": %s,
+							"deployed_at": %s,
+							"version": %d
+						}
+					],
+					"total": 1
+				}`, depl1.ID, depl1.State, formattedTimeForJSON(&depl1.CreatedAt), formattedTimeForJSON(depl1.DeployedAt), depl1.Version,
 				)))
 			})
+
+			It("returns deployments created after the start when the range is open-ended", func() {
+				delete(query, "created_before")
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var body struct {
+					Deployments []struct {
+						ID uint `json:"id"`
+					} `json:"deployments"`
+				}
+				Expect(json.Unmarshal(b.Bytes(), &body)).To(BeNil())
+				Expect(body.Deployments).To(HaveLen(2))
+				Expect(body.Deployments[0].ID).To(Equal(depl2.ID))
+				Expect(body.Deployments[1].ID).To(Equal(depl1.ID))
+			})
+
+			It("returns 422 when a timestamp is invalid", func() {
+				query.Set("created_after", "yesterday")
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"created_after": "is not a valid RFC 3339 timestamp"
+					}
+				}`))
+			})
+
+			It("returns 422 when the range ends before it starts", func() {
+				query.Set("created_after", timeAgo(time.Hour).Format(time.RFC3339))
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"created_before": "must be later than created_after"
+					}
+				}`))
+			})
 		})
 	})
 })
+
+// useReplica configures the primary database as a read replica, which is a
+// separate connection, and records the queries made through it.
+func useReplica() (*gorm.DB, *queryRecorder) {
+	Expect(os.Setenv("POSTGRES_REPLICA_URL", os.Getenv("POSTGRES_URL"))).To(BeNil())
+
+	replica, err := dbconn.ReplicaDB()
+	Expect(err).To(BeNil())
+
+	primary, err := dbconn.DB()
+	Expect(err).To(BeNil())
+	Expect(replica).NotTo(BeIdenticalTo(primary))
+
+	recorder := &queryRecorder{}
+	replica.SetLogger(recorder)
+	replica.LogMode(true)
+
+	return replica, recorder
+}
+
+func stopUsingReplica(replica *gorm.DB) {
+	replica.LogMode(false)
+	Expect(os.Unsetenv("POSTGRES_REPLICA_URL")).To(BeNil())
+}
+
+// queryRecorder is a gorm logger that records the SQL of the queries made.
+type queryRecorder struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (r *queryRecorder) Print(v ...interface{}) {
+	if len(v) < 4 || v[0] != "sql" {
+		return
+	}
+
+	if q, ok := v[3].(string); ok {
+		r.mu.Lock()
+		r.queries = append(r.queries, q)
+		r.mu.Unlock()
+	}
+}
+
+func (r *queryRecorder) Queries() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.queries...)
+}
@@ -0,0 +1,219 @@
+package deployments
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// Number of files returned by Files at a time, unless a smaller limit is
+// given.
+const maxFilesPerPage = 1000
+
+// Where the list of files returned by Files came from.
+const (
+	filesSourceManifest = "manifest"
+	filesSourceStorage  = "storage"
+)
+
+// Files lists the paths of the files in the webroot of a deployment, in
+// lexicographical order. The list is taken from the manifest of the
+// deployment, or from the objects stored under its webroot for deployments
+// that were deployed before manifests were recorded. Paths are paginated with
+// the "after" and "limit" params; "next_after" is returned while there are
+// more paths.
+func Files(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	limit := maxFilesPerPage
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxFilesPerPage {
+			c.JSON(422, gin.H{
+				"error":  "invalid_params",
+				"errors": map[string]string{"limit": "must be between 1 and " + strconv.Itoa(maxFilesPerPage)},
+			})
+			return
+		}
+		limit = n
+	}
+
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if depl.State != deployment.StateDeployed && depl.State != deployment.StateUnpublished {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment has not been deployed",
+		})
+		return
+	}
+
+	files, source, err := deployedFiles(depl)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	after := c.Query("after")
+	start := sort.SearchStrings(paths, after)
+	if start < len(paths) && paths[start] == after {
+		start++
+	}
+	paths = paths[start:]
+
+	res := gin.H{
+		"source": source,
+	}
+	if len(paths) > limit {
+		paths = paths[:limit]
+		res["next_after"] = paths[limit-1]
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+	res["files"] = paths
+
+	c.JSON(http.StatusOK, res)
+}
+
+// deployedFiles returns the files in the webroot of a deployment mapped to
+// their sizes, along with where the list came from. The list is taken from the
+// manifest of the deployment, or from the objects stored under its webroot
+// for deployments that were deployed before manifests were recorded, whose
+// sizes are not known.
+func deployedFiles(depl *deployment.Deployment) (map[string]*int64, string, error) {
+	m, err := depl.ParsedManifest()
+	if err != nil {
+		return nil, "", err
+	}
+
+	files := map[string]*int64{}
+	if len(m) > 0 {
+		for p, entry := range m {
+			size := entry.Size
+			files[p] = &size
+		}
+		return files, filesSourceManifest, nil
+	}
+
+	// Stored objects include the pre-compressed variants of files, which
+	// cannot be told apart from files that were deployed as they are.
+	webroot := shared.DeploymentKey(depl.PrefixID(), "webroot/")
+	target := s3client.WebrootTargets[0]
+
+	keys, err := s3client.S3.List(target.Region, target.Bucket, webroot)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, key := range keys {
+		files[strings.TrimPrefix(key, webroot)] = nil
+	}
+	return files, filesSourceStorage, nil
+}
+
+// Checksum returns the checksum of the manifest of a deployment, which CI can
+// compare against one computed from the files it built to verify what was
+// deployed. See deployment.Manifest.Checksum for how it is computed.
+func Checksum(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if depl.State != deployment.StateDeployed && depl.State != deployment.StateUnpublished {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment has not been deployed",
+		})
+		return
+	}
+
+	m, err := depl.ParsedManifest()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Deployments from before manifests were recorded have nothing to
+	// compute the checksum from.
+	if len(m) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment has no manifest",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm":  "sha256",
+		"checksum":   m.Checksum(),
+		"file_count": len(m),
+	})
+}
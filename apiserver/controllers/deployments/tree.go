@@ -0,0 +1,156 @@
+package deployments
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// Types of the nodes of a file tree.
+const (
+	nodeTypeDirectory = "directory"
+	nodeTypeFile      = "file"
+)
+
+// treeNode is a directory or a file in the tree of the files of a deployment.
+// Sizes of files are only known if they come from the manifest.
+type treeNode struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Type     string      `json:"type"`
+	Size     *int64      `json:"size,omitempty"`
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+// buildTree returns the directory tree of the given files, which are mapped
+// to their sizes. The children of each directory are sorted by name, with
+// directories first.
+func buildTree(files map[string]*int64) *treeNode {
+	root := &treeNode{Path: "", Type: nodeTypeDirectory, Children: []*treeNode{}}
+	dirs := map[string]*treeNode{"": root}
+
+	var dir func(p string) *treeNode
+	dir = func(p string) *treeNode {
+		if n, ok := dirs[p]; ok {
+			return n
+		}
+		parent, name := path.Split(p)
+		n := &treeNode{Name: name, Path: p, Type: nodeTypeDirectory, Children: []*treeNode{}}
+		pn := dir(strings.TrimSuffix(parent, "/"))
+		pn.Children = append(pn.Children, n)
+		dirs[p] = n
+		return n
+	}
+
+	for p, size := range files {
+		parent, name := path.Split(p)
+		pn := dir(strings.TrimSuffix(parent, "/"))
+		pn.Children = append(pn.Children, &treeNode{Name: name, Path: p, Type: nodeTypeFile, Size: size})
+	}
+
+	for _, n := range dirs {
+		sort.Sort(byTypeAndName(n.Children))
+	}
+	return root
+}
+
+// find returns the node at the given path in the tree, or nil if there is
+// none. The root is at the empty path.
+func (n *treeNode) find(p string) *treeNode {
+	if p == "" {
+		return n
+	}
+	for _, child := range n.Children {
+		if child.Path == p {
+			return child
+		}
+		if child.Type == nodeTypeDirectory && strings.HasPrefix(p, child.Path+"/") {
+			return child.find(p)
+		}
+	}
+	return nil
+}
+
+type byTypeAndName []*treeNode
+
+func (s byTypeAndName) Len() int      { return len(s) }
+func (s byTypeAndName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byTypeAndName) Less(i, j int) bool {
+	if s[i].Type != s[j].Type {
+		return s[i].Type == nodeTypeDirectory
+	}
+	return s[i].Name < s[j].Name
+}
+
+// Tree returns the files in the webroot of a deployment as a tree of
+// directories and files, for browsing them. The files are taken from the same
+// source as Files. The "path" param picks the subtree of a directory, or a
+// single file.
+func Tree(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if depl.State != deployment.StateDeployed && depl.State != deployment.StateUnpublished {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment has not been deployed",
+		})
+		return
+	}
+
+	files, source, err := deployedFiles(depl)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	p := strings.Trim(path.Clean("/"+c.Query("path")), "/")
+	node := buildTree(files).find(p)
+	if node == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "path could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"source": source,
+		"tree":   node,
+	})
+}
@@ -1,6 +1,7 @@
 package domains
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,6 +21,7 @@ import (
 	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/meta"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
@@ -33,6 +35,21 @@ func Index(c *gin.Context) {
 		return
 	}
 
+	// The status of each domain is only listed when asked for, since it
+	// requires checking S3 for the meta.json of each domain.
+	if withStatus, _ := strconv.ParseBool(c.Query("status")); withStatus {
+		statuses, err := domainStatuses(db, proj)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"domains": statuses,
+		})
+		return
+	}
+
 	domNames, err := proj.DomainNames(db)
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -161,12 +178,7 @@ func Create(c *gin.Context) {
 
 	if err := db.Create(dom).Error; err != nil {
 		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
-			c.JSON(422, gin.H{
-				"error": "invalid_params",
-				"errors": map[string]interface{}{
-					"name": "is taken",
-				},
-			})
+			respondDomainTaken(c, db, dom.Name)
 			return
 		}
 
@@ -191,7 +203,7 @@ func Create(c *gin.Context) {
 		}
 	}
 
-	{
+	if !proj.AnalyticsOptOut {
 		u := controllers.CurrentUser(c)
 
 		var (
@@ -216,6 +228,42 @@ func Create(c *gin.Context) {
 	})
 }
 
+// respondDomainTaken responds with 409 Conflict to an attempt to add a domain
+// that another project, or the current one, already has. The project that has
+// it is only named if the current user owns it, so as not to reveal projects
+// of other users.
+func respondDomainTaken(c *gin.Context, db *gorm.DB, domainName string) {
+	u := controllers.CurrentUser(c)
+
+	dom, err := domain.FindByName(db, domainName)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if dom != nil {
+		owner := &project.Project{}
+		if err := db.First(owner, dom.ProjectID).Error; err != nil && err != gorm.RecordNotFound {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if owner.ID != 0 && owner.UserID == u.ID {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "already_exists",
+				"error_description": fmt.Sprintf("domain is already used by your project %q", owner.Name),
+				"project_name":      owner.Name,
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusConflict, gin.H{
+		"error":             "already_exists",
+		"error_description": "domain is already used by another project",
+	})
+}
+
 func Destroy(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 	domainName := c.Param("name")
@@ -247,7 +295,7 @@ func Destroy(c *gin.Context) {
 		}
 	}
 
-	metaJSONPath := "domains/" + domainName + "/meta.json"
+	metaJSONPath := meta.Path(domainName)
 	certificatePath := "certs/" + domainName + "/ssl.crt"
 	privateKeyPath := "certs/" + domainName + "/ssl.key"
 	if err := s3client.Delete(metaJSONPath, certificatePath, privateKeyPath); err != nil {
@@ -289,7 +337,7 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
-	{
+	if !proj.AnalyticsOptOut {
 		u := controllers.CurrentUser(c)
 
 		var (
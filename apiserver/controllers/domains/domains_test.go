@@ -11,6 +11,7 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/domains"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
@@ -160,6 +161,118 @@ var _ = Describe("Domains", func() {
 			})
 		})
 
+		Context("when the status of the domains is asked for", func() {
+			var (
+				origServingStatusTTL time.Duration
+				depl                 *deployment.Deployment
+			)
+
+			doStatusRequest := func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/domains?status=true", nil, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			BeforeEach(func() {
+				origServingStatusTTL = domains.ServingStatusTTL
+				domains.ServingStatusTTL = 0
+
+				for _, dn := range []string{"www.foo-bar-express.com", "www.foobarexpress.com"} {
+					factories.Domain(db, proj, dn)
+				}
+
+				depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+
+				fakeS3.ExistingKeys = map[string]bool{
+					"domains/" + proj.DefaultDomainName() + "/meta.json": true,
+					"domains/www.foo-bar-express.com/meta.json":          true,
+				}
+			})
+
+			AfterEach(func() {
+				domains.ServingStatusTTL = origServingStatusTTL
+			})
+
+			It("lists whether each domain is being served and what it points at", func() {
+				doStatusRequest()
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"domains": [
+						{
+							"name": "%s",
+							"serving": true,
+							"prefix": "%s"
+						},
+						{
+							"name": "www.foo-bar-express.com",
+							"serving": true,
+							"prefix": "%s"
+						},
+						{
+							"name": "www.foobarexpress.com",
+							"serving": false
+						}
+					]
+				}`, proj.DefaultDomainName(), depl.PrefixID(), depl.PrefixID())))
+			})
+
+			Context("when the meta of a domain could not be updated for the active deployment", func() {
+				BeforeEach(func() {
+					Expect(db.Model(depl).Update("failed_meta_domains", []byte(`["www.foo-bar-express.com"]`)).Error).To(BeNil())
+				})
+
+				It("does not list the active deployment as what the domain points at", func() {
+					doStatusRequest()
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+					Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+						"domains": [
+							{
+								"name": "%s",
+								"serving": true,
+								"prefix": "%s"
+							},
+							{
+								"name": "www.foo-bar-express.com",
+								"serving": true
+							},
+							{
+								"name": "www.foobarexpress.com",
+								"serving": false
+							}
+						]
+					}`, proj.DefaultDomainName(), depl.PrefixID())))
+				})
+			})
+
+			Context("when the serving status is cached", func() {
+				BeforeEach(func() {
+					domains.ServingStatusTTL = time.Minute
+				})
+
+				It("does not check S3 again for the same domains", func() {
+					doStatusRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+					Expect(fakeS3.ExistsCalls.Count()).To(Equal(3))
+
+					res.Body.Close()
+					s.Close()
+
+					doStatusRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+					Expect(fakeS3.ExistsCalls.Count()).To(Equal(3))
+				})
+			})
+		})
+
 		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
 			return db, u, &headers
 		}, func() *http.Response {
@@ -233,30 +346,102 @@ var _ = Describe("Domains", func() {
 				})
 			})
 
-			Context("when the domain name is taken", func() {
+			Context("when the domain name is taken by the same project", func() {
 				BeforeEach(func() {
-					dom := &domain.Domain{
-						Name:      "www.foo-bar-express.com",
-						ProjectID: proj.ID,
-					}
-					err := db.Create(dom).Error
+					factories.Domain(db, proj, "www.foo-bar-express.com")
+					doRequest()
+				})
+
+				It("returns 409 conflict naming the project", func() {
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
 					Expect(err).To(BeNil())
 
+					Expect(res.StatusCode).To(Equal(http.StatusConflict))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "already_exists",
+						"error_description": "domain is already used by your project \"foo-bar-express\"",
+						"project_name": "foo-bar-express"
+					}`))
+				})
+			})
+
+			Context("when the domain name is taken by another project of the same user", func() {
+				BeforeEach(func() {
+					proj2 := factories.Project(db, u, "baz-cloud")
+					factories.Domain(db, proj2, "www.foo-bar-express.com")
 					doRequest()
 				})
 
-				It("returns 422 unprocessable entity", func() {
+				It("returns 409 conflict naming the other project", func() {
 					b := &bytes.Buffer{}
 					_, err := b.ReadFrom(res.Body)
 					Expect(err).To(BeNil())
 
-					Expect(res.StatusCode).To(Equal(422))
+					Expect(res.StatusCode).To(Equal(http.StatusConflict))
 					Expect(b.String()).To(MatchJSON(`{
-						"error": "invalid_params",
-						"errors": {
-							"name": "is taken"
-						}
+						"error": "already_exists",
+						"error_description": "domain is already used by your project \"baz-cloud\"",
+						"project_name": "baz-cloud"
 					}`))
+
+					var count int
+					Expect(db.Model(domain.Domain{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+				})
+			})
+
+			Context("when the domain name is taken by a project of another user", func() {
+				var proj2 *project.Project
+
+				BeforeEach(func() {
+					proj2 = factories.Project(db, nil, "baz-cloud")
+					factories.Domain(db, proj2, "www.foo-bar-express.com")
+				})
+
+				It("returns 409 conflict without naming the other project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusConflict))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "already_exists",
+						"error_description": "domain is already used by another project"
+					}`))
+				})
+
+				Context("when the user is a collaborator of that project", func() {
+					BeforeEach(func() {
+						factories.Collab(db, proj2, u)
+					})
+
+					It("returns 409 conflict without naming the other project", func() {
+						doRequest()
+
+						b := &bytes.Buffer{}
+						_, err := b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(http.StatusConflict))
+						Expect(b.String()).To(MatchJSON(`{
+							"error": "already_exists",
+							"error_description": "domain is already used by another project"
+						}`))
+					})
+				})
+
+				Context("when the domain has been deleted from that project", func() {
+					BeforeEach(func() {
+						Expect(db.Delete(domain.Domain{}, "project_id = ?", proj2.ID).Error).To(BeNil())
+					})
+
+					It("returns 201 created", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusCreated))
+					})
 				})
 			})
 
@@ -297,6 +482,66 @@ var _ = Describe("Domains", func() {
 				})
 			})
 
+			Context("when the project has its own limit on the number of domains", func() {
+				var (
+					origMaxDomains int
+					existingNames  []string
+				)
+
+				domainNames := func() []string {
+					var doms []*domain.Domain
+					Expect(db.Where("project_id = ?", proj.ID).Order("id ASC").Find(&doms).Error).To(BeNil())
+
+					names := make([]string, len(doms))
+					for i, dom := range doms {
+						names[i] = dom.Name
+					}
+					return names
+				}
+
+				BeforeEach(func() {
+					origMaxDomains = shared.MaxDomainsPerProject
+					shared.MaxDomainsPerProject = 1
+
+					Expect(db.Model(proj).Update("max_domains", 3).Error).To(BeNil())
+
+					for i := 0; i < 2; i++ {
+						factories.Domain(db, proj)
+					}
+					existingNames = domainNames()
+				})
+
+				AfterEach(func() {
+					shared.MaxDomainsPerProject = origMaxDomains
+				})
+
+				It("allows adding domains beyond the global limit up to the project's limit", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+					Expect(domainNames()).To(Equal(append(existingNames, "www.foo-bar-express.com")))
+				})
+
+				It("returns 422 unprocessable entity once the project's limit is reached", func() {
+					factories.Domain(db, proj)
+					existingNames = domainNames()
+
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_request",
+						"error_description": "project cannot have more domains"
+					}`))
+
+					Expect(domainNames()).To(Equal(existingNames))
+				})
+			})
+
 			Context("when the domain name contains uppercase characters", func() {
 				BeforeEach(func() {
 					dom := &domain.Domain{
@@ -0,0 +1,114 @@
+package domains
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/shared/meta"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// ServingStatusTTL is how long whether the meta.json of a domain exists is
+// cached for, so that the dashboard polling the status of domains does not
+// hit S3 on every request. Nothing is cached if it is not positive.
+var ServingStatusTTL = 1 * time.Minute
+
+// DomainStatus describes how a domain of a project is being served.
+type DomainStatus struct {
+	Name string `json:"name"`
+	// Serving is whether the meta.json of the domain is uploaded.
+	Serving bool `json:"serving"`
+	// Prefix is the prefix of the deployment the domain points at, if it is
+	// known to point at the active deployment.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+type servingStatus struct {
+	serving   bool
+	checkedAt time.Time
+}
+
+var servingCache = struct {
+	sync.Mutex
+	statuses map[string]*servingStatus
+}{statuses: map[string]*servingStatus{}}
+
+// isServing returns whether the meta.json of a domain is uploaded.
+func isServing(domainName string) (bool, error) {
+	if ServingStatusTTL <= 0 {
+		return s3client.Exists(meta.Path(domainName))
+	}
+
+	servingCache.Lock()
+	st, ok := servingCache.statuses[domainName]
+	servingCache.Unlock()
+
+	if ok && time.Since(st.checkedAt) < ServingStatusTTL {
+		return st.serving, nil
+	}
+
+	serving, err := s3client.Exists(meta.Path(domainName))
+	if err != nil {
+		return false, err
+	}
+
+	servingCache.Lock()
+	servingCache.statuses[domainName] = &servingStatus{
+		serving:   serving,
+		checkedAt: time.Now(),
+	}
+	servingCache.Unlock()
+
+	return serving, nil
+}
+
+// domainStatuses returns the status of each domain of a project.
+func domainStatuses(db *gorm.DB, proj *project.Project) ([]*DomainStatus, error) {
+	domNames, err := proj.DomainNames(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		activeDepl  *deployment.Deployment
+		failedNames = map[string]bool{}
+	)
+	if proj.ActiveDeploymentID != nil {
+		activeDepl = &deployment.Deployment{}
+		if err := db.First(activeDepl, *proj.ActiveDeploymentID).Error; err != nil {
+			return nil, err
+		}
+
+		names, err := activeDepl.FailedMetaDomainNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			failedNames[name] = true
+		}
+	}
+
+	statuses := make([]*DomainStatus, 0, len(domNames))
+	for _, domName := range domNames {
+		serving, err := isServing(domName)
+		if err != nil {
+			return nil, err
+		}
+
+		st := &DomainStatus{
+			Name:    domName,
+			Serving: serving,
+		}
+		// Domains whose meta.json could not be updated when the active
+		// deployment was published still point at an older deployment.
+		if serving && activeDepl != nil && !failedNames[domName] {
+			st.Prefix = activeDepl.PrefixID()
+		}
+		statuses = append(statuses, st)
+	}
+
+	return statuses, nil
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/repo"
 	"github.com/nitrous-io/rise-server/pkg/githubapi"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 )
@@ -108,11 +109,21 @@ func GitHubPush(c *gin.Context) {
 		return
 	}
 
+	if proj.DeploysPaused {
+		c.String(http.StatusAccepted, "Deploys of this project are paused, aborting.")
+		return
+	}
+
 	// TODO We should record more metadata:
 	// E.g. "Triggered by GitHub push by @chuyeow. Changes: https://github.com/PubStorm/pubstorm-www/compare/a0fbcc76e4b2...5e908dc1f01e."
+	branch, commit := pl.Branch(), pl.After
 	depl := &deployment.Deployment{
 		ProjectID: rp.ProjectID,
 		UserID:    rp.UserID,
+		Branch:    &branch,
+		CommitSHA: &commit,
+
+		OpaquePrefix: shared.OpaqueDeploymentPrefixes,
 	}
 
 	// Get JS environment variables from previous deployment.
@@ -166,7 +177,7 @@ func GitHubPush(c *gin.Context) {
 		return
 	}
 
-	{
+	if !proj.AnalyticsOptOut {
 		// Track event, attributing it to the user who setup the GitHub repo
 		// integration.
 		var (
@@ -135,6 +135,10 @@ var _ = Describe("GitHub", func() {
 			Expect(depl.Version).To(Equal(int64(1)))
 			Expect(depl.RawBundleID).To(BeNil())
 			Expect(depl.JsEnvVars).To(Equal([]byte("{}")))
+			Expect(depl.Branch).NotTo(BeNil())
+			Expect(*depl.Branch).To(Equal("master"))
+			Expect(depl.CommitSHA).NotTo(BeNil())
+			Expect(*depl.CommitSHA).To(Equal("5e908dc1f01e9e5ae2ff1314666e366cbc7260dc"))
 
 			push := &push.Push{}
 			db.Last(push)
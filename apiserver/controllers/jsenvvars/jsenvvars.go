@@ -12,6 +12,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 )
@@ -205,6 +206,8 @@ func deployWithJsEnvVars(db *gorm.DB, u *user.User, proj *project.Project, curre
 		UserID:      u.ID,
 		JsEnvVars:   updatedJSON,
 		RawBundleID: currentDepl.RawBundleID,
+
+		OpaquePrefix: shared.OpaqueDeploymentPrefixes,
 	}
 
 	ver, err := proj.NextVersion(db)
@@ -13,7 +13,6 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
-	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
@@ -47,9 +46,8 @@ var _ = Describe("JSEnvVars", func() {
 		res *http.Response
 		err error
 
-		u  *user.User
-		oc *oauthclient.OauthClient
-		t  *oauthtoken.OauthToken
+		u *user.User
+		t *oauthtoken.OauthToken
 
 		headers http.Header
 		proj    *project.Project
@@ -65,7 +63,7 @@ var _ = Describe("JSEnvVars", func() {
 		Expect(err).To(BeNil())
 
 		testhelper.TruncateTables(db.DB())
-		u, oc, t = factories.AuthTrio(db)
+		u, _, t = factories.AuthTrio(db)
 
 		proj = &project.Project{
 			Name:   "foo-bar-express",
@@ -80,7 +80,7 @@ func AddCollaborator(c *gin.Context) {
 		return
 	}
 
-	{
+	if !proj.AnalyticsOptOut {
 		currUser := controllers.CurrentUser(c)
 
 		var (
@@ -136,7 +136,7 @@ func RemoveCollaborator(c *gin.Context) {
 		}
 	}
 
-	{
+	if !proj.AnalyticsOptOut {
 		currUser := controllers.CurrentUser(c)
 
 		var (
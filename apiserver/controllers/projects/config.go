@@ -0,0 +1,202 @@
+package projects
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// Export returns the configuration of a project, which can be imported as a
+// new project with Import.
+func Export(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	cfg, err := proj.Config(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"config": cfg,
+	})
+}
+
+// Import creates a project from an exported configuration. Settings left out
+// of the configuration get their defaults.
+func Import(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	cfg := project.NewConfig()
+	if err := c.Bind(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	cfg.Name = strings.ToLower(cfg.Name)
+	proj := &project.Project{UserID: u.ID}
+	if err := proj.ApplyConfig(cfg); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	errs := proj.Validate()
+	if errs == nil {
+		errs = map[string]string{}
+	}
+
+	doms := make([]*domain.Domain, len(cfg.Domains))
+	for i, name := range cfg.Domains {
+		dom := &domain.Domain{Name: strings.ToLower(name)}
+		if dom.Sanitize() != nil || dom.Validate() != nil {
+			errs["domains"] = fmt.Sprintf("contains an invalid domain (%s)", name)
+			break
+		}
+		doms[i] = dom
+	}
+
+	if len(doms) > proj.DomainLimit() {
+		errs["domains"] = fmt.Sprintf("has too many domains (max. %d)", proj.DomainLimit())
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	blacklisted, err := blacklistedname.IsBlacklisted(db, proj.Name)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if blacklisted {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"name": "is taken",
+			},
+		})
+		return
+	}
+
+	canCreate, err := project.CanAddProject(db, u)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !canCreate {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "invalid_request",
+			"error_description": "maximum number of projects reached",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.Create(proj).Error; err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					"name": "is taken",
+				},
+			})
+			return
+		}
+
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Columns with defaults are left out of the insert when their value is
+	// false or zero, so the project is saved again to keep those values.
+	if err := tx.Save(proj).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	for _, dom := range doms {
+		dom.ProjectID = proj.ID
+		if err := tx.Create(dom).Error; err != nil {
+			if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+				c.JSON(422, gin.H{
+					"error": "invalid_params",
+					"errors": map[string]interface{}{
+						"domains": fmt.Sprintf("contains a domain that is taken (%s)", dom.Name),
+					},
+				})
+				return
+			}
+
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Re-fetch from db to get correct timestamps.
+	if err := db.First(proj, proj.ID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !proj.AnalyticsOptOut {
+		var (
+			event   = "Imported Project"
+			props   = map[string]interface{}{"projectName": proj.Name}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"project": proj.AsJSON(),
+	})
+}
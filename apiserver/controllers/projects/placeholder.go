@@ -0,0 +1,138 @@
+package projects
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"html"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// placeholderLabel is the label of placeholder deployments, to tell them from
+// deployments of the user's own files.
+const placeholderLabel = "Coming soon placeholder"
+
+const placeholderHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%[1]s - Coming soon</title>
+<style>
+body { margin: 0; font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; color: #333; background: #f7f7f7; }
+main { max-width: 600px; margin: 20vh auto 0; padding: 0 20px; text-align: center; }
+h1 { font-size: 2.5em; font-weight: 300; }
+</style>
+</head>
+<body>
+<main>
+<h1>%[1]s</h1>
+<p>This site is coming soon.</p>
+</main>
+</body>
+</html>
+`
+
+// placeholderBundle returns a tar.gz bundle of the placeholder page of a
+// project.
+func placeholderBundle(projName string) ([]byte, error) {
+	content := []byte(fmt.Sprintf(placeholderHTML, html.EscapeString(projName)))
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name: "index.html",
+		Mode: 0644,
+		Size: int64(len(content)),
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// deployPlaceholder uploads the placeholder page of a newly created project
+// and enqueues a job to deploy it. The page needs no building, so it goes
+// straight to the deploy queue.
+func deployPlaceholder(db *gorm.DB, proj *project.Project) (*deployment.Deployment, error) {
+	bundle, err := placeholderBundle(proj.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ver, err := proj.NextVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	label := placeholderLabel
+	depl := &deployment.Deployment{
+		ProjectID:    proj.ID,
+		UserID:       proj.UserID,
+		Version:      ver,
+		Label:        &label,
+		OpaquePrefix: shared.OpaqueDeploymentPrefixes,
+	}
+	if err := db.Create(depl).Error; err != nil {
+		return nil, err
+	}
+
+	uploadKey := shared.DeploymentKey(depl.PrefixID(), "raw-bundle.tar.gz")
+	if err := s3client.Upload(uploadKey, bytes.NewReader(bundle), "", "private"); err != nil {
+		return nil, err
+	}
+
+	bun := &rawbundle.RawBundle{
+		ProjectID:    proj.ID,
+		UploadedPath: uploadKey,
+		Size:         int64(len(bundle)),
+	}
+	if err := db.Create(bun).Error; err != nil {
+		return nil, err
+	}
+
+	depl.RawBundleID = &bun.ID
+	if err := depl.UpdateState(db, deployment.StateUploaded); err != nil {
+		return nil, err
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:  depl.ID,
+		UseRawBundle:  true,
+		ArchiveFormat: "tar.gz",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.Enqueue(); err != nil {
+		return nil, err
+	}
+
+	if err := depl.UpdateState(db, deployment.StatePendingDeploy); err != nil {
+		return nil, err
+	}
+
+	return depl, nil
+}
@@ -1,17 +1,21 @@
 package projects
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/lib/pq"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/pkg/job"
@@ -19,6 +23,7 @@ import (
 	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/meta"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
@@ -112,6 +117,14 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	if placeholderPage, _ := strconv.ParseBool(c.PostForm("placeholder_page")); placeholderPage {
+		// The project is usable without the placeholder, so failing to deploy
+		// it does not fail the request.
+		if _, err := deployPlaceholder(db, proj); err != nil {
+			log.Errorf("failed to deploy placeholder page of project %q, err: %v", proj.Name, err)
+		}
+	}
+
 	{
 		var (
 			event   = "Created Project"
@@ -140,6 +153,124 @@ func Get(c *gin.Context) {
 	})
 }
 
+// GetLock returns whether the project is currently locked, and if so, since
+// when and by which deployment (when it can be determined).
+func GetLock(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if proj.LockedAt == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"lock": gin.H{"locked": false},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	lock := gin.H{
+		"locked":    true,
+		"locked_at": proj.LockedAt,
+	}
+
+	depl, err := deployment.InProgress(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if depl != nil {
+		lock["deployment"] = depl.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"lock": lock,
+	})
+}
+
+// Pause stops new deployments of the project from going out until it is
+// unpaused. Deployments that are already in progress are failed once they
+// reach the deployer.
+func Pause(c *gin.Context) {
+	setDeploysPaused(c, true, "Paused Deploys")
+}
+
+// Unpause lets deployments of the project go out again.
+func Unpause(c *gin.Context) {
+	setDeploysPaused(c, false, "Unpaused Deploys")
+}
+
+func setDeploysPaused(c *gin.Context, paused bool, event string) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Model(project.Project{}).Where("id = ?", proj.ID).Update("deploys_paused", paused).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	proj.DeploysPaused = paused
+
+	if !proj.AnalyticsOptOut {
+		var (
+			props   = map[string]interface{}{"projectName": proj.Name}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": proj.AsJSON(),
+	})
+}
+
+// MetaPreview returns the meta.json that the deployer would upload for the
+// domains of the project with its current settings. The prefix is that of the
+// active deployment, and is empty if the project has not been deployed yet.
+func MetaPreview(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	depl := &deployment.Deployment{}
+	if proj.ActiveDeploymentID != nil {
+		db, err := dbconn.DB()
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if err := db.First(depl, *proj.ActiveDeploymentID).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	m, err := meta.New(proj, depl)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if proj.ActiveDeploymentID == nil {
+		m.Prefix = ""
+	}
+
+	c.JSON(http.StatusOK, m)
+}
+
 func Index(c *gin.Context) {
 	u := controllers.CurrentUser(c)
 
@@ -177,6 +308,17 @@ func Index(c *gin.Context) {
 	})
 }
 
+// splitList returns the non-empty items of a comma-separated list.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func Update(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
@@ -184,6 +326,115 @@ func Update(c *gin.Context) {
 	updatedProj := *proj
 	projChanged := false
 
+	if publishGateURL, ok := c.GetPostForm("publish_gate_url"); ok {
+		// An empty URL removes the publish gate.
+		updatedProj.PublishGateURL = nil
+		if publishGateURL != "" {
+			updatedProj.PublishGateURL = &publishGateURL
+		}
+		projChanged = true
+	}
+
+	if preDeployHookURL, ok := c.GetPostForm("pre_deploy_hook_url"); ok {
+		// An empty URL removes the pre-deploy hook.
+		updatedProj.PreDeployHookURL = nil
+		if preDeployHookURL != "" {
+			updatedProj.PreDeployHookURL = &preDeployHookURL
+		}
+		projChanged = true
+	}
+
+	if slackWebhookURL, ok := c.GetPostForm("slack_webhook_url"); ok {
+		// An empty URL turns Slack notifications off.
+		updatedProj.SlackWebhookURL = nil
+		if slackWebhookURL != "" {
+			updatedProj.SlackWebhookURL = &slackWebhookURL
+		}
+		projChanged = true
+	}
+
+	if securityContact, ok := c.GetPostForm("security_contact"); ok {
+		// An empty contact stops security.txt from being generated. An email
+		// address is taken as a mailto URI.
+		updatedProj.SecurityContact = nil
+		if securityContact != "" {
+			if !strings.Contains(securityContact, ":") && strings.Contains(securityContact, "@") {
+				securityContact = "mailto:" + securityContact
+			}
+			updatedProj.SecurityContact = &securityContact
+		}
+		projChanged = true
+	}
+
+	if requiredFiles, ok := c.GetPostForm("required_files"); ok {
+		// Required files are given as a comma-separated list of paths.
+		b, err := json.Marshal(splitList(requiredFiles))
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		updatedProj.RequiredFiles = b
+		projChanged = true
+	}
+
+	if includeGlobs, ok := c.GetPostForm("include_globs"); ok {
+		b, err := json.Marshal(splitList(includeGlobs))
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		updatedProj.IncludeGlobs = b
+		projChanged = true
+	}
+
+	if excludeGlobs, ok := c.GetPostForm("exclude_globs"); ok {
+		b, err := json.Marshal(splitList(excludeGlobs))
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		updatedProj.ExcludeGlobs = b
+		projChanged = true
+	}
+
+	if defaultDeployOptions, ok := c.GetPostForm("default_deploy_options"); ok {
+		// Default deploy options are given as a JSON object. An empty value
+		// removes them.
+		if strings.TrimSpace(defaultDeployOptions) == "" {
+			defaultDeployOptions = "{}"
+		}
+		updatedProj.DefaultDeployOptions = []byte(defaultDeployOptions)
+		projChanged = true
+	}
+
+	if jsEnvFilename, ok := c.GetPostForm("js_env_filename"); ok {
+		// An empty filename restores the default.
+		updatedProj.JsEnvFilename = project.DefaultJsEnvFilename
+		if jsEnvFilename != "" {
+			updatedProj.JsEnvFilename = jsEnvFilename
+		}
+		projChanged = true
+	}
+
+	// Only validate the settings that can be updated here, e.g. the basic auth
+	// password is not loaded and would fail validation.
+	if errs := updatedProj.Validate(); errs != nil {
+		settingErrs := map[string]string{}
+		for _, key := range []string{"publish_gate_url", "pre_deploy_hook_url", "slack_webhook_url", "security_contact", "required_files", "include_globs", "exclude_globs", "default_deploy_options", "js_env_filename"} {
+			if errs[key] != "" {
+				settingErrs[key] = errs[key]
+			}
+		}
+
+		if len(settingErrs) > 0 {
+			c.JSON(422, gin.H{
+				"error":  "invalid_params",
+				"errors": settingErrs,
+			})
+			return
+		}
+	}
+
 	if c.PostForm("default_domain_enabled") != "" {
 		defaultDomainEnabled, _ := strconv.ParseBool(c.PostForm("default_domain_enabled"))
 		updatedProj.DefaultDomainEnabled = defaultDomainEnabled
@@ -214,7 +465,7 @@ func Update(c *gin.Context) {
 					// If default domain was just disabled, we need to remove it so that it no longer works.
 					defaultDomain := proj.Name + "." + shared.DefaultDomain
 
-					if err := s3client.Delete("/domains/" + defaultDomain + "/meta.json"); err != nil {
+					if err := s3client.Delete(meta.Path(defaultDomain)); err != nil {
 						controllers.InternalServerError(c, err)
 						return
 					}
@@ -273,6 +524,158 @@ func Update(c *gin.Context) {
 		}
 	}
 
+	if c.PostForm("auto_publish") != "" {
+		autoPublish, _ := strconv.ParseBool(c.PostForm("auto_publish"))
+		updatedProj.AutoPublish = autoPublish
+		if proj.AutoPublish != updatedProj.AutoPublish {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("optimize_images") != "" {
+		optimizeImages, _ := strconv.ParseBool(c.PostForm("optimize_images"))
+		updatedProj.OptimizeImages = optimizeImages
+		if proj.OptimizeImages != updatedProj.OptimizeImages {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("strict_content_types") != "" {
+		strictContentTypes, _ := strconv.ParseBool(c.PostForm("strict_content_types"))
+		updatedProj.StrictContentTypes = strictContentTypes
+		if proj.StrictContentTypes != updatedProj.StrictContentTypes {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("minify_html") != "" {
+		minifyHTML, _ := strconv.ParseBool(c.PostForm("minify_html"))
+		updatedProj.MinifyHTML = minifyHTML
+		if proj.MinifyHTML != updatedProj.MinifyHTML {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("js_env_disabled") != "" {
+		jsEnvDisabled, _ := strconv.ParseBool(c.PostForm("js_env_disabled"))
+		updatedProj.JsEnvDisabled = jsEnvDisabled
+		if proj.JsEnvDisabled != updatedProj.JsEnvDisabled {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("content_hash_prefixes") != "" {
+		contentHashPrefixes, _ := strconv.ParseBool(c.PostForm("content_hash_prefixes"))
+		updatedProj.ContentHashPrefixes = contentHashPrefixes
+		if proj.ContentHashPrefixes != updatedProj.ContentHashPrefixes {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("check_internal_links") != "" {
+		checkInternalLinks, _ := strconv.ParseBool(c.PostForm("check_internal_links"))
+		updatedProj.CheckInternalLinks = checkInternalLinks
+		if proj.CheckInternalLinks != updatedProj.CheckInternalLinks {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("csp_nonces") != "" {
+		cspNonces, _ := strconv.ParseBool(c.PostForm("csp_nonces"))
+		updatedProj.CSPNonces = cspNonces
+		if proj.CSPNonces != updatedProj.CSPNonces {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("fingerprint") != "" {
+		fingerprint, _ := strconv.ParseBool(c.PostForm("fingerprint"))
+		updatedProj.Fingerprint = fingerprint
+		if proj.Fingerprint != updatedProj.Fingerprint {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("validate_js") != "" {
+		validateJS, _ := strconv.ParseBool(c.PostForm("validate_js"))
+		updatedProj.ValidateJS = validateJS
+		if proj.ValidateJS != updatedProj.ValidateJS {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("validate_json") != "" {
+		validateJSON, _ := strconv.ParseBool(c.PostForm("validate_json"))
+		updatedProj.ValidateJSON = validateJSON
+		if proj.ValidateJSON != updatedProj.ValidateJSON {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("accessibility_check") != "" {
+		accessibilityCheck, _ := strconv.ParseBool(c.PostForm("accessibility_check"))
+		updatedProj.AccessibilityCheck = accessibilityCheck
+		if proj.AccessibilityCheck != updatedProj.AccessibilityCheck {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("check_mixed_content") != "" {
+		checkMixedContent, _ := strconv.ParseBool(c.PostForm("check_mixed_content"))
+		updatedProj.CheckMixedContent = checkMixedContent
+		if proj.CheckMixedContent != updatedProj.CheckMixedContent {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("strict_mixed_content") != "" {
+		strictMixedContent, _ := strconv.ParseBool(c.PostForm("strict_mixed_content"))
+		updatedProj.StrictMixedContent = strictMixedContent
+		if proj.StrictMixedContent != updatedProj.StrictMixedContent {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("asset_manifest") != "" {
+		assetManifest, _ := strconv.ParseBool(c.PostForm("asset_manifest"))
+		updatedProj.AssetManifest = assetManifest
+		if proj.AssetManifest != updatedProj.AssetManifest {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("preload_critical_assets") != "" {
+		preloadCriticalAssets, _ := strconv.ParseBool(c.PostForm("preload_critical_assets"))
+		updatedProj.PreloadCriticalAssets = preloadCriticalAssets
+		if proj.PreloadCriticalAssets != updatedProj.PreloadCriticalAssets {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("generate_favicons") != "" {
+		generateFavicons, _ := strconv.ParseBool(c.PostForm("generate_favicons"))
+		updatedProj.GenerateFavicons = generateFavicons
+		if proj.GenerateFavicons != updatedProj.GenerateFavicons {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("analytics_opt_out") != "" {
+		analyticsOptOut, _ := strconv.ParseBool(c.PostForm("analytics_opt_out"))
+		updatedProj.AnalyticsOptOut = analyticsOptOut
+		if proj.AnalyticsOptOut != updatedProj.AnalyticsOptOut {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("tombstone_removed_paths") != "" {
+		tombstoneRemovedPaths, _ := strconv.ParseBool(c.PostForm("tombstone_removed_paths"))
+		updatedProj.TombstoneRemovedPaths = tombstoneRemovedPaths
+		if proj.TombstoneRemovedPaths != updatedProj.TombstoneRemovedPaths {
+			projChanged = true
+		}
+	}
+
 	if projChanged {
 		db, err := dbconn.DB()
 		if err != nil {
@@ -285,7 +688,7 @@ func Update(c *gin.Context) {
 			return
 		}
 
-		{
+		if !updatedProj.AnalyticsOptOut {
 			u := controllers.CurrentUser(c)
 
 			if proj.DefaultDomainEnabled != updatedProj.DefaultDomainEnabled {
@@ -362,7 +765,7 @@ func Destroy(c *gin.Context) {
 	// Delete ssl certs from S3
 	var filesToDelete []string
 	for _, domainName := range domainNames {
-		filesToDelete = append(filesToDelete, "domains/"+domainName+"/meta.json")
+		filesToDelete = append(filesToDelete, meta.Path(domainName))
 		if domainName != proj.DefaultDomainName() {
 			filesToDelete = append(filesToDelete, "certs/"+domainName+"/ssl.crt")
 			filesToDelete = append(filesToDelete, "certs/"+domainName+"/ssl.key")
@@ -401,7 +804,7 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
-	{
+	if !proj.AnalyticsOptOut {
 		u := controllers.CurrentUser(c)
 
 		var (
@@ -423,6 +826,147 @@ func Destroy(c *gin.Context) {
 	})
 }
 
+// CreateStatusToken enables the public status page of the project with a new
+// token, which replaces the previous one if there was one.
+func CreateStatusToken(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := proj.RegenerateStatusToken(db); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status_token": *proj.StatusToken,
+	})
+}
+
+// DestroyStatusToken disables the public status page of the project.
+func DestroyStatusToken(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := proj.DisableStatusPage(db); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": true,
+	})
+}
+
+// CreateDeployToken creates a token that can only be used to deploy the
+// project, e.g. from CI.
+func CreateDeployToken(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	scope := oauthtoken.ScopeDeploy
+	t := &oauthtoken.OauthToken{
+		UserID:        u.ID,
+		OauthClientID: controllers.CurrentToken(c).OauthClientID,
+		ProjectID:     &proj.ID,
+		Scope:         &scope,
+	}
+	if err := db.Create(t).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"deploy_token": gin.H{
+			"token": t.Token,
+			"scope": scope,
+		},
+	})
+}
+
+// ListDeployTokens lists the deploy tokens of the project, masked.
+func ListDeployTokens(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	tokens, err := oauthtoken.DeployTokensByProject(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	tokensAsJSON := make([]interface{}, len(tokens))
+	for i, t := range tokens {
+		tokensAsJSON[i] = t.AsDeployTokenJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deploy_tokens": tokensAsJSON,
+	})
+}
+
+// DestroyDeployToken revokes a deploy token of the project.
+func DestroyDeployToken(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	tokenID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deploy token could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	t := &oauthtoken.OauthToken{}
+	if err := db.Where("id = ? AND project_id = ? AND scope = ?", tokenID, proj.ID, oauthtoken.ScopeDeploy).First(t).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deploy token could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Delete(t).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": true,
+	})
+}
+
 func CreateAuth(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
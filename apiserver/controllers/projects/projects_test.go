@@ -2,12 +2,16 @@ package projects_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
@@ -238,6 +242,7 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
+						"auto_publish": true,
 						"created_at": %s
 					}
 				}`, createdAtJSON)))
@@ -302,6 +307,7 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
+						"auto_publish": true,
 						"created_at": %s
 					}
 				}`, createdAtJSON)))
@@ -333,6 +339,86 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when a placeholder page is requested", func() {
+			var (
+				fakeS3 *fake.S3
+				origS3 filetransfer.FileTransfer
+				mq     *amqp.Connection
+			)
+
+			BeforeEach(func() {
+				origS3 = s3client.S3
+				fakeS3 = &fake.S3{}
+				s3client.S3 = fakeS3
+
+				mq, err = mqconn.MQ()
+				Expect(err).To(BeNil())
+				testhelper.DeleteQueue(mq, queues.All...)
+
+				params.Set("placeholder_page", "true")
+			})
+
+			AfterEach(func() {
+				s3client.S3 = origS3
+			})
+
+			It("creates a placeholder deployment and enqueues a job to deploy it", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				proj := &project.Project{}
+				Expect(db.Last(proj).Error).To(BeNil())
+
+				depl := &deployment.Deployment{}
+				Expect(db.Where("project_id = ?", proj.ID).First(depl).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+				Expect(depl.Version).To(Equal(int64(1)))
+				Expect(depl.RawBundleID).NotTo(BeNil())
+				Expect(depl.Label).NotTo(BeNil())
+				Expect(*depl.Label).To(Equal("Coming soon placeholder"))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+				call := fakeS3.UploadCalls.NthCall(1)
+				Expect(call.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())))
+
+				gr, err := gzip.NewReader(bytes.NewReader(call.SideEffects["uploaded_content"].([]byte)))
+				Expect(err).To(BeNil())
+				bundle, err := ioutil.ReadAll(gr)
+				Expect(err).To(BeNil())
+				Expect(string(bundle)).To(ContainSubstring("index.html"))
+				Expect(string(bundle)).To(ContainSubstring("<h1>foo-bar-express</h1>"))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": false,
+					"skip_invalidation": false,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+			})
+
+			Context("when the option is not set", func() {
+				BeforeEach(func() {
+					params.Del("placeholder_page")
+				})
+
+				It("does not deploy anything", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+					var count int
+					Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).To(BeNil())
+				})
+			})
+		})
+
 		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
 			return db, u, &headers
 		}, func() *http.Response {
@@ -382,6 +468,7 @@ var _ = Describe("Projects", func() {
 					"default_domain_enabled": true,
 					"force_https": false,
 					"skip_build": false,
+					"auto_publish": true,
 					"created_at": %s
 				}
 			}`, proj.Name, createdAtJSON)))
@@ -402,317 +489,247 @@ var _ = Describe("Projects", func() {
 		}, nil)
 	})
 
-	Describe("GET /projects", func() {
+	Describe("GET /projects/:name/lock", func() {
 		var (
-			headers http.Header
-
-			anotherU *user.User
+			proj *project.Project
 
-			proj  *project.Project
-			proj2 *project.Project
-			proj3 *project.Project
+			headers http.Header
 		)
 
 		BeforeEach(func() {
+			proj = factories.Project(db, u)
 			headers = http.Header{
 				"Authorization": {"Bearer " + t.Token},
 			}
-
-			anotherU = factories.User(db)
-
-			proj = factories.Project(db, u, "site-1")
-			proj2 = factories.Project(db, anotherU, "site-2")
-			proj3 = factories.Project(db, u, "site-3")
 		})
 
 		doRequest := func() {
 			s = httptest.NewServer(server.New())
-			res, err = testhelper.MakeRequest("GET", s.URL+"/projects", nil, headers, nil)
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/"+proj.Name+"/lock", nil, headers, nil)
 			Expect(err).To(BeNil())
 		}
 
-		It("returns current user's projects ordered by name", func() {
+		It("returns 200 OK and reports the project as unlocked", func() {
 			doRequest()
 
 			b := &bytes.Buffer{}
 			_, err := b.ReadFrom(res.Body)
 			Expect(err).To(BeNil())
 
-			proj := &project.Project{}
-			err = db.Where("name = 'site-1'").First(proj).Error
-			Expect(err).To(BeNil())
-
-			createdAtJSON, err := proj.CreatedAt.MarshalJSON()
-			Expect(err).To(BeNil())
-
-			proj3 := &project.Project{}
-			err = db.Where("name = 'site-3'").First(proj3).Error
-			Expect(err).To(BeNil())
-
-			createdAt3JSON, err := proj3.CreatedAt.MarshalJSON()
-			Expect(err).To(BeNil())
-
 			Expect(res.StatusCode).To(Equal(http.StatusOK))
-			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-				"projects": [
-					{
-						"name": "%s",
-						"default_domain_enabled": true,
-						"force_https": false,
-						"skip_build": false,
-						"created_at": %s
-					},
-					{
-						"name": "%s",
-						"default_domain_enabled": true,
-						"force_https": false,
-						"skip_build": false,
-						"created_at": %s
-					}
-				],
-				"shared_projects": []
-			}`, proj.Name, createdAtJSON, proj3.Name, createdAt3JSON)))
+			Expect(b.String()).To(MatchJSON(`{
+				"lock": {
+					"locked": false
+				}
+			}`))
 		})
 
-		Context("when user is a collaborator of other users' projects", func() {
-			var (
-				yetAnotherU *user.User
-				proj4       *project.Project
-				proj5       *project.Project
-				proj6       *project.Project
-			)
+		Context("when a deploy holds the lock", func() {
+			var depl *deployment.Deployment
 
 			BeforeEach(func() {
-				yetAnotherU = factories.User(db)
-
-				proj4 = factories.Project(db, anotherU, "site-4")
-				proj5 = factories.Project(db, yetAnotherU, "site-5")
-				proj6 = factories.Project(db, yetAnotherU, "site-6")
+				factories.Deployment(db, proj, u, deployment.StateDeployed)
+				depl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
 
-				err := proj4.AddCollaborator(db, u)
-				Expect(err).To(BeNil())
-				err = proj5.AddCollaborator(db, u)
+				acquired, err := proj.Lock(db)
 				Expect(err).To(BeNil())
+				Expect(acquired).To(BeTrue())
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
 			})
 
-			It("returns the shared projects ordered by name", func() {
+			It("returns 200 OK and reports the project as locked by the deployment", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
 				_, err := b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				proj := &project.Project{}
-				err = db.Where("name = 'site-1'").First(proj).Error
-				Expect(err).To(BeNil())
-
-				createdAtJSON, err := proj.CreatedAt.MarshalJSON()
-				Expect(err).To(BeNil())
-
-				proj3 := &project.Project{}
-				err = db.Where("name = 'site-3'").First(proj3).Error
-				Expect(err).To(BeNil())
-
-				createdAt3JSON, err := proj3.CreatedAt.MarshalJSON()
-				Expect(err).To(BeNil())
-
-				proj4 := &project.Project{}
-				err = db.Where("name = 'site-4'").First(proj4).Error
-				Expect(err).To(BeNil())
-
-				createdAt4JSON, err := proj4.CreatedAt.MarshalJSON()
-				Expect(err).To(BeNil())
-
-				proj5 := &project.Project{}
-				err = db.Where("name = 'site-5'").First(proj5).Error
-				Expect(err).To(BeNil())
-
-				createdAt5JSON, err := proj5.CreatedAt.MarshalJSON()
+				lockedAtJSON, err := proj.LockedAt.MarshalJSON()
 				Expect(err).To(BeNil())
 
 				Expect(res.StatusCode).To(Equal(http.StatusOK))
 				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"projects": [
-						{
-							"name": "%s",
-							"default_domain_enabled": true,
-							"force_https": false,
-							"skip_build": false,
-							"created_at": %s
-						},
-						{
-							"name": "%s",
-							"default_domain_enabled": true,
-							"force_https": false,
-							"skip_build": false,
-							"created_at": %s
-						}
-					],
-					"shared_projects": [
-						{
-							"name": "%s",
-							"default_domain_enabled": true,
-							"force_https": false,
-							"skip_build": false,
-							"created_at": %s
-						},
-						{
-							"name": "%s",
-							"default_domain_enabled": true,
-							"force_https": false,
-							"skip_build": false,
-							"created_at": %s
+					"lock": {
+						"locked": true,
+						"locked_at": %s,
+						"deployment": {
+							"id": %d,
+							"state": "pending_deploy",
+							"version": %d
 						}
-					]
-				}`, proj.Name, createdAtJSON, proj3.Name, createdAt3JSON, proj4.Name, createdAt4JSON, proj5.Name, createdAt5JSON)))
+					}
+				}`, lockedAtJSON, depl.ID, depl.Version)))
 			})
 		})
 
-		Context("when some projects have deployed and have collaborators", func() {
-			var (
-				depl  *deployment.Deployment
-				depl4 *deployment.Deployment
-				proj4 *project.Project
-			)
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-			BeforeEach(func() {
-				u2 := factories.User(db)
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
 
-				proj4 = factories.Project(db, u2, "site-4")
-				err := proj4.AddCollaborator(db, u)
-				Expect(err).To(BeNil())
+	Describe("PUT /projects/:name/pause", func() {
+		var (
+			proj *project.Project
 
-				depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
-				factories.Deployment(db, proj3, u, deployment.StatePendingDeploy)
-				depl4 = factories.Deployment(db, proj4, u2, deployment.StateDeployed)
-			})
+			headers http.Header
+		)
 
-			It("responses with deployed at", func() {
-				doRequest()
+		BeforeEach(func() {
+			proj = factories.Project(db, u)
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+		})
 
-				b := &bytes.Buffer{}
-				_, err := b.ReadFrom(res.Body)
-				Expect(err).To(BeNil())
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/"+proj.Name+"/pause", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
 
-				// project 1
-				Expect(db.First(proj, proj.ID).Error).To(BeNil())
-				createdAtJSON, err := proj.CreatedAt.MarshalJSON()
-				Expect(err).To(BeNil())
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-				Expect(db.First(depl, depl.ID).Error).To(BeNil())
-				deployedAtJSON, err := depl.DeployedAt.MarshalJSON()
-				Expect(err).To(BeNil())
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-				// project 3
-				Expect(db.First(proj3, proj3.ID).Error).To(BeNil())
-				createdAt3JSON, err := proj3.CreatedAt.MarshalJSON()
-				Expect(err).To(BeNil())
+		It("returns 200 OK and pauses deploys of the project", func() {
+			doRequest()
 
-				// project 4
-				Expect(db.First(proj4, proj4.ID).Error).To(BeNil())
-				createdAt4JSON, err := proj4.CreatedAt.MarshalJSON()
-				Expect(err).To(BeNil())
+			var j struct {
+				Project map[string]interface{} `json:"project"`
+			}
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+			Expect(j.Project["deploys_paused"]).To(Equal(true))
 
-				Expect(db.First(depl4, depl4.ID).Error).To(BeNil())
-				deployedAt4JSON, err := depl4.DeployedAt.MarshalJSON()
-				Expect(err).To(BeNil())
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.DeploysPaused).To(BeTrue())
+		})
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"projects": [
-						{
-							"name": "%s",
-							"default_domain_enabled": true,
-							"force_https": false,
-							"skip_build": false,
-							"created_at": %s,
-							"deployed_at": %s
-						},
-						{
-							"name": "%s",
-							"default_domain_enabled": true,
-							"force_https": false,
-							"skip_build": false,
-							"created_at": %s
-						}
-					],
-					"shared_projects": [
-						{
-							"name": "%s",
-							"default_domain_enabled": true,
-							"force_https": false,
-							"skip_build": false,
-							"created_at": %s,
-							"deployed_at": %s
-						}
-					]
-				}`, proj.Name, createdAtJSON, deployedAtJSON,
-					proj3.Name, createdAt3JSON,
-					proj4.Name, createdAt4JSON, deployedAt4JSON,
-				)))
-			})
-		})
-
-		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
-			return db, u, &headers
-		}, func() *http.Response {
+		It("tracks a 'Paused Deploys' event", func() {
 			doRequest()
-			return res
-		}, nil)
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Paused Deploys"))
+			Expect(trackCall.Arguments[3]).To(Equal(map[string]interface{}{"projectName": proj.Name}))
+		})
 	})
 
-	Describe("PUT /projects/:name", func() {
+	Describe("PUT /projects/:name/unpause", func() {
 		var (
-			fakeS3                *fake.S3
-			origS3                filetransfer.FileTransfer
-			mq                    *amqp.Connection
-			invalidationQueueName string
-
 			proj *project.Project
 
-			params  url.Values
 			headers http.Header
 		)
 
 		BeforeEach(func() {
-			origS3 = s3client.S3
-			fakeS3 = &fake.S3{}
-			s3client.S3 = fakeS3
+			proj = factories.Project(db, u)
+			Expect(db.Model(proj).Update("deploys_paused", true).Error).To(BeNil())
 
-			mq, err = mqconn.MQ()
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/"+proj.Name+"/unpause", nil, headers, nil)
 			Expect(err).To(BeNil())
+		}
 
-			testhelper.DeleteQueue(mq, queues.All...)
-			testhelper.DeleteExchange(mq, exchanges.All...)
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+		It("returns 200 OK and lets deploys of the project go out again", func() {
+			doRequest()
 
-			headers = http.Header{
-				"Authorization": {"Bearer " + t.Token},
+			var j struct {
+				Project map[string]interface{} `json:"project"`
 			}
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+			Expect(j.Project).NotTo(HaveKey("deploys_paused"))
 
-			proj = factories.Project(db, u)
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.DeploysPaused).To(BeFalse())
 		})
+	})
 
-		AfterEach(func() {
-			s3client.S3 = origS3
+	Describe("GET /projects/:name/meta_preview", func() {
+		var (
+			proj *project.Project
+
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			proj = factories.Project(db, u)
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
 		})
 
 		doRequest := func() {
 			s = httptest.NewServer(server.New())
-			res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/"+proj.Name, params, headers, nil)
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/"+proj.Name+"/meta_preview", nil, headers, nil)
 			Expect(err).To(BeNil())
 		}
 
-		Context("when default domain is newly disabled (i.e. it was enabled)", func() {
+		It("returns 200 OK with an empty prefix when the project has not been deployed", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"prefix": ""
+			}`))
+		})
+
+		Context("when the project has an active deployment", func() {
+			var depl *deployment.Deployment
+
 			BeforeEach(func() {
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
-				params = url.Values{
-					"default_domain_enabled": {"false"},
-				}
+				depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+					State:    deployment.StateDeployed,
+					Variants: []byte(`{"index.html": ["identity", "gzip"]}`),
+				})
+
+				Expect(db.Model(proj).Updates(map[string]interface{}{
+					"active_deployment_id": depl.ID,
+					"force_https":          true,
+				}).Error).To(BeNil())
 			})
 
-			It("returns 200 OK", func() {
+			It("returns the meta reflecting the current settings of the project", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
@@ -720,284 +737,2429 @@ var _ = Describe("Projects", func() {
 				Expect(err).To(BeNil())
 
 				Expect(res.StatusCode).To(Equal(http.StatusOK))
-
-				err = db.First(proj, proj.ID).Error
-				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(false))
-
 				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"project":{
-						"name": "%s",
-						"default_domain_enabled": false,
-						"force_https": false,
-						"skip_build": false,
-						"created_at": "%s"
+					"prefix": "%s",
+					"force_https": true,
+					"variants": {
+						"index.html": ["identity", "gzip"]
 					}
-				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+				}`, depl.PrefixID())))
 			})
+		})
 
-			Context("when there is an active deployment", func() {
-				var depl *deployment.Deployment
-
-				BeforeEach(func() {
-					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
-					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
-					Expect(err).To(BeNil())
-				})
-
-				It("deletes the meta.json for the default domain from S3", func() {
-					doRequest()
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-					Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
 
-					deleteCall := fakeS3.DeleteCalls.NthCall(1)
-					Expect(deleteCall).NotTo(BeNil())
-					Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
-					Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
-					Expect(deleteCall.Arguments[2]).To(Equal("/domains/" + proj.Name + "." + shared.DefaultDomain + "/meta.json"))
-					Expect(deleteCall.ReturnValues[0]).To(BeNil())
-				})
+	Describe("GET /projects/:name/export", func() {
+		var (
+			proj *project.Project
 
-				It("publishes invalidation message for the default domain", func() {
-					doRequest()
+			headers http.Header
+		)
 
-					d := testhelper.ConsumeQueue(mq, invalidationQueueName)
-					Expect(d).NotTo(BeNil())
-					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-						"domains": ["%s"]
-					}`, proj.Name+"."+shared.DefaultDomain)))
-				})
-			})
+		BeforeEach(func() {
+			proj = factories.Project(db, u)
+			factories.Domain(db, proj, "www.foo-bar-express.com")
+
+			gateURL := "https://ci.example.com/gate"
+			Expect(db.Model(proj).Updates(map[string]interface{}{
+				"force_https":      true,
+				"watermark":        false,
+				"minify_html":      true,
+				"max_deploys_kept": 5,
+				"publish_gate_url": &gateURL,
+				"required_files":   []byte(`["index.html"]`),
+				"js_env_filename":  "config/env.js",
+			}).Error).To(BeNil())
 
-			Context("when there is no active deployment", func() {
-				It("does not delete the meta.json for the default domain from S3", func() {
-					doRequest()
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+		})
 
-					Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
-				})
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/"+proj.Name+"/export", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
 
-				It("does not enqueue any job", func() {
-					doRequest()
+		It("returns the configuration of the project", func() {
+			doRequest()
 
-					d := testhelper.ConsumeQueue(mq, queues.Deploy)
-					Expect(d).To(BeNil())
-				})
-			})
-		})
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
 
-		Context("when default domain is newly enabled (i.e. it was disabled)", func() {
-			BeforeEach(func() {
-				proj.DefaultDomainEnabled = false
-				Expect(db.Save(proj).Error).To(BeNil())
-				params = url.Values{
-					"default_domain_enabled": {"true"},
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"config": {
+					"name": "%s",
+					"default_domain_enabled": true,
+					"force_https": true,
+					"skip_build": true,
+					"watermark": false,
+					"auto_publish": true,
+					"optimize_images": false,
+					"strict_content_types": false,
+					"minify_html": true,
+					"content_hash_prefixes": false,
+					"check_internal_links": false,
+					"csp_nonces": false,
+					"fingerprint": false,
+					"validate_js": false,
+					"validate_json": false,
+					"accessibility_check": false,
+					"check_mixed_content": false,
+					"strict_mixed_content": false,
+					"asset_manifest": false,
+					"preload_critical_assets": false,
+					"generate_favicons": false,
+					"analytics_opt_out": false,
+					"tombstone_removed_paths": false,
+					"max_deploys_kept": 5,
+					"deploy_retention_days": 0,
+					"publish_gate_url": "https://ci.example.com/gate",
+					"pre_deploy_hook_url": null,
+					"slack_webhook_url": null,
+					"security_contact": null,
+					"required_files": ["index.html"],
+					"include_globs": [],
+					"exclude_globs": [],
+					"default_deploy_options": {},
+					"js_env_filename": "config/env.js",
+					"js_env_disabled": false,
+					"domains": ["www.foo-bar-express.com"]
 				}
-			})
+			}`, proj.Name)))
+		})
 
-			It("returns 200 OK", func() {
-				doRequest()
+		It("can be imported as an equivalent project", func() {
+			doRequest()
 
-				b := &bytes.Buffer{}
-				_, err := b.ReadFrom(res.Body)
-				Expect(err).To(BeNil())
+			var exported struct {
+				Config *project.Config `json:"config"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&exported)).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			// Domains belong to a single project, so the original project
+			// has to let go of them first.
+			Expect(db.Unscoped().Where("project_id = ?", proj.ID).Delete(domain.Domain{}).Error).To(BeNil())
 
-				err = db.First(proj, proj.ID).Error
-				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+			cfg := exported.Config
+			cfg.Name = "imported-project"
+			body, err := json.Marshal(cfg)
+			Expect(err).To(BeNil())
 
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"project":{
-						"name": "%s",
-						"default_domain_enabled": true,
-						"force_https": false,
-						"skip_build": false,
-						"created_at": "%s"
-					}
-				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
-			})
+			req, err := http.NewRequest("POST", s.URL+"/project_imports", bytes.NewBuffer(body))
+			Expect(err).To(BeNil())
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+t.Token)
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
 
-			Context("when there is an active deployment", func() {
-				var depl *deployment.Deployment
+			imported := &project.Project{}
+			Expect(db.Where("name = ?", "imported-project").First(imported).Error).To(BeNil())
+			Expect(imported.UserID).To(Equal(u.ID))
 
-				BeforeEach(func() {
-					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
-					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
-					Expect(err).To(BeNil())
-				})
+			importedCfg, err := imported.Config(db)
+			Expect(err).To(BeNil())
+			Expect(importedCfg.Name).To(Equal("imported-project"))
+			importedCfg.Name = proj.Name
+			Expect(importedCfg).To(Equal(exported.Config))
+		})
 
-				It("enqueues a deploy job to upload meta.json", func() {
-					doRequest()
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
 
-					d := testhelper.ConsumeQueue(mq, queues.Deploy)
-					Expect(d).NotTo(BeNil())
-					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-						"deployment_id": %d,
-						"skip_webroot_upload": true,
-						"skip_invalidation": true,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
-				})
-			})
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
 
-			Context("when there is no active deployment", func() {
-				It("does not enqueue any job", func() {
-					doRequest()
+	Describe("POST /project_imports", func() {
+		var (
+			headers http.Header
+			body    string
+		)
 
-					d := testhelper.ConsumeQueue(mq, queues.Deploy)
-					Expect(d).To(BeNil())
-				})
-			})
+		BeforeEach(func() {
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+			body = `{
+				"name": "foo-bar-express",
+				"force_https": true,
+				"auto_publish": false,
+				"domains": ["www.foo-bar-express.com"]
+			}`
 		})
 
-		Context("when force_https is newly enabled (i.e. it was disabled)", func() {
-			BeforeEach(func() {
-				proj.ForceHTTPS = false
-				Expect(db.Save(proj).Error).To(BeNil())
-				params = url.Values{
-					"force_https": {"true"},
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			req, err := http.NewRequest("POST", s.URL+"/project_imports", bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		It("creates a project with the configuration, defaulting the settings left out", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			proj := &project.Project{}
+			Expect(db.Where("name = ?", "foo-bar-express").First(proj).Error).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"project": {
+					"name": "foo-bar-express",
+					"default_domain_enabled": true,
+					"force_https": true,
+					"skip_build": true,
+					"auto_publish": false,
+					"created_at": "%s"
 				}
+			}`, proj.CreatedAt.Format(time.RFC3339Nano))))
+
+			Expect(proj.UserID).To(Equal(u.ID))
+			Expect(proj.Watermark).To(BeTrue())
+			Expect(proj.JsEnvFilename).To(Equal(project.DefaultJsEnvFilename))
+
+			domNames, err := proj.DomainNames(db)
+			Expect(err).To(BeNil())
+			Expect(domNames).To(Equal([]string{proj.DefaultDomainName(), "www.foo-bar-express.com"}))
+		})
+
+		Context("when the configuration is invalid", func() {
+			BeforeEach(func() {
+				body = `{
+					"name": "x",
+					"js_env_filename": "../env.js",
+					"domains": ["www.foo-bar-express.com", "not a domain"]
+				}`
 			})
 
-			It("returns 200 OK", func() {
+			It("returns 422 with invalid_params and does not create the project", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
 				_, err := b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"name": "is too short (min. 3 characters)",
+						"js_env_filename": "is invalid",
+						"domains": "contains an invalid domain (not a domain)"
+					}
+				}`))
 
-				err = db.First(proj, proj.ID).Error
-				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+				var count int
+				Expect(db.Model(project.Project{}).Where("user_id = ?", u.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(BeZero())
+			})
+		})
 
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"project":{
-						"name": "%s",
-						"default_domain_enabled": true,
-						"force_https": true,
-						"skip_build": false,
-						"created_at": "%s"
-					}
-				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+		Context("when a domain is taken", func() {
+			BeforeEach(func() {
+				other := factories.Project(db, nil)
+				factories.Domain(db, other, "www.foo-bar-express.com")
 			})
 
-			Context("when there is an active deployment", func() {
-				var depl *deployment.Deployment
+			It("returns 422 with invalid_params and does not create the project", func() {
+				doRequest()
 
-				BeforeEach(func() {
-					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
-					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
-					Expect(err).To(BeNil())
-				})
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-				It("enqueues a deploy job to update meta.json", func() {
-					doRequest()
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"domains": "contains a domain that is taken (www.foo-bar-express.com)"
+					}
+				}`))
 
-					d := testhelper.ConsumeQueue(mq, queues.Deploy)
-					Expect(d).NotTo(BeNil())
-					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-						"deployment_id": %d,
-						"skip_webroot_upload": true,
-						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
-				})
+				Expect(db.Where("name = ?", "foo-bar-express").First(&project.Project{}).Error).To(Equal(gorm.RecordNotFound))
 			})
 		})
 
-		Context("when force_https is newly disabled (i.e. it was enabled)", func() {
+		Context("when the body is not JSON", func() {
 			BeforeEach(func() {
-				proj.ForceHTTPS = true
-				Expect(db.Save(proj).Error).To(BeNil())
-				params = url.Values{
-					"force_https": {"false"},
-				}
+				body = "name=foo-bar-express"
 			})
 
-			It("returns 200 OK", func() {
+			It("returns 400 bad request", func() {
 				doRequest()
 
-				b := &bytes.Buffer{}
-				_, err := b.ReadFrom(res.Body)
-				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
+	Describe("GET /projects", func() {
+		var (
+			headers http.Header
 
-				err = db.First(proj, proj.ID).Error
-				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+			anotherU *user.User
 
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"project":{
+			proj  *project.Project
+			proj3 *project.Project
+		)
+
+		BeforeEach(func() {
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			anotherU = factories.User(db)
+
+			proj = factories.Project(db, u, "site-1")
+			factories.Project(db, anotherU, "site-2")
+			proj3 = factories.Project(db, u, "site-3")
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns current user's projects ordered by name", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			proj := &project.Project{}
+			err = db.Where("name = 'site-1'").First(proj).Error
+			Expect(err).To(BeNil())
+
+			createdAtJSON, err := proj.CreatedAt.MarshalJSON()
+			Expect(err).To(BeNil())
+
+			proj3 := &project.Project{}
+			err = db.Where("name = 'site-3'").First(proj3).Error
+			Expect(err).To(BeNil())
+
+			createdAt3JSON, err := proj3.CreatedAt.MarshalJSON()
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"projects": [
+					{
 						"name": "%s",
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
-						"created_at": "%s"
+						"auto_publish": true,
+						"created_at": %s
+					},
+					{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"created_at": %s
 					}
-				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
-			})
-
-			Context("when there is an active deployment", func() {
-				var depl *deployment.Deployment
+				],
+				"shared_projects": []
+			}`, proj.Name, createdAtJSON, proj3.Name, createdAt3JSON)))
+		})
 
-				BeforeEach(func() {
-					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
-					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
-					Expect(err).To(BeNil())
-				})
+		Context("when user is a collaborator of other users' projects", func() {
+			var (
+				yetAnotherU *user.User
+				proj4       *project.Project
+				proj5       *project.Project
+			)
 
-				It("enqueues a deploy job to update meta.json", func() {
-					doRequest()
+			BeforeEach(func() {
+				yetAnotherU = factories.User(db)
 
-					d := testhelper.ConsumeQueue(mq, queues.Deploy)
-					Expect(d).NotTo(BeNil())
-					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-						"deployment_id": %d,
-						"skip_webroot_upload": true,
-						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
-				})
-			})
-		})
+				proj4 = factories.Project(db, anotherU, "site-4")
+				proj5 = factories.Project(db, yetAnotherU, "site-5")
+				factories.Project(db, yetAnotherU, "site-6")
 
-		Context("when skip_build set to true", func() {
-			BeforeEach(func() {
-				proj.SkipBuild = false
-				Expect(db.Save(proj).Error).To(BeNil())
-				params = url.Values{
-					"skip_build": {"true"},
-				}
+				err := proj4.AddCollaborator(db, u)
+				Expect(err).To(BeNil())
+				err = proj5.AddCollaborator(db, u)
+				Expect(err).To(BeNil())
 			})
 
-			It("returns 200 OK", func() {
+			It("returns the shared projects ordered by name", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
 				_, err := b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				proj := &project.Project{}
+				err = db.Where("name = 'site-1'").First(proj).Error
+				Expect(err).To(BeNil())
 
-				err = db.First(proj, proj.ID).Error
+				createdAtJSON, err := proj.CreatedAt.MarshalJSON()
 				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
 
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"project":{
-						"name": "%s",
-						"default_domain_enabled": true,
-						"force_https": false,
-						"skip_build": true,
-						"created_at": "%s"
-					}
-				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+				proj3 := &project.Project{}
+				err = db.Where("name = 'site-3'").First(proj3).Error
+				Expect(err).To(BeNil())
+
+				createdAt3JSON, err := proj3.CreatedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				proj4 := &project.Project{}
+				err = db.Where("name = 'site-4'").First(proj4).Error
+				Expect(err).To(BeNil())
+
+				createdAt4JSON, err := proj4.CreatedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				proj5 := &project.Project{}
+				err = db.Where("name = 'site-5'").First(proj5).Error
+				Expect(err).To(BeNil())
+
+				createdAt5JSON, err := proj5.CreatedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"projects": [
+						{
+							"name": "%s",
+							"default_domain_enabled": true,
+							"force_https": false,
+							"skip_build": false,
+							"auto_publish": true,
+							"created_at": %s
+						},
+						{
+							"name": "%s",
+							"default_domain_enabled": true,
+							"force_https": false,
+							"skip_build": false,
+							"auto_publish": true,
+							"created_at": %s
+						}
+					],
+					"shared_projects": [
+						{
+							"name": "%s",
+							"default_domain_enabled": true,
+							"force_https": false,
+							"skip_build": false,
+							"auto_publish": true,
+							"created_at": %s
+						},
+						{
+							"name": "%s",
+							"default_domain_enabled": true,
+							"force_https": false,
+							"skip_build": false,
+							"auto_publish": true,
+							"created_at": %s
+						}
+					]
+				}`, proj.Name, createdAtJSON, proj3.Name, createdAt3JSON, proj4.Name, createdAt4JSON, proj5.Name, createdAt5JSON)))
+			})
+		})
+
+		Context("when some projects have deployed and have collaborators", func() {
+			var (
+				depl  *deployment.Deployment
+				depl4 *deployment.Deployment
+				proj4 *project.Project
+			)
+
+			BeforeEach(func() {
+				u2 := factories.User(db)
+
+				proj4 = factories.Project(db, u2, "site-4")
+				err := proj4.AddCollaborator(db, u)
+				Expect(err).To(BeNil())
+
+				depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+				factories.Deployment(db, proj3, u, deployment.StatePendingDeploy)
+				depl4 = factories.Deployment(db, proj4, u2, deployment.StateDeployed)
+			})
+
+			It("responses with deployed at", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				// project 1
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				createdAtJSON, err := proj.CreatedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				deployedAtJSON, err := depl.DeployedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				// project 3
+				Expect(db.First(proj3, proj3.ID).Error).To(BeNil())
+				createdAt3JSON, err := proj3.CreatedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				// project 4
+				Expect(db.First(proj4, proj4.ID).Error).To(BeNil())
+				createdAt4JSON, err := proj4.CreatedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl4, depl4.ID).Error).To(BeNil())
+				deployedAt4JSON, err := depl4.DeployedAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"projects": [
+						{
+							"name": "%s",
+							"default_domain_enabled": true,
+							"force_https": false,
+							"skip_build": false,
+							"auto_publish": true,
+							"created_at": %s,
+							"deployed_at": %s
+						},
+						{
+							"name": "%s",
+							"default_domain_enabled": true,
+							"force_https": false,
+							"skip_build": false,
+							"auto_publish": true,
+							"created_at": %s
+						}
+					],
+					"shared_projects": [
+						{
+							"name": "%s",
+							"default_domain_enabled": true,
+							"force_https": false,
+							"skip_build": false,
+							"auto_publish": true,
+							"created_at": %s,
+							"deployed_at": %s
+						}
+					]
+				}`, proj.Name, createdAtJSON, deployedAtJSON,
+					proj3.Name, createdAt3JSON,
+					proj4.Name, createdAt4JSON, deployedAt4JSON,
+				)))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:name", func() {
+		var (
+			fakeS3                *fake.S3
+			origS3                filetransfer.FileTransfer
+			mq                    *amqp.Connection
+			invalidationQueueName string
+
+			proj *project.Project
+
+			params  url.Values
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+			testhelper.DeleteExchange(mq, exchanges.All...)
+
+			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u)
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/"+proj.Name, params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when authenticated with a deploy token of the project", func() {
+			BeforeEach(func() {
+				dt := factories.DeployToken(db, t, proj)
+				headers = http.Header{
+					"Authorization": {"Bearer " + dt.Token},
+				}
+				params = url.Values{
+					"force_https": {"true"},
+				}
+			})
+
+			It("returns 403 forbidden and does not update the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "insufficient_scope",
+					"error_description": "access token is not allowed to perform this action"
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ForceHTTPS).To(BeFalse())
+			})
+		})
+
+		Context("when default domain is newly disabled (i.e. it was enabled)", func() {
+			BeforeEach(func() {
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+				params = url.Values{
+					"default_domain_enabled": {"false"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(false))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": false,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
+
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("deletes the meta.json for the default domain from S3", func() {
+					doRequest()
+
+					Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+
+					deleteCall := fakeS3.DeleteCalls.NthCall(1)
+					Expect(deleteCall).NotTo(BeNil())
+					Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
+					Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
+					Expect(deleteCall.Arguments[2]).To(Equal("/domains/" + proj.Name + "." + shared.DefaultDomain + "/meta.json"))
+					Expect(deleteCall.ReturnValues[0]).To(BeNil())
+				})
+
+				It("publishes invalidation message for the default domain", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"domains": ["%s"]
+					}`, proj.Name+"."+shared.DefaultDomain)))
+				})
+			})
+
+			Context("when there is no active deployment", func() {
+				It("does not delete the meta.json for the default domain from S3", func() {
+					doRequest()
+
+					Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
+				})
+
+				It("does not enqueue any job", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).To(BeNil())
+				})
+			})
+		})
+
+		Context("when default domain is newly enabled (i.e. it was disabled)", func() {
+			BeforeEach(func() {
+				proj.DefaultDomainEnabled = false
+				Expect(db.Save(proj).Error).To(BeNil())
+				params = url.Values{
+					"default_domain_enabled": {"true"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
+
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("enqueues a deploy job to upload meta.json", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": true,
+						"use_raw_bundle": false
+					}`, *proj.ActiveDeploymentID)))
+				})
+			})
+
+			Context("when there is no active deployment", func() {
+				It("does not enqueue any job", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).To(BeNil())
+				})
+			})
+		})
+
+		Context("when force_https is newly enabled (i.e. it was disabled)", func() {
+			BeforeEach(func() {
+				proj.ForceHTTPS = false
+				Expect(db.Save(proj).Error).To(BeNil())
+				params = url.Values{
+					"force_https": {"true"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": true,
+						"skip_build": false,
+						"auto_publish": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
+
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("enqueues a deploy job to update meta.json", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}`, *proj.ActiveDeploymentID)))
+				})
+			})
+		})
+
+		Context("when force_https is newly disabled (i.e. it was enabled)", func() {
+			BeforeEach(func() {
+				proj.ForceHTTPS = true
+				Expect(db.Save(proj).Error).To(BeNil())
+				params = url.Values{
+					"force_https": {"false"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
+
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("enqueues a deploy job to update meta.json", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}`, *proj.ActiveDeploymentID)))
+				})
+			})
+		})
+
+		Context("when skip_build set to true", func() {
+			BeforeEach(func() {
+				proj.SkipBuild = false
+				Expect(db.Save(proj).Error).To(BeNil())
+				params = url.Values{
+					"skip_build": {"true"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": true,
+						"auto_publish": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+		})
+
+		Context("when optimize_images set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.OptimizeImages).To(BeFalse())
+				params = url.Values{
+					"optimize_images": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables image optimization", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.OptimizeImages).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"optimize_images": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when strict_content_types set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.StrictContentTypes).To(BeFalse())
+				params = url.Values{
+					"strict_content_types": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables strict content types", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.StrictContentTypes).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"strict_content_types": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when minify_html set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.MinifyHTML).To(BeFalse())
+				params = url.Values{
+					"minify_html": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables HTML minification", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.MinifyHTML).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"minify_html": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when csp_nonces set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.CSPNonces).To(BeFalse())
+				params = url.Values{
+					"csp_nonces": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables CSP nonces", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.CSPNonces).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"csp_nonces": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when fingerprint set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.Fingerprint).To(BeFalse())
+				params = url.Values{
+					"fingerprint": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables asset fingerprinting", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.Fingerprint).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"fingerprint": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when validate_js and validate_json set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.ValidateJS).To(BeFalse())
+				Expect(proj.ValidateJSON).To(BeFalse())
+				params = url.Values{
+					"validate_js":   {"true"},
+					"validate_json": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables syntax validation of JS and JSON files", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.ValidateJS).To(BeTrue())
+				Expect(proj.ValidateJSON).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"validate_js": true,
+						"validate_json": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when accessibility_check set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.AccessibilityCheck).To(BeFalse())
+				params = url.Values{
+					"accessibility_check": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables accessibility checks of HTML pages", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.AccessibilityCheck).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"accessibility_check": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when asset_manifest set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.AssetManifest).To(BeFalse())
+				params = url.Values{
+					"asset_manifest": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables uploading an asset manifest with deployments", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.AssetManifest).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"asset_manifest": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when preload_critical_assets set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.PreloadCriticalAssets).To(BeFalse())
+				params = url.Values{
+					"preload_critical_assets": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables preload hints for deployments", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.PreloadCriticalAssets).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"preload_critical_assets": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when generate_favicons set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.GenerateFavicons).To(BeFalse())
+				params = url.Values{
+					"generate_favicons": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables favicon generation for deployments", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.GenerateFavicons).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"generate_favicons": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when analytics_opt_out set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.AnalyticsOptOut).To(BeFalse())
+				params = url.Values{
+					"analytics_opt_out": {"true"},
+				}
+			})
+
+			It("returns 200 OK and opts the project out of analytics", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.AnalyticsOptOut).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"analytics_opt_out": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when tombstone_removed_paths set to true", func() {
+			BeforeEach(func() {
+				Expect(proj.TombstoneRemovedPaths).To(BeFalse())
+				params = url.Values{
+					"tombstone_removed_paths": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables tombstones for removed pages", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.TombstoneRemovedPaths).To(BeTrue())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"tombstone_removed_paths": true,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when auto_publish set to false", func() {
+			BeforeEach(func() {
+				Expect(proj.AutoPublish).To(BeTrue())
+				params = url.Values{
+					"auto_publish": {"false"},
+				}
+			})
+
+			It("returns 200 OK and disables auto publishing", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.AutoPublish).To(BeFalse())
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": false,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			It("does not enqueue any deploy job", func() {
+				doRequest()
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).To(BeNil())
+			})
+		})
+
+		Context("when required_files is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"required_files": {"index.html, css/app.css"},
+				}
+			})
+
+			It("returns 200 OK and sets the required files", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.RequiredFilePaths()).To(Equal([]string{"index.html", "css/app.css"}))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"required_files": ["index.html", "css/app.css"],
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when a path is not a clean relative path", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"required_files": {"index.html,../secret.txt"},
+					}
+				})
+
+				It("returns 422 and does not set the required files", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"required_files": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.RequiredFilePaths()).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("when default_deploy_options is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"default_deploy_options": {`{"priority": "high", "skip_invalidation": true}`},
+				}
+			})
+
+			It("returns 200 OK and sets the default deploy options", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DeployDefaults()).To(Equal(&project.DeployOptions{
+					Priority:         "high",
+					SkipInvalidation: true,
+				}))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"default_deploy_options": {
+							"priority": "high",
+							"skip_invalidation": true
+						},
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when an option is unknown", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"default_deploy_options": {`{"use_raw_bundel": true}`},
+					}
+				})
+
+				It("returns 422 and does not set the default deploy options", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"default_deploy_options": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.DeployDefaults()).To(Equal(&project.DeployOptions{}))
+				})
+			})
+
+			Context("when the priority is invalid", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"default_deploy_options": {`{"priority": "urgent"}`},
+					}
+				})
+
+				It("returns 422 and does not set the default deploy options", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"default_deploy_options": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.DeployDefaults()).To(Equal(&project.DeployOptions{}))
+				})
+			})
+		})
+
+		Context("when include_globs and exclude_globs are set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"include_globs": {"dist/**"},
+					"exclude_globs": {"*.map, dist/tmp/**"},
+				}
+			})
+
+			It("returns 200 OK and sets the globs", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.IncludeGlobPatterns()).To(Equal([]string{"dist/**"}))
+				Expect(proj.ExcludeGlobPatterns()).To(Equal([]string{"*.map", "dist/tmp/**"}))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"include_globs": ["dist/**"],
+						"exclude_globs": ["*.map", "dist/tmp/**"],
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when a glob is malformed", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"exclude_globs": {"*.map,[abc"},
+					}
+				})
+
+				It("returns 422 and does not set the globs", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"exclude_globs": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.ExcludeGlobPatterns()).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("when js_env_filename is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"js_env_filename": {"config/pubstorm-env.js"},
+				}
+			})
+
+			It("returns 200 OK and sets the JS environment filename", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.JsEnvPath()).To(Equal("config/pubstorm-env.js"))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"js_env_filename": "config/pubstorm-env.js",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when the filename is not a clean relative path", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"js_env_filename": {"../jsenv.js"},
+					}
+				})
+
+				It("returns 422 and does not set the JS environment filename", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"js_env_filename": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.JsEnvPath()).To(Equal(project.DefaultJsEnvFilename))
+				})
+			})
+		})
+
+		Context("when js_env_disabled set to true", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"js_env_disabled": {"true"},
+				}
+			})
+
+			It("returns 200 OK and disables JS environment generation", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.JsEnvDisabled).To(BeTrue())
+			})
+		})
+
+		Context("when publish_gate_url is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"publish_gate_url": {"https://ci.example.com/gate"},
+				}
+			})
+
+			It("returns 200 OK and sets the publish gate", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.PublishGateURL).NotTo(BeNil())
+				Expect(*proj.PublishGateURL).To(Equal("https://ci.example.com/gate"))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"publish_gate_url": "https://ci.example.com/gate",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when the url is invalid", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"publish_gate_url": {"ftp://ci.example.com/gate"},
+					}
+				})
+
+				It("returns 422 and does not set the publish gate", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"publish_gate_url": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.PublishGateURL).To(BeNil())
+				})
+			})
+
+			Context("when the url is empty", func() {
+				BeforeEach(func() {
+					gateURL := "https://ci.example.com/gate"
+					Expect(db.Model(proj).Update("publish_gate_url", &gateURL).Error).To(BeNil())
+
+					params = url.Values{
+						"publish_gate_url": {""},
+					}
+				})
+
+				It("removes the publish gate", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.PublishGateURL).To(BeNil())
+				})
+			})
+		})
+
+		Context("when pre_deploy_hook_url is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"pre_deploy_hook_url": {"https://ci.example.com/pre-deploy"},
+				}
+			})
+
+			It("returns 200 OK and sets the pre-deploy hook", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.PreDeployHookURL).NotTo(BeNil())
+				Expect(*proj.PreDeployHookURL).To(Equal("https://ci.example.com/pre-deploy"))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"pre_deploy_hook_url": "https://ci.example.com/pre-deploy",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when the url is invalid", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"pre_deploy_hook_url": {"not a url"},
+					}
+				})
+
+				It("returns 422 and does not set the pre-deploy hook", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"pre_deploy_hook_url": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.PreDeployHookURL).To(BeNil())
+				})
+			})
+
+			Context("when the url is empty", func() {
+				BeforeEach(func() {
+					hookURL := "https://ci.example.com/pre-deploy"
+					Expect(db.Model(proj).Update("pre_deploy_hook_url", &hookURL).Error).To(BeNil())
+
+					params = url.Values{
+						"pre_deploy_hook_url": {""},
+					}
+				})
+
+				It("removes the pre-deploy hook", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.PreDeployHookURL).To(BeNil())
+				})
+			})
+		})
+
+		Context("when slack_webhook_url is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"slack_webhook_url": {"https://hooks.slack.com/services/T000/B000/XXXX"},
+				}
+			})
+
+			It("returns 200 OK and sets the Slack webhook", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.SlackWebhookURL).NotTo(BeNil())
+				Expect(*proj.SlackWebhookURL).To(Equal("https://hooks.slack.com/services/T000/B000/XXXX"))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"slack_webhook_url": "https://hooks.slack.com/services/T000/B000/XXXX",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when the url is not a Slack incoming webhook", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"slack_webhook_url": {"https://example.com/services/T000/B000/XXXX"},
+					}
+				})
+
+				It("returns 422 and does not set the Slack webhook", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"slack_webhook_url": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.SlackWebhookURL).To(BeNil())
+				})
+			})
+
+			Context("when the url is empty", func() {
+				BeforeEach(func() {
+					webhookURL := "https://hooks.slack.com/services/T000/B000/XXXX"
+					Expect(db.Model(proj).Update("slack_webhook_url", &webhookURL).Error).To(BeNil())
+
+					params = url.Values{
+						"slack_webhook_url": {""},
+					}
+				})
+
+				It("removes the Slack webhook", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.SlackWebhookURL).To(BeNil())
+				})
+			})
+		})
+
+		Context("when security_contact is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"security_contact": {"mailto:security@example.com"},
+				}
+			})
+
+			It("returns 200 OK and sets the security contact", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.SecurityContact).NotTo(BeNil())
+				Expect(*proj.SecurityContact).To(Equal("mailto:security@example.com"))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"auto_publish": true,
+						"security_contact": "mailto:security@example.com",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when the contact is an email address", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"security_contact": {"security@example.com"},
+					}
+				})
+
+				It("sets it as a mailto URI", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.SecurityContact).NotTo(BeNil())
+					Expect(*proj.SecurityContact).To(Equal("mailto:security@example.com"))
+				})
+			})
+
+			Context("when the contact is invalid", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"security_contact": {"http://example.com/security"},
+					}
+				})
+
+				It("returns 422 and does not set the security contact", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"security_contact": "is invalid"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.SecurityContact).To(BeNil())
+				})
+			})
+
+			Context("when the contact is empty", func() {
+				BeforeEach(func() {
+					contact := "mailto:security@example.com"
+					Expect(db.Model(proj).Update("security_contact", &contact).Error).To(BeNil())
+
+					params = url.Values{
+						"security_contact": {""},
+					}
+				})
+
+				It("removes the security contact", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.SecurityContact).To(BeNil())
+				})
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:name", func() {
+		var (
+			fakeS3                *fake.S3
+			origS3                filetransfer.FileTransfer
+			mq                    *amqp.Connection
+			invalidationQueueName string
+
+			proj *project.Project
+			dm1  *domain.Domain
+			dm2  *domain.Domain
+
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+			testhelper.DeleteExchange(mq, exchanges.All...)
+
+			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u)
+			dm1 = factories.Domain(db, proj)
+			dm2 = factories.Domain(db, proj)
+
+			ct1 := &cert.Cert{
+				DomainID:        dm1.ID,
+				CertificatePath: "old/path",
+				PrivateKeyPath:  "old/path",
+			}
+			Expect(db.Create(ct1).Error).To(BeNil())
+
+			ct2 := &cert.Cert{
+				DomainID:        dm2.ID,
+				CertificatePath: "old/path",
+				PrivateKeyPath:  "old/path",
+			}
+			Expect(db.Create(ct2).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/"+proj.Name, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 with OK", func() {
+			doRequest()
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"deleted": true
+			}`))
+		})
+
+		It("deletes associated domains and certs", func() {
+			doRequest()
+
+			var count int
+			Expect(db.Model(domain.Domain{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			Expect(db.Model(cert.Cert{}).Where("domain_id IN (?,?)", dm1.ID, dm2.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+		})
+
+		It("deletes meta.json and ssl certs for the associated domains from s3", func() {
+			doRequest()
+
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+
+			deleteCall := fakeS3.DeleteCalls.NthCall(1)
+			Expect(deleteCall).NotTo(BeNil())
+			Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
+			Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
+			Expect(deleteCall.ReturnValues[0]).To(BeNil())
+
+			filesToDelete := []string{
+				"domains/" + proj.DefaultDomainName() + "/meta.json",
+				"domains/" + dm1.Name + "/meta.json",
+				"certs/" + dm1.Name + "/ssl.crt",
+				"certs/" + dm1.Name + "/ssl.key",
+				"domains/" + dm2.Name + "/meta.json",
+				"certs/" + dm2.Name + "/ssl.crt",
+				"certs/" + dm2.Name + "/ssl.key",
+			}
+
+			for i, path := range filesToDelete {
+				Expect(deleteCall.Arguments[2+i]).To(Equal(path))
+			}
+		})
+
+		It("deletes the given project", func() {
+			doRequest()
+			Expect(db.First(&project.Project{}, proj.ID).Error).To(Equal(gorm.RecordNotFound))
+		})
+
+		It("publishes invalidation message for the associated domains", func() {
+			doRequest()
+
+			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"domains": ["%s", "%s", "%s"]
+			}`, proj.Name+"."+shared.DefaultDomain, dm1.Name, dm2.Name)))
+		})
+
+		It("tracks a 'Deleted Project' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Deleted Project"))
+			Expect(trackCall.Arguments[2]).To(Equal(""))
+
+			t := trackCall.Arguments[3]
+			props, ok := t.(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+
+			c := trackCall.Arguments[4]
+			context, ok := c.(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(context["ip"]).ToNot(BeNil())
+			Expect(context["user_agent"]).ToNot(BeNil())
+
+			Expect(trackCall.ReturnValues[0]).To(BeNil())
+		})
+
+		Context("when there are associated raw bundles", func() {
+			var (
+				bun1 *rawbundle.RawBundle
+				bun2 *rawbundle.RawBundle
+			)
+
+			BeforeEach(func() {
+				bun1 = factories.RawBundle(db, proj)
+				bun2 = factories.RawBundle(db, proj)
+			})
+
+			It("deletes associated raw bundles from DB and S3", func() {
+				doRequest()
+
+				Expect(db.First(bun1, bun1.ID).Error).To(Equal(gorm.RecordNotFound))
+				Expect(db.First(bun2, bun2.ID).Error).To(Equal(gorm.RecordNotFound))
+
+				Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+
+				deleteCall := fakeS3.DeleteCalls.NthCall(1)
+				Expect(deleteCall).NotTo(BeNil())
+				Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
+				Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
+				Expect(deleteCall.ReturnValues[0]).To(BeNil())
+
+				filesToDelete := []string{
+					"domains/" + proj.DefaultDomainName() + "/meta.json",
+					"domains/" + dm1.Name + "/meta.json",
+					"certs/" + dm1.Name + "/ssl.crt",
+					"certs/" + dm1.Name + "/ssl.key",
+					"domains/" + dm2.Name + "/meta.json",
+					"certs/" + dm2.Name + "/ssl.crt",
+					"certs/" + dm2.Name + "/ssl.key",
+
+					bun1.UploadedPath,
+					bun2.UploadedPath,
+				}
+
+				for i, path := range filesToDelete {
+					Expect(deleteCall.Arguments[2+i]).To(Equal(path))
+				}
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /projects/:name/deploy_tokens", func() {
+		var (
+			proj *project.Project
+
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/"+proj.Name+"/deploy_tokens", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 201 Created with a token that can only deploy the project", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			dt := &oauthtoken.OauthToken{}
+			Expect(db.Last(dt).Error).To(BeNil())
+			Expect(dt.ID).NotTo(Equal(t.ID))
+			Expect(dt.UserID).To(Equal(u.ID))
+			Expect(dt.OauthClientID).To(Equal(t.OauthClientID))
+			Expect(dt.ProjectID).NotTo(BeNil())
+			Expect(*dt.ProjectID).To(Equal(proj.ID))
+			Expect(dt.Scope).NotTo(BeNil())
+			Expect(*dt.Scope).To(Equal(oauthtoken.ScopeDeploy))
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deploy_token": {
+					"token": "%s",
+					"scope": "deploy"
+				}
+			}`, dt.Token)))
+		})
+
+		Context("when authenticated with a deploy token", func() {
+			BeforeEach(func() {
+				dt := factories.DeployToken(db, t, proj)
+				headers = http.Header{
+					"Authorization": {"Bearer " + dt.Token},
+				}
+			})
+
+			It("returns 403 forbidden", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects/:name/deploy_tokens", func() {
+		var (
+			proj *project.Project
+
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/"+proj.Name+"/deploy_tokens", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the masked deploy tokens of the project", func() {
+			dt1 := factories.DeployToken(db, t, proj)
+			dt2 := factories.DeployToken(db, t, proj)
+			Expect(dt2.Touch(db)).To(BeNil())
+
+			// Neither revoked tokens nor those of other projects are listed.
+			revoked := factories.DeployToken(db, t, proj)
+			Expect(db.Delete(revoked).Error).To(BeNil())
+			factories.DeployToken(db, t, factories.Project(db, u))
+
+			// Reload the tokens, so that their times are as precise as the DB's.
+			Expect(db.First(dt1, dt1.ID).Error).To(BeNil())
+			Expect(db.First(dt2, dt2.ID).Error).To(BeNil())
+
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).NotTo(ContainSubstring(dt1.Token))
+			Expect(b.String()).NotTo(ContainSubstring(dt2.Token))
+
+			expectedJSON, err := json.Marshal(map[string]interface{}{
+				"deploy_tokens": []interface{}{
+					dt1.AsDeployTokenJSON(),
+					dt2.AsDeployTokenJSON(),
+				},
 			})
+			Expect(err).To(BeNil())
+			Expect(b.String()).To(MatchJSON(expectedJSON))
 
+			j := map[string][]map[string]interface{}{}
+			Expect(json.Unmarshal(b.Bytes(), &j)).To(BeNil())
+			Expect(j["deploy_tokens"][0]["token"]).To(Equal(dt1.MaskedToken()))
+			Expect(j["deploy_tokens"][0]["last_used_at"]).To(BeNil())
+			Expect(j["deploy_tokens"][1]["last_used_at"]).NotTo(BeNil())
 		})
 
 		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
@@ -1007,210 +3169,172 @@ var _ = Describe("Projects", func() {
 			return res
 		}, nil)
 
-		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
-
-		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
 		}, func() *http.Response {
 			doRequest()
 			return res
 		}, nil)
 	})
 
-	Describe("DELETE /projects/:name", func() {
+	Describe("DELETE /projects/:name/deploy_tokens/:id", func() {
 		var (
-			fakeS3                *fake.S3
-			origS3                filetransfer.FileTransfer
-			mq                    *amqp.Connection
-			invalidationQueueName string
-
 			proj *project.Project
-			dm1  *domain.Domain
-			dm2  *domain.Domain
+			dt   *oauthtoken.OauthToken
 
+			tokenID string
 			headers http.Header
 		)
 
 		BeforeEach(func() {
-			origS3 = s3client.S3
-			fakeS3 = &fake.S3{}
-			s3client.S3 = fakeS3
-
-			mq, err = mqconn.MQ()
-			Expect(err).To(BeNil())
-
-			testhelper.DeleteQueue(mq, queues.All...)
-			testhelper.DeleteExchange(mq, exchanges.All...)
-
-			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
-
 			headers = http.Header{
 				"Authorization": {"Bearer " + t.Token},
 			}
 
 			proj = factories.Project(db, u)
-			dm1 = factories.Domain(db, proj)
-			dm2 = factories.Domain(db, proj)
-
-			ct1 := &cert.Cert{
-				DomainID:        dm1.ID,
-				CertificatePath: "old/path",
-				PrivateKeyPath:  "old/path",
-			}
-			Expect(db.Create(ct1).Error).To(BeNil())
-
-			ct2 := &cert.Cert{
-				DomainID:        dm2.ID,
-				CertificatePath: "old/path",
-				PrivateKeyPath:  "old/path",
-			}
-			Expect(db.Create(ct2).Error).To(BeNil())
-		})
-
-		AfterEach(func() {
-			s3client.S3 = origS3
+			dt = factories.DeployToken(db, t, proj)
+			tokenID = strconv.Itoa(int(dt.ID))
 		})
 
 		doRequest := func() {
 			s = httptest.NewServer(server.New())
-			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/"+proj.Name, nil, headers, nil)
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/"+proj.Name+"/deploy_tokens/"+tokenID, nil, headers, nil)
 			Expect(err).To(BeNil())
 		}
 
-		It("returns 200 with OK", func() {
+		It("returns 200 OK and revokes the token", func() {
 			doRequest()
+
 			b := &bytes.Buffer{}
 			_, err := b.ReadFrom(res.Body)
 			Expect(err).To(BeNil())
 
 			Expect(res.StatusCode).To(Equal(http.StatusOK))
 			Expect(b.String()).To(MatchJSON(`{
-				"deleted": true
+				"revoked": true
 			}`))
+
+			found, err := oauthtoken.FindByToken(db, dt.Token)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
 		})
 
-		It("deletes associated domains and certs", func() {
-			doRequest()
+		Context("when the token belongs to another project", func() {
+			BeforeEach(func() {
+				dt = factories.DeployToken(db, t, factories.Project(db, u))
+				tokenID = strconv.Itoa(int(dt.ID))
+			})
 
-			var count int
-			Expect(db.Model(domain.Domain{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
-			Expect(count).To(Equal(0))
+			It("returns 404 not found and does not revoke the token", func() {
+				doRequest()
 
-			Expect(db.Model(cert.Cert{}).Where("domain_id IN (?,?)", dm1.ID, dm2.ID).Count(&count).Error).To(BeNil())
-			Expect(count).To(Equal(0))
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+				found, err := oauthtoken.FindByToken(db, dt.Token)
+				Expect(err).To(BeNil())
+				Expect(found).NotTo(BeNil())
+			})
 		})
 
-		It("deletes meta.json and ssl certs for the associated domains from s3", func() {
-			doRequest()
+		Context("when the token is not a deploy token", func() {
+			BeforeEach(func() {
+				tokenID = strconv.Itoa(int(t.ID))
+			})
 
-			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+			It("returns 404 not found", func() {
+				doRequest()
 
-			deleteCall := fakeS3.DeleteCalls.NthCall(1)
-			Expect(deleteCall).NotTo(BeNil())
-			Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
-			Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
-			Expect(deleteCall.ReturnValues[0]).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
 
-			filesToDelete := []string{
-				"domains/" + proj.DefaultDomainName() + "/meta.json",
-				"domains/" + dm1.Name + "/meta.json",
-				"certs/" + dm1.Name + "/ssl.crt",
-				"certs/" + dm1.Name + "/ssl.key",
-				"domains/" + dm2.Name + "/meta.json",
-				"certs/" + dm2.Name + "/ssl.crt",
-				"certs/" + dm2.Name + "/ssl.key",
-			}
+		Context("when the id is not a number", func() {
+			BeforeEach(func() {
+				tokenID = "abc"
+			})
 
-			for i, path := range filesToDelete {
-				Expect(deleteCall.Arguments[2+i]).To(Equal(path))
-			}
-		})
+			It("returns 404 not found", func() {
+				doRequest()
 
-		It("deletes the given project", func() {
-			doRequest()
-			Expect(db.First(&project.Project{}, proj.ID).Error).To(Equal(gorm.RecordNotFound))
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deploy token could not be found"
+				}`))
+			})
 		})
 
-		It("publishes invalidation message for the associated domains", func() {
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
 			doRequest()
+			return res
+		}, nil)
 
-			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
-			Expect(d).NotTo(BeNil())
-			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-				"domains": ["%s", "%s", "%s"]
-			}`, proj.Name+"."+shared.DefaultDomain, dm1.Name, dm2.Name)))
-		})
-
-		It("tracks a 'Deleted Project' event", func() {
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
 			doRequest()
+			return res
+		}, nil)
+	})
 
-			trackCall := fakeTracker.TrackCalls.NthCall(1)
-			Expect(trackCall).NotTo(BeNil())
-			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
-			Expect(trackCall.Arguments[1]).To(Equal("Deleted Project"))
-			Expect(trackCall.Arguments[2]).To(Equal(""))
+	Describe("POST /projects/:name/status_token", func() {
+		var (
+			proj *project.Project
 
-			t := trackCall.Arguments[3]
-			props, ok := t.(map[string]interface{})
-			Expect(ok).To(BeTrue())
-			Expect(props["projectName"]).To(Equal(proj.Name))
+			headers http.Header
+		)
 
-			c := trackCall.Arguments[4]
-			context, ok := c.(map[string]interface{})
-			Expect(ok).To(BeTrue())
-			Expect(context["ip"]).ToNot(BeNil())
-			Expect(context["user_agent"]).ToNot(BeNil())
+		BeforeEach(func() {
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
 
-			Expect(trackCall.ReturnValues[0]).To(BeNil())
+			proj = factories.Project(db, u)
 		})
 
-		Context("when there are associated raw bundles", func() {
-			var (
-				bun1 *rawbundle.RawBundle
-				bun2 *rawbundle.RawBundle
-			)
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/"+proj.Name+"/status_token", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
 
-			BeforeEach(func() {
-				bun1 = factories.RawBundle(db, proj)
-				bun2 = factories.RawBundle(db, proj)
-			})
+		It("returns 201 Created and enables the status page of the project with a new token", func() {
+			doRequest()
 
-			It("deletes associated raw bundles from DB and S3", func() {
-				doRequest()
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
 
-				Expect(db.First(bun1, bun1.ID).Error).To(Equal(gorm.RecordNotFound))
-				Expect(db.First(bun2, bun2.ID).Error).To(Equal(gorm.RecordNotFound))
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.StatusToken).NotTo(BeNil())
+			Expect(*proj.StatusToken).To(HaveLen(64))
 
-				Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"status_token": "%s"
+			}`, *proj.StatusToken)))
+		})
 
-				deleteCall := fakeS3.DeleteCalls.NthCall(1)
-				Expect(deleteCall).NotTo(BeNil())
-				Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
-				Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
-				Expect(deleteCall.ReturnValues[0]).To(BeNil())
+		It("replaces the previous token", func() {
+			Expect(proj.RegenerateStatusToken(db)).To(BeNil())
+			prevToken := *proj.StatusToken
 
-				filesToDelete := []string{
-					"domains/" + proj.DefaultDomainName() + "/meta.json",
-					"domains/" + dm1.Name + "/meta.json",
-					"certs/" + dm1.Name + "/ssl.crt",
-					"certs/" + dm1.Name + "/ssl.key",
-					"domains/" + dm2.Name + "/meta.json",
-					"certs/" + dm2.Name + "/ssl.crt",
-					"certs/" + dm2.Name + "/ssl.key",
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
 
-					bun1.UploadedPath,
-					bun2.UploadedPath,
-				}
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.StatusToken).NotTo(BeNil())
+			Expect(*proj.StatusToken).NotTo(Equal(prevToken))
 
-				for i, path := range filesToDelete {
-					Expect(deleteCall.Arguments[2+i]).To(Equal(path))
-				}
-			})
+			p, err := project.FindByStatusToken(db, prevToken)
+			Expect(err).To(BeNil())
+			Expect(p).To(BeNil())
 		})
 
 		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
@@ -1220,15 +3344,58 @@ var _ = Describe("Projects", func() {
 			return res
 		}, nil)
 
-		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
 		}, func() *http.Response {
 			doRequest()
 			return res
 		}, nil)
+	})
 
-		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
+	Describe("DELETE /projects/:name/status_token", func() {
+		var (
+			proj *project.Project
+
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u)
+			Expect(proj.RegenerateStatusToken(db)).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/"+proj.Name+"/status_token", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and disables the status page of the project", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{"deleted": true}`))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.StatusToken).To(BeNil())
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
 		}, func() *http.Response {
 			doRequest()
 			return res
@@ -9,7 +9,6 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
-	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
@@ -35,9 +34,8 @@ var _ = Describe("RawBundles", func() {
 		res *http.Response
 		err error
 
-		u  *user.User
-		oc *oauthclient.OauthClient
-		t  *oauthtoken.OauthToken
+		u *user.User
+		t *oauthtoken.OauthToken
 	)
 
 	BeforeEach(func() {
@@ -45,7 +43,7 @@ var _ = Describe("RawBundles", func() {
 		Expect(err).To(BeNil())
 		testhelper.TruncateTables(db.DB())
 
-		u, oc, t = factories.AuthTrio(db)
+		u, _, t = factories.AuthTrio(db)
 	})
 
 	AfterEach(func() {
@@ -0,0 +1,92 @@
+package status
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// RecentDeploymentsShown is the number of the latest deployments of a project
+// that are listed on its status page.
+var RecentDeploymentsShown = 10
+
+// deploymentJSON is the part of a deployment that is safe to show publicly,
+// leaving out e.g. error messages and warnings that may reveal its contents.
+type deploymentJSON struct {
+	ID         uint       `json:"id"`
+	Version    int64      `json:"version"`
+	State      string     `json:"state"`
+	Active     bool       `json:"active,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DeployedAt *time.Time `json:"deployed_at,omitempty"`
+}
+
+func asJSON(depl *deployment.Deployment, proj *project.Project) *deploymentJSON {
+	return &deploymentJSON{
+		ID:         depl.ID,
+		Version:    depl.Version,
+		State:      depl.PublicState(),
+		Active:     proj.ActiveDeploymentID != nil && depl.ID == *proj.ActiveDeploymentID,
+		CreatedAt:  depl.CreatedAt,
+		DeployedAt: depl.DeployedAt,
+	}
+}
+
+// Show responds with a snapshot of the deploy state of the project whose
+// status page has the given token, without requiring authentication.
+func Show(c *gin.Context) {
+	db, err := dbconn.ReplicaDB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	proj, err := project.FindByStatusToken(db, c.Param("project_token"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if proj == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "status page could not be found",
+		})
+		return
+	}
+
+	var activeDepl *deploymentJSON
+	if proj.ActiveDeploymentID != nil {
+		depl := &deployment.Deployment{}
+		if err := db.First(depl, *proj.ActiveDeploymentID).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		activeDepl = asJSON(depl, proj)
+	}
+
+	depls, err := deployment.Recent(db, proj.ID, RecentDeploymentsShown)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	deplsToJSON := make([]*deploymentJSON, len(depls))
+	for i, depl := range depls {
+		deplsToJSON[i] = asJSON(depl, proj)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": gin.H{
+			"name":           proj.Name,
+			"deploys_paused": proj.DeploysPaused,
+		},
+		"active_deployment": activeDepl,
+		"deployments":       deplsToJSON,
+	})
+}
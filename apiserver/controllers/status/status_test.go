@@ -0,0 +1,175 @@
+package status_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "status")
+}
+
+var _ = Describe("Status", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /status/:project_token", func() {
+		var (
+			u     *user.User
+			proj  *project.Project
+			depl1 *deployment.Deployment
+			depl2 *deployment.Deployment
+			depl3 *deployment.Deployment
+
+			token string
+		)
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			proj = factories.Project(db, u)
+
+			depl1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			depl2 = factories.Deployment(db, proj, u, deployment.StateDeployFailed)
+			depl3 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+			Expect(db.Model(proj).Update("active_deployment_id", depl1.ID).Error).To(BeNil())
+
+			Expect(proj.RegenerateStatusToken(db)).To(BeNil())
+			token = *proj.StatusToken
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/status/"+token, nil, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the deploy state of the project without authentication", func() {
+			doRequest()
+
+			var j struct {
+				Project struct {
+					Name          string `json:"name"`
+					DeploysPaused bool   `json:"deploys_paused"`
+				} `json:"project"`
+				ActiveDeployment map[string]interface{}   `json:"active_deployment"`
+				Deployments      []map[string]interface{} `json:"deployments"`
+			}
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+
+			Expect(j.Project.Name).To(Equal(proj.Name))
+			Expect(j.Project.DeploysPaused).To(BeFalse())
+
+			Expect(j.ActiveDeployment["id"]).To(BeEquivalentTo(depl1.ID))
+			Expect(j.ActiveDeployment["state"]).To(Equal(depl1.PublicState()))
+			Expect(j.ActiveDeployment["active"]).To(Equal(true))
+
+			Expect(j.Deployments).To(HaveLen(3))
+			Expect(j.Deployments[0]["id"]).To(BeEquivalentTo(depl3.ID))
+			Expect(j.Deployments[0]["state"]).To(Equal(depl3.PublicState()))
+			Expect(j.Deployments[1]["id"]).To(BeEquivalentTo(depl2.ID))
+			Expect(j.Deployments[1]["state"]).To(Equal(depl2.PublicState()))
+			Expect(j.Deployments[2]["id"]).To(BeEquivalentTo(depl1.ID))
+			Expect(j.Deployments[2]["active"]).To(Equal(true))
+		})
+
+		It("does not reveal error messages of deployments", func() {
+			Expect(db.Model(depl2).Update("error_message", "secret.txt is too large").Error).To(BeNil())
+
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).NotTo(ContainSubstring("secret.txt"))
+		})
+
+		Context("when the token is wrong", func() {
+			BeforeEach(func() {
+				token = "not-" + token
+			})
+
+			It("returns 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "status page could not be found"
+				}`))
+			})
+		})
+
+		Context("when the status page has been disabled", func() {
+			BeforeEach(func() {
+				Expect(proj.DisableStatusPage(db)).To(BeNil())
+			})
+
+			It("returns 404 Not Found", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("when the project has no active deployment", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("active_deployment_id", nil).Error).To(BeNil())
+			})
+
+			It("returns a null active deployment", func() {
+				doRequest()
+
+				var j map[string]interface{}
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+				Expect(j).To(HaveKeyWithValue("active_deployment", BeNil()))
+			})
+		})
+	})
+
+	Describe("GET /status/", func() {
+		It("returns 404 Not Found without a token", func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/status/", nil, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+})
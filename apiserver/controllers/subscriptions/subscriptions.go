@@ -0,0 +1,139 @@
+package subscriptions
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/subscription"
+)
+
+// Create subscribes a URL to an event of a project.
+func Create(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	sub := &subscription.Subscription{
+		ProjectID: proj.ID,
+		UserID:    u.ID,
+		EventType: c.PostForm("event_type"),
+		TargetURL: c.PostForm("target_url"),
+	}
+
+	if errs := sub.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var count int
+	if err := db.Model(subscription.Subscription{}).Where("project_id = ?", proj.ID).Count(&count).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if count >= subscription.MaxPerProject {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "invalid_request",
+			"error_description": "maximum number of subscriptions reached",
+		})
+		return
+	}
+
+	if err := db.Create(sub).Error; err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "already_exists",
+				"error_description": "target url is already subscribed to the event",
+			})
+			return
+		}
+
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"subscription": sub.AsJSON(),
+	})
+}
+
+// Index lists the subscriptions of a project.
+func Index(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	subs, err := subscription.ForProject(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	subsJSON := make([]interface{}, len(subs))
+	for i, sub := range subs {
+		subsJSON[i] = sub.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscriptions": subsJSON,
+	})
+}
+
+// Destroy unsubscribes a URL from an event of a project.
+func Destroy(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "subscription could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	sub := &subscription.Subscription{}
+	if err := db.Where("id = ? AND project_id = ?", id, proj.ID).First(sub).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "subscription could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Delete(sub).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": true,
+	})
+}
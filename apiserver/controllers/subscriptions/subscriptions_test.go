@@ -0,0 +1,335 @@
+package subscriptions_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/subscription"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "subscriptions")
+}
+
+var _ = Describe("Subscriptions", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		proj    *project.Project
+		headers http.Header
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+		proj = factories.Project(db, u)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("POST /projects/:project_name/subscriptions", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"event_type": {"deploy_succeeded"},
+				"target_url": {"https://example.com/hooks/deployed"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/"+proj.Name+"/subscriptions", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 201 Created and creates the subscription", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			sub := &subscription.Subscription{}
+			Expect(db.Last(sub).Error).To(BeNil())
+			Expect(sub.ProjectID).To(Equal(proj.ID))
+			Expect(sub.UserID).To(Equal(u.ID))
+			Expect(sub.EventType).To(Equal(subscription.EventDeploySucceeded))
+			Expect(sub.TargetURL).To(Equal("https://example.com/hooks/deployed"))
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"subscription": {
+					"id": %d,
+					"event_type": "deploy_succeeded",
+					"target_url": "https://example.com/hooks/deployed",
+					"created_at": "%s"
+				}
+			}`, sub.ID, sub.CreatedAt.Format(time.RFC3339Nano))))
+		})
+
+		DescribeTable("validations",
+			func(eventType, targetURL, message string) {
+				params = url.Values{
+					"event_type": {eventType},
+					"target_url": {targetURL},
+				}
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(message))
+
+				var count int
+				Expect(db.Model(subscription.Subscription{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			},
+
+			Entry("missing event type", "", "https://example.com", `{
+				"error": "invalid_params",
+				"errors": { "event_type": "is required" }
+			}`),
+			Entry("unknown event type", "deploy_started", "https://example.com", `{
+				"error": "invalid_params",
+				"errors": { "event_type": "is invalid" }
+			}`),
+			Entry("missing target url", "deploy_failed", "", `{
+				"error": "invalid_params",
+				"errors": { "target_url": "is required" }
+			}`),
+			Entry("target url that is not http", "deploy_failed", "ftp://example.com", `{
+				"error": "invalid_params",
+				"errors": { "target_url": "is invalid" }
+			}`),
+		)
+
+		Context("when the target url is already subscribed to the event", func() {
+			BeforeEach(func() {
+				factories.Subscription(db, proj, subscription.EventDeploySucceeded, "https://example.com/hooks/deployed")
+			})
+
+			It("returns 409 conflict", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "already_exists",
+					"error_description": "target url is already subscribed to the event"
+				}`))
+			})
+		})
+
+		Context("when the project has the maximum number of subscriptions", func() {
+			var origMaxPerProject int
+
+			BeforeEach(func() {
+				origMaxPerProject = subscription.MaxPerProject
+				subscription.MaxPerProject = 1
+
+				factories.Subscription(db, proj, subscription.EventDeployFailed, "https://example.com/hooks/failed")
+			})
+
+			AfterEach(func() {
+				subscription.MaxPerProject = origMaxPerProject
+			})
+
+			It("returns 403 forbidden", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "maximum number of subscriptions reached"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects/:project_name/subscriptions", func() {
+		var (
+			sub1 *subscription.Subscription
+			sub2 *subscription.Subscription
+		)
+
+		BeforeEach(func() {
+			sub1 = factories.Subscription(db, proj, subscription.EventDeploySucceeded, "https://example.com/hooks/deployed")
+			sub2 = factories.Subscription(db, proj, subscription.EventDeployFailed, "https://example.com/hooks/failed")
+			factories.Subscription(db, nil, subscription.EventDeploySucceeded, "https://example.com/hooks/other")
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/"+proj.Name+"/subscriptions", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the subscriptions of the project", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"subscriptions": [
+					{
+						"id": %d,
+						"event_type": "deploy_succeeded",
+						"target_url": "https://example.com/hooks/deployed",
+						"created_at": "%s"
+					},
+					{
+						"id": %d,
+						"event_type": "deploy_failed",
+						"target_url": "https://example.com/hooks/failed",
+						"created_at": "%s"
+					}
+				]
+			}`, sub1.ID, sub1.CreatedAt.Format(time.RFC3339Nano),
+				sub2.ID, sub2.CreatedAt.Format(time.RFC3339Nano))))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:project_name/subscriptions/:id", func() {
+		var sub *subscription.Subscription
+
+		BeforeEach(func() {
+			sub = factories.Subscription(db, proj, subscription.EventDeploySucceeded, "https://example.com/hooks/deployed")
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/"+proj.Name+"/subscriptions/"+fmt.Sprint(sub.ID), nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and deletes the subscription", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"deleted": true
+			}`))
+
+			err = db.First(&subscription.Subscription{}, sub.ID).Error
+			Expect(err).To(Equal(gorm.RecordNotFound))
+		})
+
+		Context("when the subscription belongs to another project", func() {
+			BeforeEach(func() {
+				sub = factories.Subscription(db, nil, subscription.EventDeploySucceeded, "https://example.com/hooks/other")
+			})
+
+			It("returns 404 not found and does not delete the subscription", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "subscription could not be found"
+				}`))
+
+				Expect(db.First(&subscription.Subscription{}, sub.ID).Error).To(BeNil())
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
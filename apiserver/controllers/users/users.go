@@ -12,7 +12,9 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedemail"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 )
 
@@ -241,6 +243,47 @@ func Show(c *gin.Context) {
 	})
 }
 
+// Usage returns how much storage and how many projects and deployments the
+// current user is using.
+func Usage(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	storageUsed, err := u.StorageUsedBytes(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var projectCount int
+	if err := db.Model(project.Project{}).Where("user_id = ?", u.ID).Count(&projectCount).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var deploymentCount int
+	if err := db.Model(deployment.Deployment{}).
+		Joins("JOIN projects ON projects.id = deployments.project_id").
+		Where("projects.user_id = ? AND projects.deleted_at IS NULL", u.ID).
+		Count(&deploymentCount).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"usage": gin.H{
+			"storage_used_bytes": storageUsed,
+			"project_count":      projectCount,
+			"deployment_count":   deploymentCount,
+		},
+	})
+}
+
 func Update(c *gin.Context) {
 	currentUser := controllers.CurrentUser(c)
 	for _, k := range []string{"existing_password", "password"} {
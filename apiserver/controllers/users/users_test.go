@@ -14,8 +14,10 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/users"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/server"
 	"github.com/nitrous-io/rise-server/pkg/mailer"
@@ -624,6 +626,70 @@ var _ = Describe("Users", func() {
 		}, nil)
 	})
 
+	Describe("GET /account/usage", func() {
+		var (
+			u       *user.User
+			t       *oauthtoken.OauthToken
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj1 := factories.Project(db, u)
+			proj2 := factories.Project(db, u)
+			for _, proj := range []*project.Project{proj1, proj2} {
+				bun := factories.RawBundle(db, proj)
+				Expect(db.Model(bun).Update("size", 1024).Error).To(BeNil())
+				factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+					State:       deployment.StateDeployed,
+					RawBundleID: &bun.ID,
+				})
+			}
+			factories.Deployment(db, proj1, u, deployment.StateDeployed)
+
+			deletedProj := factories.Project(db, u)
+			bun := factories.RawBundle(db, deletedProj)
+			Expect(db.Model(bun).Update("size", 4096).Error).To(BeNil())
+			factories.Deployment(db, deletedProj, u, deployment.StateDeployed)
+			Expect(db.Delete(deletedProj).Error).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/account/usage", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and the usage of projects that are not deleted", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"usage": {
+					"storage_used_bytes": 2048,
+					"project_count": 2,
+					"deployment_count": 3
+				}
+			}`))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
 	Describe("PUT /user", func() {
 		var (
 			u                *user.User
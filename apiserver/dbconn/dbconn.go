@@ -11,6 +11,9 @@ import (
 var (
 	db     *gorm.DB
 	dbLock sync.Mutex
+
+	replica     *gorm.DB
+	replicaLock sync.Mutex
 )
 
 // DB returns gorm DB handle
@@ -18,14 +21,44 @@ func DB() (*gorm.DB, error) {
 	dbLock.Lock()
 	defer dbLock.Unlock()
 	if db == nil {
-		d, err := gorm.Open("postgres", os.Getenv("POSTGRES_URL"))
+		d, err := open(os.Getenv("POSTGRES_URL"))
 		if err != nil {
 			return nil, err
 		}
-		if os.Getenv("RISE_ENV") == "test" {
-			d.LogMode(false)
-		}
-		db = &d
+		db = d
 	}
 	return db, nil
 }
+
+// ReplicaDB returns gorm DB handle of the read replica configured with
+// POSTGRES_REPLICA_URL, or that of the primary if there is none. Replicas may
+// lag behind the primary, so it should only be used for read-only queries
+// that can tolerate slightly stale results.
+func ReplicaDB() (*gorm.DB, error) {
+	url := os.Getenv("POSTGRES_REPLICA_URL")
+	if url == "" {
+		return DB()
+	}
+
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	if replica == nil {
+		d, err := open(url)
+		if err != nil {
+			return nil, err
+		}
+		replica = d
+	}
+	return replica, nil
+}
+
+func open(url string) (*gorm.DB, error) {
+	d, err := gorm.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("RISE_ENV") == "test" {
+		d.LogMode(false)
+	}
+	return &d, nil
+}
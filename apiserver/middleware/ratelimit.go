@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+)
+
+// rateLimiter counts the requests of each client in fixed windows.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateLimitBucket
+	lastSweep time.Time
+}
+
+type rateLimitBucket struct {
+	remaining int
+	resetAt   time.Time
+}
+
+var limiter = &rateLimiter{buckets: map[string]*rateLimitBucket{}}
+
+// take uses up one of the requests the client has left in the current window,
+// starting a new window if the last one has ended. It returns the number of
+// requests left, when the window ends, and whether the request is allowed.
+func (l *rateLimiter) take(client string, limit int, window time.Duration, now time.Time) (int, time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Buckets of clients that have not made a request since their window
+	// ended are dropped once in a while, so that they do not pile up.
+	if now.Sub(l.lastSweep) >= window {
+		for c, b := range l.buckets {
+			if !now.Before(b.resetAt) {
+				delete(l.buckets, c)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok || !now.Before(b.resetAt) {
+		b = &rateLimitBucket{remaining: limit, resetAt: now.Add(window)}
+		l.buckets[client] = b
+	}
+
+	if b.remaining == 0 {
+		return 0, b.resetAt, false
+	}
+	b.remaining--
+	return b.remaining, b.resetAt, true
+}
+
+// RateLimit rejects requests of clients that have made more than
+// common.RateLimit requests in the current window, and tells clients how many
+// they have left in the X-RateLimit-* headers.
+func RateLimit(c *gin.Context) {
+	if common.RateLimit <= 0 {
+		c.Next()
+		return
+	}
+
+	// Clients are told apart by the address that the trusted proxies saw, as
+	// they could otherwise reset their limit by sending forwarding headers.
+	remaining, resetAt, ok := limiter.take(common.ClientIP(c.Request), common.RateLimit, common.RateLimitWindow, time.Now())
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(common.RateLimit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":             "too_many_requests",
+			"error_description": "rate limit exceeded, try again later",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
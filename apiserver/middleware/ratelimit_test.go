@@ -0,0 +1,188 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "middleware")
+}
+
+var _ = Describe("RateLimit", func() {
+	var (
+		s *httptest.Server
+
+		origRateLimit       int
+		origRateLimitWindow time.Duration
+		origTrustedProxies  []*net.IPNet
+
+		clientIP string
+		clients  int
+	)
+
+	BeforeEach(func() {
+		origRateLimit = common.RateLimit
+		origRateLimitWindow = common.RateLimitWindow
+		origTrustedProxies = common.TrustedProxies
+
+		// The requests of the tests come through a proxy on the loopback
+		// interface, which tells clients apart by X-Forwarded-For.
+		_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+		Expect(err).To(BeNil())
+		common.TrustedProxies = []*net.IPNet{loopback}
+
+		// Each test is a different client, so that they do not share buckets.
+		clients++
+		clientIP = "10.0.0." + strconv.Itoa(clients)
+
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		common.RateLimit = origRateLimit
+		common.RateLimitWindow = origRateLimitWindow
+		common.TrustedProxies = origTrustedProxies
+		s.Close()
+	})
+
+	doRequestForwardedFor := func(forwardedFor string) *http.Response {
+		req, err := http.NewRequest("GET", s.URL+"/ping", nil)
+		Expect(err).To(BeNil())
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		res.Body.Close()
+		return res
+	}
+
+	doRequest := func() *http.Response {
+		return doRequestForwardedFor(clientIP)
+	}
+
+	Context("when there is no rate limit", func() {
+		BeforeEach(func() {
+			common.RateLimit = 0
+		})
+
+		It("does not return rate limit headers", func() {
+			res := doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-RateLimit-Limit")).To(BeEmpty())
+			Expect(res.Header.Get("X-RateLimit-Remaining")).To(BeEmpty())
+			Expect(res.Header.Get("X-RateLimit-Reset")).To(BeEmpty())
+		})
+	})
+
+	Context("when there is a rate limit", func() {
+		BeforeEach(func() {
+			common.RateLimit = 2
+			common.RateLimitWindow = time.Hour
+		})
+
+		It("returns the number of requests left, decrementing across requests", func() {
+			res := doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-RateLimit-Limit")).To(Equal("2"))
+			Expect(res.Header.Get("X-RateLimit-Remaining")).To(Equal("1"))
+
+			reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+			Expect(err).To(BeNil())
+			Expect(time.Unix(reset, 0)).To(BeTemporally("~", time.Now().Add(time.Hour), 5*time.Second))
+
+			res = doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-RateLimit-Remaining")).To(Equal("0"))
+			Expect(res.Header.Get("X-RateLimit-Reset")).To(Equal(strconv.FormatInt(reset, 10)))
+		})
+
+		It("returns 429 too many requests once the limit is reached", func() {
+			doRequest()
+			doRequest()
+
+			req, err := http.NewRequest("GET", s.URL+"/ping", nil)
+			Expect(err).To(BeNil())
+			req.Header.Set("X-Forwarded-For", clientIP)
+
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+
+			Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(res.Header.Get("X-RateLimit-Remaining")).To(Equal("0"))
+
+			var j map[string]interface{}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+			Expect(j).To(Equal(map[string]interface{}{
+				"error":             "too_many_requests",
+				"error_description": "rate limit exceeded, try again later",
+			}))
+		})
+
+		It("does not share the requests left between clients", func() {
+			doRequest()
+			doRequest()
+
+			clientIP = "10.0.1." + strconv.Itoa(clients)
+			res := doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-RateLimit-Remaining")).To(Equal("1"))
+		})
+
+		It("does not reset the requests left when the client spoofs the hops before the proxy", func() {
+			doRequest()
+			doRequest()
+
+			res := doRequestForwardedFor("203.0.113.1, " + clientIP)
+			Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+
+			res = doRequestForwardedFor("203.0.113.2, " + clientIP)
+			Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+		})
+
+		Context("when the request does not come from a trusted proxy", func() {
+			BeforeEach(func() {
+				common.TrustedProxies = nil
+			})
+
+			It("ignores X-Forwarded-For, so that spoofing it does not reset the requests left", func() {
+				doRequestForwardedFor("203.0.113.1")
+				doRequestForwardedFor("203.0.113.2")
+
+				res := doRequestForwardedFor("203.0.113.3")
+				Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+			})
+		})
+
+		Context("when the window has ended", func() {
+			BeforeEach(func() {
+				common.RateLimitWindow = 100 * time.Millisecond
+			})
+
+			It("resets the number of requests left", func() {
+				doRequest()
+				res := doRequest()
+				Expect(res.Header.Get("X-RateLimit-Remaining")).To(Equal("0"))
+
+				time.Sleep(150 * time.Millisecond)
+
+				res = doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(res.Header.Get("X-RateLimit-Remaining")).To(Equal("1"))
+			})
+		})
+	})
+})
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+)
+
+// RequireAdmin is a Gin middleware that ensures that the current user is an
+// admin.
+func RequireAdmin(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	if u == nil {
+		controllers.InternalServerError(c, nil)
+		c.Abort()
+		return
+	}
+
+	if !u.Admin {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "forbidden",
+			"error_description": "admin access is required",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
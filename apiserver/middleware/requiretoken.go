@@ -14,7 +14,22 @@ import (
 
 var bearerTokenAuthHeaderRe = regexp.MustCompile(`\A\s*Bearer\s+([\S]+)\s*\z`)
 
+// RequireToken is a Gin middleware that authenticates the user by the bearer
+// token in the Authorization header. Scoped tokens are rejected.
 func RequireToken(c *gin.Context) {
+	requireToken(c, "")
+}
+
+// RequireTokenWithScope returns a Gin middleware that is like RequireToken,
+// but also accepts tokens with the given scope. Handlers behind it have to
+// check that the token is allowed to act on the project.
+func RequireTokenWithScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requireToken(c, scope)
+	}
+}
+
+func requireToken(c *gin.Context, allowedScope string) {
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -51,6 +66,15 @@ func RequireToken(c *gin.Context) {
 		return
 	}
 
+	if t.IsScoped() && *t.Scope != allowedScope {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "insufficient_scope",
+			"error_description": "access token is not allowed to perform this action",
+		})
+		c.Abort()
+		return
+	}
+
 	u := &user.User{}
 
 	if err := db.Model(t).Related(u).Error; err != nil {
@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN auto_publish;
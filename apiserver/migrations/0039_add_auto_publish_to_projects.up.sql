@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN auto_publish bool DEFAULT true NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN variants json DEFAULT '{}';
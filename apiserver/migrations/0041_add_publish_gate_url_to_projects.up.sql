@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN publish_gate_url text;
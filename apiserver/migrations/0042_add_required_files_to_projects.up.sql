@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN required_files json DEFAULT '[]';
@@ -0,0 +1 @@
+ALTER TABLE raw_bundles ADD COLUMN size bigint DEFAULT 0 NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE deployments DROP COLUMN commit_sha;
+ALTER TABLE deployments DROP COLUMN branch;
+ALTER TABLE deployments DROP COLUMN label;
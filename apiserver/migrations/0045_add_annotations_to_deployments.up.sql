@@ -0,0 +1,3 @@
+ALTER TABLE deployments ADD COLUMN label text;
+ALTER TABLE deployments ADD COLUMN branch text;
+ALTER TABLE deployments ADD COLUMN commit_sha text;
@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN image_bytes_saved;
+ALTER TABLE projects DROP COLUMN optimize_images;
@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN optimize_images bool DEFAULT false NOT NULL;
+ALTER TABLE deployments ADD COLUMN image_bytes_saved bigint DEFAULT 0 NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS index_oauth_tokens_on_project_id;
+
+ALTER TABLE oauth_tokens DROP COLUMN scope;
+ALTER TABLE oauth_tokens DROP COLUMN project_id;
@@ -0,0 +1,4 @@
+ALTER TABLE oauth_tokens ADD COLUMN project_id bigint REFERENCES projects(id);
+ALTER TABLE oauth_tokens ADD COLUMN scope text;
+
+CREATE INDEX index_oauth_tokens_on_project_id ON oauth_tokens USING btree (project_id);
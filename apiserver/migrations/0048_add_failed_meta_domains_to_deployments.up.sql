@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN failed_meta_domains json DEFAULT '[]';
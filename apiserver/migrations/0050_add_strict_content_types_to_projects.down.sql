@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN strict_content_types;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN strict_content_types bool DEFAULT false NOT NULL;
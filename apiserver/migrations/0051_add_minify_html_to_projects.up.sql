@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN minify_html bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN deploy_retention_days bigint DEFAULT 0 NOT NULL;
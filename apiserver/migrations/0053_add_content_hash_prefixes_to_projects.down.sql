@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN content_hash_prefixes;
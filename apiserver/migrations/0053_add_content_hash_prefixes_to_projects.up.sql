@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN content_hash_prefixes bool DEFAULT false NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN js_env_disabled;
+ALTER TABLE projects DROP COLUMN js_env_filename;
@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN js_env_filename character varying(255) DEFAULT 'jsenv.js' NOT NULL;
+ALTER TABLE projects ADD COLUMN js_env_disabled bool DEFAULT false NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN canary_percent;
+ALTER TABLE projects DROP COLUMN canary_deployment_id;
@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN canary_deployment_id bigint REFERENCES deployments(id);
+ALTER TABLE projects ADD COLUMN canary_percent integer DEFAULT 0 NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN warnings;
+ALTER TABLE projects DROP COLUMN check_internal_links;
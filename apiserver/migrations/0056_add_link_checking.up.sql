@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN check_internal_links bool DEFAULT false NOT NULL;
+ALTER TABLE deployments ADD COLUMN warnings json DEFAULT '[]';
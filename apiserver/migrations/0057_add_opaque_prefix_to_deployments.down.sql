@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN opaque_prefix;
+ALTER TABLE deployments ALTER COLUMN prefix SET DEFAULT encode(gen_random_bytes(2), 'hex');
@@ -0,0 +1,2 @@
+ALTER TABLE deployments ALTER COLUMN prefix SET DEFAULT encode(gen_random_bytes(8), 'hex');
+ALTER TABLE deployments ADD COLUMN opaque_prefix bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN pre_deploy_hook_url;
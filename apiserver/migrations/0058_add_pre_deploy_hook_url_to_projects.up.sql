@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN pre_deploy_hook_url text;
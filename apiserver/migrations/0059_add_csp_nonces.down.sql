@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN csp_nonce;
+ALTER TABLE projects DROP COLUMN csp_nonces;
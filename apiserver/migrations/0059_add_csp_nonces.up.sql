@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN csp_nonces bool DEFAULT false NOT NULL;
+ALTER TABLE deployments ADD COLUMN csp_nonce text;
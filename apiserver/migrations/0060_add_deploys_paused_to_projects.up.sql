@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN deploys_paused bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN max_domains integer;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN fingerprint bool DEFAULT false NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN validate_json;
+ALTER TABLE projects DROP COLUMN validate_js;
@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN validate_js bool DEFAULT false NOT NULL;
+ALTER TABLE projects ADD COLUMN validate_json bool DEFAULT false NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN created_by_ip;
+ALTER TABLE deployments DROP COLUMN created_by_user_agent;
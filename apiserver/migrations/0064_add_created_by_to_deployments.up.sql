@@ -0,0 +1,2 @@
+ALTER TABLE deployments ADD COLUMN created_by_user_agent text;
+ALTER TABLE deployments ADD COLUMN created_by_ip varchar(45);
@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN tombstones;
+ALTER TABLE projects DROP COLUMN tombstone_removed_paths;
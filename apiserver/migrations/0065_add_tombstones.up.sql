@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN tombstone_removed_paths bool DEFAULT false NOT NULL;
+ALTER TABLE deployments ADD COLUMN tombstones json DEFAULT '[]';
@@ -0,0 +1 @@
+ALTER TABLE oauth_tokens DROP COLUMN last_used_at;
@@ -0,0 +1 @@
+ALTER TABLE oauth_tokens ADD COLUMN last_used_at timestamp without time zone;
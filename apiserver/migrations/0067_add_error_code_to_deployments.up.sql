@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN error_code text;
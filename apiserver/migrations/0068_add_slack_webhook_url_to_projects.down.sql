@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN slack_webhook_url;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN slack_webhook_url text;
@@ -0,0 +1,3 @@
+ALTER TABLE deployments DROP COLUMN skipped_files;
+ALTER TABLE projects DROP COLUMN exclude_globs;
+ALTER TABLE projects DROP COLUMN include_globs;
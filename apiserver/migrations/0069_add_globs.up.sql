@@ -0,0 +1,3 @@
+ALTER TABLE projects ADD COLUMN include_globs json DEFAULT '[]';
+ALTER TABLE projects ADD COLUMN exclude_globs json DEFAULT '[]';
+ALTER TABLE deployments ADD COLUMN skipped_files json DEFAULT '[]';
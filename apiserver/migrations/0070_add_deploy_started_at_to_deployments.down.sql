@@ -0,0 +1 @@
+ALTER TABLE deployments DROP COLUMN deploy_started_at;
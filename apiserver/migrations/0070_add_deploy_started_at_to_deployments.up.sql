@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN deploy_started_at timestamp without time zone;
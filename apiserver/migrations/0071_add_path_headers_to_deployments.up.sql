@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN path_headers json DEFAULT '[]';
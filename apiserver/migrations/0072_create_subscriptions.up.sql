@@ -0,0 +1,15 @@
+CREATE TABLE subscriptions (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  user_id bigint REFERENCES users(id) NOT NULL,
+
+  event_type character varying(255) NOT NULL,
+  target_url text NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE UNIQUE INDEX index_subscriptions_on_project_id_and_event_type_and_target_url ON subscriptions USING btree (project_id, event_type, target_url) WHERE deleted_at IS NULL;
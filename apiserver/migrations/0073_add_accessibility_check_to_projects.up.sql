@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN accessibility_check bool DEFAULT false NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN strict_mixed_content;
+ALTER TABLE projects DROP COLUMN check_mixed_content;
@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN check_mixed_content bool DEFAULT false NOT NULL;
+ALTER TABLE projects ADD COLUMN strict_mixed_content bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN admin bool DEFAULT false NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN compressed_bytes;
+ALTER TABLE deployments DROP COLUMN uncompressed_bytes;
@@ -0,0 +1,2 @@
+ALTER TABLE deployments ADD COLUMN uncompressed_bytes bigint DEFAULT 0 NOT NULL;
+ALTER TABLE deployments ADD COLUMN compressed_bytes bigint DEFAULT 0 NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX index_projects_on_status_token;
+
+ALTER TABLE projects DROP COLUMN status_token;
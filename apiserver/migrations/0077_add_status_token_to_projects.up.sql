@@ -0,0 +1,3 @@
+ALTER TABLE projects ADD COLUMN status_token character varying(255);
+
+CREATE UNIQUE INDEX index_projects_on_status_token ON projects USING btree (status_token);
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN asset_manifest bool DEFAULT false NOT NULL;
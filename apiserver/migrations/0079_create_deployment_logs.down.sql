@@ -0,0 +1,2 @@
+DROP INDEX index_deployment_logs_on_deployment_id;
+DROP TABLE deployment_logs;
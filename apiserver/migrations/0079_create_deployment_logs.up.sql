@@ -0,0 +1,10 @@
+CREATE TABLE deployment_logs (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  deployment_id bigint REFERENCES deployments(id) NOT NULL,
+  message text NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX index_deployment_logs_on_deployment_id ON deployment_logs USING btree (deployment_id);
@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN skip_js_env bool DEFAULT false NOT NULL;
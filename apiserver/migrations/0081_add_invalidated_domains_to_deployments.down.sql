@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN invalidation_skipped;
+ALTER TABLE deployments DROP COLUMN invalidated_domains;
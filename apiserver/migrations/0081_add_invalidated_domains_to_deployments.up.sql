@@ -0,0 +1,2 @@
+ALTER TABLE deployments ADD COLUMN invalidated_domains json DEFAULT '[]';
+ALTER TABLE deployments ADD COLUMN invalidation_skipped bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN preload_critical_assets;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN preload_critical_assets bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN invalidation_status text;
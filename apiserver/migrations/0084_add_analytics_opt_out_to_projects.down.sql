@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN analytics_opt_out;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN analytics_opt_out bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN generate_favicons bool DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN security_contact text;
@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN default_deploy_options;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN default_deploy_options json DEFAULT '{}';
@@ -0,0 +1 @@
+ALTER TABLE deployments DROP COLUMN failed_uploads;
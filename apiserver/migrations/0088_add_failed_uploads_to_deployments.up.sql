@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN failed_uploads json DEFAULT '[]';
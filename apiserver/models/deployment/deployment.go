@@ -1,11 +1,17 @@
 package deployment
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/shared"
 )
 
 // Allowed deployment states.
@@ -20,8 +26,49 @@ const (
 	StateBuilt               = "built"
 	StateBuildFailed         = "build_failed"
 	StatePendingUpdateConfig = "pending_update_config"
+	StateUnpublished         = "unpublished"
 )
 
+// Error codes of failed deployments.
+const (
+	// ErrorCodeCorruptBundle is set when the bundle of a deployment could not
+	// be extracted, so deploying it again cannot succeed.
+	ErrorCodeCorruptBundle = "corrupt_bundle"
+)
+
+// Statuses of the cache invalidation of a deployment, which edges acknowledge
+// asynchronously.
+const (
+	InvalidationPending  = "pending"
+	InvalidationComplete = "complete"
+	InvalidationFailed   = "failed"
+)
+
+// publicStates maps each state to the name it is exposed as in the API.
+// Public names are part of the API and must not change when states are
+// renamed internally.
+var publicStates = map[string]string{
+	StatePendingUpload:       "pending_upload",
+	StateUploaded:            "uploaded",
+	StatePendingDeploy:       "pending_deploy",
+	StateDeployed:            "deployed",
+	StateDeployFailed:        "deploy_failed",
+	StatePendingRollback:     "pending_rollback",
+	StatePendingBuild:        "pending_build",
+	StateBuilt:               "built",
+	StateBuildFailed:         "build_failed",
+	StatePendingUpdateConfig: "pending_update_config",
+	StateUnpublished:         "unpublished",
+}
+
+// legacyPublicStates maps public state names that are no longer returned by
+// the API, but are still accepted from clients, to states.
+var legacyPublicStates = map[string]string{}
+
+// contentHashPrefixLength is the number of bytes of the hash of a bundle
+// that ContentHashPrefix uses.
+const contentHashPrefixLength = 8
+
 // Errors returned from this package.
 var (
 	ErrInvalidState = errors.New("state is not valid")
@@ -32,11 +79,17 @@ type Deployment struct {
 	gorm.Model
 
 	State string `sql:"default:'pending_upload'"`
-	// 4-digit hex hash is used to ensure files are uploaded across multiple partitions in S3
+	// Random hex hash is used to ensure files are uploaded across multiple partitions in S3
 	// http://docs.aws.amazon.com/AmazonS3/latest/dev/request-rate-perf-considerations.html
-	Prefix  string `sql:"default:encode(gen_random_bytes(2), 'hex')"`
+	Prefix  string `sql:"default:encode(gen_random_bytes(8), 'hex')"`
 	Version int64
 
+	// OpaquePrefix is set when the prefix alone identifies the deployment, so
+	// that its sequential ID is left out of its preview URLs and S3 paths.
+	// Deployments with content hash prefixes have it set too, and share their
+	// files with the other deployments of identical bundles.
+	OpaquePrefix bool `sql:"default:false"`
+
 	ProjectID   uint
 	UserID      uint
 	RawBundleID *uint
@@ -44,10 +97,268 @@ type Deployment struct {
 
 	JsEnvVars []byte `sql:"default:{}"`
 
+	// Variants maps the path of each asset in the webroot that also has
+	// pre-compressed variants to the encodings it is available in.
+	Variants []byte `sql:"default:{}"`
+
+	// Manifest is a JSON object describing each file that has been uploaded
+	// to the webroot of the deployment, keyed by path.
+	Manifest []byte `sql:"default:{}"`
+
+	// FailedMetaDomains is a JSON array of the domains whose meta.json could
+	// not be updated when the deployment was published, and should be retried.
+	FailedMetaDomains []byte `sql:"default:'[]'"`
+
+	// FailedUploads is a JSON array of the files that could not be uploaded
+	// to some of the webroot targets, and are to be copied over from the
+	// others by a repair job.
+	FailedUploads []byte `sql:"default:'[]'"`
+
+	// InvalidatedDomains is a JSON array of the domains that edges were told
+	// to invalidate their caches of when the deployment was last deployed.
+	// InvalidationSkipped is set if invalidation was skipped altogether.
+	InvalidatedDomains  []byte `sql:"default:'[]'"`
+	InvalidationSkipped bool
+
+	// InvalidationStatus is whether edges have acknowledged the cache
+	// invalidation of the deployment: InvalidationPending until they do, then
+	// InvalidationComplete or InvalidationFailed. It is nil if nothing was
+	// invalidated.
+	InvalidationStatus *string
+
+	// CSPNonce is the nonce that was added to the inline scripts and styles of
+	// the deployment, if the project had CSP nonces on when it was deployed.
+	CSPNonce *string `sql:"column:csp_nonce"`
+
+	// Warnings is a JSON array of problems found with the deployment that did
+	// not fail it, e.g. links to files that are not in the deployment.
+	Warnings []byte `sql:"default:'[]'"`
+
+	// Tombstones is a JSON array of the paths of pages that were removed from
+	// the project, which edges respond to with 410 Gone.
+	Tombstones []byte `sql:"default:'[]'"`
+
+	// PathHeaders is a JSON array of the header rules parsed from the _headers
+	// file of the bundle, in the order they appear in the file.
+	PathHeaders []byte `sql:"default:'[]'"`
+
+	// SkippedFiles is a JSON array of the paths of the files of the bundle
+	// that were not deployed because of the include and exclude globs of the
+	// project.
+	SkippedFiles []byte `sql:"default:'[]'"`
+
+	// SkipJsEnv makes the deployer leave out the JS environment file of the
+	// project from this deployment, even if the project has one.
+	SkipJsEnv bool
+
+	// ProjectSettings is a JSON snapshot of the settings of the project that
+	// were in effect when the deployment was last deployed.
+	ProjectSettings []byte
+
+	// ImageBytesSaved is the number of bytes that optimizing the images of the
+	// deployment saved.
+	ImageBytesSaved int64
+
+	// UncompressedBytes and CompressedBytes are the total sizes of the files
+	// of the deployment that have a gzipped variant, and of their variants.
+	UncompressedBytes int64
+	CompressedBytes   int64
+
 	DeployedAt *time.Time
 	PurgedAt   *time.Time
 
+	// DeployStartedAt is when a deployer first picked up the deployment.
+	DeployStartedAt *time.Time
+
 	ErrorMessage *string
+	// ErrorCode identifies why the deployment failed, for clients to act on.
+	ErrorCode *string
+
+	// Optional annotations describing what was deployed.
+	Label     *string
+	Branch    *string
+	CommitSHA *string `sql:"column:commit_sha"`
+
+	// The client the deployment was created from, which tells deploys from
+	// CI apart from those from the dashboard or CLI.
+	CreatedByUserAgent *string
+	CreatedByIP        *string `sql:"column:created_by_ip"`
+}
+
+// ManifestEntry describes a file in the webroot of a deployment.
+type ManifestEntry struct {
+	Size int64 `json:"size"`
+	// ETag is the MD5 checksum of the file as uploaded, which is what S3
+	// reports as the ETag of objects uploaded in a single part.
+	ETag      string   `json:"etag"`
+	Encodings []string `json:"encodings,omitempty"`
+	// OriginalSize is the size of the file before it was optimized, if it was.
+	OriginalSize int64 `json:"original_size,omitempty"`
+	// CompressedSize is the size of the gzipped variant of the file, if it
+	// has one.
+	CompressedSize int64 `json:"compressed_size,omitempty"`
+	// SHA256 is the SHA-256 checksum of the file as uploaded, which later
+	// deployments compare their files against to copy unchanged ones instead
+	// of uploading them again.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Manifest maps the paths of the files in the webroot of a deployment to
+// their entries.
+type Manifest map[string]*ManifestEntry
+
+// BytesSaved returns the total number of bytes saved by optimizing the files
+// in the manifest.
+func (m Manifest) BytesSaved() int64 {
+	var saved int64
+	for _, entry := range m {
+		if entry.OriginalSize > 0 {
+			saved += entry.OriginalSize - entry.Size
+		}
+	}
+	return saved
+}
+
+// CompressionTotals returns the total sizes of the files in the manifest that
+// have a gzipped variant, and of their variants.
+func (m Manifest) CompressionTotals() (original, compressed int64) {
+	for _, entry := range m {
+		if entry.CompressedSize > 0 {
+			original += entry.Size
+			compressed += entry.CompressedSize
+		}
+	}
+	return original, compressed
+}
+
+// Egress estimates how many bytes serving the files of a deployment transfers.
+type Egress struct {
+	// TransferableBytes is the total size of the files, which is what serving
+	// each of them once transfers.
+	TransferableBytes int64 `json:"transferable_bytes"`
+	// GzippedTransferableBytes is the same for clients that accept gzip, which
+	// are served the gzipped variants of the files that have them.
+	GzippedTransferableBytes int64 `json:"gzipped_transferable_bytes"`
+}
+
+// Egress returns how many bytes serving each of the files in the manifest
+// once transfers, or nil if the manifest is empty.
+func (m Manifest) Egress() *Egress {
+	if len(m) == 0 {
+		return nil
+	}
+
+	e := &Egress{}
+	for _, entry := range m {
+		e.TransferableBytes += entry.Size
+		if entry.CompressedSize > 0 {
+			e.GzippedTransferableBytes += entry.CompressedSize
+		} else {
+			e.GzippedTransferableBytes += entry.Size
+		}
+	}
+	return e
+}
+
+// ChangedPaths returns the sorted paths of the files that were added, removed
+// or changed in the manifest since prev.
+func (m Manifest) ChangedPaths(prev Manifest) []string {
+	var paths []string
+	for path, entry := range m {
+		prevEntry, ok := prev[path]
+		if !ok || entry.changedFrom(prevEntry) {
+			paths = append(paths, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := m[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// PathHeaders are headers that edges add to the responses for the paths that
+// match Path. A Path that ends in "*" matches every path that starts with the
+// rest of it.
+type PathHeaders struct {
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+}
+
+// ChangeSummary counts the files that were added, removed and changed in a
+// deployment since the previous one.
+type ChangeSummary struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+// CompressionSummary reports how much gzipping the files of a deployment
+// shrunk them. Ratio is the compressed size over the original size, so lower
+// is better.
+type CompressionSummary struct {
+	OriginalBytes   int64   `json:"original_bytes"`
+	CompressedBytes int64   `json:"compressed_bytes"`
+	Ratio           float64 `json:"ratio"`
+}
+
+// CompressionSummary returns how much gzipping the files of the deployment
+// shrunk them, or nil if none of its files were gzipped.
+func (d *Deployment) CompressionSummary() *CompressionSummary {
+	if d.UncompressedBytes <= 0 {
+		return nil
+	}
+
+	return &CompressionSummary{
+		OriginalBytes:   d.UncompressedBytes,
+		CompressedBytes: d.CompressedBytes,
+		Ratio:           float64(d.CompressedBytes) / float64(d.UncompressedBytes),
+	}
+}
+
+// Summary returns the numbers of files that were added, removed and changed in
+// the manifest since prev.
+func (m Manifest) Summary(prev Manifest) *ChangeSummary {
+	sum := &ChangeSummary{}
+	for path, entry := range m {
+		prevEntry, ok := prev[path]
+		if !ok {
+			sum.Added++
+		} else if entry.changedFrom(prevEntry) {
+			sum.Changed++
+		}
+	}
+	for path := range prev {
+		if _, ok := m[path]; !ok {
+			sum.Removed++
+		}
+	}
+	return sum
+}
+
+// Checksum returns the hex-encoded SHA-256 of the lines of the manifest in the
+// format of md5sum, "<etag>  <path>", sorted by path. It is the same for
+// manifests of identical files, and can be computed from a local copy of them.
+func (m Manifest) Checksum() string {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s  %s\n", m[path].ETag, path)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// changedFrom returns whether the file has different content or encodings
+// than prev.
+func (e *ManifestEntry) changedFrom(prev *ManifestEntry) bool {
+	return prev.ETag != e.ETag || strings.Join(prev.Encodings, ",") != strings.Join(e.Encodings, ",")
 }
 
 // JSON specifies which fields of a deployment will be marshaled to JSON.
@@ -58,24 +369,320 @@ type JSON struct {
 	Active       bool       `json:"active,omitempty"`
 	DeployedAt   *time.Time `json:"deployed_at,omitempty"`
 	ErrorMessage *string    `json:"error_message,omitempty"`
+	ErrorCode    *string    `json:"error_code,omitempty"`
+	PreviewURL   string     `json:"preview_url,omitempty"`
+	Label        *string    `json:"label,omitempty"`
+	Branch       *string    `json:"branch,omitempty"`
+	CommitSHA    *string    `json:"commit_sha,omitempty"`
+	Warnings     []string   `json:"warnings,omitempty"`
+	SkippedFiles []string   `json:"skipped_files,omitempty"`
+
+	InvalidatedDomains  []string `json:"invalidated_domains,omitempty"`
+	InvalidationSkipped bool     `json:"invalidation_skipped,omitempty"`
+	InvalidationStatus  *string  `json:"invalidation_status,omitempty"`
+
+	DeployStartedAt  *time.Time `json:"deploy_started_at,omitempty"`
+	QueueWaitSeconds *float64   `json:"queue_wait_seconds,omitempty"`
+
+	Changes     *ChangeSummary      `json:"changes,omitempty"`
+	Compression *CompressionSummary `json:"compression,omitempty"`
+	Egress      *Egress             `json:"egress,omitempty"`
+
+	// Only shown to the owner of the project.
+	CreatedByUserAgent *string `json:"created_by_user_agent,omitempty"`
+	CreatedByIP        *string `json:"created_by_ip,omitempty"`
 }
 
 // AsJSON returns a struct that can be converted to JSON
 func (d *Deployment) AsJSON() *JSON {
+	warnings, _ := d.WarningMessages()
+	skippedFiles, _ := d.SkippedFilePaths()
+	invalidatedDomains, _ := d.InvalidatedDomainNames()
+
+	var queueWaitSeconds *float64
+	if wait := d.QueueWait(); wait != nil {
+		seconds := wait.Seconds()
+		queueWaitSeconds = &seconds
+	}
+
 	return &JSON{
 		ID:           d.ID,
-		State:        d.State,
+		State:        d.PublicState(),
 		Version:      d.Version,
 		DeployedAt:   d.DeployedAt,
 		ErrorMessage: d.ErrorMessage,
+		ErrorCode:    d.ErrorCode,
+		Label:        d.Label,
+		Branch:       d.Branch,
+		CommitSHA:    d.CommitSHA,
+		Warnings:     warnings,
+		SkippedFiles: skippedFiles,
+
+		InvalidatedDomains:  invalidatedDomains,
+		InvalidationSkipped: d.InvalidationSkipped,
+		InvalidationStatus:  d.InvalidationStatus,
+
+		DeployStartedAt:  d.DeployStartedAt,
+		QueueWaitSeconds: queueWaitSeconds,
+
+		Compression: d.CompressionSummary(),
+	}
+}
+
+// QueueWait returns how long the deployment waited to be picked up by a
+// deployer after it was created, or nil if it has not been picked up yet.
+func (d *Deployment) QueueWait() *time.Duration {
+	if d.DeployStartedAt == nil {
+		return nil
+	}
+	wait := d.DeployStartedAt.Sub(d.CreatedAt)
+	return &wait
+}
+
+// PublicState returns the name of the state of the deployment as exposed in
+// the API.
+func (d *Deployment) PublicState() string {
+	if name, ok := publicStates[d.State]; ok {
+		return name
+	}
+	return d.State
+}
+
+// ParsePublicState returns the state with the given public name, which may
+// also be a legacy name. It returns ErrInvalidState if there is no such state.
+func ParsePublicState(name string) (string, error) {
+	for state, publicName := range publicStates {
+		if publicName == name {
+			return state, nil
+		}
+	}
+
+	if state, ok := legacyPublicStates[name]; ok {
+		return state, nil
+	}
+
+	return "", ErrInvalidState
+}
+
+// PreviewDomainName returns the domain name that the deployment can be
+// previewed at, e.g. "a1b2-123.pubstorm.site".
+func (d *Deployment) PreviewDomainName() string {
+	return d.PrefixID() + "." + shared.PublicBaseDomain
+}
+
+// PreviewURL returns the URL that the deployment can be previewed at.
+func (d *Deployment) PreviewURL() string {
+	return "https://" + d.PreviewDomainName()
+}
+
+// VanityPath returns the path that the deployment can also be previewed at on
+// the public base domain, e.g. "/foo-bar-express/a1b2-123".
+func (d *Deployment) VanityPath(projectName string) string {
+	return "/" + projectName + "/" + d.PrefixID()
+}
+
+// VanityURL returns the URL of the vanity path of the deployment, which is
+// easier to share than its preview URL.
+func (d *Deployment) VanityURL(projectName string) string {
+	return "https://" + shared.PublicBaseDomain + d.VanityPath(projectName)
+}
+
+// FailedMetaDomainNames returns the domains whose meta.json could not be
+// updated when the deployment was published.
+func (d *Deployment) FailedMetaDomainNames() ([]string, error) {
+	if len(d.FailedMetaDomains) == 0 {
+		return nil, nil
+	}
+
+	var domainNames []string
+	if err := json.Unmarshal(d.FailedMetaDomains, &domainNames); err != nil {
+		return nil, err
 	}
+	return domainNames, nil
 }
 
-// PrefixID returns prefix and ID in <prefix>-<id> format
+// InvalidatedDomainNames returns the domains that edges were told to
+// invalidate their caches of when the deployment was last deployed.
+func (d *Deployment) InvalidatedDomainNames() ([]string, error) {
+	if len(d.InvalidatedDomains) == 0 {
+		return nil, nil
+	}
+
+	var domainNames []string
+	if err := json.Unmarshal(d.InvalidatedDomains, &domainNames); err != nil {
+		return nil, err
+	}
+	return domainNames, nil
+}
+
+// WarningMessages returns the problems found with the deployment that did not
+// fail it.
+func (d *Deployment) WarningMessages() ([]string, error) {
+	if len(d.Warnings) == 0 {
+		return nil, nil
+	}
+
+	var warnings []string
+	if err := json.Unmarshal(d.Warnings, &warnings); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// SkippedFilePaths returns the paths of the files of the bundle that were not
+// deployed.
+func (d *Deployment) SkippedFilePaths() ([]string, error) {
+	if len(d.SkippedFiles) == 0 {
+		return nil, nil
+	}
+
+	var paths []string
+	if err := json.Unmarshal(d.SkippedFiles, &paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// PathHeaderRules returns the header rules parsed from the _headers file of
+// the deployment.
+func (d *Deployment) PathHeaderRules() ([]PathHeaders, error) {
+	if len(d.PathHeaders) == 0 {
+		return nil, nil
+	}
+
+	var rules []PathHeaders
+	if err := json.Unmarshal(d.PathHeaders, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// TombstonePaths returns the paths of the pages that were removed from the
+// project as of the deployment.
+func (d *Deployment) TombstonePaths() ([]string, error) {
+	if len(d.Tombstones) == 0 {
+		return nil, nil
+	}
+
+	var paths []string
+	if err := json.Unmarshal(d.Tombstones, &paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// ParsedManifest returns the manifest of the files that have been uploaded to
+// the webroot of the deployment.
+func (d *Deployment) ParsedManifest() (Manifest, error) {
+	m := Manifest{}
+	if len(d.Manifest) == 0 {
+		return m, nil
+	}
+
+	if err := json.Unmarshal(d.Manifest, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParsedJsEnvVars returns the JS environment variables of the deployment. It
+// fails if they are not a JSON object of strings.
+func (d *Deployment) ParsedJsEnvVars() (map[string]string, error) {
+	envvars := map[string]string{}
+	if len(d.JsEnvVars) == 0 {
+		return envvars, nil
+	}
+
+	if err := json.Unmarshal(d.JsEnvVars, &envvars); err != nil {
+		return nil, err
+	}
+	return envvars, nil
+}
+
+// UpdateManifest saves the manifest of the files that have been uploaded to
+// the webroot of the deployment.
+func (d *Deployment) UpdateManifest(db *gorm.DB, m Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).Update("manifest", b).Error; err != nil {
+		return err
+	}
+
+	d.Manifest = b
+	return nil
+}
+
+// ContentHashPrefix returns the prefix of a deployment of a bundle with the
+// given checksum to a project, with the given JS environment variables, if it
+// ships them. Deployments of identical bundles to the same project with the
+// same environment get the same prefix.
+func ContentHashPrefix(projectID uint, checksum string, jsEnvVars map[string]string) string {
+	key := fmt.Sprintf("%d:%s", projectID, checksum)
+	if len(jsEnvVars) > 0 {
+		// Marshaling sorts the keys, so equal environments hash the same.
+		env, _ := json.Marshal(jsEnvVars)
+		key += ":" + string(env)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:contentHashPrefixLength])
+}
+
+// PrefixID returns prefix and ID in <prefix>-<id> format, or just the prefix
+// if the deployment has an opaque prefix.
 func (d *Deployment) PrefixID() string {
+	if d.OpaquePrefix {
+		return d.Prefix
+	}
 	return fmt.Sprintf("%s-%d", d.Prefix, d.ID)
 }
 
+// SharesFiles returns whether another deployment of the same project that has
+// neither been deleted nor failed has the same files in S3 as d, which is the
+// case for deployments of identical bundles with content hash prefixes.
+func (d *Deployment) SharesFiles(db *gorm.DB) (bool, error) {
+	if !d.OpaquePrefix {
+		return false, nil
+	}
+
+	var count int
+	if err := db.Model(Deployment{}).Where(
+		"project_id = ? AND prefix = ? AND opaque_prefix = ? AND id <> ? AND state NOT IN (?)",
+		d.ProjectID, d.Prefix, true, d.ID,
+		[]string{StateDeployFailed, StateBuildFailed},
+	).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// WebrootOwner returns the deployment that uploaded the webroot that d shares
+// with the other deployments of an identical bundle, or nil if none of them
+// has been deployed.
+func (d *Deployment) WebrootOwner(db *gorm.DB) (*Deployment, error) {
+	if !d.OpaquePrefix {
+		return nil, nil
+	}
+
+	owner := &Deployment{}
+	if err := db.Where(
+		"project_id = ? AND prefix = ? AND opaque_prefix = ? AND id <> ? AND state IN (?)",
+		d.ProjectID, d.Prefix, true, d.ID,
+		[]string{StateDeployed, StateUnpublished},
+	).Order("id ASC").First(owner).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return owner, nil
+}
+
 // PreviousCompletedDeployment returns previous deployment of current deployment
 func (d *Deployment) PreviousCompletedDeployment(db *gorm.DB) (*Deployment, error) {
 	var prevDepl Deployment
@@ -96,22 +703,260 @@ func (d *Deployment) PreviousCompletedDeployment(db *gorm.DB) (*Deployment, erro
 	return &prevDepl, nil
 }
 
-// CompletedDeployments returns completed deployments up to the given limit.
+// ChangeSummary returns the numbers of files that were added, removed and
+// changed in the deployment since the previous completed deployment, or nil if
+// the deployment is not deployed or either has no manifest to compare. The
+// first deployment of a project has all of its files added.
+func (d *Deployment) ChangeSummary(db *gorm.DB) (*ChangeSummary, error) {
+	if d.State != StateDeployed || d.DeployedAt == nil {
+		return nil, nil
+	}
+
+	m, err := d.ParsedManifest()
+	if err != nil || len(m) == 0 {
+		return nil, err
+	}
+
+	prevDepl, err := d.PreviousCompletedDeployment(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if prevDepl == nil {
+		return m.Summary(Manifest{}), nil
+	}
+
+	prev, err := prevDepl.ParsedManifest()
+	if err != nil || len(prev) == 0 {
+		return nil, err
+	}
+
+	return m.Summary(prev), nil
+}
+
+// TimeRange is a range of time that is open on the ends that are not set.
+type TimeRange struct {
+	After  *time.Time
+	Before *time.Time
+}
+
+// CompletedDeployments returns completed deployments that were created in the
+// given range, up to the given limit.
 // A limit of 0 implies no limit (i.e. all deployments will be returned).
 // Apologies for the magic number, but who'd ask for 0 deployments anyway.
-func CompletedDeployments(db *gorm.DB, projectID, limit uint) ([]*Deployment, error) {
+func CompletedDeployments(db *gorm.DB, projectID, limit uint, created TimeRange) ([]*Deployment, error) {
 	qLimit := int(limit)
 	if qLimit == 0 {
 		qLimit = -1 // Gorm uses a limit of -1 to "disable" LIMIT clauses.
 	}
 
 	var depls []*Deployment
-	if err := db.Limit(qLimit).Where("project_id = ? AND state = ?", projectID, StateDeployed).Order("deployed_at DESC").Find(&depls).Error; err != nil {
+	if err := completed(db, projectID, created).Limit(qLimit).Order("deployed_at DESC").Find(&depls).Error; err != nil {
 		return nil, err
 	}
 	return depls, nil
 }
 
+// CompletedDeploymentsPage returns the given page of up to perPage of the
+// deployments that CompletedDeployments returns, along with how many of them
+// there are in total. Pages start at 1.
+func CompletedDeploymentsPage(db *gorm.DB, projectID, limit uint, created TimeRange, page, perPage int) ([]*Deployment, int, error) {
+	var total int
+	if err := completed(db, projectID, created).Model(Deployment{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if limit > 0 && total > int(limit) {
+		total = int(limit)
+	}
+
+	// The last page stops at the limit rather than at the end of the page.
+	offset := (page - 1) * perPage
+	n := perPage
+	if offset+n > total {
+		n = total - offset
+	}
+	if n <= 0 {
+		return []*Deployment{}, total, nil
+	}
+
+	var depls []*Deployment
+	if err := completed(db, projectID, created).Order("deployed_at DESC, id DESC").Offset(offset).Limit(n).Find(&depls).Error; err != nil {
+		return nil, 0, err
+	}
+	return depls, total, nil
+}
+
+// completed returns a query for the completed deployments of a project that
+// were created in the given range.
+func completed(db *gorm.DB, projectID uint, created TimeRange) *gorm.DB {
+	q := db.Where("project_id = ? AND state = ?", projectID, StateDeployed)
+	if created.After != nil {
+		q = q.Where("created_at > ?", *created.After)
+	}
+	if created.Before != nil {
+		q = q.Where("created_at < ?", *created.Before)
+	}
+	return q
+}
+
+// Recent returns up to limit of the latest deployments of a project in any
+// state, newest first.
+func Recent(db *gorm.DB, projectID uint, limit int) ([]*Deployment, error) {
+	var depls []*Deployment
+	if err := db.Where("project_id = ?", projectID).Order("id DESC").Limit(limit).Find(&depls).Error; err != nil {
+		return nil, err
+	}
+	return depls, nil
+}
+
+// WithOwner is a deployment along with the name of its project and the email
+// of the user who created it.
+type WithOwner struct {
+	Deployment
+	ProjectName string
+	UserEmail   string
+}
+
+// TableName returns the table name for the database.
+func (d *WithOwner) TableName() string {
+	return "deployments"
+}
+
+// FeedFilter limits the deployments returned by Feed.
+type FeedFilter struct {
+	// State is the state of the deployments, or empty for any state.
+	State   string
+	Created TimeRange
+	// BeforeID is the ID that the deployments are older than, or 0 to start
+	// from the newest deployment.
+	BeforeID uint
+}
+
+// Feed returns up to limit deployments of all projects that match the filter,
+// newest first. Pages are fetched by passing the ID of the last deployment of
+// the previous page as the BeforeID of the filter, which uses the primary key
+// rather than an offset that gets slower the further it goes.
+func Feed(db *gorm.DB, filter FeedFilter, limit int) ([]*WithOwner, error) {
+	q := db.Select("deployments.*, projects.name AS project_name, users.email AS user_email").
+		Joins(`JOIN projects ON projects.id = deployments.project_id
+			JOIN users ON users.id = deployments.user_id`)
+
+	if filter.State != "" {
+		q = q.Where("deployments.state = ?", filter.State)
+	}
+	if filter.Created.After != nil {
+		q = q.Where("deployments.created_at > ?", *filter.Created.After)
+	}
+	if filter.Created.Before != nil {
+		q = q.Where("deployments.created_at < ?", *filter.Created.Before)
+	}
+	if filter.BeforeID != 0 {
+		q = q.Where("deployments.id < ?", filter.BeforeID)
+	}
+
+	var depls []*WithOwner
+	if err := q.Order("deployments.id DESC").Limit(limit).Find(&depls).Error; err != nil {
+		return nil, err
+	}
+	return depls, nil
+}
+
+// InProgress returns the oldest deployment of a project that is waiting to be
+// processed by the builder or deployer, or nil if there is none. Since jobs
+// are processed in order, it is the one most likely to be holding the project
+// lock.
+func InProgress(db *gorm.DB, projectID uint) (*Deployment, error) {
+	var depl Deployment
+	if err := db.Where("project_id = ? AND state IN (?)", projectID, []string{
+		StatePendingBuild,
+		StatePendingDeploy,
+		StatePendingRollback,
+	}).Order("id ASC").First(&depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &depl, nil
+}
+
+// HasEarlierPending returns whether a deployment of the same project that was
+// created before d is still waiting to be built or deployed. Deploys of a
+// project go out in the order they were created, so d has to wait for it.
+// Deployments created before createdAfter are assumed to be stuck, e.g.
+// because their jobs were lost, and are not waited for.
+func (d *Deployment) HasEarlierPending(db *gorm.DB, createdAfter time.Time) (bool, error) {
+	var count int
+	if err := db.Model(Deployment{}).Where(
+		"project_id = ? AND state IN (?) AND (created_at < ? OR (created_at = ? AND id < ?)) AND created_at >= ?",
+		d.ProjectID,
+		[]string{StatePendingBuild, StateBuilt, StatePendingDeploy},
+		d.CreatedAt, d.CreatedAt, d.ID, createdAfter,
+	).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// MarkInvalidationPending records that the cache invalidation of the
+// deployment is about to be published, so that edges' acknowledgements of it
+// can be recorded.
+func (d *Deployment) MarkInvalidationPending(db *gorm.DB) error {
+	status := InvalidationPending
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).Update("invalidation_status", status).Error; err != nil {
+		return err
+	}
+
+	d.InvalidationStatus = &status
+	return nil
+}
+
+// RecordInvalidationAck records an edge's acknowledgement of the cache
+// invalidation of the deployment with the given ID. A failure on any edge
+// marks the invalidation failed for good, since stale files may still be
+// served from it, so a later success does not complete a failed invalidation.
+// Acknowledgements of deployments whose invalidation is not pending or
+// complete are ignored.
+func RecordInvalidationAck(db *gorm.DB, id uint, succeeded bool) error {
+	q := db.Model(Deployment{}).Where("id = ?", id)
+	if succeeded {
+		q = q.Where("invalidation_status = ?", InvalidationPending).Update("invalidation_status", InvalidationComplete)
+	} else {
+		q = q.Where("invalidation_status IN (?)", []string{InvalidationPending, InvalidationComplete}).Update("invalidation_status", InvalidationFailed)
+	}
+
+	return q.Error
+}
+
+// SoftDeletedBefore returns the deployed deployments that were soft-deleted
+// before t and whose files have not been purged yet.
+func SoftDeletedBefore(db *gorm.DB, t time.Time) ([]*Deployment, error) {
+	depls := []*Deployment{}
+	if err := db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", t).
+		Where("purged_at IS NULL").
+		Where("state = ?", StateDeployed).
+		Order("deleted_at ASC").
+		Find(&depls).Error; err != nil {
+		return nil, err
+	}
+
+	return depls, nil
+}
+
+// MarkPurged records that the files of the deployment were removed.
+func (d *Deployment) MarkPurged(db *gorm.DB) error {
+	now := time.Now()
+	if err := db.Unscoped().Model(Deployment{}).Where("id = ?", d.ID).UpdateColumn("purged_at", now).Error; err != nil {
+		return err
+	}
+
+	d.PurgedAt = &now
+	return nil
+}
+
 // DeleteExceptLastN deletes all but the last n deployed deployments.
 func DeleteExceptLastN(db *gorm.DB, projectID, n uint) error {
 	q := db.Exec(`
@@ -133,6 +978,21 @@ func DeleteExceptLastN(db *gorm.DB, projectID, n uint) error {
 	return q.Error
 }
 
+// DeleteOlderThan deletes the deployed deployments of a project that were
+// deployed more than the given number of days ago, except the active one.
+func DeleteOlderThan(db *gorm.DB, projectID, days, activeDeploymentID uint) error {
+	q := db.Exec(`
+		UPDATE deployments
+		SET deleted_at = now()
+		WHERE
+			project_id = ?
+			AND state = ?
+			AND deleted_at IS NULL
+			AND id <> ?
+			AND deployed_at < now() - ? * interval '1 day';`, projectID, StateDeployed, activeDeploymentID, days)
+	return q.Error
+}
+
 // UpdateState updates deployment state
 func (d *Deployment) UpdateState(db *gorm.DB, state string) error {
 	if !isValidState(state) {
@@ -144,8 +1004,9 @@ func (d *Deployment) UpdateState(db *gorm.DB, state string) error {
 		q = q.Update("deployed_at", gorm.Expr("now()"))
 	}
 
-	if state == StateBuildFailed || state == StateDeployFailed {
+	if state == StateBuildFailed || state == StateDeployFailed || state == StateUnpublished {
 		q = q.Update("error_message", d.ErrorMessage)
+		q = q.Update("error_code", d.ErrorCode)
 	}
 	if state == StateUploaded && d.RawBundleID != nil {
 		q = q.Update("raw_bundle_id", d.RawBundleID)
@@ -155,7 +1016,43 @@ func (d *Deployment) UpdateState(db *gorm.DB, state string) error {
 		return err
 	}
 
-	return nil
+	message := "Deployment is " + d.PublicState()
+	if d.ErrorMessage != nil && (state == StateBuildFailed || state == StateDeployFailed) {
+		message += ": " + *d.ErrorMessage
+	}
+
+	return d.AppendLog(db, message)
+}
+
+// LogEntry is a line of the log of a deployment, which records what happened
+// to it as it was built and deployed.
+type LogEntry struct {
+	ID           uint `gorm:"primary_key"`
+	DeploymentID uint
+	Message      string
+	CreatedAt    time.Time
+}
+
+// TableName returns the table name for the database.
+func (l *LogEntry) TableName() string {
+	return "deployment_logs"
+}
+
+// AppendLog adds a line to the log of the deployment.
+func (d *Deployment) AppendLog(db *gorm.DB, message string) error {
+	return db.Create(&LogEntry{
+		DeploymentID: d.ID,
+		Message:      message,
+	}).Error
+}
+
+// Logs returns the log of the deployment, oldest line first.
+func (d *Deployment) Logs(db *gorm.DB) ([]*LogEntry, error) {
+	var entries []*LogEntry
+	if err := db.Where("deployment_id = ?", d.ID).Order("id ASC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
 }
 
 func (d *Deployment) String() string {
@@ -172,5 +1069,6 @@ func isValidState(state string) bool {
 		StatePendingBuild == state ||
 		StateBuilt == state ||
 		StateBuildFailed == state ||
-		StatePendingUpdateConfig == state
+		StatePendingUpdateConfig == state ||
+		StateUnpublished == state
 }
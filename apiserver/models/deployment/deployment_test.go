@@ -1,6 +1,9 @@
 package deployment_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"testing"
 	"time"
 
@@ -9,6 +12,8 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/testhelper"
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 
@@ -75,7 +80,6 @@ var _ = Describe("Deployment", func() {
 			proj *project.Project
 
 			d1 *deployment.Deployment
-			d2 *deployment.Deployment
 			d3 *deployment.Deployment
 		)
 
@@ -83,13 +87,13 @@ var _ = Describe("Deployment", func() {
 			u := factories.User(db)
 			proj = factories.Project(db, u)
 			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
-			d2 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+			factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
 			d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
 		})
 
 		It("returns completed deployments sorted by deployed_at", func() {
 			limit := uint(0) // No limit.
-			depls, err := deployment.CompletedDeployments(db, proj.ID, limit)
+			depls, err := deployment.CompletedDeployments(db, proj.ID, limit, deployment.TimeRange{})
 			Expect(err).To(BeNil())
 
 			Expect(depls).To(HaveLen(2))
@@ -100,22 +104,217 @@ var _ = Describe("Deployment", func() {
 		Context("with a non-zero limit", func() {
 			It("limits deployments", func() {
 				limit := uint(1) // No limit.
-				depls, err := deployment.CompletedDeployments(db, proj.ID, limit)
+				depls, err := deployment.CompletedDeployments(db, proj.ID, limit, deployment.TimeRange{})
 				Expect(err).To(BeNil())
 
 				Expect(depls).To(HaveLen(1))
 				Expect(depls[0].ID).To(Equal(d3.ID))
 			})
 		})
+
+		Context("with a creation time range", func() {
+			It("returns only deployments created in the range", func() {
+				after, before := time.Now().Add(-3*time.Hour), time.Now().Add(-time.Hour)
+				Expect(db.Model(d1).Update("created_at", time.Now().Add(-2*time.Hour)).Error).To(BeNil())
+				Expect(db.Model(d3).Update("created_at", time.Now().Add(-4*time.Hour)).Error).To(BeNil())
+
+				depls, err := deployment.CompletedDeployments(db, proj.ID, 0, deployment.TimeRange{After: &after, Before: &before})
+				Expect(err).To(BeNil())
+
+				Expect(depls).To(HaveLen(1))
+				Expect(depls[0].ID).To(Equal(d1.ID))
+			})
+		})
 	})
 
-	Describe("DeleteExceptLastN()", func() {
+	Describe("CompletedDeploymentsPage()", func() {
 		var (
 			proj *project.Project
 
 			d1 *deployment.Deployment
 			d2 *deployment.Deployment
 			d3 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj = factories.Project(db, u)
+			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+			d2 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		})
+
+		It("returns a page of completed deployments sorted by deployed_at and the total", func() {
+			depls, total, err := deployment.CompletedDeploymentsPage(db, proj.ID, 0, deployment.TimeRange{}, 1, 2)
+			Expect(err).To(BeNil())
+			Expect(total).To(Equal(3))
+			Expect(depls).To(HaveLen(2))
+			Expect(depls[0].ID).To(Equal(d3.ID))
+			Expect(depls[1].ID).To(Equal(d2.ID))
+
+			depls, total, err = deployment.CompletedDeploymentsPage(db, proj.ID, 0, deployment.TimeRange{}, 2, 2)
+			Expect(err).To(BeNil())
+			Expect(total).To(Equal(3))
+			Expect(depls).To(HaveLen(1))
+			Expect(depls[0].ID).To(Equal(d1.ID))
+		})
+
+		Context("with a non-zero limit", func() {
+			It("does not return deployments past the limit", func() {
+				depls, total, err := deployment.CompletedDeploymentsPage(db, proj.ID, 2, deployment.TimeRange{}, 1, 3)
+				Expect(err).To(BeNil())
+				Expect(total).To(Equal(2))
+				Expect(depls).To(HaveLen(2))
+
+				depls, total, err = deployment.CompletedDeploymentsPage(db, proj.ID, 2, deployment.TimeRange{}, 2, 2)
+				Expect(err).To(BeNil())
+				Expect(total).To(Equal(2))
+				Expect(depls).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("InProgress()", func() {
+		var (
+			u    *user.User
+			proj *project.Project
+		)
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			proj = factories.Project(db, u)
+			factories.Deployment(db, proj, u, deployment.StateDeployed)
+		})
+
+		It("returns nil if there is no deployment in progress", func() {
+			depl, err := deployment.InProgress(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(depl).To(BeNil())
+		})
+
+		It("returns the oldest deployment in progress", func() {
+			d1 := factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+			factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			depl, err := deployment.InProgress(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(depl).NotTo(BeNil())
+			Expect(depl.ID).To(Equal(d1.ID))
+		})
+	})
+
+	Describe("HasEarlierPending()", func() {
+		var (
+			u    *user.User
+			proj *project.Project
+		)
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			proj = factories.Project(db, u)
+			factories.Deployment(db, proj, u, deployment.StateDeployed)
+		})
+
+		It("returns false if no earlier deployment is pending", func() {
+			depl := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			pending, err := depl.HasEarlierPending(db, time.Now().Add(-time.Hour))
+			Expect(err).To(BeNil())
+			Expect(pending).To(BeFalse())
+		})
+
+		It("returns true if an earlier deployment is pending", func() {
+			d1 := factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+			d2 := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			pending, err := d2.HasEarlierPending(db, time.Now().Add(-time.Hour))
+			Expect(err).To(BeNil())
+			Expect(pending).To(BeTrue())
+
+			pending, err = d1.HasEarlierPending(db, time.Now().Add(-time.Hour))
+			Expect(err).To(BeNil())
+			Expect(pending).To(BeFalse())
+		})
+
+		It("ignores pending deployments created before the cutoff", func() {
+			stuck := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+			Expect(db.Model(stuck).Update("created_at", time.Now().Add(-2*time.Hour)).Error).To(BeNil())
+			depl := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			pending, err := depl.HasEarlierPending(db, time.Now().Add(-time.Hour))
+			Expect(err).To(BeNil())
+			Expect(pending).To(BeFalse())
+		})
+
+		It("ignores pending deployments of other projects", func() {
+			otherProj := factories.Project(db, u)
+			factories.Deployment(db, otherProj, u, deployment.StatePendingDeploy)
+			depl := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			pending, err := depl.HasEarlierPending(db, time.Now().Add(-time.Hour))
+			Expect(err).To(BeNil())
+			Expect(pending).To(BeFalse())
+		})
+	})
+
+	Describe("SoftDeletedBefore()", func() {
+		var (
+			u    *user.User
+			proj *project.Project
+		)
+
+		softDelete := func(state string, deletedAt time.Time) *deployment.Deployment {
+			depl := factories.Deployment(db, proj, u, state)
+			Expect(db.Model(depl).Update("deleted_at", deletedAt).Error).To(BeNil())
+			return depl
+		}
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			proj = factories.Project(db, u)
+		})
+
+		It("returns the deployed deployments soft-deleted before the given time that are not purged", func() {
+			d1 := softDelete(deployment.StateDeployed, time.Now().Add(-48*time.Hour))
+			d2 := softDelete(deployment.StateDeployed, time.Now().Add(-25*time.Hour))
+			softDelete(deployment.StateDeployed, time.Now().Add(-time.Hour))
+			softDelete(deployment.StateDeployFailed, time.Now().Add(-48*time.Hour))
+			factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+			purged := softDelete(deployment.StateDeployed, time.Now().Add(-48*time.Hour))
+			Expect(purged.MarkPurged(db)).To(BeNil())
+
+			depls, err := deployment.SoftDeletedBefore(db, time.Now().Add(-24*time.Hour))
+			Expect(err).To(BeNil())
+			Expect(depls).To(HaveLen(2))
+			Expect(depls[0].ID).To(Equal(d1.ID))
+			Expect(depls[1].ID).To(Equal(d2.ID))
+		})
+	})
+
+	Describe("MarkPurged()", func() {
+		It("sets purged_at of a soft-deleted deployment", func() {
+			u := factories.User(db)
+			proj := factories.Project(db, u)
+			depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(db.Delete(depl).Error).To(BeNil())
+
+			Expect(depl.MarkPurged(db)).To(BeNil())
+			Expect(depl.PurgedAt).NotTo(BeNil())
+
+			reloaded := &deployment.Deployment{}
+			Expect(db.Unscoped().First(reloaded, depl.ID).Error).To(BeNil())
+			Expect(reloaded.PurgedAt).NotTo(BeNil())
+		})
+	})
+
+	Describe("DeleteExceptLastN()", func() {
+		var (
+			proj *project.Project
+
+			d1 *deployment.Deployment
+			d3 *deployment.Deployment
 			d4 *deployment.Deployment
 		)
 
@@ -123,7 +322,7 @@ var _ = Describe("Deployment", func() {
 			u := factories.User(db)
 			proj = factories.Project(db, u)
 			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
-			d2 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+			factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
 			d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
 			d4 = factories.Deployment(db, proj, u, deployment.StateDeployed)
 		})
@@ -180,6 +379,464 @@ var _ = Describe("Deployment", func() {
 		})
 	})
 
+	Describe("DeleteOlderThan()", func() {
+		var (
+			proj *project.Project
+
+			d1 *deployment.Deployment
+			d2 *deployment.Deployment
+			d3 *deployment.Deployment
+			d4 *deployment.Deployment
+		)
+
+		daysAgo := func(days int) *time.Time {
+			t := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+			return &t
+		}
+
+		remainingIDs := func() []uint {
+			var depls []*deployment.Deployment
+			Expect(db.Where("project_id = ?", proj.ID).Find(&depls).Error).To(BeNil())
+
+			var ids []uint
+			for _, depl := range depls {
+				ids = append(ids, depl.ID)
+			}
+			return ids
+		}
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj = factories.Project(db, u)
+			d1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(120),
+			})
+			d2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(100),
+			})
+			d3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(10),
+			})
+			d4 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+		})
+
+		It("deletes deployed deployments deployed more than the given number of days ago", func() {
+			err := deployment.DeleteOlderThan(db, proj.ID, 90, d3.ID)
+			Expect(err).To(BeNil())
+
+			Expect(remainingIDs()).To(ConsistOf(d3.ID, d4.ID))
+		})
+
+		It("does not delete the active deployment even if it is older", func() {
+			err := deployment.DeleteOlderThan(db, proj.ID, 90, d1.ID)
+			Expect(err).To(BeNil())
+
+			Expect(remainingIDs()).To(ConsistOf(d1.ID, d3.ID, d4.ID))
+		})
+
+		It("does not delete any records if no deployment is old enough", func() {
+			err := deployment.DeleteOlderThan(db, proj.ID, 365, d3.ID)
+			Expect(err).To(BeNil())
+
+			Expect(remainingIDs()).To(ConsistOf(d1.ID, d2.ID, d3.ID, d4.ID))
+		})
+	})
+
+	Describe("ContentHashPrefix()", func() {
+		checksum := "db39e098913eee20e5371139022e4431ffe7b01baa524bd87e08f2763de3ea55"
+
+		It("returns the same prefix for the same bundle and project", func() {
+			Expect(deployment.ContentHashPrefix(1, checksum, nil)).To(Equal(deployment.ContentHashPrefix(1, checksum, nil)))
+			Expect(deployment.ContentHashPrefix(1, checksum, nil)).To(MatchRegexp(`\A[0-9a-f]{16}\z`))
+		})
+
+		It("returns different prefixes for different bundles", func() {
+			Expect(deployment.ContentHashPrefix(1, checksum, nil)).NotTo(Equal(deployment.ContentHashPrefix(1, "5c3a8d5a2c4e41bd", nil)))
+		})
+
+		It("returns different prefixes for the same bundle in different projects", func() {
+			Expect(deployment.ContentHashPrefix(1, checksum, nil)).NotTo(Equal(deployment.ContentHashPrefix(2, checksum, nil)))
+		})
+
+		It("returns different prefixes for the same bundle with different JS environments", func() {
+			env := map[string]string{"API_URL": "https://api.example.com", "DEBUG": "0"}
+			sameEnv := map[string]string{"DEBUG": "0", "API_URL": "https://api.example.com"}
+			otherEnv := map[string]string{"API_URL": "https://staging.example.com", "DEBUG": "0"}
+
+			Expect(deployment.ContentHashPrefix(1, checksum, env)).To(Equal(deployment.ContentHashPrefix(1, checksum, sameEnv)))
+			Expect(deployment.ContentHashPrefix(1, checksum, env)).NotTo(Equal(deployment.ContentHashPrefix(1, checksum, otherEnv)))
+			Expect(deployment.ContentHashPrefix(1, checksum, env)).NotTo(Equal(deployment.ContentHashPrefix(1, checksum, nil)))
+		})
+	})
+
+	Describe("SharesFiles()", func() {
+		var (
+			u    *user.User
+			proj *project.Project
+			d    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			proj = factories.Project(db, u)
+			d = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateDeployed,
+			})
+		})
+
+		It("returns true if another deployment has the same content hash prefix", func() {
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateDeployed,
+			})
+
+			shares, err := d.SharesFiles(db)
+			Expect(err).To(BeNil())
+			Expect(shares).To(BeTrue())
+		})
+
+		It("returns false if the other deployment has been deleted or failed", func() {
+			deleted := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateDeployed,
+			})
+			Expect(db.Delete(deleted).Error).To(BeNil())
+
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateDeployFailed,
+			})
+
+			shares, err := d.SharesFiles(db)
+			Expect(err).To(BeNil())
+			Expect(shares).To(BeFalse())
+		})
+
+		It("returns false if the prefixes of the deployments include their IDs", func() {
+			Expect(db.Model(d).Update("opaque_prefix", false).Error).To(BeNil())
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "9f86d081884c7d65",
+				State:  deployment.StateDeployed,
+			})
+
+			shares, err := d.SharesFiles(db)
+			Expect(err).To(BeNil())
+			Expect(shares).To(BeFalse())
+		})
+	})
+
+	Describe("WebrootOwner()", func() {
+		var (
+			u    *user.User
+			proj *project.Project
+			d    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			proj = factories.Project(db, u)
+			d = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StatePendingDeploy,
+			})
+		})
+
+		It("returns the first deployed deployment with the same content hash prefix", func() {
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateDeployFailed,
+			})
+			owner := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateUnpublished,
+			})
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateDeployed,
+			})
+
+			found, err := d.WebrootOwner(db)
+			Expect(err).To(BeNil())
+			Expect(found).NotTo(BeNil())
+			Expect(found.ID).To(Equal(owner.ID))
+		})
+
+		It("returns nil if no deployment with the same prefix has been deployed", func() {
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StatePendingDeploy,
+			})
+			deleted := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+				State:        deployment.StateDeployed,
+			})
+			Expect(db.Delete(deleted).Error).To(BeNil())
+
+			found, err := d.WebrootOwner(db)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+
+		It("returns nil if the prefix of the deployment includes its ID", func() {
+			Expect(db.Model(d).Update("opaque_prefix", false).Error).To(BeNil())
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "9f86d081884c7d65",
+				State:  deployment.StateDeployed,
+			})
+
+			found, err := d.WebrootOwner(db)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("Manifest.ChangedPaths()", func() {
+		It("returns the paths of added, removed and changed files", func() {
+			prev := deployment.Manifest{
+				"index.html":  {Size: 10, ETag: "a"},
+				"css/app.css": {Size: 20, ETag: "b", Encodings: []string{"gzip"}},
+				"js/app.js":   {Size: 30, ETag: "c"},
+				"old.html":    {Size: 40, ETag: "d"},
+			}
+			m := deployment.Manifest{
+				"index.html":  {Size: 10, ETag: "a"},
+				"css/app.css": {Size: 20, ETag: "b"},
+				"js/app.js":   {Size: 31, ETag: "e"},
+				"new.html":    {Size: 50, ETag: "f"},
+			}
+
+			Expect(m.ChangedPaths(prev)).To(Equal([]string{"css/app.css", "js/app.js", "new.html", "old.html"}))
+		})
+
+		It("returns nothing if no files changed", func() {
+			m := deployment.Manifest{"index.html": {Size: 10, ETag: "a"}}
+			Expect(m.ChangedPaths(deployment.Manifest{"index.html": {Size: 10, ETag: "a"}})).To(BeEmpty())
+		})
+	})
+
+	Describe("Manifest.Summary()", func() {
+		It("counts the added, removed and changed files", func() {
+			prev := deployment.Manifest{
+				"index.html":  {Size: 10, ETag: "a"},
+				"css/app.css": {Size: 20, ETag: "b", Encodings: []string{"gzip"}},
+				"js/app.js":   {Size: 30, ETag: "c"},
+				"old.html":    {Size: 40, ETag: "d"},
+			}
+			m := deployment.Manifest{
+				"index.html":  {Size: 10, ETag: "a"},
+				"css/app.css": {Size: 20, ETag: "b"},
+				"js/app.js":   {Size: 31, ETag: "e"},
+				"new.html":    {Size: 50, ETag: "f"},
+				"about.html":  {Size: 60, ETag: "g"},
+			}
+
+			Expect(m.Summary(prev)).To(Equal(&deployment.ChangeSummary{
+				Added:   2,
+				Removed: 1,
+				Changed: 2,
+			}))
+		})
+	})
+
+	Describe("Manifest.Egress()", func() {
+		It("sums the sizes of the files, and of their gzipped variants if they have them", func() {
+			m := deployment.Manifest{
+				"index.html":  {Size: 100, ETag: "a", CompressedSize: 40},
+				"css/app.css": {Size: 200, ETag: "b", CompressedSize: 50},
+				"logo.png":    {Size: 300, ETag: "c"},
+			}
+
+			Expect(m.Egress()).To(Equal(&deployment.Egress{
+				TransferableBytes:        600,
+				GzippedTransferableBytes: 390,
+			}))
+		})
+
+		It("returns nil for an empty manifest", func() {
+			Expect(deployment.Manifest{}.Egress()).To(BeNil())
+		})
+	})
+
+	Describe("Manifest.Checksum()", func() {
+		It("returns the SHA-256 of the md5sum lines of the files sorted by path", func() {
+			m := deployment.Manifest{
+				"index.html":  {Size: 10, ETag: "a"},
+				"css/app.css": {Size: 20, ETag: "b", Encodings: []string{"gzip"}},
+			}
+
+			sum := sha256.Sum256([]byte("b  css/app.css\na  index.html\n"))
+			Expect(m.Checksum()).To(Equal(hex.EncodeToString(sum[:])))
+		})
+
+		It("only depends on the paths and content of the files", func() {
+			m := deployment.Manifest{"index.html": {Size: 10, ETag: "a"}}
+			Expect(m.Checksum()).To(Equal(deployment.Manifest{"index.html": {Size: 10, ETag: "a", Encodings: []string{"gzip"}}}.Checksum()))
+			Expect(m.Checksum()).NotTo(Equal(deployment.Manifest{"index.html": {Size: 10, ETag: "b"}}.Checksum()))
+			Expect(m.Checksum()).NotTo(Equal(deployment.Manifest{"about.html": {Size: 10, ETag: "a"}}.Checksum()))
+		})
+	})
+
+	Describe("ChangeSummary()", func() {
+		var (
+			d1 *deployment.Deployment
+			d2 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj := factories.Project(db, u)
+			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(d1.UpdateManifest(db, deployment.Manifest{
+				"index.html": {Size: 10, ETag: "a"},
+				"app.js":     {Size: 20, ETag: "b"},
+				"old.css":    {Size: 30, ETag: "c"},
+			})).To(BeNil())
+
+			d2 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(d2.UpdateManifest(db, deployment.Manifest{
+				"index.html": {Size: 10, ETag: "a"},
+				"app.js":     {Size: 21, ETag: "d"},
+				"new.css":    {Size: 30, ETag: "e"},
+				"logo.png":   {Size: 40, ETag: "f"},
+			})).To(BeNil())
+		})
+
+		It("summarizes the changes since the previous completed deployment", func() {
+			sum, err := d2.ChangeSummary(db)
+			Expect(err).To(BeNil())
+			Expect(sum).To(Equal(&deployment.ChangeSummary{
+				Added:   2,
+				Removed: 1,
+				Changed: 1,
+			}))
+		})
+
+		It("counts every file as added for the first deployment", func() {
+			sum, err := d1.ChangeSummary(db)
+			Expect(err).To(BeNil())
+			Expect(sum).To(Equal(&deployment.ChangeSummary{Added: 3}))
+		})
+
+		It("returns nil if the previous deployment has no manifest", func() {
+			Expect(d1.UpdateManifest(db, deployment.Manifest{})).To(BeNil())
+
+			sum, err := d2.ChangeSummary(db)
+			Expect(err).To(BeNil())
+			Expect(sum).To(BeNil())
+		})
+
+		It("returns nil if the deployment has not been deployed", func() {
+			d2.State = deployment.StatePendingDeploy
+
+			sum, err := d2.ChangeSummary(db)
+			Expect(err).To(BeNil())
+			Expect(sum).To(BeNil())
+		})
+	})
+
+	Describe("PublicState()", func() {
+		// The public names are spelled out rather than referring to the state
+		// constants, so that renaming a constant cannot change the API.
+		publicNames := map[string]string{
+			deployment.StatePendingUpload:       "pending_upload",
+			deployment.StateUploaded:            "uploaded",
+			deployment.StatePendingDeploy:       "pending_deploy",
+			deployment.StateDeployed:            "deployed",
+			deployment.StateDeployFailed:        "deploy_failed",
+			deployment.StatePendingRollback:     "pending_rollback",
+			deployment.StatePendingBuild:        "pending_build",
+			deployment.StateBuilt:               "built",
+			deployment.StateBuildFailed:         "build_failed",
+			deployment.StatePendingUpdateConfig: "pending_update_config",
+			deployment.StateUnpublished:         "unpublished",
+		}
+
+		It("returns the public name of the state", func() {
+			for state, publicName := range publicNames {
+				d := &deployment.Deployment{State: state}
+				Expect(d.PublicState()).To(Equal(publicName))
+				Expect(d.AsJSON().State).To(Equal(publicName))
+			}
+		})
+	})
+
+	Describe("ParsePublicState()", func() {
+		It("returns the state with the given public name", func() {
+			state, err := deployment.ParsePublicState("pending_deploy")
+			Expect(err).To(BeNil())
+			Expect(state).To(Equal(deployment.StatePendingDeploy))
+
+			state, err = deployment.ParsePublicState("unpublished")
+			Expect(err).To(BeNil())
+			Expect(state).To(Equal(deployment.StateUnpublished))
+		})
+
+		It("returns ErrInvalidState if there is no state with the given name", func() {
+			_, err := deployment.ParsePublicState("queued")
+			Expect(err).To(Equal(deployment.ErrInvalidState))
+		})
+	})
+
+	Describe("PreviewURL()", func() {
+		var origPublicBaseDomain string
+
+		BeforeEach(func() {
+			origPublicBaseDomain = shared.PublicBaseDomain
+			shared.PublicBaseDomain = "preview.example.com"
+		})
+
+		AfterEach(func() {
+			shared.PublicBaseDomain = origPublicBaseDomain
+		})
+
+		It("returns the URL under the configured base domain", func() {
+			d := factories.DeploymentWithAttrs(db, nil, nil, deployment.Deployment{Prefix: "a1b2"})
+			Expect(d.PreviewURL()).To(Equal(fmt.Sprintf("https://a1b2-%d.preview.example.com", d.ID)))
+		})
+
+		It("leaves the ID out of the URL if the deployment has an opaque prefix", func() {
+			d := factories.DeploymentWithAttrs(db, nil, nil, deployment.Deployment{Prefix: "9f86d081884c7d65", OpaquePrefix: true})
+			Expect(d.PreviewURL()).To(Equal("https://9f86d081884c7d65.preview.example.com"))
+		})
+	})
+
+	Describe("VanityURL()", func() {
+		var origPublicBaseDomain string
+
+		BeforeEach(func() {
+			origPublicBaseDomain = shared.PublicBaseDomain
+			shared.PublicBaseDomain = "preview.example.com"
+		})
+
+		AfterEach(func() {
+			shared.PublicBaseDomain = origPublicBaseDomain
+		})
+
+		It("returns the URL of the vanity path of the deployment", func() {
+			d := factories.DeploymentWithAttrs(db, nil, nil, deployment.Deployment{Prefix: "a1b2"})
+			Expect(d.VanityURL("foo-bar-express")).To(Equal(fmt.Sprintf("https://preview.example.com/foo-bar-express/a1b2-%d", d.ID)))
+		})
+
+		It("leaves the ID out of the URL if the deployment has an opaque prefix", func() {
+			d := factories.DeploymentWithAttrs(db, nil, nil, deployment.Deployment{Prefix: "9f86d081884c7d65", OpaquePrefix: true})
+			Expect(d.VanityURL("foo-bar-express")).To(Equal("https://preview.example.com/foo-bar-express/9f86d081884c7d65"))
+		})
+	})
+
 	Describe("UpdateState()", func() {
 		var d *deployment.Deployment
 
@@ -220,6 +877,20 @@ var _ = Describe("Deployment", func() {
 			Expect(*d.ErrorMessage).To(Equal(msg))
 		})
 
+		It("appends the new state to the log of the deployment", func() {
+			Expect(d.UpdateState(db, deployment.StateDeployed)).To(BeNil())
+
+			msg := "You did something wrong"
+			d.ErrorMessage = &msg
+			Expect(d.UpdateState(db, deployment.StateDeployFailed)).To(BeNil())
+
+			entries, err := d.Logs(db)
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Message).To(Equal("Deployment is deployed"))
+			Expect(entries[1].Message).To(Equal("Deployment is deploy_failed: You did something wrong"))
+		})
+
 		It("updates state and checksum if updates to uploaded state and checksum is not empty", func() {
 			proj := factories.Project(db, nil)
 			rb := rawbundle.RawBundle{
@@ -72,6 +72,20 @@ func (d *Domain) Validate() map[string]string {
 	return errors
 }
 
+// FindByName returns the domain with the given name, whichever project it
+// belongs to, or nil if there is none.
+func FindByName(db *gorm.DB, name string) (*Domain, error) {
+	dom := &Domain{}
+	if err := db.Where("name = ?", name).First(dom).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return dom, nil
+}
+
 // Returns a struct that can be converted to JSON
 func (d *Domain) AsJSON() interface{} {
 	return JSON{
@@ -1,11 +1,18 @@
 package oauthtoken
 
 import (
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
 )
 
+// Scopes that a token can be limited to.
+const (
+	// ScopeDeploy only allows creating deployments of the project of the token.
+	ScopeDeploy = "deploy"
+)
+
 type OauthToken struct {
 	ID            uint `gorm:"primary_key"`
 	UserID        uint
@@ -13,6 +20,83 @@ type OauthToken struct {
 	Token         string `sql:"default:encode(gen_random_bytes(64), 'hex')"`
 	CreatedAt     time.Time
 	DeletedAt     *time.Time
+
+	// Scoped tokens can only be used for the actions their scope allows on
+	// their project. Tokens without a scope have full access.
+	ProjectID *uint
+	Scope     *string
+
+	// LastUsedAt is when a deploy token was last used to create a deployment.
+	LastUsedAt *time.Time
+}
+
+// maskedTokenSuffixLength is the number of characters of a token that are
+// left visible when it is masked.
+const maskedTokenSuffixLength = 4
+
+// DeployTokenJSON is the JSON representation of a deploy token, with the
+// token itself masked.
+type DeployTokenJSON struct {
+	ID         uint       `json:"id"`
+	Token      string     `json:"token"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// AsDeployTokenJSON returns a struct that can be converted to JSON.
+func (t *OauthToken) AsDeployTokenJSON() *DeployTokenJSON {
+	var scope string
+	if t.Scope != nil {
+		scope = *t.Scope
+	}
+
+	return &DeployTokenJSON{
+		ID:         t.ID,
+		Token:      t.MaskedToken(),
+		Scope:      scope,
+		CreatedAt:  t.CreatedAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
+
+// MaskedToken returns the token with all but its last few characters masked,
+// so that it can be told apart from other tokens without being usable.
+func (t *OauthToken) MaskedToken() string {
+	if len(t.Token) <= maskedTokenSuffixLength {
+		return strings.Repeat("*", len(t.Token))
+	}
+	n := len(t.Token) - maskedTokenSuffixLength
+	return strings.Repeat("*", n) + t.Token[n:]
+}
+
+// DeployTokensByProject returns the deploy tokens of a project that have not
+// been revoked, oldest first.
+func DeployTokensByProject(db *gorm.DB, projectID uint) ([]*OauthToken, error) {
+	var tokens []*OauthToken
+	if err := db.Where("project_id = ? AND scope = ?", projectID, ScopeDeploy).Order("id ASC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Touch records that the token was just used.
+func (t *OauthToken) Touch(db *gorm.DB) error {
+	return db.Model(OauthToken{}).Where("id = ?", t.ID).Update("last_used_at", gorm.Expr("now()")).Scan(t).Error
+}
+
+// IsScoped returns whether the token is limited to a scope.
+func (t *OauthToken) IsScoped() bool {
+	return t.Scope != nil
+}
+
+// Allows returns whether the token can be used for an action that requires
+// the given scope on a project.
+func (t *OauthToken) Allows(scope string, projectID uint) bool {
+	if !t.IsScoped() {
+		return true
+	}
+	return *t.Scope == scope && t.ProjectID != nil && *t.ProjectID == projectID
 }
 
 // Finds oauth token by token
@@ -72,4 +72,35 @@ var _ = Describe("OauthToken", func() {
 			})
 		})
 	})
+
+	Describe("Allows()", func() {
+		It("allows everything if the token is not scoped", func() {
+			t = &oauthtoken.OauthToken{}
+			Expect(t.Allows(oauthtoken.ScopeDeploy, 1)).To(BeTrue())
+		})
+
+		It("only allows the scope of the token on its project", func() {
+			var (
+				projectID uint = 1
+				scope          = oauthtoken.ScopeDeploy
+			)
+			t = &oauthtoken.OauthToken{ProjectID: &projectID, Scope: &scope}
+
+			Expect(t.Allows(oauthtoken.ScopeDeploy, 1)).To(BeTrue())
+			Expect(t.Allows(oauthtoken.ScopeDeploy, 2)).To(BeFalse())
+			Expect(t.Allows("manage", 1)).To(BeFalse())
+		})
+	})
+
+	Describe("MaskedToken()", func() {
+		It("masks all but the last 4 characters of the token", func() {
+			t = &oauthtoken.OauthToken{Token: "f7c3bc1d808e"}
+			Expect(t.MaskedToken()).To(Equal("********808e"))
+		})
+
+		It("masks short tokens entirely", func() {
+			t = &oauthtoken.OauthToken{Token: "f7c3"}
+			Expect(t.MaskedToken()).To(Equal("****"))
+		})
+	})
 })
@@ -1,12 +1,17 @@
 package project
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"path"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -14,12 +19,23 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/subscription"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/glob"
 	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/messages"
 
 	"github.com/jinzhu/gorm"
 )
 
+// StagingAlias is the name of the alias that always points at the latest
+// deployment of a project, regardless of whether it has been published.
+const StagingAlias = "staging"
+
+// DefaultJsEnvFilename is the path in the webroot that the JS environment
+// variables of deployments are written to by default.
+const DefaultJsEnvFilename = "jsenv.js"
+
 var (
 	MaxProjectPerUser = 10
 
@@ -35,14 +51,70 @@ var (
 type Project struct {
 	gorm.Model
 
-	Name                 string
-	UserID               uint
-	DefaultDomainEnabled bool `sql:"default:true"`
-	ForceHTTPS           bool `sql:"column:force_https"`
-	SkipBuild            bool `sql:"default:true"`
-	Watermark            bool `sql:"default:true"`
-	MaxDeploysKept       uint
-	LastDigestSentAt     *time.Time
+	Name                  string
+	UserID                uint
+	DefaultDomainEnabled  bool `sql:"default:true"`
+	ForceHTTPS            bool `sql:"column:force_https"`
+	SkipBuild             bool `sql:"default:true"`
+	Watermark             bool `sql:"default:true"`
+	AutoPublish           bool `sql:"default:true"`
+	OptimizeImages        bool
+	StrictContentTypes    bool
+	MinifyHTML            bool `sql:"column:minify_html"`
+	ContentHashPrefixes   bool
+	CheckInternalLinks    bool
+	CSPNonces             bool `sql:"column:csp_nonces"`
+	Fingerprint           bool
+	ValidateJS            bool `sql:"column:validate_js"`
+	ValidateJSON          bool `sql:"column:validate_json"`
+	AccessibilityCheck    bool
+	CheckMixedContent     bool
+	StrictMixedContent    bool
+	AssetManifest         bool
+	PreloadCriticalAssets bool
+	GenerateFavicons      bool
+	AnalyticsOptOut       bool
+	MaxDeploysKept        uint
+	PublishGateURL        *string
+	PreDeployHookURL      *string
+	SlackWebhookURL       *string
+	SecurityContact       *string
+	LastDigestSentAt      *time.Time
+
+	// DeploysPaused blocks new deployments of the project from going out,
+	// e.g. during an incident.
+	DeploysPaused bool
+
+	// TombstoneRemovedPaths makes edges respond with 410 Gone to the pages
+	// that were removed from the project, instead of 404 Not Found.
+	TombstoneRemovedPaths bool
+
+	// MaxDomains, if set, is the maximum number of custom domains of the
+	// project, instead of shared.MaxDomainsPerProject.
+	MaxDomains *int
+
+	// DeployRetentionDays is the number of days deployments are kept for after
+	// they were deployed. 0 means deployments are kept regardless of age.
+	DeployRetentionDays uint
+
+	// RequiredFiles is a JSON array of the paths of the files that every
+	// deployment of the project must contain.
+	RequiredFiles []byte `sql:"default:'[]'"`
+
+	// IncludeGlobs and ExcludeGlobs are JSON arrays of glob patterns that
+	// decide which files of a bundle are deployed: those that match an include
+	// glob, if there are any, and no exclude glob.
+	IncludeGlobs []byte `sql:"default:'[]'"`
+	ExcludeGlobs []byte `sql:"default:'[]'"`
+
+	// DefaultDeployOptions is a JSON object of the DeployOptions that deploys
+	// of the project get unless they set them.
+	DefaultDeployOptions []byte `sql:"default:'{}'"`
+
+	// JsEnvFilename is the path in the webroot that the JS environment
+	// variables of deployments are written to, unless JsEnvDisabled is set.
+	JsEnvFilename string `sql:"default:'jsenv.js'"`
+	JsEnvDisabled bool
 
 	ActiveDeploymentID *uint // pointer to be nullable. remember to dereference by using *ActiveDeploymentID to get actual value
 	BasicAuthUsername  *string
@@ -50,16 +122,56 @@ type Project struct {
 
 	EncryptedBasicAuthPassword *string
 
+	// CanaryDeploymentID is a deployment that edges serve CanaryPercent
+	// percent of the traffic to the domains of the project with, instead of
+	// the active deployment.
+	CanaryDeploymentID *uint
+	CanaryPercent      uint
+
+	// StatusToken, if set, is the unguessable token of the public status page
+	// of the project. The status page is disabled when it is nil.
+	StatusToken *string
+
 	LockedAt *time.Time
 }
 
 type JSON struct {
-	Name                 string     `json:"name"`
-	DefaultDomainEnabled bool       `json:"default_domain_enabled"`
-	ForceHTTPS           bool       `json:"force_https"`
-	SkipBuild            bool       `json:"skip_build"`
-	CreatedAt            time.Time  `json:"created_at"`
-	DeployedAt           *time.Time `json:"deployed_at,omitempty"`
+	Name                  string         `json:"name"`
+	DefaultDomainEnabled  bool           `json:"default_domain_enabled"`
+	ForceHTTPS            bool           `json:"force_https"`
+	SkipBuild             bool           `json:"skip_build"`
+	AutoPublish           bool           `json:"auto_publish"`
+	OptimizeImages        bool           `json:"optimize_images,omitempty"`
+	StrictContentTypes    bool           `json:"strict_content_types,omitempty"`
+	MinifyHTML            bool           `json:"minify_html,omitempty"`
+	ContentHashPrefixes   bool           `json:"content_hash_prefixes,omitempty"`
+	CheckInternalLinks    bool           `json:"check_internal_links,omitempty"`
+	CSPNonces             bool           `json:"csp_nonces,omitempty"`
+	Fingerprint           bool           `json:"fingerprint,omitempty"`
+	ValidateJS            bool           `json:"validate_js,omitempty"`
+	ValidateJSON          bool           `json:"validate_json,omitempty"`
+	AccessibilityCheck    bool           `json:"accessibility_check,omitempty"`
+	CheckMixedContent     bool           `json:"check_mixed_content,omitempty"`
+	StrictMixedContent    bool           `json:"strict_mixed_content,omitempty"`
+	AssetManifest         bool           `json:"asset_manifest,omitempty"`
+	PreloadCriticalAssets bool           `json:"preload_critical_assets,omitempty"`
+	GenerateFavicons      bool           `json:"generate_favicons,omitempty"`
+	AnalyticsOptOut       bool           `json:"analytics_opt_out,omitempty"`
+	TombstoneRemovedPaths bool           `json:"tombstone_removed_paths,omitempty"`
+	PublishGateURL        *string        `json:"publish_gate_url,omitempty"`
+	PreDeployHookURL      *string        `json:"pre_deploy_hook_url,omitempty"`
+	SlackWebhookURL       *string        `json:"slack_webhook_url,omitempty"`
+	SecurityContact       *string        `json:"security_contact,omitempty"`
+	RequiredFiles         []string       `json:"required_files,omitempty"`
+	IncludeGlobs          []string       `json:"include_globs,omitempty"`
+	ExcludeGlobs          []string       `json:"exclude_globs,omitempty"`
+	DefaultDeployOptions  *DeployOptions `json:"default_deploy_options,omitempty"`
+	JsEnvFilename         string         `json:"js_env_filename,omitempty"`
+	JsEnvDisabled         bool           `json:"js_env_disabled,omitempty"`
+	DeploysPaused         bool           `json:"deploys_paused,omitempty"`
+	StatusToken           *string        `json:"status_token,omitempty"`
+	CreatedAt             time.Time      `json:"created_at"`
+	DeployedAt            *time.Time     `json:"deployed_at,omitempty"`
 }
 
 // Validates Project, if there are invalid fields, it returns a map of
@@ -75,6 +187,11 @@ func (p *Project) Validate() map[string]string {
 		errors["name"] = "is too long (max. 63 characters)"
 	} else if !projectNameRe.MatchString(p.Name) {
 		errors["name"] = "is invalid"
+	} else if p.ID == 0 && strings.Contains(p.Name, "--") {
+		// "--" separates the name of a project from an alias in the domain of
+		// the alias, which must not be the default domain of another project.
+		// Names cannot be changed, so only new projects are checked.
+		errors["name"] = "is invalid"
 	}
 
 	if (p.BasicAuthUsername != nil && *p.BasicAuthUsername != "") || p.BasicAuthPassword != "" {
@@ -85,20 +202,495 @@ func (p *Project) Validate() map[string]string {
 		}
 	}
 
+	if p.PublishGateURL != nil && !isHookURL(*p.PublishGateURL) {
+		errors["publish_gate_url"] = "is invalid"
+	}
+
+	if p.PreDeployHookURL != nil && !isHookURL(*p.PreDeployHookURL) {
+		errors["pre_deploy_hook_url"] = "is invalid"
+	}
+
+	if p.SlackWebhookURL != nil && !isSlackWebhookURL(*p.SlackWebhookURL) {
+		errors["slack_webhook_url"] = "is invalid"
+	}
+
+	if p.SecurityContact != nil && !isSecurityContact(*p.SecurityContact) {
+		errors["security_contact"] = "is invalid"
+	}
+
+	if paths, err := p.RequiredFilePaths(); err != nil {
+		errors["required_files"] = "is invalid"
+	} else {
+		for _, f := range paths {
+			if !isWebrootPath(f) {
+				errors["required_files"] = "is invalid"
+				break
+			}
+		}
+	}
+
+	for key, globs := range map[string][]byte{"include_globs": p.IncludeGlobs, "exclude_globs": p.ExcludeGlobs} {
+		patterns, err := unmarshalStrings(globs)
+		if err != nil {
+			errors[key] = "is invalid"
+			continue
+		}
+		for _, pattern := range patterns {
+			if glob.Validate(pattern) != nil {
+				errors[key] = "is invalid"
+				break
+			}
+		}
+	}
+
+	if opts, err := p.DeployDefaults(); err != nil || !opts.valid() {
+		errors["default_deploy_options"] = "is invalid"
+	}
+
+	if p.JsEnvFilename != "" && !isWebrootPath(p.JsEnvFilename) {
+		errors["js_env_filename"] = "is invalid"
+	}
+
 	if len(errors) == 0 {
 		return nil
 	}
 	return errors
 }
 
+// isWebrootPath returns whether f is a clean path relative to the webroot of
+// a deployment that does not escape it.
+func isWebrootPath(f string) bool {
+	return f != "" && f != "." && !path.IsAbs(f) && path.Clean(f) == f &&
+		f != ".." && !strings.HasPrefix(f, "../")
+}
+
+// isHookURL returns whether s is an absolute http or https URL that the
+// deployer can call.
+func isHookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isSlackWebhookURL returns whether s is the URL of a Slack incoming webhook.
+func isSlackWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "https" && u.Host == "hooks.slack.com" && strings.HasPrefix(u.Path, "/services/")
+}
+
+// isSecurityContact returns whether s is a contact for a security.txt, i.e. a
+// mailto, tel or https URI.
+func isSecurityContact(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	switch u.Scheme {
+	case "mailto", "tel":
+		return u.Opaque != ""
+	case "https":
+		return u.Host != ""
+	}
+	return false
+}
+
+// JsEnvPath returns the path in the webroot that the JS environment variables
+// of deployments of the project are written to.
+func (p *Project) JsEnvPath() string {
+	if p.JsEnvFilename == "" {
+		return DefaultJsEnvFilename
+	}
+	return p.JsEnvFilename
+}
+
+// customJsEnvFilename returns the JS environment filename if it is not the
+// default, or an empty string if it is.
+func customJsEnvFilename(filename string) string {
+	if filename == DefaultJsEnvFilename {
+		return ""
+	}
+	return filename
+}
+
+// RequiredFilePaths returns the paths of the files that every deployment of
+// the project must contain.
+func (p *Project) RequiredFilePaths() ([]string, error) {
+	return unmarshalStrings(p.RequiredFiles)
+}
+
+// IncludeGlobPatterns returns the glob patterns of the files of a bundle that
+// are deployed. All files are if there are none.
+func (p *Project) IncludeGlobPatterns() ([]string, error) {
+	return unmarshalStrings(p.IncludeGlobs)
+}
+
+// ExcludeGlobPatterns returns the glob patterns of the files of a bundle that
+// are not deployed.
+func (p *Project) ExcludeGlobPatterns() ([]string, error) {
+	return unmarshalStrings(p.ExcludeGlobs)
+}
+
+// DeployOptions are the options of a deploy of a project.
+type DeployOptions struct {
+	Priority         string `json:"priority,omitempty"`
+	SkipInvalidation bool   `json:"skip_invalidation,omitempty"`
+	SkipJsEnv        bool   `json:"skip_js_env,omitempty"`
+	ForceFullUpload  bool   `json:"force_full_upload,omitempty"`
+}
+
+// valid returns whether the priority of the options is one that deploys can
+// be made with.
+func (o *DeployOptions) valid() bool {
+	switch o.Priority {
+	case "", messages.PriorityNormal, messages.PriorityHigh:
+		return true
+	}
+	return false
+}
+
+// orNil returns nil if no options are set, so that they are left out of the
+// JSON of a project.
+func (o *DeployOptions) orNil() *DeployOptions {
+	if o == nil || *o == (DeployOptions{}) {
+		return nil
+	}
+	return o
+}
+
+// DeployDefaults returns the options that deploys of the project get unless
+// they set them. Unknown options are an error, so that a misspelt option is
+// not silently ignored.
+func (p *Project) DeployDefaults() (*DeployOptions, error) {
+	opts := &DeployOptions{}
+	if len(p.DefaultDeployOptions) == 0 {
+		return opts, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(p.DefaultDeployOptions))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// unmarshalStrings returns the strings of a JSON array column.
+func unmarshalStrings(b []byte) ([]string, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Settings are the settings of a project that affect how its deployments are
+// built and served. A snapshot of them is stored with each deployment.
+type Settings struct {
+	ForceHTTPS                 bool    `json:"force_https"`
+	Watermark                  *bool   `json:"watermark,omitempty"`
+	BasicAuthUsername          *string `json:"basic_auth_username,omitempty"`
+	EncryptedBasicAuthPassword *string `json:"encrypted_basic_auth_password,omitempty"`
+}
+
+// Settings returns the current settings of the project.
+func (p *Project) Settings() *Settings {
+	watermark := p.Watermark
+	return &Settings{
+		ForceHTTPS:                 p.ForceHTTPS,
+		Watermark:                  &watermark,
+		BasicAuthUsername:          p.BasicAuthUsername,
+		EncryptedBasicAuthPassword: p.EncryptedBasicAuthPassword,
+	}
+}
+
+// ApplySettings sets the settings of the project, e.g. to restore them from a
+// snapshot. It does not save the project.
+func (p *Project) ApplySettings(s *Settings) {
+	p.ForceHTTPS = s.ForceHTTPS
+	if s.Watermark != nil {
+		p.Watermark = *s.Watermark
+	}
+	p.BasicAuthUsername = s.BasicAuthUsername
+	p.EncryptedBasicAuthPassword = s.EncryptedBasicAuthPassword
+}
+
+// SnapshottedSettings returns the settings of the project that were in effect
+// when the deployment was deployed, or nil if they were not snapshotted.
+func SnapshottedSettings(depl *deployment.Deployment) (*Settings, error) {
+	if len(depl.ProjectSettings) == 0 {
+		return nil, nil
+	}
+
+	s := &Settings{}
+	if err := json.Unmarshal(depl.ProjectSettings, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Config is the portable configuration of a project. It can be exported from
+// a project and imported as a new project, e.g. in another account or
+// environment. Deployments, collaborators and credentials are not part of it.
+type Config struct {
+	Name                  string         `json:"name"`
+	DefaultDomainEnabled  bool           `json:"default_domain_enabled"`
+	ForceHTTPS            bool           `json:"force_https"`
+	SkipBuild             bool           `json:"skip_build"`
+	Watermark             bool           `json:"watermark"`
+	AutoPublish           bool           `json:"auto_publish"`
+	OptimizeImages        bool           `json:"optimize_images"`
+	StrictContentTypes    bool           `json:"strict_content_types"`
+	MinifyHTML            bool           `json:"minify_html"`
+	ContentHashPrefixes   bool           `json:"content_hash_prefixes"`
+	CheckInternalLinks    bool           `json:"check_internal_links"`
+	CSPNonces             bool           `json:"csp_nonces"`
+	Fingerprint           bool           `json:"fingerprint"`
+	ValidateJS            bool           `json:"validate_js"`
+	ValidateJSON          bool           `json:"validate_json"`
+	AccessibilityCheck    bool           `json:"accessibility_check"`
+	CheckMixedContent     bool           `json:"check_mixed_content"`
+	StrictMixedContent    bool           `json:"strict_mixed_content"`
+	AssetManifest         bool           `json:"asset_manifest"`
+	PreloadCriticalAssets bool           `json:"preload_critical_assets"`
+	GenerateFavicons      bool           `json:"generate_favicons"`
+	AnalyticsOptOut       bool           `json:"analytics_opt_out"`
+	TombstoneRemovedPaths bool           `json:"tombstone_removed_paths"`
+	MaxDeploysKept        uint           `json:"max_deploys_kept"`
+	DeployRetentionDays   uint           `json:"deploy_retention_days"`
+	PublishGateURL        *string        `json:"publish_gate_url"`
+	PreDeployHookURL      *string        `json:"pre_deploy_hook_url"`
+	SlackWebhookURL       *string        `json:"slack_webhook_url"`
+	SecurityContact       *string        `json:"security_contact"`
+	RequiredFiles         []string       `json:"required_files"`
+	IncludeGlobs          []string       `json:"include_globs"`
+	ExcludeGlobs          []string       `json:"exclude_globs"`
+	DefaultDeployOptions  *DeployOptions `json:"default_deploy_options"`
+	JsEnvFilename         string         `json:"js_env_filename"`
+	JsEnvDisabled         bool           `json:"js_env_disabled"`
+	Domains               []string       `json:"domains"`
+}
+
+// NewConfig returns the configuration of a new project, so that settings
+// left out of an imported configuration keep their defaults.
+func NewConfig() *Config {
+	return &Config{
+		DefaultDomainEnabled: true,
+		SkipBuild:            true,
+		Watermark:            true,
+		AutoPublish:          true,
+		RequiredFiles:        []string{},
+		IncludeGlobs:         []string{},
+		ExcludeGlobs:         []string{},
+		DefaultDeployOptions: &DeployOptions{},
+		JsEnvFilename:        DefaultJsEnvFilename,
+		Domains:              []string{},
+	}
+}
+
+// Config returns the configuration of the project.
+func (p *Project) Config(db *gorm.DB) (*Config, error) {
+	requiredFiles, err := p.RequiredFilePaths()
+	if err != nil {
+		return nil, err
+	}
+	if requiredFiles == nil {
+		requiredFiles = []string{}
+	}
+
+	includeGlobs, err := p.IncludeGlobPatterns()
+	if err != nil {
+		return nil, err
+	}
+	if includeGlobs == nil {
+		includeGlobs = []string{}
+	}
+
+	excludeGlobs, err := p.ExcludeGlobPatterns()
+	if err != nil {
+		return nil, err
+	}
+	if excludeGlobs == nil {
+		excludeGlobs = []string{}
+	}
+
+	defaultDeployOptions, err := p.DeployDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	doms := []*domain.Domain{}
+	if err := db.Order("name ASC").Where("project_id = ?", p.ID).Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	domNames := make([]string, len(doms))
+	for i, dom := range doms {
+		domNames[i] = dom.Name
+	}
+
+	return &Config{
+		Name:                  p.Name,
+		DefaultDomainEnabled:  p.DefaultDomainEnabled,
+		ForceHTTPS:            p.ForceHTTPS,
+		SkipBuild:             p.SkipBuild,
+		Watermark:             p.Watermark,
+		AutoPublish:           p.AutoPublish,
+		OptimizeImages:        p.OptimizeImages,
+		StrictContentTypes:    p.StrictContentTypes,
+		MinifyHTML:            p.MinifyHTML,
+		ContentHashPrefixes:   p.ContentHashPrefixes,
+		CheckInternalLinks:    p.CheckInternalLinks,
+		CSPNonces:             p.CSPNonces,
+		Fingerprint:           p.Fingerprint,
+		ValidateJS:            p.ValidateJS,
+		ValidateJSON:          p.ValidateJSON,
+		AccessibilityCheck:    p.AccessibilityCheck,
+		CheckMixedContent:     p.CheckMixedContent,
+		StrictMixedContent:    p.StrictMixedContent,
+		AssetManifest:         p.AssetManifest,
+		PreloadCriticalAssets: p.PreloadCriticalAssets,
+		GenerateFavicons:      p.GenerateFavicons,
+		AnalyticsOptOut:       p.AnalyticsOptOut,
+		TombstoneRemovedPaths: p.TombstoneRemovedPaths,
+		MaxDeploysKept:        p.MaxDeploysKept,
+		DeployRetentionDays:   p.DeployRetentionDays,
+		PublishGateURL:        p.PublishGateURL,
+		PreDeployHookURL:      p.PreDeployHookURL,
+		SlackWebhookURL:       p.SlackWebhookURL,
+		SecurityContact:       p.SecurityContact,
+		RequiredFiles:         requiredFiles,
+		IncludeGlobs:          includeGlobs,
+		ExcludeGlobs:          excludeGlobs,
+		DefaultDeployOptions:  defaultDeployOptions,
+		JsEnvFilename:         p.JsEnvPath(),
+		JsEnvDisabled:         p.JsEnvDisabled,
+		Domains:               domNames,
+	}, nil
+}
+
+// ApplyConfig sets the settings of the project from a configuration. The
+// domains of the configuration are not added. It does not save the project.
+func (p *Project) ApplyConfig(c *Config) error {
+	requiredFiles, err := json.Marshal(c.RequiredFiles)
+	if err != nil {
+		return err
+	}
+	if c.RequiredFiles == nil {
+		requiredFiles = []byte("[]")
+	}
+
+	includeGlobs, err := json.Marshal(c.IncludeGlobs)
+	if err != nil {
+		return err
+	}
+	if c.IncludeGlobs == nil {
+		includeGlobs = []byte("[]")
+	}
+
+	excludeGlobs, err := json.Marshal(c.ExcludeGlobs)
+	if err != nil {
+		return err
+	}
+	if c.ExcludeGlobs == nil {
+		excludeGlobs = []byte("[]")
+	}
+
+	defaultDeployOptions := []byte("{}")
+	if c.DefaultDeployOptions != nil {
+		defaultDeployOptions, err = json.Marshal(c.DefaultDeployOptions)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.Name = c.Name
+	p.DefaultDomainEnabled = c.DefaultDomainEnabled
+	p.ForceHTTPS = c.ForceHTTPS
+	p.SkipBuild = c.SkipBuild
+	p.Watermark = c.Watermark
+	p.AutoPublish = c.AutoPublish
+	p.OptimizeImages = c.OptimizeImages
+	p.StrictContentTypes = c.StrictContentTypes
+	p.MinifyHTML = c.MinifyHTML
+	p.ContentHashPrefixes = c.ContentHashPrefixes
+	p.CheckInternalLinks = c.CheckInternalLinks
+	p.CSPNonces = c.CSPNonces
+	p.Fingerprint = c.Fingerprint
+	p.ValidateJS = c.ValidateJS
+	p.ValidateJSON = c.ValidateJSON
+	p.AccessibilityCheck = c.AccessibilityCheck
+	p.CheckMixedContent = c.CheckMixedContent
+	p.StrictMixedContent = c.StrictMixedContent
+	p.AssetManifest = c.AssetManifest
+	p.PreloadCriticalAssets = c.PreloadCriticalAssets
+	p.GenerateFavicons = c.GenerateFavicons
+	p.AnalyticsOptOut = c.AnalyticsOptOut
+	p.TombstoneRemovedPaths = c.TombstoneRemovedPaths
+	p.MaxDeploysKept = c.MaxDeploysKept
+	p.DeployRetentionDays = c.DeployRetentionDays
+	p.PublishGateURL = c.PublishGateURL
+	p.PreDeployHookURL = c.PreDeployHookURL
+	p.SlackWebhookURL = c.SlackWebhookURL
+	p.SecurityContact = c.SecurityContact
+	p.RequiredFiles = requiredFiles
+	p.IncludeGlobs = includeGlobs
+	p.ExcludeGlobs = excludeGlobs
+	p.DefaultDeployOptions = defaultDeployOptions
+	p.JsEnvFilename = c.JsEnvFilename
+	p.JsEnvDisabled = c.JsEnvDisabled
+	if p.JsEnvFilename == "" {
+		p.JsEnvFilename = DefaultJsEnvFilename
+	}
+	return nil
+}
+
 // Returns a struct that can be converted to JSON
 func (p *Project) AsJSON() interface{} {
+	requiredFiles, _ := p.RequiredFilePaths()
+	includeGlobs, _ := p.IncludeGlobPatterns()
+	excludeGlobs, _ := p.ExcludeGlobPatterns()
+	defaultDeployOptions, _ := p.DeployDefaults()
+
 	return JSON{
-		Name:                 p.Name,
-		DefaultDomainEnabled: p.DefaultDomainEnabled,
-		ForceHTTPS:           p.ForceHTTPS,
-		SkipBuild:            p.SkipBuild,
-		CreatedAt:            p.CreatedAt,
+		Name:                  p.Name,
+		DefaultDomainEnabled:  p.DefaultDomainEnabled,
+		ForceHTTPS:            p.ForceHTTPS,
+		SkipBuild:             p.SkipBuild,
+		AutoPublish:           p.AutoPublish,
+		OptimizeImages:        p.OptimizeImages,
+		StrictContentTypes:    p.StrictContentTypes,
+		MinifyHTML:            p.MinifyHTML,
+		ContentHashPrefixes:   p.ContentHashPrefixes,
+		CheckInternalLinks:    p.CheckInternalLinks,
+		CSPNonces:             p.CSPNonces,
+		Fingerprint:           p.Fingerprint,
+		ValidateJS:            p.ValidateJS,
+		ValidateJSON:          p.ValidateJSON,
+		AccessibilityCheck:    p.AccessibilityCheck,
+		CheckMixedContent:     p.CheckMixedContent,
+		StrictMixedContent:    p.StrictMixedContent,
+		AssetManifest:         p.AssetManifest,
+		PreloadCriticalAssets: p.PreloadCriticalAssets,
+		GenerateFavicons:      p.GenerateFavicons,
+		AnalyticsOptOut:       p.AnalyticsOptOut,
+		TombstoneRemovedPaths: p.TombstoneRemovedPaths,
+		PublishGateURL:        p.PublishGateURL,
+		PreDeployHookURL:      p.PreDeployHookURL,
+		SlackWebhookURL:       p.SlackWebhookURL,
+		SecurityContact:       p.SecurityContact,
+		RequiredFiles:         requiredFiles,
+		IncludeGlobs:          includeGlobs,
+		ExcludeGlobs:          excludeGlobs,
+		DefaultDeployOptions:  defaultDeployOptions.orNil(),
+		JsEnvFilename:         customJsEnvFilename(p.JsEnvFilename),
+		JsEnvDisabled:         p.JsEnvDisabled,
+		DeploysPaused:         p.DeploysPaused,
+		StatusToken:           p.StatusToken,
+		CreatedAt:             p.CreatedAt,
 	}
 }
 
@@ -128,6 +720,24 @@ func (p *Project) DefaultDomainName() string {
 	return p.Name + "." + shared.DefaultDomain
 }
 
+// AliasDomainName returns the domain name under the default domain that an
+// alias of this project is served from, e.g. "foo-bar--staging.rise.cloud".
+func (p *Project) AliasDomainName(alias string) string {
+	return p.Name + "--" + alias + "." + shared.DefaultDomain
+}
+
+// AliasDomainTaken returns whether the domain of an alias of the project is
+// the default domain of another project, in which case the alias must not be
+// served. Only projects created before names with "--" were rejected can be
+// named like that.
+func (p *Project) AliasDomainTaken(db *gorm.DB, alias string) (bool, error) {
+	var count int
+	if err := db.Model(Project{}).Where("name = ?", p.Name+"--"+alias).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // Find project by name
 func FindByName(db *gorm.DB, name string) (proj *Project, err error) {
 	proj = &Project{}
@@ -142,6 +752,61 @@ func FindByName(db *gorm.DB, name string) (proj *Project, err error) {
 	return proj, nil
 }
 
+// FindByStatusToken returns the project whose status page has the given token,
+// or nil if there is none.
+func FindByStatusToken(db *gorm.DB, token string) (*Project, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	proj := &Project{}
+	if err := db.Where("status_token = ?", token).First(proj).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return proj, nil
+}
+
+// RegenerateStatusToken enables the status page of the project with a new
+// random token, which replaces the previous one if there was one.
+func (p *Project) RegenerateStatusToken(db *gorm.DB) error {
+	r := struct{ StatusToken string }{}
+
+	if err := db.Raw(`
+		UPDATE projects
+		SET status_token = encode(gen_random_bytes(32), 'hex')
+		WHERE id = ?
+		RETURNING status_token;
+	`, p.ID).Scan(&r).Error; err != nil {
+		return err
+	}
+
+	p.StatusToken = &r.StatusToken
+	return nil
+}
+
+// DisableStatusPage removes the status token of the project, so that its
+// status page can no longer be viewed.
+func (p *Project) DisableStatusPage(db *gorm.DB) error {
+	if err := db.Model(Project{}).Where("id = ?", p.ID).Update("status_token", nil).Error; err != nil {
+		return err
+	}
+
+	p.StatusToken = nil
+	return nil
+}
+
+// DomainLimit returns the maximum number of custom domains of this project.
+func (p *Project) DomainLimit() int {
+	if p.MaxDomains != nil {
+		return *p.MaxDomains
+	}
+	return shared.MaxDomainsPerProject
+}
+
 // Returns whether more domains can be added to this project
 func (p *Project) CanAddDomain(db *gorm.DB) (bool, error) {
 	var domainCount int
@@ -149,7 +814,7 @@ func (p *Project) CanAddDomain(db *gorm.DB) (bool, error) {
 		return false, err
 	}
 
-	if domainCount < shared.MaxDomainsPerProject {
+	if domainCount < p.DomainLimit() {
 		return true, nil
 	}
 
@@ -258,6 +923,10 @@ func (p *Project) Destroy(db *gorm.DB) error {
 		return err
 	}
 
+	if err := db.Delete(subscription.Subscription{}, "project_id = ?", p.ID).Error; err != nil {
+		return err
+	}
+
 	if err := db.Delete(p).Error; err != nil {
 		return err
 	}
@@ -312,6 +981,26 @@ func (p *Project) DomainNamesWithProtocol(db *gorm.DB) ([]string, error) {
 	return domNames, nil
 }
 
+// DomainCertIDs returns the ID of the SSL cert of each custom domain of this
+// project that has one, keyed by domain name.
+func (p *Project) DomainCertIDs(db *gorm.DB) (map[string]uint, error) {
+	doms := []*struct {
+		Name   string
+		CertID uint
+	}{}
+
+	if err := db.Table("domains").Select("domains.name, certs.id AS cert_id").Joins("JOIN certs ON domains.id = certs.domain_id AND certs.deleted_at IS NULL").Where("project_id = ? AND domains.deleted_at IS NULL", p.ID).Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	certIDs := make(map[string]uint, len(doms))
+	for _, dom := range doms {
+		certIDs[dom.Name] = dom.CertID
+	}
+
+	return certIDs, nil
+}
+
 // Returns whether more projects can be added for this user
 func CanAddProject(db *gorm.DB, u *user.User) (bool, error) {
 	var count int
@@ -335,13 +1024,48 @@ func (pd *ProjectWithDeployedAt) TableName() string {
 
 // AsJSON return table name for database
 func (pd *ProjectWithDeployedAt) AsJSON() interface{} {
+	requiredFiles, _ := pd.RequiredFilePaths()
+	includeGlobs, _ := pd.IncludeGlobPatterns()
+	excludeGlobs, _ := pd.ExcludeGlobPatterns()
+	defaultDeployOptions, _ := pd.DeployDefaults()
+
 	return JSON{
-		Name:                 pd.Name,
-		DefaultDomainEnabled: pd.DefaultDomainEnabled,
-		ForceHTTPS:           pd.ForceHTTPS,
-		SkipBuild:            pd.SkipBuild,
-		CreatedAt:            pd.CreatedAt,
-		DeployedAt:           pd.DeployedAt,
+		Name:                  pd.Name,
+		DefaultDomainEnabled:  pd.DefaultDomainEnabled,
+		ForceHTTPS:            pd.ForceHTTPS,
+		SkipBuild:             pd.SkipBuild,
+		AutoPublish:           pd.AutoPublish,
+		OptimizeImages:        pd.OptimizeImages,
+		StrictContentTypes:    pd.StrictContentTypes,
+		MinifyHTML:            pd.MinifyHTML,
+		ContentHashPrefixes:   pd.ContentHashPrefixes,
+		CheckInternalLinks:    pd.CheckInternalLinks,
+		CSPNonces:             pd.CSPNonces,
+		Fingerprint:           pd.Fingerprint,
+		ValidateJS:            pd.ValidateJS,
+		ValidateJSON:          pd.ValidateJSON,
+		AccessibilityCheck:    pd.AccessibilityCheck,
+		CheckMixedContent:     pd.CheckMixedContent,
+		StrictMixedContent:    pd.StrictMixedContent,
+		AssetManifest:         pd.AssetManifest,
+		PreloadCriticalAssets: pd.PreloadCriticalAssets,
+		GenerateFavicons:      pd.GenerateFavicons,
+		AnalyticsOptOut:       pd.AnalyticsOptOut,
+		TombstoneRemovedPaths: pd.TombstoneRemovedPaths,
+		PublishGateURL:        pd.PublishGateURL,
+		PreDeployHookURL:      pd.PreDeployHookURL,
+		SlackWebhookURL:       pd.SlackWebhookURL,
+		SecurityContact:       pd.SecurityContact,
+		RequiredFiles:         requiredFiles,
+		IncludeGlobs:          includeGlobs,
+		ExcludeGlobs:          excludeGlobs,
+		DefaultDeployOptions:  defaultDeployOptions.orNil(),
+		JsEnvFilename:         customJsEnvFilename(pd.JsEnvFilename),
+		JsEnvDisabled:         pd.JsEnvDisabled,
+		DeploysPaused:         pd.DeploysPaused,
+		StatusToken:           pd.StatusToken,
+		CreatedAt:             pd.CreatedAt,
+		DeployedAt:            pd.DeployedAt,
 	}
 }
 
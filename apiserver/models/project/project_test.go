@@ -3,7 +3,9 @@ package project_test
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -76,6 +78,15 @@ var _ = Describe("Project", func() {
 			Entry("disallows names longer than 63 characters", strings.Repeat("a", 64), "is too long (max. 63 characters)"),
 		)
 
+		It("disallows consecutive hyphens in the names of new projects, which clash with alias domains", func() {
+			newProj := &project.Project{Name: "foo-bar--staging", UserID: u.ID}
+			Expect(newProj.Validate()["name"]).To(Equal("is invalid"))
+
+			// Existing projects cannot be renamed, so they are left alone.
+			proj.Name = "foo-bar--staging"
+			Expect(proj.Validate()).To(BeNil())
+		})
+
 		DescribeTable("validates basic auth credential",
 			func(username, password, usernameErr, passwordErr string) {
 				proj.BasicAuthUsername = &username
@@ -102,6 +113,47 @@ var _ = Describe("Project", func() {
 			Entry("missing username", "", "def", "is required", ""),
 			Entry("missing password", "abc", "", "", "is required"),
 		)
+
+		DescribeTable("validates slack webhook url",
+			func(webhookURL, webhookErr string) {
+				proj.SlackWebhookURL = &webhookURL
+				errors := proj.Validate()
+
+				if webhookErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["slack_webhook_url"]).To(Equal(webhookErr))
+				}
+			},
+
+			Entry("normal", "https://hooks.slack.com/services/T000/B000/XXXX", ""),
+			Entry("disallows http", "http://hooks.slack.com/services/T000/B000/XXXX", "is invalid"),
+			Entry("disallows other hosts", "https://example.com/services/T000/B000/XXXX", "is invalid"),
+			Entry("disallows other paths", "https://hooks.slack.com/commands/T000", "is invalid"),
+			Entry("disallows non-urls", "not a url", "is invalid"),
+		)
+
+		DescribeTable("validates security contact",
+			func(contact, contactErr string) {
+				proj.SecurityContact = &contact
+				errors := proj.Validate()
+
+				if contactErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["security_contact"]).To(Equal(contactErr))
+				}
+			},
+
+			Entry("mailto", "mailto:security@example.com", ""),
+			Entry("tel", "tel:+1-201-555-0123", ""),
+			Entry("https", "https://example.com/security", ""),
+			Entry("disallows http", "http://example.com/security", "is invalid"),
+			Entry("disallows empty mailto", "mailto:", "is invalid"),
+			Entry("disallows non-uris", "security@example.com", "is invalid"),
+		)
 	})
 
 	Describe("FindByName()", func() {
@@ -222,6 +274,32 @@ var _ = Describe("Project", func() {
 				Expect(err).To(BeNil())
 				Expect(canCreate).To(BeFalse())
 			})
+
+			Context("when the project has a higher limit of its own", func() {
+				BeforeEach(func() {
+					maxDomains := shared.MaxDomainsPerProject + 1
+					proj.MaxDomains = &maxDomains
+				})
+
+				It("returns true", func() {
+					canCreate, err := proj.CanAddDomain(db)
+					Expect(err).To(BeNil())
+					Expect(canCreate).To(BeTrue())
+				})
+			})
+		})
+
+		Context("when the project has a lower limit of its own", func() {
+			BeforeEach(func() {
+				maxDomains := 0
+				proj.MaxDomains = &maxDomains
+			})
+
+			It("returns false", func() {
+				canCreate, err := proj.CanAddDomain(db)
+				Expect(err).To(BeNil())
+				Expect(canCreate).To(BeFalse())
+			})
 		})
 	})
 
@@ -390,6 +468,44 @@ var _ = Describe("Project", func() {
 			Expect(err).To(BeNil())
 			Expect(v).To(Equal(int64(2)))
 		})
+
+		It("returns unique and gapless versions when called concurrently", func() {
+			const n = 20
+
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				versions []int
+				errs     []error
+			)
+
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					v, err := proj.NextVersion(db)
+
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						errs = append(errs, err)
+						return
+					}
+					versions = append(versions, int(v))
+				}()
+			}
+			wg.Wait()
+
+			Expect(errs).To(BeEmpty())
+
+			sort.Ints(versions)
+			expected := make([]int, n)
+			for i := range expected {
+				expected[i] = i + 1
+			}
+			Expect(versions).To(Equal(expected))
+		})
 	})
 
 	Describe("Destroy()", func() {
@@ -421,9 +537,6 @@ var _ = Describe("Project", func() {
 				ct3  *cert.Cert
 				bun2 *rawbundle.RawBundle
 
-				d1 *deployment.Deployment
-				d2 *deployment.Deployment
-				d3 *deployment.Deployment
 				d4 *deployment.Deployment
 			)
 
@@ -465,9 +578,9 @@ var _ = Describe("Project", func() {
 				bun1 = factories.RawBundle(db, proj)
 				bun2 = factories.RawBundle(db, proj2)
 
-				d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
-				d2 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
-				d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+				factories.Deployment(db, proj, u, deployment.StateDeployed)
+				factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+				factories.Deployment(db, proj, u, deployment.StateDeployed)
 				d4 = factories.Deployment(db, proj2, u, deployment.StateDeployed)
 			})
 
@@ -642,6 +755,42 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("DomainCertIDs()", func() {
+		var (
+			dom1 *domain.Domain
+			ct   *cert.Cert
+		)
+
+		BeforeEach(func() {
+			dom1 = factories.Domain(db, proj, "foo-bar-express.com")
+			factories.Domain(db, proj, "foobarexpress.com")
+			ct = factories.Cert(db, dom1)
+
+			otherProj := factories.Project(db, nil)
+			factories.Cert(db, factories.Domain(db, otherProj, "other-project.com"))
+		})
+
+		It("returns the cert ID of each custom domain of the project that has a cert", func() {
+			certIDs, err := proj.DomainCertIDs(db)
+			Expect(err).To(BeNil())
+			Expect(certIDs).To(Equal(map[string]uint{
+				"foo-bar-express.com": ct.ID,
+			}))
+		})
+
+		Context("when the cert is soft-deleted", func() {
+			BeforeEach(func() {
+				Expect(db.Delete(ct).Error).To(BeNil())
+			})
+
+			It("returns an empty map", func() {
+				certIDs, err := proj.DomainCertIDs(db)
+				Expect(err).To(BeNil())
+				Expect(certIDs).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("CanAddProject()", func() {
 		var origMaxProjectPerUser int
 
@@ -8,6 +8,7 @@ type RawBundle struct {
 	ProjectID    uint
 	Checksum     string
 	UploadedPath string
+	Size         int64 // in bytes
 }
 
 // Returns a struct that can be converted to JSON
@@ -0,0 +1,99 @@
+package subscription
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Events that subscriptions can be made to.
+const (
+	EventDeploySucceeded = "deploy_succeeded"
+	EventDeployFailed    = "deploy_failed"
+)
+
+// Events are all the events that subscriptions can be made to.
+var Events = []string{
+	EventDeploySucceeded,
+	EventDeployFailed,
+}
+
+// MaxPerProject is the maximum number of subscriptions a project can have.
+var MaxPerProject = 10
+
+// Subscription is a URL that events of a project are posted to as they
+// happen.
+type Subscription struct {
+	gorm.Model
+
+	ProjectID uint
+	// UserID is the ID of the user who created the subscription.
+	UserID uint
+
+	EventType string
+	TargetURL string `sql:"column:target_url"`
+}
+
+func (s *Subscription) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if s.EventType == "" {
+		errors["event_type"] = "is required"
+	} else if !isEvent(s.EventType) {
+		errors["event_type"] = "is invalid"
+	}
+
+	if s.TargetURL == "" {
+		errors["target_url"] = "is required"
+	} else if u, err := url.Parse(s.TargetURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errors["target_url"] = "is invalid"
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+func isEvent(eventType string) bool {
+	for _, e := range Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a struct that can be converted to JSON
+func (s *Subscription) AsJSON() interface{} {
+	return struct {
+		ID        uint      `json:"id"`
+		EventType string    `json:"event_type"`
+		TargetURL string    `json:"target_url"`
+		CreatedAt time.Time `json:"created_at"`
+	}{
+		s.ID,
+		s.EventType,
+		s.TargetURL,
+		s.CreatedAt,
+	}
+}
+
+// ForProject returns the subscriptions of a project, oldest first.
+func ForProject(db *gorm.DB, projectID uint) ([]*Subscription, error) {
+	var subs []*Subscription
+	if err := db.Where("project_id = ?", projectID).Order("id ASC").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// ForEvent returns the subscriptions of a project to an event.
+func ForEvent(db *gorm.DB, projectID uint, eventType string) ([]*Subscription, error) {
+	var subs []*Subscription
+	if err := db.Where("project_id = ? AND event_type = ?", projectID, eventType).Order("id ASC").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
@@ -36,6 +36,9 @@ type User struct {
 
 	PasswordResetToken          string
 	PasswordResetTokenCreatedAt *time.Time
+
+	// Admin lets the user access the endpoints for monitoring the platform.
+	Admin bool
 }
 
 // AsJSON returns a struct that can be converted to JSON
@@ -193,3 +196,23 @@ func FindByEmail(db *gorm.DB, email string) (u *User, err error) {
 
 	return u, nil
 }
+
+// StorageUsedBytes returns the total size of the raw bundles of all the
+// projects the user owns, excluding deleted projects.
+func (u *User) StorageUsedBytes(db *gorm.DB) (int64, error) {
+	r := struct{ Total int64 }{}
+
+	if err := db.Raw(`
+		SELECT COALESCE(SUM(raw_bundles.size), 0) AS total
+		FROM raw_bundles
+		JOIN projects ON projects.id = raw_bundles.project_id
+		WHERE
+			projects.user_id = ?
+			AND projects.deleted_at IS NULL
+			AND raw_bundles.deleted_at IS NULL;
+	`, u.ID).Scan(&r).Error; err != nil {
+		return 0, err
+	}
+
+	return r.Total, nil
+}
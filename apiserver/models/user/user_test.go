@@ -8,6 +8,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -320,4 +321,32 @@ var _ = Describe("User", func() {
 			})
 		})
 	})
+
+	Describe("StorageUsedBytes()", func() {
+		BeforeEach(func() {
+			u = factories.User(db)
+
+			for i, size := range []int64{100, 200} {
+				proj := factories.Project(db, u)
+				for j := 0; j <= i; j++ {
+					bun := factories.RawBundle(db, proj)
+					Expect(db.Model(bun).Update("size", size).Error).To(BeNil())
+				}
+			}
+
+			deletedProj := factories.Project(db, u)
+			bun := factories.RawBundle(db, deletedProj)
+			Expect(db.Model(bun).Update("size", 1000).Error).To(BeNil())
+			Expect(db.Delete(deletedProj).Error).To(BeNil())
+
+			otherUsersBun := factories.RawBundle(db, nil)
+			Expect(db.Model(otherUsersBun).Update("size", 2000).Error).To(BeNil())
+		})
+
+		It("returns the total size of the raw bundles of the user's projects", func() {
+			used, err := u.StorageUsedBytes(db)
+			Expect(err).To(BeNil())
+			Expect(used).To(Equal(int64(100 + 200 + 200)))
+		})
+	})
 })
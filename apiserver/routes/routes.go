@@ -3,6 +3,7 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/acme"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/admin"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/certs"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/deployments"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/domains"
@@ -15,9 +16,12 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/controllers/repos"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/root"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/stats"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/status"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/subscriptions"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/templates"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/users"
 	"github.com/nitrous-io/rise-server/apiserver/middleware"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 )
 
 func Draw(r *gin.Engine) {
@@ -27,6 +31,7 @@ func Draw(r *gin.Engine) {
 	}
 
 	r.Use(middleware.CORS)
+	r.Use(middleware.RateLimit)
 
 	r.GET("/", root.Root)
 	r.GET("/ping", ping.Ping)
@@ -41,23 +46,46 @@ func Draw(r *gin.Engine) {
 	r.GET("/.well-known/acme-challenge/:token", acme.ChallengeResponse)
 
 	r.POST("/hooks/github/:path", hooks.GitHubPush)
+	r.GET("/status/:project_token", status.Show)
+
+	{ // Routes that deploy tokens can also access
+		deploy := r.Group("/projects/:project_name",
+			middleware.RequireTokenWithScope(oauthtoken.ScopeDeploy),
+			middleware.RequireProjectCollab,
+			middleware.LockProject,
+		)
+		deploy.POST("/deployments", deployments.Create)
+	}
 
 	{ // Routes that require a OAuth Token
 		authorized := r.Group("", middleware.RequireToken)
 		authorized.DELETE("/oauth/token", oauth.DestroyToken)
 		authorized.POST("/projects", projects.Create)
 		authorized.GET("/projects", projects.Index)
+		authorized.POST("/project_imports", projects.Import)
 		authorized.GET("/user", users.Show)
 		authorized.PUT("/user", users.Update)
+		authorized.GET("/account/usage", users.Usage)
 		authorized.GET("/templates", templates.Index)
 		authorized.GET("/domains", domains.DomainsByUser)
 
+		{ // Routes that only admins can access
+			adminOnly := authorized.Group("/admin", middleware.RequireAdmin)
+			adminOnly.GET("/deployments", admin.Deployments)
+		}
+
 		{ // Routes that either project owners or collaborators can access
 			projCollab := authorized.Group("/projects/:project_name", middleware.RequireProjectCollab)
 
 			projCollab.GET("", projects.Get)
 			projCollab.GET("/deployments/:id/download", deployments.Download)
 			projCollab.GET("/deployments/:id", deployments.Show)
+			projCollab.GET("/deployments/:id/meta_diff", deployments.MetaDiff)
+			projCollab.GET("/deployments/:id/vanity_url", deployments.VanityURL)
+			projCollab.GET("/deployments/:id/files", deployments.Files)
+			projCollab.GET("/deployments/:id/tree", deployments.Tree)
+			projCollab.GET("/deployments/:id/checksum", deployments.Checksum)
+			projCollab.GET("/deployments/:id/logs.txt", deployments.Logs)
 			projCollab.GET("/deployments", deployments.Index)
 			projCollab.GET("repos", repos.Show)
 			projCollab.POST("/repos", repos.Link)
@@ -70,14 +98,31 @@ func Draw(r *gin.Engine) {
 			projCollab.DELETE("/domains/:name/cert", certs.Destroy)
 			projCollab.GET("/raw_bundles/:bundle_checksum", rawbundles.Get)
 			projCollab.GET("/jsenvvars", jsenvvars.Index)
+			projCollab.GET("/meta_preview", projects.MetaPreview)
+			projCollab.GET("/export", projects.Export)
+			projCollab.POST("/deploy_tokens", projects.CreateDeployToken)
+			projCollab.GET("/deploy_tokens", projects.ListDeployTokens)
+			projCollab.DELETE("/deploy_tokens/:id", projects.DestroyDeployToken)
+			projCollab.POST("/status_token", projects.CreateStatusToken)
+			projCollab.DELETE("/status_token", projects.DestroyStatusToken)
+			projCollab.POST("/subscriptions", subscriptions.Create)
+			projCollab.GET("/subscriptions", subscriptions.Index)
+			projCollab.DELETE("/subscriptions/:id", subscriptions.Destroy)
+			projCollab.PUT("/pause", projects.Pause)
+			projCollab.PUT("/unpause", projects.Unpause)
 
 			{ // Routes that lock a project
 				lock := projCollab.Group("", middleware.LockProject)
 				lock.PUT("", projects.Update)
-				lock.POST("/deployments", deployments.Create)
 				lock.POST("/domains", domains.Create)
 				lock.DELETE("/domains/:name", domains.Destroy)
 				lock.POST("/rollback", deployments.Rollback)
+				lock.POST("/deployments/:id/rollback", deployments.RollbackToDeployment)
+				lock.POST("/deployments/:id/publish", deployments.Publish)
+				lock.DELETE("/deployments/:id", deployments.Destroy)
+				lock.PUT("/canary", deployments.SetCanary)
+				lock.DELETE("/canary", deployments.ClearCanary)
+				lock.POST("/canary/promote", deployments.PromoteCanary)
 				lock.POST("/auth", projects.CreateAuth)
 				lock.DELETE("/auth", projects.DeleteAuth)
 				lock.PUT("/jsenvvars/add", jsenvvars.Add)
@@ -88,6 +133,7 @@ func Draw(r *gin.Engine) {
 		{ // Routes that only project owners can access
 			projOwner := authorized.Group("/projects/:project_name", middleware.RequireProject)
 
+			projOwner.GET("/lock", projects.GetLock)
 			projOwner.POST("/collaborators", projects.AddCollaborator)
 			projOwner.DELETE("/collaborators/:email", projects.RemoveCollaborator)
 
@@ -24,8 +24,8 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/messages"
-	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
@@ -42,7 +42,7 @@ func init() {
 		os.Setenv("RISE_ENV", riseEnv)
 	}
 
-	if riseEnv != "test" {
+	if riseEnv != "test" && s3client.Backend == "s3" {
 		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
 			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
 		}
@@ -50,7 +50,7 @@ func init() {
 }
 
 var (
-	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+	S3 filetransfer.FileTransfer = s3client.S3
 
 	errUnexpectedState  = errors.New("deployment is in unexpected state")
 	ErrProjectLocked    = errors.New("project is locked")
@@ -133,7 +133,7 @@ func Work(data []byte) error {
 	// been uploaded to the deployment's prefix directory.
 	prefixID := depl.PrefixID()
 	if bundlePath == "" {
-		bundlePath = "deployments/" + prefixID + "/raw-bundle." + archiveFormat
+		bundlePath = shared.DeploymentKey(prefixID, "raw-bundle."+archiveFormat)
 	}
 
 	f, err := ioutil.TempFile("", prefixID+"-raw-bundle."+archiveFormat)
@@ -241,8 +241,11 @@ func Work(data []byte) error {
 	defer os.Remove(optimizedBundleArchive.Name())
 
 	deployJobMsg := messages.DeployJobData{
-		DeploymentID:  depl.ID,
-		ArchiveFormat: archiveFormat,
+		DeploymentID:     depl.ID,
+		ArchiveFormat:    archiveFormat,
+		Priority:         d.Priority,
+		SkipInvalidation: d.SkipInvalidation,
+		ForceFullUpload:  d.ForceFullUpload,
 	}
 
 	nextState := deployment.StateBuilt
@@ -273,7 +276,7 @@ func Work(data []byte) error {
 			return err
 		}
 
-		if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, "deployments/"+prefixID+"/optimized-bundle."+archiveFormat, optimizedBundleArchive, "", "private"); err != nil {
+		if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, shared.DeploymentKey(prefixID, "optimized-bundle."+archiveFormat), optimizedBundleArchive, "", "private"); err != nil {
 			return err
 		}
 
@@ -294,7 +297,7 @@ func Work(data []byte) error {
 		return err
 	}
 
-	j, err := job.NewWithJSON(queues.Deploy, &deployJobMsg)
+	j, err := job.NewWithJSON(deployJobMsg.QueueName(), &deployJobMsg)
 	if err != nil {
 		return err
 	}
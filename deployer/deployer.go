@@ -8,6 +8,7 @@ import (
 
 	"github.com/nitrous-io/rise-server/deployer/deployer"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/streadway/amqp"
 
@@ -20,6 +21,11 @@ func main() {
 }
 
 func run() {
+	if err := deployer.PrepareTempDir(); err != nil {
+		log.Errorln("Failed to prepare temp dir:", err)
+		return
+	}
+
 	mq, err := mqconn.MQ()
 	if err != nil {
 		log.Errorln("Failed to connect to mq:", err)
@@ -69,40 +75,57 @@ func run() {
 		return
 	}
 
-	msgCh, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
-
+	consumer, err := deployer.NewConsumer(ch, q.Name)
 	if err != nil {
 		log.Errorf("Failed to start consuming message from queue(%s): %v", q.Name, err)
 		return
 	}
 
+	// Edges' acknowledgements of invalidations are consumed on a channel of
+	// their own, so that they are still recorded while deploys are paused.
+	ackCh, err := mq.Channel()
+	if err != nil {
+		log.Errorln("Failed to obtain channel:", err)
+		return
+	}
+
+	defer func() {
+		if err := ackCh.Close(); err != nil {
+			log.Errorln("Failed to close channel:", err)
+		}
+	}()
+
+	acks, err := consumeInvalidationAcks(ackCh)
+	if err != nil {
+		log.Errorf("Failed to start consuming message from queue(%s): %v", queues.InvalidationAck, err)
+		return
+	}
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
+	// SIGUSR1 pauses consuming jobs for maintenance and SIGUSR2 resumes it.
+	// The job being worked on is completed either way.
+	ctlCh := make(chan os.Signal, 1)
+	signal.Notify(ctlCh, syscall.SIGUSR1, syscall.SIGUSR2)
+
 	log.Infof("Worker started listening to queue(%s)...", q.Name)
 
 	for {
 		select {
-		case d := <-msgCh:
+		case d, ok := <-consumer.Deliveries():
+			if !ok {
+				log.Errorf("Stopped receiving messages from queue(%s)", q.Name)
+				return
+			}
+
 			err = deployer.Work(d.Body)
 
 			if err != nil {
 				// failure
 				log.Warnln("Work failed", err, string(d.Body))
 
-				// It does not retry for timeout or record not found error or unarchive failed
-				// because it could retry for long time.
-				if err == deployer.ErrTimeout ||
-					err == deployer.ErrRecordNotFound ||
-					err == deployer.ErrUnarchiveFailed {
+				if !deployer.Retriable(err) {
 					if err := d.Ack(false); err != nil {
 						log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
 					}
@@ -122,6 +145,41 @@ func run() {
 				}
 			}
 
+		case d, ok := <-acks:
+			if !ok {
+				log.Errorf("Stopped receiving messages from queue(%s)", queues.InvalidationAck)
+				return
+			}
+
+			if err := deployer.WorkInvalidationAck(d.Body); err != nil {
+				log.Warnln("Recording invalidation ack failed", err, string(d.Body))
+
+				go func() {
+					// nack after a delay to prevent thrashing
+					time.Sleep(1 * time.Second)
+					if err := d.Nack(false, true); err != nil {
+						log.WithFields(log.Fields{"queue": queues.InvalidationAck}).Warnln("Failed to Nack message:", err)
+					}
+				}()
+			} else if err := d.Ack(false); err != nil {
+				log.WithFields(log.Fields{"queue": queues.InvalidationAck}).Warnln("Failed to Ack message:", err)
+			}
+
+		case sig := <-ctlCh:
+			if sig == syscall.SIGUSR1 {
+				if err := consumer.Pause(); err != nil {
+					log.Errorf("Failed to pause consuming messages from queue(%s): %v", q.Name, err)
+					return
+				}
+				log.Infof("Worker paused listening to queue(%s)", q.Name)
+			} else {
+				if err := consumer.Resume(); err != nil {
+					log.Errorf("Failed to resume consuming messages from queue(%s): %v", q.Name, err)
+					return
+				}
+				log.Infof("Worker resumed listening to queue(%s)...", q.Name)
+			}
+
 		case err := <-connErrCh:
 			log.Errorln(err)
 			return
@@ -132,3 +190,51 @@ func run() {
 		}
 	}
 }
+
+// consumeInvalidationAcks starts consuming edges' acknowledgements of the
+// invalidations of deployments from a queue that is shared by all deployers.
+func consumeInvalidationAcks(ch *amqp.Channel) (<-chan amqp.Delivery, error) {
+	if err := ch.ExchangeDeclare(
+		exchanges.Edges, // name
+		"direct",        // type
+		true,            // durable
+		false,           // auto-deleted
+		false,           // internal
+		false,           // no-wait
+		nil,             // arguments
+	); err != nil {
+		return nil, err
+	}
+
+	q, err := ch.QueueDeclare(
+		queues.InvalidationAck,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // noWait
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.QueueBind(
+		q.Name,                           // queue name
+		exchanges.RouteV1InvalidationAck, // routing key
+		exchanges.Edges,                  // exchange
+		false,
+		nil,
+	); err != nil {
+		return nil, err
+	}
+
+	return ch.Consume(
+		q.Name, // queue
+		"",     // consumer
+		false,  // auto-ack
+		false,  // exclusive
+		false,  // no-local
+		false,  // no-wait
+		nil,    // args
+	)
+}
@@ -0,0 +1,94 @@
+package deployer
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxAccessibilityWarnings is the maximum number of accessibility problems
+// that are recorded on a deployment individually.
+const maxAccessibilityWarnings = 50
+
+var (
+	htmlTagRe    = regexp.MustCompile(`(?i)<html(?:\s[^>]*)?>`)
+	imgTagRe     = regexp.MustCompile(`(?i)<img(?:\s[^>]*)?>`)
+	anchorRe     = regexp.MustCompile(`(?is)<a(\s[^>]*)?>(.*?)</a\s*>`)
+	anyTagRe     = regexp.MustCompile(`(?s)<[^>]*>`)
+	commentRe    = regexp.MustCompile(`(?s)<!--.*?-->`)
+	hrefAttrRe   = regexp.MustCompile(`(?i)\shref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	imgSrcAttrRe = regexp.MustCompile(`(?i)\ssrc\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	langAttrRe   = regexp.MustCompile(`(?i)\slang(?:\s*=|[\s/>])`)
+	altAttrRe    = regexp.MustCompile(`(?i)\salt(?:\s*=|[\s/>])`)
+	altValueRe   = regexp.MustCompile(`(?i)\salt\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	labelAttrRe  = regexp.MustCompile(`(?i)\s(?:aria-label|aria-labelledby|title)\s*=\s*(?:"[^"]*\S[^"]*"|'[^']*\S[^']*'|[^\s"'>]+)`)
+)
+
+// accessibilityProblems returns a warning for each accessibility problem
+// found in an HTML page: images without an alt attribute, an <html> element
+// without a lang attribute and links without any text. It only looks at the
+// markup, so problems in markup that scripts add are not found.
+func accessibilityProblems(pagePath string, html []byte) []string {
+	html = commentRe.ReplaceAll(html, nil)
+
+	var problems []string
+
+	if tag := htmlTagRe.Find(html); tag != nil && !langAttrRe.Match(tag) {
+		problems = append(problems, fmt.Sprintf("%s has no lang attribute on <html>", pagePath))
+	}
+
+	for _, tag := range imgTagRe.FindAll(html, -1) {
+		if !altAttrRe.Match(tag) {
+			problems = append(problems, fmt.Sprintf("%s has an image without alt text: %s", pagePath, attrValue(imgSrcAttrRe, tag)))
+		}
+	}
+
+	for _, match := range anchorRe.FindAllSubmatch(html, -1) {
+		attrs, content := match[1], match[2]
+		if labelAttrRe.Match(attrs) || hasText(content) {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("%s has a link without text: %s", pagePath, attrValue(hrefAttrRe, attrs)))
+	}
+
+	return problems
+}
+
+// attrValue returns the value of the attribute that re matches in tag, or an
+// empty string if tag does not have it.
+func attrValue(re *regexp.Regexp, tag []byte) string {
+	match := re.FindSubmatch(tag)
+	if match == nil {
+		return ""
+	}
+	return string(bytes.Join(match[1:], nil))
+}
+
+// hasText returns whether the content of a link has text that describes it,
+// either as text or as the alt text of an image.
+func hasText(content []byte) bool {
+	if len(bytes.TrimSpace(anyTagRe.ReplaceAll(content, nil))) > 0 {
+		return true
+	}
+	for _, tag := range imgTagRe.FindAll(content, -1) {
+		if strings.TrimSpace(attrValue(altValueRe, tag)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// accessibilityWarnings sorts the accessibility problems found in the pages
+// of a deployment and caps their number.
+func accessibilityWarnings(problems []string) []string {
+	sort.Strings(problems)
+
+	if len(problems) > maxAccessibilityWarnings {
+		more := len(problems) - maxAccessibilityWarnings
+		problems = append(problems[:maxAccessibilityWarnings], fmt.Sprintf("and %d more accessibility problems", more))
+	}
+
+	return problems
+}
@@ -0,0 +1,47 @@
+package deployer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte("\x1f\x8b")
+)
+
+// detectArchiveFormat returns the format of the bundle in r going by its
+// magic bytes, "zip" or "tar.gz". The given format is returned when the bundle
+// is neither, so that extracting it fails as it would have.
+func detectArchiveFormat(r io.ReaderAt, format string) string {
+	magic := make([]byte, len(zipMagic))
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		return "zip"
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "tar.gz"
+	}
+	return format
+}
+
+// corruptBundleError returns ErrCorruptBundle if err is from reading a bundle
+// that is truncated or not a valid archive, or err otherwise.
+func corruptBundleError(err error) error {
+	if _, ok := err.(flate.CorruptInputError); ok {
+		return ErrCorruptBundle
+	}
+
+	switch err {
+	case gzip.ErrHeader, gzip.ErrChecksum, io.ErrUnexpectedEOF, tar.ErrHeader,
+		zip.ErrFormat, zip.ErrChecksum, zip.ErrAlgorithm:
+		return ErrCorruptBundle
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package deployer
+
+import (
+	"encoding/json"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// AssetManifestFileName is the path in the webroot that the asset manifest of
+// a deployment is uploaded to, for projects that have one.
+const AssetManifestFileName = "asset-manifest.json"
+
+type assetManifestEntry struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// assetManifest returns the asset manifest of a deployment with the given
+// manifest, which lists the URL path of every file in its webroot with its
+// MD5 checksum and size, e.g. for service workers to precache. A file of the
+// bundle at AssetManifestFileName is left out, as it is replaced by the asset
+// manifest.
+func assetManifest(m deployment.Manifest) ([]byte, error) {
+	files := make(map[string]assetManifestEntry, len(m))
+	for fileName, entry := range m {
+		if fileName == AssetManifestFileName {
+			continue
+		}
+		files["/"+fileName] = assetManifestEntry{
+			Hash: entry.ETag,
+			Size: entry.Size,
+		}
+	}
+
+	return json.Marshal(struct {
+		Files map[string]assetManifestEntry `json:"files"`
+	}{files})
+}
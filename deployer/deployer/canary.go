@@ -0,0 +1,34 @@
+package deployer
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/shared/meta"
+)
+
+// domainMeta returns the meta to upload for the domains of a project that depl
+// is published to. It is m, plus the canary of the project if the project has
+// one other than depl. A canary that has since been deleted is left out.
+func domainMeta(db *gorm.DB, proj *project.Project, depl *deployment.Deployment, m *meta.Meta) (*meta.Meta, error) {
+	if proj.CanaryDeploymentID == nil || *proj.CanaryDeploymentID == depl.ID || proj.CanaryPercent == 0 {
+		return m, nil
+	}
+
+	canaryDepl := &deployment.Deployment{}
+	if err := db.First(canaryDepl, *proj.CanaryDeploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return m, nil
+		}
+		return nil, err
+	}
+
+	canary, err := meta.NewCanary(canaryDepl, proj.CanaryPercent)
+	if err != nil {
+		return nil, err
+	}
+
+	withCanary := *m
+	withCanary.Canary = canary
+	return &withCanary, nil
+}
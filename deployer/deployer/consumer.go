@@ -0,0 +1,92 @@
+package deployer
+
+import "github.com/streadway/amqp"
+
+// ConsumerTag identifies the consumer of deploy jobs on its channel.
+const ConsumerTag = "deployer"
+
+// Channel is the part of an AMQP channel that a Consumer needs.
+type Channel interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+}
+
+// Consumer consumes deploy jobs from a queue, and can be paused for
+// maintenance without stopping the worker. Pausing cancels the consumption,
+// so that no more jobs are fetched, and leaves the job being worked on to
+// complete.
+type Consumer struct {
+	ch         Channel
+	queue      string
+	deliveries <-chan amqp.Delivery
+}
+
+// NewConsumer returns a Consumer that has started consuming from the queue.
+func NewConsumer(ch Channel, queue string) (*Consumer, error) {
+	c := &Consumer{ch: ch, queue: queue}
+	if err := c.consume(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Consumer) consume() error {
+	deliveries, err := c.ch.Consume(
+		c.queue,     // queue
+		ConsumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		return err
+	}
+	c.deliveries = deliveries
+	return nil
+}
+
+// Deliveries returns the channel jobs are delivered on. It is nil while the
+// consumer is paused, so that receiving from it blocks.
+func (c *Consumer) Deliveries() <-chan amqp.Delivery {
+	return c.deliveries
+}
+
+// Paused returns whether the consumer is paused.
+func (c *Consumer) Paused() bool {
+	return c.deliveries == nil
+}
+
+// Pause stops consuming jobs. Jobs that were already delivered but not yet
+// received are requeued for other workers. Pausing a paused consumer does
+// nothing.
+func (c *Consumer) Pause() error {
+	if c.Paused() {
+		return nil
+	}
+
+	if err := c.ch.Cancel(ConsumerTag, false); err != nil {
+		return err
+	}
+
+	// The channel is closed once the deliveries it buffered are received.
+	var nackErr error
+	for d := range c.deliveries {
+		if err := d.Nack(false, true); err != nil && nackErr == nil {
+			nackErr = err
+		}
+	}
+
+	c.deliveries = nil
+	return nackErr
+}
+
+// Resume starts consuming jobs again. Resuming a consumer that is not paused
+// does nothing.
+func (c *Consumer) Resume() error {
+	if !c.Paused() {
+		return nil
+	}
+	return c.consume()
+}
@@ -0,0 +1,105 @@
+package deployer_test
+
+import (
+	"github.com/nitrous-io/rise-server/deployer/deployer"
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeChannel struct {
+	consumed   int
+	canceled   int
+	deliveries chan amqp.Delivery
+}
+
+func (ch *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.consumed++
+	ch.deliveries = make(chan amqp.Delivery, 10)
+	return ch.deliveries, nil
+}
+
+func (ch *fakeChannel) Cancel(consumer string, noWait bool) error {
+	ch.canceled++
+	close(ch.deliveries)
+	return nil
+}
+
+type fakeAcknowledger struct {
+	nacked []uint64
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error { return nil }
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		a.nacked = append(a.nacked, tag)
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error { return nil }
+
+var _ = Describe("Consumer", func() {
+	var (
+		ch       *fakeChannel
+		consumer *deployer.Consumer
+		err      error
+	)
+
+	BeforeEach(func() {
+		ch = &fakeChannel{}
+		consumer, err = deployer.NewConsumer(ch, "deploy")
+		Expect(err).To(BeNil())
+	})
+
+	It("starts consuming", func() {
+		Expect(ch.consumed).To(Equal(1))
+		Expect(consumer.Paused()).To(BeFalse())
+		Expect(consumer.Deliveries()).NotTo(BeNil())
+	})
+
+	Describe("Pause()", func() {
+		It("cancels consuming and requeues jobs that were delivered but not received", func() {
+			ack := &fakeAcknowledger{}
+			ch.deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 1}
+			ch.deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 2}
+
+			Expect(consumer.Pause()).To(BeNil())
+
+			Expect(ch.canceled).To(Equal(1))
+			Expect(ack.nacked).To(Equal([]uint64{1, 2}))
+			Expect(consumer.Paused()).To(BeTrue())
+			Expect(consumer.Deliveries()).To(BeNil())
+		})
+
+		It("does nothing if already paused", func() {
+			Expect(consumer.Pause()).To(BeNil())
+			Expect(consumer.Pause()).To(BeNil())
+
+			Expect(ch.canceled).To(Equal(1))
+			Expect(consumer.Paused()).To(BeTrue())
+		})
+	})
+
+	Describe("Resume()", func() {
+		It("does nothing if not paused", func() {
+			Expect(consumer.Resume()).To(BeNil())
+
+			Expect(ch.consumed).To(Equal(1))
+			Expect(consumer.Paused()).To(BeFalse())
+		})
+
+		It("starts consuming again if paused", func() {
+			Expect(consumer.Pause()).To(BeNil())
+			Expect(consumer.Resume()).To(BeNil())
+
+			Expect(ch.consumed).To(Equal(2))
+			Expect(consumer.Paused()).To(BeFalse())
+
+			ch.deliveries <- amqp.Delivery{DeliveryTag: 3}
+			Expect((<-consumer.Deliveries()).DeliveryTag).To(Equal(uint64(3)))
+		})
+	})
+})
@@ -0,0 +1,85 @@
+package deployer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffedContentTypes maps security-sensitive extensions to the content types
+// their content may be sniffed as. Browsers may render content that looks
+// like HTML even when it is served as something else, so a file whose content
+// does not match its extension is rejected when strict content types are on.
+var sniffedContentTypes = map[string][]string{
+	".html": {"text/html", "text/plain"},
+	".htm":  {"text/html", "text/plain"},
+	".js":   {"text/plain"},
+	".css":  {"text/plain"},
+	".json": {"text/plain"},
+	".svg":  {"text/xml", "text/plain"},
+}
+
+// contentTypeMismatch describes a file whose content does not match the
+// content type it would be served with.
+type contentTypeMismatch struct {
+	Path        string
+	ContentType string
+	Sniffed     string
+}
+
+func (m *contentTypeMismatch) String() string {
+	contentType := m.ContentType
+	if contentType == "" {
+		contentType = "unknown type"
+	}
+	return fmt.Sprintf("%s (%s, but looks like %s)", m.Path, contentType, m.Sniffed)
+}
+
+// sniffContentType detects the content type of the content of rdr. It
+// returns a reader that yields the entire content, including the part that
+// was read to detect the content type.
+func sniffContentType(rdr io.Reader) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(rdr, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+
+	sniffed := http.DetectContentType(head)
+	if i := strings.Index(sniffed, ";"); i != -1 {
+		sniffed = sniffed[:i]
+	}
+
+	return sniffed, io.MultiReader(bytes.NewReader(head), rdr), nil
+}
+
+// checkContentType returns a mismatch if the content of a file that would be
+// served with contentType was sniffed as something it should not be. Files
+// with security-sensitive extensions must be sniffed as one of the expected
+// types, and files of unknown type must not look like HTML.
+func checkContentType(fileName, contentType, sniffed string) *contentTypeMismatch {
+	mismatch := &contentTypeMismatch{
+		Path:        fileName,
+		ContentType: contentType,
+		Sniffed:     sniffed,
+	}
+
+	expected, ok := sniffedContentTypes[strings.ToLower(filepath.Ext(fileName))]
+	if !ok {
+		if contentType == "" && sniffed == "text/html" {
+			return mismatch
+		}
+		return nil
+	}
+
+	for _, t := range expected {
+		if sniffed == t {
+			return nil
+		}
+	}
+	return mismatch
+}
@@ -0,0 +1,58 @@
+package deployer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"regexp"
+)
+
+// cspNonceLength is the number of random bytes in a CSP nonce.
+const cspNonceLength = 16
+
+var (
+	// inlineTagRe matches the opening tags of script and style elements.
+	inlineTagRe = regexp.MustCompile(`(?i)<(script|style)(\s[^>]*)?>`)
+	// srcAttrRe matches the src attribute of an element, which makes a script
+	// external rather than inline.
+	srcAttrRe = regexp.MustCompile(`(?i)\ssrc\s*=`)
+	// nonceAttrRe matches the nonce attribute of an element.
+	nonceAttrRe = regexp.MustCompile(`(?i)\snonce\s*=`)
+)
+
+// newCSPNonce returns a random nonce for the inline scripts and styles of a
+// deployment.
+func newCSPNonce() (string, error) {
+	b := make([]byte, cspNonceLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// addCSPNonce adds the nonce to the inline script and style elements of the
+// HTML page read from rdr that do not have one yet.
+func addCSPNonce(rdr io.Reader, nonce string) (io.Reader, error) {
+	b, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+
+	b = inlineTagRe.ReplaceAllFunc(b, func(tag []byte) []byte {
+		attrs := inlineTagRe.FindSubmatch(tag)[2]
+		if srcAttrRe.Match(attrs) || nonceAttrRe.Match(attrs) {
+			return tag
+		}
+
+		// Insert the nonce right after the tag name.
+		n := len("<script")
+		if bytes.HasPrefix(bytes.ToLower(tag), []byte("<style")) {
+			n = len("<style")
+		}
+		return append(append(append([]byte{}, tag[:n]...), ` nonce="`+nonce+`"`...), tag[n:]...)
+	})
+
+	return bytes.NewReader(b), nil
+}
@@ -12,39 +12,91 @@ import (
 	"io/ioutil"
 	"log"
 	"mime"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jinzhu/gorm"
-	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/glob"
+	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/pubsub"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/meta"
 	"github.com/nitrous-io/rise-server/shared/mimetypes"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
 var (
-	ErrProjectLocked   = errors.New("project is locked")
-	ErrRecordNotFound  = errors.New("project or deployment is deleted")
-	ErrTimeout         = errors.New("failed to upload files due to timeout on uploading to s3")
-	ErrUnarchiveFailed = errors.New("Failed to unarchive file")
+	ErrProjectLocked  = errors.New("project is locked")
+	ErrRecordNotFound = errors.New("project or deployment is deleted")
+	ErrTimeout        = errors.New("failed to upload files due to timeout on uploading to s3")
+	ErrCorruptBundle  = errors.New("bundle is corrupt")
+	ErrNoDiskSpace    = errors.New("insufficient disk space to download the bundle")
 
 	MaxFileSizeToWatermark int64 = 5 * 1000 * 1000 // in bytes
 	UploadTimeout                = 3 * time.Minute
+
+	// MaxDeployOrderWait is how long ago an earlier deployment of a project
+	// may have been created for deploys to wait for it. Older ones are
+	// assumed to be stuck, so that they cannot hold up the project forever.
+	MaxDeployOrderWait = 30 * time.Minute
+
+	// EarlierPendingDelay is how long a deploy that has to wait for an earlier
+	// deployment of the project is held before it is picked up again.
+	EarlierPendingDelay = 30 * time.Second
 )
 
+// Retriable returns whether a job that failed with err should be requeued.
+// Errors that retrying cannot fix, or that could keep a job retrying for a
+// long time, e.g. running out of disk space after the deployment has already
+// been failed, are not retried.
+func Retriable(err error) bool {
+	switch err {
+	case ErrTimeout, ErrRecordNotFound, ErrCorruptBundle, ErrNoDiskSpace:
+		return false
+	}
+	return true
+}
+
+// enqueueDelayed enqueues the job again once delay has passed.
+func enqueueDelayed(d *messages.DeployJobData, delay time.Duration) error {
+	j, err := job.NewWithJSON(d.QueueName(), d)
+	if err != nil {
+		return err
+	}
+	return j.EnqueueDelayed(d.DelayedQueueName(), delay)
+}
+
+// From http://docs.aws.amazon.com/AmazonS3/latest/dev/UsingMetadata.html#object-keys
+// Add @ as an exceptional
+var invalidFileNameRe = regexp.MustCompile("[^0-9A-Za-z,!_'()\\.\\*\\-@]+")
+
+// isValidFileName returns whether every element of the path of a file in a
+// bundle only has characters that are safe in S3 object keys.
+func isValidFileName(fileName string) bool {
+	for _, pathElement := range strings.Split(fileName, "/") {
+		if invalidFileNameRe.MatchString(pathElement) {
+			return false
+		}
+	}
+	return true
+}
+
 var jsenvFormat = `(function(global, env) {
 	if (typeof module === "object" && typeof module.exports === "object") {
 		module.exports = env;
@@ -61,7 +113,7 @@ func init() {
 		os.Setenv("RISE_ENV", riseEnv)
 	}
 
-	if riseEnv != "test" {
+	if riseEnv != "test" && s3client.Backend == "s3" {
 		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
 			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
 		}
@@ -71,7 +123,7 @@ func init() {
 }
 
 var (
-	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+	S3 filetransfer.FileTransfer = s3client.S3
 
 	errUnexpectedState = errors.New("deployment is in unexpected state")
 )
@@ -103,6 +155,48 @@ func Work(data []byte) error {
 		return err
 	}
 
+	// The project's Slack channel and subscriptions are told once the
+	// deployment has gone out or failed, after the project is unlocked.
+	prevState := depl.State
+	defer func() {
+		finished := &deployment.Deployment{}
+		if err := db.First(finished, depl.ID).Error; err != nil {
+			log.Printf("failed to fetch deployment %d to notify of, err: %v", depl.ID, err)
+			return
+		}
+
+		if finished.State == prevState ||
+			(finished.State != deployment.StateDeployed && finished.State != deployment.StateDeployFailed) {
+			return
+		}
+
+		if proj.SlackWebhookURL != nil {
+			if err := notifySlack(db, proj, finished); err != nil {
+				log.Printf("failed to notify Slack of deployment %d, err: %v", depl.ID, err)
+			}
+		}
+
+		if err := notifySubscriptions(db, proj, finished); err != nil {
+			log.Printf("failed to notify subscriptions of deployment %d, err: %v", depl.ID, err)
+		}
+	}()
+
+	// Deploys of a project go out in the order they were created, however the
+	// workers pick them up, so the job is put back at the end of the queue
+	// until the earlier ones are done. Requeueing it at the head would keep
+	// the worker from ever picking up the earlier ones. Meta-only jobs (e.g.
+	// rollbacks) and repairs do not have to wait.
+	if !d.SkipWebrootUpload && !d.RepairUploads {
+		pending, err := depl.HasEarlierPending(db, time.Now().Add(-MaxDeployOrderWait))
+		if err != nil {
+			return err
+		}
+
+		if pending {
+			return enqueueDelayed(d, EarlierPendingDelay)
+		}
+	}
+
 	acquired, err := proj.Lock(db)
 	if err != nil {
 		return err
@@ -118,6 +212,16 @@ func Work(data []byte) error {
 		}
 	}()
 
+	// Failed uploads are repaired with the project locked, so that a deploy
+	// cannot rewrite a file while it is being copied over.
+	if d.RepairUploads {
+		return repairUploads(db, proj, depl, d)
+	}
+
+	// Uploads that fail on a minority of the targets are recorded for a
+	// repair job once the deployment is out.
+	failures := &uploadFailures{}
+
 	if proj.Name != "help" && proj.Name != "pubstorm-blog" && proj.Name != "pubstorm-www" && proj.Name != "nitrous-www" {
 		var errorMessage = "Project deployments and new account sign ups are no longer accepted. For more information, please visit https://www.pubstorm.com/"
 		depl.ErrorMessage = &errorMessage
@@ -125,6 +229,14 @@ func Work(data []byte) error {
 		return nil
 	}
 
+	// Meta-only jobs (e.g. rollbacks) still go through, so that a paused
+	// project can be rolled back.
+	if proj.DeploysPaused && !d.SkipWebrootUpload {
+		errorMessage := "Deploys of the project are paused"
+		depl.ErrorMessage = &errorMessage
+		return depl.UpdateState(db, deployment.StateDeployFailed)
+	}
+
 	// Return error if the deployment is in a state that bundle is not uploaded or not prepared for deploying
 	if depl.State == deployment.StateUploaded || depl.State == deployment.StatePendingUpload {
 		return errUnexpectedState
@@ -138,6 +250,36 @@ func Work(data []byte) error {
 			return errUnexpectedState
 		}
 
+		// How long the deployment waited in the queue is measured up to the
+		// first time a deployer picks it up, not to retries.
+		if depl.DeployStartedAt == nil {
+			now := time.Now()
+			if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("deploy_started_at", now).Error; err != nil {
+				return err
+			}
+			depl.DeployStartedAt = &now
+		}
+	}
+
+	// Deployments of identical bundles with content hash prefixes share a
+	// webroot, which is only uploaded by the first of them to be deployed.
+	// Uploading it again would rewrite the files of a deployment that may be
+	// live with output that differs between deployments, e.g. CSP nonces.
+	var webrootOwner *deployment.Deployment
+	if !d.SkipWebrootUpload {
+		webrootOwner, err = depl.WebrootOwner(db)
+		if err != nil {
+			return err
+		}
+
+		if webrootOwner != nil {
+			if err := shareWebroot(db, depl, webrootOwner); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !d.SkipWebrootUpload && webrootOwner == nil {
 		archiveFormat := d.ArchiveFormat
 		if archiveFormat == "" {
 			archiveFormat = "tar.gz"
@@ -145,7 +287,7 @@ func Work(data []byte) error {
 
 		var bundlePath string
 		if !d.UseRawBundle {
-			bundlePath = "deployments/" + prefixID + "/optimized-bundle." + archiveFormat
+			bundlePath = shared.DeploymentKey(prefixID, "optimized-bundle."+archiveFormat)
 		} else {
 			// If this deployment uses a raw bundle from a previous deploy, use that.
 			if depl.RawBundleID != nil {
@@ -154,11 +296,26 @@ func Work(data []byte) error {
 					bundlePath = bun.UploadedPath
 				}
 			} else {
-				bundlePath = "deployments/" + prefixID + "/raw-bundle." + archiveFormat
+				bundlePath = shared.DeploymentKey(prefixID, "raw-bundle."+archiveFormat)
+			}
+		}
+
+		// Fail fast rather than partway through downloading the bundle.
+		hasSpace, err := hasSpaceForBundle(bundlePath)
+		if err != nil {
+			return err
+		}
+
+		if !hasSpace {
+			errorMessage := "Insufficient disk space to download the bundle, please try again later"
+			depl.ErrorMessage = &errorMessage
+			if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
+				return err
 			}
+			return ErrNoDiskSpace
 		}
 
-		f, err := ioutil.TempFile("", prefixID+"-optimized-bundle."+archiveFormat)
+		f, err := ioutil.TempFile(tempDir(), prefixID+"-optimized-bundle."+archiveFormat)
 		if err != nil {
 			return err
 		}
@@ -167,23 +324,425 @@ func Work(data []byte) error {
 			os.Remove(f.Name())
 		}()
 
-		if err := S3.Download(s3client.BucketRegion, s3client.BucketName, bundlePath, f); err != nil {
+		if err := downloadBundle(bundlePath, f); err != nil {
 			return err
 		}
 
+		// Build tools may produce either format regardless of the one the bundle
+		// was uploaded as, so the content is what decides how it is extracted.
+		archiveFormat = detectArchiveFormat(f, archiveFormat)
+
+		// Extracting a corrupt bundle fails however many times it is retried,
+		// so the deployment is failed for good.
+		failCorruptBundle := func() error {
+			errorMessage := ErrCorruptBundle.Error()
+			errorCode := deployment.ErrorCodeCorruptBundle
+			depl.ErrorMessage = &errorMessage
+			depl.ErrorCode = &errorCode
+			if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
+				return err
+			}
+			return ErrCorruptBundle
+		}
+
+		if proj.PreDeployHookURL != nil {
+			if err := callPreDeployHook(proj, depl); err != nil {
+				log.Printf("deployment %s was stopped by the pre-deploy hook, err: %v", prefixID, err)
+				errorMessage := "Pre-deploy hook failed: " + err.Error()
+				depl.ErrorMessage = &errorMessage
+				return depl.UpdateState(db, deployment.StateDeployFailed)
+			}
+		}
+
 		// webroot is a publicly readable directory on S3.
-		webroot := "deployments/" + prefixID + "/webroot"
+		webroot := shared.DeploymentKey(prefixID, "webroot")
 
-		// From http://docs.aws.amazon.com/AmazonS3/latest/dev/UsingMetadata.html#object-keys
-		// Add @ as an exceptional
-		r := regexp.MustCompile("[^0-9A-Za-z,!_'()\\.\\*\\-@]+")
 		done := make(chan struct{})
-		errCh := make(chan error)
+		errCh := make(chan error, 1)
+
+		// Files uploaded by a previous attempt of this deploy that are still
+		// intact are not uploaded again.
+		prevManifest, err := depl.ParsedManifest()
+		if err != nil {
+			return err
+		}
+		progress := &uploadProgress{manifest: deployment.Manifest{}}
+
+		// Files that have not changed since the active deployment are copied
+		// from its webroot rather than uploaded again, unless the job asks for
+		// a full upload.
+		var active *activeWebroot
+		if !d.ForceFullUpload {
+			if active, err = findActiveWebroot(db, proj, depl); err != nil {
+				return err
+			}
+		}
+		var reused int
+
+		// Files whose content does not match their type are not uploaded when
+		// the project has strict content types on.
+		var mismatches []string
+
+		// Links between the files of the deployment are checked once all of
+		// them are uploaded, if the project has link checking on.
+		var links *linkChecker
+		if proj.CheckInternalLinks {
+			links = newLinkChecker()
+		}
+
+		// JS and JSON files that do not parse are warned about, if the project
+		// has syntax validation on for them.
+		var syntaxErrors []string
+
+		// HTML pages with accessibility problems are warned about, if the
+		// project has accessibility checks on.
+		var accessibilityIssues []string
+
+		// Pages and stylesheets that load resources over insecure HTTP are
+		// warned about, or fail the deployment in strict mode, if the project
+		// has mixed content checks on.
+		var insecureResources []string
+
+		// The critical resources of the main page are preloaded through the
+		// Link header in meta.json, if the project has preload hints on.
+		var preloads []string
+
+		// Only the files that the include and exclude globs of the project
+		// allow are deployed, the rest are recorded as skipped.
+		includeGlobs, err := proj.IncludeGlobPatterns()
+		if err != nil {
+			return err
+		}
+		excludeGlobs, err := proj.ExcludeGlobPatterns()
+		if err != nil {
+			return err
+		}
+		var skippedFiles []string
+
+		// Files of the bundle under the directory that variants are stored in
+		// would be overwritten by them, so they are left out and warned about.
+		var reservedFiles []string
+
+		skip := func(fileName string) bool {
+			if (len(includeGlobs) > 0 && !glob.MatchAny(includeGlobs, fileName)) || glob.MatchAny(excludeGlobs, fileName) {
+				skippedFiles = append(skippedFiles, fileName)
+				return true
+			}
+			return false
+		}
+
+		// Inline scripts and styles are given a nonce that the CSP header in
+		// meta.json allows, if the project has CSP nonces on. A previous
+		// attempt's nonce is kept, as the files it uploaded are not uploaded
+		// again.
+		if proj.CSPNonces && depl.CSPNonce == nil {
+			nonce, err := newCSPNonce()
+			if err != nil {
+				return err
+			}
+			if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("csp_nonce", nonce).Error; err != nil {
+				return err
+			}
+			depl.CSPNonce = &nonce
+		}
+
+		// Assets are uploaded under a name with a hash of their content as well,
+		// and pages and stylesheets reference them by it, if the project has
+		// fingerprinting on.
+		var fps fingerprints
+		if proj.Fingerprint {
+			fps, err = fingerprintArchive(f.Name(), archiveFormat)
+			if err == ErrCorruptBundle {
+				return failCorruptBundle()
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// Favicons of other sizes are generated from the favicon at the root of
+		// the bundle, and pages link to them, if the project has favicon
+		// generation on.
+		var fvs favicons
+		if proj.GenerateFavicons {
+			fvs, err = faviconsFromArchive(f.Name(), archiveFormat)
+			if err == ErrCorruptBundle {
+				return failCorruptBundle()
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// Files are uploaded concurrently, so the results collected from them
+		// are guarded by mu.
+		var mu sync.Mutex
+
+		uploadFile := func(fileName string, rdr io.Reader, size int64, contentType string, modTime time.Time) error {
+			remotePath := webroot + "/" + fileName
+
+			if fvs != nil && contentType == "text/html" {
+				r, err := fvs.inject(rdr)
+				if err != nil {
+					return err
+				}
+				rdr = r
+			}
+
+			if depl.CSPNonce != nil && contentType == "text/html" {
+				r, err := addCSPNonce(rdr, *depl.CSPNonce)
+				if err != nil {
+					return err
+				}
+				rdr = r
+			}
+
+			if links != nil && contentType == "text/html" {
+				r, err := links.collect(fileName, rdr)
+				if err != nil {
+					return err
+				}
+				rdr = r
+			}
+
+			if proj.PreloadCriticalAssets && fileName == preloadPage && contentType == "text/html" {
+				b, err := ioutil.ReadAll(rdr)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				preloads = preloadHints(fileName, b)
+				mu.Unlock()
+				rdr = bytes.NewReader(b)
+			}
+
+			if proj.AccessibilityCheck && contentType == "text/html" {
+				b, err := ioutil.ReadAll(rdr)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				accessibilityIssues = append(accessibilityIssues, accessibilityProblems(fileName, b)...)
+				mu.Unlock()
+				rdr = bytes.NewReader(b)
+			}
+
+			if (proj.CheckMixedContent || proj.StrictMixedContent) && (contentType == "text/html" || contentType == "text/css") {
+				b, err := ioutil.ReadAll(rdr)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				for _, u := range mixedContent(contentType, b) {
+					insecureResources = append(insecureResources, fileName+" loads insecure resource "+u)
+				}
+				mu.Unlock()
+				rdr = bytes.NewReader(b)
+			}
+
+			if proj.StrictContentTypes {
+				sniffed, r, err := sniffContentType(rdr)
+				if err != nil {
+					return err
+				}
+				if mismatch := checkContentType(fileName, contentType, sniffed); mismatch != nil {
+					mu.Lock()
+					mismatches = append(mismatches, mismatch.String())
+					mu.Unlock()
+					return nil
+				}
+				rdr = r
+			}
+
+			if entry, ok := prevManifest[fileName]; ok {
+				uploaded, err := isUploaded(remotePath, entry)
+				if err != nil {
+					return err
+				}
+				if uploaded {
+					progress.add(fileName, entry)
+					return nil
+				}
+			}
+
+			var originalSize int64
+			if proj.OptimizeImages && isOptimizableImage(contentType, size) {
+				optimized, err := optimizeImage(rdr, contentType)
+				if err != nil {
+					return err
+				}
+				if int64(len(optimized)) < size {
+					originalSize = size
+				}
+				rdr, size = bytes.NewReader(optimized), int64(len(optimized))
+			}
+
+			// Edges serve the time the file was last modified in the bundle as
+			// its Last-Modified, rather than the time it was uploaded, so that
+			// conditional requests for it still hit after a redeploy.
+			var metadata map[string]string
+			if !modTime.IsZero() {
+				metadata = map[string]string{meta.LastModifiedMetadataKey: modTime.UTC().Format(http.TimeFormat)}
+			}
+
+			if active != nil {
+				b, err := ioutil.ReadAll(rdr)
+				if err != nil {
+					return err
+				}
+				if entry := active.reuse(webroot, fileName, b, contentType); entry != nil {
+					entry.OriginalSize = originalSize
+					progress.add(fileName, entry)
+					mu.Lock()
+					reused++
+					mu.Unlock()
+					return nil
+				}
+				rdr = bytes.NewReader(b)
+			}
+
+			entry, err := uploadWithVariants(failures, webroot, fileName, rdr, size, contentType, metadata)
+			if err != nil {
+				return err
+			}
+			entry.OriginalSize = originalSize
+			progress.add(fileName, entry)
+			return nil
+		}
+
+		upload := uploadFile
+		if fps != nil {
+			upload = func(fileName string, rdr io.Reader, size int64, contentType string, modTime time.Time) error {
+				fp, isAsset := fps[fileName]
+				rewrite := contentType == "text/html" || contentType == "text/css"
+				if !isAsset && !rewrite {
+					return uploadFile(fileName, rdr, size, contentType, modTime)
+				}
+
+				b, err := ioutil.ReadAll(rdr)
+				if err != nil {
+					return err
+				}
+				if rewrite {
+					b = fps.rewrite(fileName, contentType, b)
+				}
+
+				if err := uploadFile(fileName, bytes.NewReader(b), int64(len(b)), contentType, modTime); err != nil {
+					return err
+				}
+				if !isAsset {
+					return nil
+				}
+
+				// Assets keep their original names too, as scripts may reference
+				// them in ways that cannot be rewritten.
+				return uploadFile(fp, bytes.NewReader(b), int64(len(b)), contentType, modTime)
+			}
+		}
+
+		if proj.ValidateJS || proj.ValidateJSON {
+			uploadValidated := upload
+			upload = func(fileName string, rdr io.Reader, size int64, contentType string, modTime time.Time) error {
+				ext := strings.ToLower(path.Ext(fileName))
+				if ext != ".js" && ext != ".json" {
+					return uploadValidated(fileName, rdr, size, contentType, modTime)
+				}
+
+				b, err := ioutil.ReadAll(rdr)
+				if err != nil {
+					return err
+				}
+				if err := checkSyntax(fileName, b, proj.ValidateJS, proj.ValidateJSON); err != nil {
+					mu.Lock()
+					syntaxErrors = append(syntaxErrors, fileName+": "+err.Error())
+					mu.Unlock()
+				}
+				return uploadValidated(fileName, bytes.NewReader(b), size, contentType, modTime)
+			}
+		}
+
+		// The _headers file at the root of the bundle is parsed into meta.json
+		// instead of being served.
+		var (
+			pathHeaders    []deployment.PathHeaders
+			pathHeadersErr error
+		)
+		uploadServed := upload
+		upload = func(fileName string, rdr io.Reader, size int64, contentType string, modTime time.Time) error {
+			if fileName != HeadersFileName {
+				return uploadServed(fileName, rdr, size, contentType, modTime)
+			}
+			headers, err := parseHeadersFile(rdr)
+			mu.Lock()
+			pathHeaders, pathHeadersErr = headers, err
+			mu.Unlock()
+			return nil
+		}
+
+		// Entries are read from the archive one at a time, as it can only be
+		// read sequentially, and are processed and uploaded by a pool of
+		// goroutines.
+		pool := newUploadPool(UploadConcurrency)
+		uploadEntry := func(fileName string, b []byte, contentType string, modTime time.Time) error {
+			var rdr io.Reader = bytes.NewReader(b)
+
+			if proj.MinifyHTML && contentType == "text/html" {
+				mr := minifyHTML(rdr)
+				defer mr.Close()
+				rdr = mr
+			}
+
+			// Inject "watermark" that links to PubStorm website for HTML pages.
+			if proj.Watermark &&
+				contentType == "text/html" &&
+				int64(len(b)) <= MaxFileSizeToWatermark {
+
+				var err error
+				rdr, err = injectWatermark(rdr)
+				if err != nil {
+					// Log and skip this file.
+					log.Printf("failed to inject watermark to %q, err: %v", fileName, err)
+					return nil
+				}
+			}
+
+			return upload(fileName, rdr, int64(len(b)), contentType, modTime)
+		}
+
+		// dispatch reads an entry into memory and hands it to the pool. It
+		// returns false once an upload has failed.
+		dispatch := func(fileName string, rdr io.Reader, contentType string, modTime time.Time) (bool, error) {
+			b, err := ioutil.ReadAll(rdr)
+			if err != nil {
+				return false, err
+			}
+			return pool.submit(func() error {
+				return uploadEntry(fileName, b, contentType, modTime)
+			}), nil
+		}
+
+		// abort stops the uploads that have not started yet and reports the
+		// first error, once the running ones are finished.
+		abort := func(err error) {
+			pool.fail(err)
+			errCh <- corruptBundleError(pool.wait())
+		}
+
+		// finish waits for the uploads and reports whether all of them
+		// succeeded.
+		finish := func() {
+			if err := pool.wait(); err != nil {
+				errCh <- corruptBundleError(err)
+				return
+			}
+			close(done)
+		}
+
 		if archiveFormat == "tar.gz" {
 			go func() {
 				gr, err := gzip.NewReader(f)
 				if err != nil {
-					errCh <- ErrUnarchiveFailed
+					errCh <- ErrCorruptBundle
 					return
 				}
 				defer gr.Close()
@@ -195,7 +754,7 @@ func Work(data []byte) error {
 						if err == io.EOF {
 							break
 						}
-						errCh <- err
+						abort(err)
 						return
 					}
 
@@ -204,20 +763,22 @@ func Work(data []byte) error {
 					}
 
 					fileName := path.Clean(hdr.Name)
-					remotePath := webroot + "/" + fileName
 
 					// Skip file with invalid filename
-					pathElements := strings.Split(fileName, string(filepath.Separator))
-					isValidFileName := true
-					for _, pathElement := range pathElements {
-						if r.MatchString(pathElement) {
-							isValidFileName = false
-							break
-						}
+					if !isValidFileName(fileName) {
+						log.Printf("filename contains invalid character: %q", fileName)
+						continue
 					}
 
-					if !isValidFileName {
-						log.Printf("filename contains invalid character: %q", fileName)
+					// Skip files where variants are stored, which would be
+					// overwritten by them.
+					if meta.IsVariantPath(fileName) {
+						log.Printf("filename is reserved for variants: %q", fileName)
+						reservedFiles = append(reservedFiles, fileName)
+						continue
+					}
+
+					if skip(fileName) {
 						continue
 					}
 
@@ -226,91 +787,93 @@ func Work(data []byte) error {
 						contentType = contentType[:i]
 					}
 
-					var rdr io.Reader = tr
-
-					// Inject "watermark" that links to PubStorm website for HTML pages.
-					// TODO We should do the watermarking and uploading in several worker
-					// goroutines.
-					if proj.Watermark &&
-						contentType == "text/html" &&
-						hdr.Size <= MaxFileSizeToWatermark {
-
-						var err error
-						rdr, err = injectWatermark(rdr)
-						if err != nil {
-							// Log and skip this file.
-							log.Printf("failed to inject watermark to %q, err: %v", hdr.Name, err)
-							continue
-						}
-					}
-
-					if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read"); err != nil {
-						errCh <- err
+					ok, err := dispatch(fileName, tr, contentType, hdr.ModTime)
+					if err != nil {
+						abort(err)
 						return
 					}
+					if !ok {
+						break
+					}
 				}
 
-				close(done)
+				finish()
 			}()
 		} else if archiveFormat == "zip" {
 			go func() {
 				r, err := zip.OpenReader(f.Name())
 				if err != nil {
-					errCh <- ErrUnarchiveFailed
+					errCh <- ErrCorruptBundle
 					return
 				}
 				defer r.Close()
 
 				for _, file := range r.File {
-					rc, err := file.Open()
-					if err != nil {
-						errCh <- err
-						return
+					if file.FileInfo().IsDir() {
+						continue
 					}
-					defer rc.Close()
 
-					if file.FileInfo().IsDir() {
+					fileName := path.Clean(file.Name)
+
+					// Skip file with invalid filename
+					if !isValidFileName(fileName) {
+						log.Printf("filename contains invalid character: %q", fileName)
 						continue
 					}
-					remotePath := webroot + "/" + file.Name
 
-					contentType := mime.TypeByExtension(filepath.Ext(file.Name))
+					// Skip files where variants are stored, which would be
+					// overwritten by them.
+					if meta.IsVariantPath(fileName) {
+						log.Printf("filename is reserved for variants: %q", fileName)
+						reservedFiles = append(reservedFiles, fileName)
+						continue
+					}
+
+					if skip(fileName) {
+						continue
+					}
+
+					contentType := mime.TypeByExtension(filepath.Ext(fileName))
 					if i := strings.Index(contentType, ";"); i != -1 {
 						contentType = contentType[:i]
 					}
 
-					var rdr io.Reader = rc
-
-					// Inject "watermark" that links to PubStorm website for HTML pages.
-					// TODO We should do the watermarking and uploading in several worker
-					// goroutines.
-					if proj.Watermark &&
-						contentType == "text/html" &&
-						file.FileInfo().Size() <= MaxFileSizeToWatermark {
-
-						var err error
-						rdr, err = injectWatermark(rdr)
-						if err != nil {
-							// Log and skip this file.
-							log.Printf("failed to inject watermark to %q, err: %v", file.Name, err)
-							continue
-						}
+					rc, err := file.Open()
+					if err != nil {
+						abort(err)
+						return
 					}
-
-					if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read"); err != nil {
-						errCh <- err
+					ok, err := dispatch(fileName, rc, contentType, file.ModTime())
+					rc.Close()
+					if err != nil {
+						abort(err)
 						return
 					}
+					if !ok {
+						break
+					}
 				}
-				close(done)
+
+				finish()
 			}()
 		}
 
 		select {
 		case <-done:
 		case err := <-errCh:
+			if err := progress.save(db, depl); err != nil {
+				log.Printf("failed to save upload progress of %s, err: %v", prefixID, err)
+			}
+			if err == ErrCorruptBundle {
+				return failCorruptBundle()
+			}
 			return err
 		case <-time.After(UploadTimeout):
+			pool.fail(ErrTimeout)
+			if err := progress.save(db, depl); err != nil {
+				log.Printf("failed to save upload progress of %s, err: %v", prefixID, err)
+			}
+
 			errorMessage := "Timed out due to too many files"
 			depl.ErrorMessage = &errorMessage
 			if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
@@ -320,63 +883,401 @@ func Work(data []byte) error {
 			return ErrTimeout
 		}
 
-		var envvars map[string]string
-		if err := json.Unmarshal(depl.JsEnvVars, &envvars); err != nil {
+		for _, v := range faviconVariants {
+			b, ok := fvs[v.name]
+			if !ok {
+				continue
+			}
+			if err := uploadFile(v.name, bytes.NewReader(b), int64(len(b)), "image/png", time.Time{}); err != nil {
+				return err
+			}
+		}
+
+		// A security.txt with the security contact of the project is generated
+		// unless the bundle has its own.
+		if proj.SecurityContact != nil {
+			hasSecurityTxt := false
+			for _, p := range securityTxtPaths {
+				if _, ok := progress.manifest[p]; ok {
+					hasSecurityTxt = true
+				}
+			}
+
+			if !hasSecurityTxt {
+				b := securityTxt(*proj.SecurityContact, time.Now())
+				if err := uploadFile(securityTxtPaths[0], bytes.NewReader(b), int64(len(b)), "text/plain", time.Time{}); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := progress.save(db, depl); err != nil {
 			return err
 		}
 
-		if err := S3.Upload(s3client.BucketRegion,
-			s3client.BucketName,
-			webroot+"/jsenv.js",
-			bytes.NewBufferString(fmt.Sprintf(jsenvFormat, depl.JsEnvVars)),
-			"application/javascript",
-			"public-read"); err != nil {
+		if len(mismatches) > 0 {
+			sort.Strings(mismatches)
+			errorMessage := "Deployment has files whose content does not match their type: " + strings.Join(mismatches, ", ")
+			depl.ErrorMessage = &errorMessage
+			return depl.UpdateState(db, deployment.StateDeployFailed)
+		}
+
+		if proj.StrictMixedContent && len(insecureResources) > 0 {
+			errorMessage := "Deployment has mixed content: " + strings.Join(mixedContentWarnings(insecureResources), ", ")
+			depl.ErrorMessage = &errorMessage
+			return depl.UpdateState(db, deployment.StateDeployFailed)
+		}
+
+		// Abort before anything is pointed at the deployment if any of the
+		// files the project requires is missing.
+		missing, err := missingRequiredFiles(proj, progress.manifest)
+		if err != nil {
 			return err
 		}
-	}
 
-	// the metadata file is also publicly readable, do not put sensitive data
-	metaJson, err := json.Marshal(struct {
-		Prefix            string  `json:"prefix"`
-		ForceHTTPS        bool    `json:"force_https,omitempty"`
-		BasicAuthUsername *string `json:"basic_auth_username,omitempty"`
-		BasicAuthPassword *string `json:"basic_auth_password,omitempty"`
-	}{
-		prefixID,
-		proj.ForceHTTPS,
-		proj.BasicAuthUsername,
-		proj.EncryptedBasicAuthPassword,
-	})
+		if len(missing) > 0 {
+			errorMessage := "Deployment is missing required files: " + strings.Join(missing, ", ")
+			depl.ErrorMessage = &errorMessage
+			return depl.UpdateState(db, deployment.StateDeployFailed)
+		}
+
+		if pathHeadersErr != nil {
+			errorMessage := "Invalid " + HeadersFileName + " file: " + pathHeadersErr.Error()
+			depl.ErrorMessage = &errorMessage
+			return depl.UpdateState(db, deployment.StateDeployFailed)
+		}
+
+		pathHeaders = addPreloadHints(pathHeaders, preloads)
+
+		if pathHeaders != nil {
+			pathHeadersJSON, err := json.Marshal(pathHeaders)
+			if err != nil {
+				return err
+			}
+
+			depl.PathHeaders = pathHeadersJSON
+			if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("path_headers", depl.PathHeaders).Error; err != nil {
+				return err
+			}
+		}
+
+		// Broken links, syntax errors, accessibility problems, mixed content
+		// and files left out for variants are only warned about, as the
+		// deployment may still be usable.
+		if links != nil || proj.ValidateJS || proj.ValidateJSON || proj.AccessibilityCheck || proj.CheckMixedContent || len(reservedFiles) > 0 {
+			warnings := []string{}
+			if links != nil {
+				var extraPaths []string
+				if uploadsJsEnv(proj, depl) {
+					extraPaths = append(extraPaths, proj.JsEnvPath())
+				}
+				if proj.AssetManifest {
+					extraPaths = append(extraPaths, AssetManifestFileName)
+				}
+				warnings = append(warnings, links.brokenLinks(progress.manifest, extraPaths...)...)
+			}
+
+			sort.Strings(syntaxErrors)
+			warnings = append(warnings, syntaxErrors...)
+			warnings = append(warnings, accessibilityWarnings(accessibilityIssues)...)
+			warnings = append(warnings, mixedContentWarnings(insecureResources)...)
+			warnings = append(warnings, reservedFileWarnings(reservedFiles)...)
+
+			warningsJSON, err := json.Marshal(warnings)
+			if err != nil {
+				return err
+			}
+
+			depl.Warnings = warningsJSON
+			if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("warnings", depl.Warnings).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(includeGlobs) > 0 || len(excludeGlobs) > 0 {
+			sort.Strings(skippedFiles)
+			if skippedFiles == nil {
+				skippedFiles = []string{}
+			}
+
+			skippedFilesJSON, err := json.Marshal(skippedFiles)
+			if err != nil {
+				return err
+			}
+
+			depl.SkippedFiles = skippedFilesJSON
+			if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("skipped_files", depl.SkippedFiles).Error; err != nil {
+				return err
+			}
+		}
+
+		// Edges respond with 410 Gone to the pages that were removed, if the
+		// project tombstones removed paths.
+		if proj.TombstoneRemovedPaths {
+			paths, err := tombstones(db, proj, depl, progress.manifest)
+			if err != nil {
+				return err
+			}
+
+			tombstonesJSON, err := json.Marshal(paths)
+			if err != nil {
+				return err
+			}
+
+			depl.Tombstones = tombstonesJSON
+			if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("tombstones", depl.Tombstones).Error; err != nil {
+				return err
+			}
+		}
+
+		variants := map[string][]string{}
+		for fileName, entry := range progress.manifest {
+			if len(entry.Encodings) > 0 {
+				variants[fileName] = entry.Encodings
+			}
+		}
+
+		variantsJSON, err := json.Marshal(variants)
+		if err != nil {
+			return err
+		}
+
+		depl.Variants = variantsJSON
+		depl.ImageBytesSaved = progress.manifest.BytesSaved()
+		depl.UncompressedBytes, depl.CompressedBytes = progress.manifest.CompressionTotals()
+		if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Updates(map[string]interface{}{
+			"variants":           depl.Variants,
+			"image_bytes_saved":  depl.ImageBytesSaved,
+			"uncompressed_bytes": depl.UncompressedBytes,
+			"compressed_bytes":   depl.CompressedBytes,
+		}).Error; err != nil {
+			return err
+		}
 
+		logLine := fmt.Sprintf("Uploaded %d files", len(progress.manifest))
+		if reused > 0 {
+			logLine += fmt.Sprintf(" (%d unchanged since the active deployment)", reused)
+		}
+		if err := depl.AppendLog(db, logLine); err != nil {
+			return err
+		}
+
+		// Projects that manage their own environment can turn this off so that
+		// their files are not clobbered.
+		if uploadsJsEnv(proj, depl) {
+			// An environment that is not a JSON object of strings is not worth
+			// failing the deployment over, so it is deployed empty instead.
+			envJSON := []byte("{}")
+			if envvars, err := depl.ParsedJsEnvVars(); err != nil {
+				log.Printf("deployment %s has invalid JS environment variables, deploying an empty environment, err: %v", prefixID, err)
+			} else if len(envvars) > 0 {
+				envJSON = depl.JsEnvVars
+			}
+
+			if err := uploadToTargets(failures, webroot+"/"+proj.JsEnvPath(),
+				bytes.NewBufferString(fmt.Sprintf(jsenvFormat, envJSON)),
+				"application/javascript"); err != nil {
+				return err
+			}
+		}
+
+		// The asset manifest lists the files as uploaded, so it is built from
+		// the manifest of the deployment once all of them are.
+		if proj.AssetManifest {
+			assetManifestJSON, err := assetManifest(progress.manifest)
+			if err != nil {
+				return err
+			}
+
+			if err := uploadToTargets(failures, webroot+"/"+AssetManifestFileName,
+				bytes.NewReader(assetManifestJSON),
+				"application/json"); err != nil {
+				return err
+			}
+		}
+	}
+
+	m, err := meta.New(proj, depl)
 	if err != nil {
 		return err
 	}
 
-	domainNames, err := proj.DomainNames(db)
+	metaJson, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
 
-	// Upload metadata file for each domain.
+	// The settings are snapshotted when the webroot is uploaded, so that a
+	// rollback or a config update does not overwrite the settings that the
+	// deployment went live with. Deployments that predate snapshots get one
+	// the first time they are deployed again.
+	if !d.SkipWebrootUpload || len(depl.ProjectSettings) == 0 {
+		settingsJSON, err := json.Marshal(proj.Settings())
+		if err != nil {
+			return err
+		}
+
+		depl.ProjectSettings = settingsJSON
+		if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("project_settings", depl.ProjectSettings).Error; err != nil {
+			return err
+		}
+	}
+
+	// A deployment with a newly uploaded webroot only goes live on the
+	// project's domains if the project auto-publishes. Deployments that skip
+	// the webroot upload (e.g. rollbacks, publishes and config updates) are
+	// always published.
+	publish := d.SkipWebrootUpload || proj.AutoPublish
+
+	var (
+		invalidationDomains []string
+		failedMetaDomains   = []string{}
+
+		// The domains that were invalidated, which are recorded on the
+		// deployment.
+		invalidatedDomains = []string{}
+
+		// The project's domains are invalidated separately when only the
+		// files that changed since the active deployment need invalidating.
+		publishedDomains []string
+		changedPaths     []string
+	)
 	reader := bytes.NewReader(metaJson)
-	for _, domain := range domainNames {
+
+	// Every newly uploaded deployment can be previewed at its own domain, and
+	// the staging alias is repointed to it, whether or not it gets published.
+	if !d.SkipWebrootUpload {
+		if err := uploadToTargets(failures, meta.Path(depl.PreviewDomainName()), reader, "application/json"); err != nil {
+			return err
+		}
+
 		reader.Seek(0, 0)
-		if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, "domains/"+domain+"/meta.json", reader, "application/json", "public-read"); err != nil {
+		if err := uploadToTargets(failures, meta.SubpathPath(shared.PublicBaseDomain, depl.VanityPath(proj.Name)), reader, "application/json"); err != nil {
+			return err
+		}
+
+		stagingDomain := proj.AliasDomainName(project.StagingAlias)
+		taken, err := proj.AliasDomainTaken(db, project.StagingAlias)
+		if err != nil {
 			return err
 		}
+		if taken {
+			log.Printf("not updating staging alias %s of deployment %s, it is the default domain of another project", stagingDomain, prefixID)
+		} else {
+			reader.Seek(0, 0)
+			if err := uploadToTargets(failures, meta.Path(stagingDomain), reader, "application/json"); err != nil {
+				return err
+			}
+			invalidationDomains = append(invalidationDomains, stagingDomain)
+		}
 	}
 
-	if !d.SkipInvalidation {
-		m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-			Domains: domainNames,
-		})
+	// A newly uploaded deployment has to pass the project's publish gate, if
+	// there is one, before going live. The gate may check the deployment
+	// through the staging alias, so the alias is invalidated beforehand.
+	if publish && !d.SkipWebrootUpload && proj.PublishGateURL != nil {
+		if !d.SkipInvalidation {
+			if err := invalidate(invalidationDomains, nil, 0); err != nil {
+				return err
+			}
+			invalidatedDomains = append(invalidatedDomains, invalidationDomains...)
+		}
+		invalidationDomains = nil
+
+		if err := checkPublishGate(proj, depl); err != nil {
+			log.Printf("deployment %s did not pass the publish gate, err: %v", prefixID, err)
+			errorMessage := "Publish gate check failed: " + err.Error()
+			depl.ErrorMessage = &errorMessage
+			publish = false
+		}
+	}
+
+	if publish {
+		domainNames, err := proj.DomainNames(db)
+		if err != nil {
+			return err
+		}
+
+		if !d.SkipWebrootUpload && !d.SkipInvalidation {
+			changedPaths, err = invalidationPaths(db, proj, depl)
+			if err != nil {
+				return err
+			}
+		}
+
+		dm, err := domainMeta(db, proj, depl, m)
+		if err != nil {
+			return err
+		}
+
+		certIDs, err := proj.DomainCertIDs(db)
 		if err != nil {
 			return err
 		}
 
-		if err := m.Publish(); err != nil {
+		domainMetas, err := domainMetaJSONs(domainNames, certIDs, dm)
+		if err != nil {
+			return err
+		}
+
+		// Upload metadata file for each domain. The deployment is live once the
+		// primary (i.e. first) domain points to it, so failures on the other
+		// domains are recorded for a retry instead of failing the deploy.
+		uploadErrs := uploadDomainMetas(failures, domainNames, domainMetas)
+		for i, domain := range domainNames {
+			if err := uploadErrs[i]; err != nil {
+				if i == 0 {
+					return err
+				}
+				log.Printf("failed to upload meta.json of %q for %s, err: %v", domain, prefixID, err)
+				failedMetaDomains = append(failedMetaDomains, domain)
+				continue
+			}
+			if changedPaths != nil {
+				publishedDomains = append(publishedDomains, domain)
+			} else {
+				invalidationDomains = append(invalidationDomains, domain)
+			}
+		}
+	}
+
+	if !d.SkipInvalidation {
+		// Edges acknowledge these invalidations, which completes the
+		// invalidation of the deployment.
+		if len(invalidationDomains) > 0 || len(publishedDomains) > 0 {
+			if err := depl.MarkInvalidationPending(db); err != nil {
+				return err
+			}
+		}
+		if err := invalidate(invalidationDomains, nil, depl.ID); err != nil {
+			return err
+		}
+		if err := invalidate(publishedDomains, changedPaths, depl.ID); err != nil {
 			return err
 		}
+		invalidatedDomains = append(invalidatedDomains, invalidationDomains...)
+		invalidatedDomains = append(invalidatedDomains, publishedDomains...)
+	}
+
+	invalidatedDomainsJSON, err := json.Marshal(invalidatedDomains)
+	if err != nil {
+		return err
+	}
+
+	depl.InvalidatedDomains = invalidatedDomainsJSON
+	depl.InvalidationSkipped = d.SkipInvalidation
+	if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Updates(map[string]interface{}{
+		"invalidated_domains":  depl.InvalidatedDomains,
+		"invalidation_skipped": depl.InvalidationSkipped,
+	}).Error; err != nil {
+		return err
+	}
+
+	if !publish {
+		if err := recordFailedUploads(db, depl, failures); err != nil {
+			log.Printf("failed to record failed uploads of %s, err: %v", prefixID, err)
+		}
+		return depl.UpdateState(db, deployment.StateUnpublished)
 	}
 
 	tx := db.Begin()
@@ -389,10 +1290,29 @@ func Work(data []byte) error {
 		return err
 	}
 
+	failedMetaDomainsJSON, err := json.Marshal(failedMetaDomains)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("failed_meta_domains", failedMetaDomainsJSON).Error; err != nil {
+		return err
+	}
+
 	if err := tx.Model(project.Project{}).Where("id = ?", proj.ID).Update("active_deployment_id", &depl.ID).Error; err != nil {
 		return err
 	}
 
+	// A canary that goes live on its own is no longer a canary.
+	if proj.CanaryDeploymentID != nil && *proj.CanaryDeploymentID == depl.ID {
+		if err := tx.Model(project.Project{}).Where("id = ?", proj.ID).Updates(map[string]interface{}{
+			"canary_deployment_id": nil,
+			"canary_percent":       0,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
 	// If project has exceeded its max number of deployments (N), we soft delete
 	// deployments older than the last N deployments.
 	if proj.MaxDeploysKept > 0 {
@@ -401,11 +1321,26 @@ func Work(data []byte) error {
 		}
 	}
 
+	// Deployments deployed longer ago than the project keeps deployments for
+	// are soft deleted too, even if there are fewer than N of them.
+	if proj.DeployRetentionDays > 0 {
+		if err := deployment.DeleteOlderThan(tx, proj.ID, proj.DeployRetentionDays, depl.ID); err != nil {
+			return err
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return err
 	}
 
-	{
+	// The deployment is out, so files that are missing from some of the
+	// targets are repaired by a job of their own.
+	if err := recordFailedUploads(db, depl, failures); err != nil {
+		log.Printf("failed to record failed uploads of %s, err: %v", prefixID, err)
+	}
+
+	// Deploys of projects that opted out of analytics are not tracked.
+	if !proj.AnalyticsOptOut {
 		var u user.User
 		if err := db.First(&u, depl.UserID).Error; err == nil {
 			var (
@@ -420,12 +1355,106 @@ func Work(data []byte) error {
 				}
 				context map[string]interface{}
 			)
-			if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
-				log.Printf("failed to track %q event for user ID %d, err: %v",
-					event, u.ID, err)
+			if depl.Label != nil {
+				props["deploymentLabel"] = *depl.Label
+			}
+			if depl.Branch != nil {
+				props["branch"] = *depl.Branch
+			}
+			if depl.CommitSHA != nil {
+				props["commit"] = *depl.CommitSHA
 			}
+			if wait := depl.QueueWait(); wait != nil {
+				props["queueWaitInSeconds"] = int64(*wait / time.Second)
+			}
+			track(strconv.Itoa(int(u.ID)), event, props, context)
 		}
 	}
 
 	return nil
 }
+
+// invalidate tells edges to drop their cached meta of the given domains, and
+// either the given paths on them or, if there are none, everything else they
+// have cached for them. Edges acknowledge the invalidation if it is for a
+// deployment, i.e. deploymentID is not 0.
+func invalidate(domains, paths []string, deploymentID uint) error {
+	if len(domains) == 0 {
+		return nil
+	}
+
+	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
+		Domains:      domains,
+		Paths:        paths,
+		DeploymentID: deploymentID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.Publish()
+}
+
+// uploadsJsEnv returns whether the JS environment file of the project is
+// uploaded with the deployment.
+func uploadsJsEnv(proj *project.Project, depl *deployment.Deployment) bool {
+	return !proj.JsEnvDisabled && !depl.SkipJsEnv
+}
+
+// invalidationPaths returns the paths that changed between the webroot of the
+// project's active deployment and that of depl, or nil if the domains of the
+// project should be invalidated in full. This is the case when there is no
+// active deployment to compare against, when either deployment has no
+// manifest (e.g. it was deployed before manifests were kept), or when no
+// files changed, in which case something other than the files (e.g. the JS
+// environment) did.
+func invalidationPaths(db *gorm.DB, proj *project.Project, depl *deployment.Deployment) ([]string, error) {
+	if proj.ActiveDeploymentID == nil || *proj.ActiveDeploymentID == depl.ID {
+		return nil, nil
+	}
+
+	active := &deployment.Deployment{}
+	if err := db.First(active, *proj.ActiveDeploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prevManifest, err := active.ParsedManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := depl.ParsedManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(prevManifest) == 0 || len(manifest) == 0 {
+		return nil, nil
+	}
+
+	changed := manifest.ChangedPaths(prevManifest)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	var paths []string
+	for _, fileName := range changed {
+		paths = append(paths, servedPaths(fileName)...)
+	}
+
+	// The JS environment is not in the manifest but is uploaded anew with
+	// every deployment.
+	if uploadsJsEnv(proj, depl) {
+		paths = append(paths, "/"+proj.JsEnvPath())
+	}
+
+	// So is the asset manifest, which lists the files that changed.
+	if proj.AssetManifest {
+		paths = append(paths, "/"+AssetManifestFileName)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,4610 @@
+package deployer_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/subscription"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/deployer/deployer"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/meta"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/streadway/amqp"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deployer")
+}
+
+var _ = Describe("Deployer", func() {
+	var (
+		fakeS3      *fake.S3
+		origS3      filetransfer.FileTransfer
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		origUploadRetryInterval time.Duration
+
+		err error
+
+		db *gorm.DB
+		mq *amqp.Connection
+
+		u    *user.User
+		proj *project.Project
+		depl *deployment.Deployment
+
+		invalidationQueueName string
+	)
+
+	BeforeEach(func() {
+		origS3 = deployer.S3
+		fakeS3 = &fake.S3{}
+		deployer.S3 = fakeS3
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		origUploadRetryInterval = deployer.UploadRetryInterval
+		deployer.UploadRetryInterval = 0
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+		testhelper.DeleteQueue(mq, queues.All...)
+		testhelper.DeleteExchange(mq, exchanges.All...)
+
+		invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+
+		u = factories.User(db)
+		proj = factories.Project(db, u, "pubstorm-www")
+		factories.Domain(db, proj, "www.pubstorm.com")
+		depl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+		fakeS3.DownloadContent, err = ioutil.ReadFile("../../testhelper/fixtures/website.tar.gz")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		deployer.S3 = origS3
+		common.Tracker = origTracker
+		deployer.UploadRetryInterval = origUploadRetryInterval
+	})
+
+	// uploadedContent returns the content last uploaded to the given key, or
+	// nil if nothing was uploaded to it.
+	uploadedContent := func(key string) []byte {
+		var content []byte
+		for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+			call := fakeS3.UploadCalls.NthCall(i)
+			if call.Arguments[2] == key {
+				content, _ = call.SideEffects["uploaded_content"].([]byte)
+			}
+		}
+		return content
+	}
+
+	// withETags adds the ETags of the files in the manifest of the deployment
+	// to the given meta.json.
+	withETags := func(metaJSON string) string {
+		d := &deployment.Deployment{}
+		Expect(db.First(d, depl.ID).Error).To(BeNil())
+		manifest, err := d.ParsedManifest()
+		Expect(err).To(BeNil())
+
+		m := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(metaJSON), &m)).To(BeNil())
+		tags := map[string]string{}
+		for path, entry := range manifest {
+			tags[path] = entry.ETag
+		}
+		m["etags"] = tags
+
+		b, err := json.Marshal(m)
+		Expect(err).To(BeNil())
+		return string(b)
+	}
+
+	invalidatedDomains := func() []string {
+		d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+		Expect(d).NotTo(BeNil())
+
+		m := &messages.V1InvalidationMessageData{}
+		Expect(json.Unmarshal(d.Body, m)).To(BeNil())
+		return m.Domains
+	}
+
+	Describe("tracking", func() {
+		It("tracks a 'Project Deployed' event", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Eventually(func() *fake.Call { return fakeTracker.TrackCalls.NthCall(1) }).ShouldNot(BeNil())
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Project Deployed"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal("pubstorm-www"))
+			Expect(props["deploymentId"]).To(Equal(depl.ID))
+			Expect(props).NotTo(HaveKey("deploymentLabel"))
+			Expect(props).NotTo(HaveKey("branch"))
+			Expect(props).NotTo(HaveKey("commit"))
+		})
+
+		Context("when the deployment is annotated", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Updates(map[string]interface{}{
+					"label":      "launch day",
+					"branch":     "master",
+					"commit_sha": "5e908dc1f01e",
+				}).Error).To(BeNil())
+			})
+
+			It("includes the annotations in the event props", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Eventually(func() *fake.Call { return fakeTracker.TrackCalls.NthCall(1) }).ShouldNot(BeNil())
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+
+				props, ok := trackCall.Arguments[3].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["deploymentLabel"]).To(Equal("launch day"))
+				Expect(props["branch"]).To(Equal("master"))
+				Expect(props["commit"]).To(Equal("5e908dc1f01e"))
+			})
+		})
+
+		Context("when the project opted out of analytics", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("analytics_opt_out", true).Error).To(BeNil())
+			})
+
+			It("does not track the deploy", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				Consistently(func() int { return fakeTracker.TrackCalls.Count() }).Should(Equal(0))
+			})
+		})
+	})
+
+	Describe("slow tracking", func() {
+		var (
+			origTrackTimeout time.Duration
+			slow             *slowTracker
+		)
+
+		BeforeEach(func() {
+			origTrackTimeout = deployer.TrackTimeout
+			deployer.TrackTimeout = 5 * time.Second
+
+			slow = &slowTracker{Tracker: fakeTracker, delay: 2 * time.Second}
+			common.Tracker = slow
+		})
+
+		AfterEach(func() {
+			deployer.TrackTimeout = origTrackTimeout
+		})
+
+		It("does not wait for the event to be tracked", func() {
+			start := time.Now()
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+			Expect(time.Since(start)).To(BeNumerically("<", slow.delay))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+			Eventually(func() *fake.Call {
+				return fakeTracker.TrackCalls.NthCall(1)
+			}, 2*slow.delay).ShouldNot(BeNil())
+		})
+	})
+
+	Describe("variants", func() {
+		It("uploads gzipped variants of text assets apart from them and lists the encodings of each asset in meta.json", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			for _, fileName := range []string{"index.html", "js/app.js", "css/app.css"} {
+				gzipped := uploadedContent(webroot + ".variants/gz/" + fileName)
+				Expect(gzipped).NotTo(BeNil())
+
+				gr, err := gzip.NewReader(bytes.NewReader(gzipped))
+				Expect(err).To(BeNil())
+				content, err := ioutil.ReadAll(gr)
+				Expect(err).To(BeNil())
+				Expect(content).To(Equal(uploadedContent(webroot + fileName)))
+			}
+
+			Expect(uploadedContent(webroot + "images/astley.jpg")).NotTo(BeNil())
+			Expect(uploadedContent(webroot + ".variants/gz/images/astley.jpg")).To(BeNil())
+
+			Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(MatchJSON(withETags(fmt.Sprintf(`{
+				"prefix": "%s",
+				"variants": {
+					"index.html": ["identity", "gzip"],
+					"js/app.js": ["identity", "gzip"],
+					"css/app.css": ["identity", "gzip"]
+				}
+			}`, depl.PrefixID()))))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.Variants).To(MatchJSON(`{
+				"index.html": ["identity", "gzip"],
+				"js/app.js": ["identity", "gzip"],
+				"css/app.css": ["identity", "gzip"]
+			}`))
+		})
+
+		Context("when the bundle has files where variants are stored", func() {
+			BeforeEach(func() {
+				files := map[string]string{
+					"index.html":              "<html><body>Hello</body></html>",
+					".variants/gz/index.html": "not gzipped",
+				}
+
+				bundle := new(bytes.Buffer)
+				gw := gzip.NewWriter(bundle)
+				tw := tar.NewWriter(gw)
+				for _, name := range []string{"index.html", ".variants/gz/index.html"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))})).To(BeNil())
+					_, err = tw.Write([]byte(files[name]))
+					Expect(err).To(BeNil())
+				}
+				Expect(tw.Close()).To(BeNil())
+				Expect(gw.Close()).To(BeNil())
+				fakeS3.DownloadContent = bundle.Bytes()
+			})
+
+			It("skips them so that they do not collide with the variants", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				var variantUploads int
+				for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+					if fakeS3.UploadCalls.NthCall(i).Arguments[2] == webroot+".variants/gz/index.html" {
+						variantUploads++
+					}
+				}
+				Expect(variantUploads).To(Equal(1))
+
+				gr, err := gzip.NewReader(bytes.NewReader(uploadedContent(webroot + ".variants/gz/index.html")))
+				Expect(err).To(BeNil())
+				content, err := ioutil.ReadAll(gr)
+				Expect(err).To(BeNil())
+				Expect(content).To(Equal(uploadedContent(webroot + "index.html")))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				manifest, err := depl.ParsedManifest()
+				Expect(err).To(BeNil())
+				Expect(manifest).To(HaveKey("index.html"))
+				Expect(manifest).NotTo(HaveKey(".variants/gz/index.html"))
+
+				warnings, err := depl.WarningMessages()
+				Expect(err).To(BeNil())
+				Expect(warnings).To(Equal([]string{
+					".variants/gz/index.html was not deployed, as .variants/ is reserved for compressed variants",
+				}))
+			})
+		})
+
+		It("records the ETag of each uploaded file in the manifest and lists them in meta.json", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			manifest, err := depl.ParsedManifest()
+			Expect(err).To(BeNil())
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			for _, fileName := range []string{"index.html", "js/app.js", "css/app.css", "images/astley.jpg"} {
+				Expect(manifest).To(HaveKey(fileName))
+				sum := md5.Sum(uploadedContent(webroot + fileName))
+				Expect(manifest[fileName].ETag).To(Equal(hex.EncodeToString(sum[:])), fileName)
+			}
+
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			Expect(m.ETags).To(HaveLen(len(manifest)))
+			for fileName, entry := range manifest {
+				Expect(m.ETags[fileName]).To(Equal(entry.ETag), fileName)
+			}
+		})
+
+		It("records the total sizes of the gzipped assets before and after compression", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			var original, compressed int64
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			for _, fileName := range []string{"index.html", "js/app.js", "css/app.css"} {
+				original += int64(len(uploadedContent(webroot + fileName)))
+				compressed += int64(len(uploadedContent(webroot + ".variants/gz/" + fileName)))
+			}
+			Expect(original).NotTo(BeZero())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.UncompressedBytes).To(Equal(original))
+			Expect(depl.CompressedBytes).To(Equal(compressed))
+
+			summary := depl.AsJSON().Compression
+			Expect(summary).NotTo(BeNil())
+			Expect(summary.OriginalBytes).To(Equal(original))
+			Expect(summary.CompressedBytes).To(Equal(compressed))
+			Expect(summary.Ratio).To(BeNumerically("~", float64(compressed)/float64(original), 1e-9))
+		})
+	})
+
+	Describe("project settings snapshot", func() {
+		BeforeEach(func() {
+			Expect(db.Model(proj).Updates(map[string]interface{}{
+				"force_https": true,
+				"watermark":   false,
+			}).Error).To(BeNil())
+		})
+
+		It("snapshots the settings of the project that went into the meta", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.ProjectSettings).To(MatchJSON(`{"force_https": true, "watermark": false}`))
+		})
+
+		It("snapshots settings that can be restored onto the project", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			settings, err := project.SnapshottedSettings(depl)
+			Expect(err).To(BeNil())
+			Expect(settings).NotTo(BeNil())
+
+			restored := &project.Project{Watermark: true}
+			restored.ApplySettings(settings)
+			Expect(restored.ForceHTTPS).To(BeTrue())
+			Expect(restored.Watermark).To(BeFalse())
+		})
+
+		Context("when the job skips the webroot upload", func() {
+			rollBack := func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+			}
+
+			BeforeEach(func() {
+				Expect(depl.UpdateState(db, deployment.StatePendingRollback)).To(BeNil())
+			})
+
+			It("keeps the snapshot the deployment went live with", func() {
+				Expect(db.Model(depl).Update("project_settings", []byte(`{"force_https": false, "watermark": true}`)).Error).To(BeNil())
+
+				rollBack()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.ProjectSettings).To(MatchJSON(`{"force_https": false, "watermark": true}`))
+			})
+
+			It("snapshots the settings if the deployment has no snapshot", func() {
+				rollBack()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.ProjectSettings).To(MatchJSON(`{"force_https": true, "watermark": false}`))
+			})
+		})
+	})
+
+	Describe("preview domain", func() {
+		var origPublicBaseDomain string
+
+		BeforeEach(func() {
+			origPublicBaseDomain = shared.PublicBaseDomain
+			shared.PublicBaseDomain = "preview.example.com"
+		})
+
+		AfterEach(func() {
+			shared.PublicBaseDomain = origPublicBaseDomain
+		})
+
+		It("uploads the meta of the deployment under the configured base domain", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			content := uploadedContent("domains/" + depl.PrefixID() + ".preview.example.com/meta.json")
+			Expect(content).NotTo(BeNil())
+			Expect(string(content)).To(ContainSubstring(`"prefix":"` + depl.PrefixID() + `"`))
+		})
+
+		It("uploads the meta of the deployment under its vanity path on the base domain", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			content := uploadedContent("domains/preview.example.com/paths/pubstorm-www/" + depl.PrefixID() + "/meta.json")
+			Expect(content).NotTo(BeNil())
+			Expect(string(content)).To(ContainSubstring(`"prefix":"` + depl.PrefixID() + `"`))
+		})
+	})
+
+	Describe("staging alias", func() {
+		var (
+			stagingDomain string
+			metaJSON      string
+		)
+
+		BeforeEach(func() {
+			stagingDomain = "pubstorm-www--staging." + shared.DefaultDomain
+			metaJSON = fmt.Sprintf(`{
+				"prefix": "%s",
+				"variants": {
+					"index.html": ["identity", "gzip"],
+					"js/app.js": ["identity", "gzip"],
+					"css/app.css": ["identity", "gzip"]
+				}
+			}`, depl.PrefixID())
+		})
+
+		Context("when the project is set to auto publish", func() {
+			It("points the staging alias and all the domains of the project at the deployment", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedContent("domains/" + stagingDomain + "/meta.json")).To(MatchJSON(withETags(metaJSON)))
+				Expect(uploadedContent("domains/pubstorm-www." + shared.DefaultDomain + "/meta.json")).To(MatchJSON(withETags(metaJSON)))
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(MatchJSON(withETags(metaJSON)))
+
+				Expect(invalidatedDomains()).To(ConsistOf(
+					stagingDomain,
+					"pubstorm-www."+shared.DefaultDomain,
+					"www.pubstorm.com",
+				))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ActiveDeploymentID).NotTo(BeNil())
+				Expect(*proj.ActiveDeploymentID).To(Equal(depl.ID))
+			})
+		})
+
+		Context("when the staging alias domain is the default domain of another project", func() {
+			BeforeEach(func() {
+				// Projects named like this predate names with "--" being
+				// rejected.
+				factories.Project(db, nil, "pubstorm-www--staging")
+			})
+
+			It("does not overwrite the meta.json of the other project", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedContent("domains/" + stagingDomain + "/meta.json")).To(BeNil())
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(MatchJSON(withETags(metaJSON)))
+
+				Expect(invalidatedDomains()).To(ConsistOf(
+					"pubstorm-www."+shared.DefaultDomain,
+					"www.pubstorm.com",
+				))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when the project is not set to auto publish", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("auto_publish", false).Error).To(BeNil())
+			})
+
+			It("points only the staging alias at the deployment and leaves it unpublished", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedContent("domains/" + stagingDomain + "/meta.json")).To(MatchJSON(withETags(metaJSON)))
+				Expect(uploadedContent("domains/pubstorm-www." + shared.DefaultDomain + "/meta.json")).To(BeNil())
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(BeNil())
+
+				Expect(invalidatedDomains()).To(ConsistOf(stagingDomain))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateUnpublished))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ActiveDeploymentID).To(BeNil())
+			})
+
+			Context("when an unpublished deployment is published", func() {
+				BeforeEach(func() {
+					Expect(depl.UpdateState(db, deployment.StatePendingDeploy)).To(BeNil())
+				})
+
+				It("points the domains of the project at the deployment without touching the staging alias", func() {
+					err = deployer.Work([]byte(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true
+					}`, depl.ID)))
+					Expect(err).To(BeNil())
+
+					Expect(uploadedContent("domains/" + stagingDomain + "/meta.json")).To(BeNil())
+					Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(MatchJSON(fmt.Sprintf(`{"prefix": "%s"}`, depl.PrefixID())))
+
+					Expect(invalidatedDomains()).To(ConsistOf(
+						"pubstorm-www."+shared.DefaultDomain,
+						"www.pubstorm.com",
+					))
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+					Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+					Expect(db.First(proj, proj.ID).Error).To(BeNil())
+					Expect(proj.ActiveDeploymentID).NotTo(BeNil())
+					Expect(*proj.ActiveDeploymentID).To(Equal(depl.ID))
+				})
+			})
+		})
+	})
+
+	Describe("publish gate", func() {
+		var (
+			gate       *httptest.Server
+			gateStatus int
+			gateBodies []map[string]interface{}
+
+			origRetryInterval time.Duration
+		)
+
+		BeforeEach(func() {
+			gateStatus = http.StatusOK
+			gateBodies = nil
+
+			gate = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := map[string]interface{}{}
+				json.NewDecoder(r.Body).Decode(&body)
+				gateBodies = append(gateBodies, body)
+				w.WriteHeader(gateStatus)
+			}))
+
+			gateURL := gate.URL
+			Expect(db.Model(proj).Update("publish_gate_url", &gateURL).Error).To(BeNil())
+
+			origRetryInterval = deployer.PublishGateRetryInterval
+			deployer.PublishGateRetryInterval = 0
+		})
+
+		AfterEach(func() {
+			gate.Close()
+			deployer.PublishGateRetryInterval = origRetryInterval
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the gate passes", func() {
+			It("publishes the deployment after checking the gate with the staging alias", func() {
+				doWork()
+
+				Expect(gateBodies).To(HaveLen(1))
+				Expect(gateBodies[0]["project_name"]).To(Equal("pubstorm-www"))
+				Expect(gateBodies[0]["deployment_id"]).To(BeEquivalentTo(depl.ID))
+				Expect(gateBodies[0]["preview_url"]).To(Equal("https://pubstorm-www--staging." + shared.DefaultDomain))
+
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).NotTo(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ActiveDeploymentID).NotTo(BeNil())
+				Expect(*proj.ActiveDeploymentID).To(Equal(depl.ID))
+			})
+		})
+
+		Context("when the gate fails", func() {
+			BeforeEach(func() {
+				gateStatus = http.StatusServiceUnavailable
+			})
+
+			It("retries the gate and leaves the deployment unpublished", func() {
+				doWork()
+
+				Expect(gateBodies).To(HaveLen(deployer.PublishGateAttempts))
+
+				Expect(uploadedContent("domains/pubstorm-www--staging." + shared.DefaultDomain + "/meta.json")).NotTo(BeNil())
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateUnpublished))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(ContainSubstring("503"))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ActiveDeploymentID).To(BeNil())
+			})
+		})
+	})
+
+	Describe("pre-deploy hook", func() {
+		var (
+			hook       *httptest.Server
+			hookStatus int
+			hookDelay  time.Duration
+			hookBodies []map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			hookStatus = http.StatusOK
+			hookDelay = 0
+			hookBodies = nil
+
+			hook = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(hookDelay)
+				body := map[string]interface{}{}
+				json.NewDecoder(r.Body).Decode(&body)
+				hookBodies = append(hookBodies, body)
+				w.WriteHeader(hookStatus)
+			}))
+
+			hookURL := hook.URL
+			Expect(db.Model(proj).Update("pre_deploy_hook_url", &hookURL).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			hook.Close()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the hook allows the deploy", func() {
+			It("calls the hook with the deployment and deploys it", func() {
+				doWork()
+
+				Expect(hookBodies).To(HaveLen(1))
+				Expect(hookBodies[0]["project_name"]).To(Equal("pubstorm-www"))
+				Expect(hookBodies[0]["deployment_id"]).To(BeEquivalentTo(depl.ID))
+				Expect(hookBodies[0]["deployment_version"]).To(BeEquivalentTo(depl.Version))
+
+				Expect(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/index.html")).NotTo(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when the hook blocks the deploy", func() {
+			BeforeEach(func() {
+				hookStatus = http.StatusUnprocessableEntity
+			})
+
+			It("fails the deployment without retrying the hook or uploading any files", func() {
+				doWork()
+
+				Expect(hookBodies).To(HaveLen(1))
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(ContainSubstring("422"))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ActiveDeploymentID).To(BeNil())
+			})
+		})
+
+		Context("when the hook does not respond in time", func() {
+			var origTimeout time.Duration
+
+			BeforeEach(func() {
+				origTimeout = deployer.PreDeployHookTimeout
+				deployer.PreDeployHookTimeout = 50 * time.Millisecond
+				hookDelay = 200 * time.Millisecond
+			})
+
+			AfterEach(func() {
+				deployer.PreDeployHookTimeout = origTimeout
+			})
+
+			It("fails the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+			})
+		})
+	})
+
+	Describe("required files", func() {
+		var activeDepl *deployment.Deployment
+
+		BeforeEach(func() {
+			activeDepl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(db.Model(proj).Update("active_deployment_id", activeDepl.ID).Error).To(BeNil())
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the bundle contains all the required files", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("required_files", []byte(`["index.html", "js/app.js"]`)).Error).To(BeNil())
+			})
+
+			It("deploys the bundle", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(*proj.ActiveDeploymentID).To(Equal(depl.ID))
+			})
+		})
+
+		Context("when the bundle is missing a required file", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("required_files", []byte(`["index.html", "404.html", "robots.txt"]`)).Error).To(BeNil())
+			})
+
+			It("fails the deployment and leaves the live site unchanged", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(Equal("Deployment is missing required files: 404.html, robots.txt"))
+
+				Expect(uploadedContent("domains/pubstorm-www--staging." + shared.DefaultDomain + "/meta.json")).To(BeNil())
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(BeNil())
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(*proj.ActiveDeploymentID).To(Equal(activeDepl.ID))
+			})
+		})
+	})
+
+	Describe("strict content types", func() {
+		var activeDepl *deployment.Deployment
+
+		BeforeEach(func() {
+			activeDepl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(db.Model(proj).Update("active_deployment_id", activeDepl.ID).Error).To(BeNil())
+
+			// A script that is actually an HTML page.
+			content := []byte("<!DOCTYPE html><html><body><script>alert(1)</script></body></html>")
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			Expect(tw.WriteHeader(&tar.Header{Name: "js/app.js", Mode: 0644, Size: int64(len(content))})).To(BeNil())
+			_, err = tw.Write(content)
+			Expect(err).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("deploys files regardless of their content", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+			Expect(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/js/app.js")).NotTo(BeNil())
+		})
+
+		Context("when the project has strict content types on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("strict_content_types", true).Error).To(BeNil())
+			})
+
+			It("rejects the deployment with a report of the mismatched files", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(Equal("Deployment has files whose content does not match their type: js/app.js (application/javascript, but looks like text/html)"))
+
+				Expect(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/js/app.js")).To(BeNil())
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(BeNil())
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(*proj.ActiveDeploymentID).To(Equal(activeDepl.ID))
+			})
+
+			It("deploys files whose content matches their type", func() {
+				fakeS3.DownloadContent, err = ioutil.ReadFile("../../testhelper/fixtures/website.tar.gz")
+				Expect(err).To(BeNil())
+
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+	})
+
+	Describe("HTML minification", func() {
+		var (
+			htmlContent string
+			htmlKey     string
+		)
+
+		BeforeEach(func() {
+			Expect(db.Model(proj).Update("watermark", false).Error).To(BeNil())
+
+			htmlContent = "<!DOCTYPE html>\n<html>\n  <body>\n" +
+				"    <!-- navigation -->\n" +
+				"    <!--[if IE]><p>Please upgrade your browser</p><![endif]-->\n" +
+				"    <h1>  Hello,   world  </h1>\n" +
+				"    <pre>\n  keep    this\n    as is</pre>\n" +
+				"    <textarea>  and\n\n  this </textarea>\n" +
+				"  </body>\n</html>\n"
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			Expect(tw.WriteHeader(&tar.Header{Name: "index.html", Mode: 0644, Size: int64(len(htmlContent))})).To(BeNil())
+			_, err = tw.Write([]byte(htmlContent))
+			Expect(err).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+
+			htmlKey = "deployments/" + depl.PrefixID() + "/webroot/index.html"
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("uploads HTML files verbatim", func() {
+			doWork()
+			Expect(string(uploadedContent(htmlKey))).To(Equal(htmlContent))
+		})
+
+		Context("when the project has HTML minification on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("minify_html", true).Error).To(BeNil())
+			})
+
+			It("collapses whitespace and strips comments while preserving preformatted content", func() {
+				doWork()
+				Expect(string(uploadedContent(htmlKey))).To(Equal("<!DOCTYPE html>\n<html>\n<body>\n\n" +
+					"<!--[if IE]><p>Please upgrade your browser</p><![endif]-->\n" +
+					"<h1> Hello, world </h1>\n" +
+					"<pre>\n  keep    this\n    as is</pre>\n" +
+					"<textarea>  and\n\n  this </textarea>\n" +
+					"</body>\n</html>\n"))
+			})
+		})
+	})
+
+	Describe("pruning old deployments", func() {
+		var (
+			d1 *deployment.Deployment
+			d2 *deployment.Deployment
+			d3 *deployment.Deployment
+		)
+
+		daysAgo := func(days int) *time.Time {
+			t := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+			return &t
+		}
+
+		remainingIDs := func() []uint {
+			var depls []*deployment.Deployment
+			Expect(db.Where("project_id = ?", proj.ID).Find(&depls).Error).To(BeNil())
+
+			var ids []uint
+			for _, d := range depls {
+				ids = append(ids, d.ID)
+			}
+			return ids
+		}
+
+		BeforeEach(func() {
+			d1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(200),
+			})
+			d2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(100),
+			})
+			d3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(10),
+			})
+			Expect(db.Model(proj).Update("active_deployment_id", d3.ID).Error).To(BeNil())
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the project only keeps a number of deployments", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("max_deploys_kept", 3).Error).To(BeNil())
+			})
+
+			It("keeps the last deployments regardless of their age", func() {
+				doWork()
+				Expect(remainingIDs()).To(ConsistOf(d2.ID, d3.ID, depl.ID))
+			})
+		})
+
+		Context("when the project only keeps deployments for a number of days", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("deploy_retention_days", 90).Error).To(BeNil())
+			})
+
+			It("deletes the deployments older than that", func() {
+				doWork()
+				Expect(remainingIDs()).To(ConsistOf(d3.ID, depl.ID))
+			})
+		})
+
+		Context("when the project keeps a number of deployments for a number of days", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Updates(map[string]interface{}{
+					"max_deploys_kept":      3,
+					"deploy_retention_days": 150,
+				}).Error).To(BeNil())
+			})
+
+			It("deletes the deployments that either rule prunes", func() {
+				doWork()
+				// d1 is older than 150 days, and there are more than 3 deployments.
+				Expect(remainingIDs()).NotTo(ContainElement(d1.ID))
+				Expect(remainingIDs()).To(ConsistOf(d2.ID, d3.ID, depl.ID))
+			})
+
+			Context("when the count allows more deployments than the age does", func() {
+				BeforeEach(func() {
+					Expect(db.Model(proj).Updates(map[string]interface{}{
+						"max_deploys_kept":      10,
+						"deploy_retention_days": 50,
+					}).Error).To(BeNil())
+				})
+
+				It("deletes the deployments older than the retention period", func() {
+					doWork()
+					Expect(remainingIDs()).To(ConsistOf(d3.ID, depl.ID))
+				})
+			})
+
+			Context("when the age allows more deployments than the count does", func() {
+				BeforeEach(func() {
+					Expect(db.Model(proj).Updates(map[string]interface{}{
+						"max_deploys_kept":      2,
+						"deploy_retention_days": 365,
+					}).Error).To(BeNil())
+				})
+
+				It("deletes all but the last deployments", func() {
+					doWork()
+					Expect(remainingIDs()).To(ConsistOf(d3.ID, depl.ID))
+				})
+			})
+		})
+	})
+
+	Describe("JS environment", func() {
+		var webroot string
+
+		BeforeEach(func() {
+			Expect(db.Model(depl).Update("js_env_vars", []byte(`{"API_URL":"https://api.example.com"}`)).Error).To(BeNil())
+			webroot = "deployments/" + depl.PrefixID() + "/webroot/"
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("writes the environment to jsenv.js", func() {
+			doWork()
+
+			content := uploadedContent(webroot + "jsenv.js")
+			Expect(content).NotTo(BeNil())
+			Expect(string(content)).To(ContainSubstring(`{"API_URL":"https://api.example.com"}`))
+		})
+
+		Context("when the environment is not a JSON object of strings", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("js_env_vars", []byte(`{"API_URL":["https://api.example.com"]}`)).Error).To(BeNil())
+			})
+
+			It("deploys an empty environment instead of failing", func() {
+				doWork()
+
+				content := uploadedContent(webroot + "jsenv.js")
+				Expect(content).NotTo(BeNil())
+				Expect(string(content)).To(ContainSubstring(`}(this, {}));`))
+				Expect(string(content)).NotTo(ContainSubstring("API_URL"))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when the project has a custom JS environment filename", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("js_env_filename", "config/env.js").Error).To(BeNil())
+			})
+
+			It("writes the environment to that file instead", func() {
+				doWork()
+
+				content := uploadedContent(webroot + "config/env.js")
+				Expect(content).NotTo(BeNil())
+				Expect(string(content)).To(ContainSubstring(`{"API_URL":"https://api.example.com"}`))
+				Expect(uploadedContent(webroot + "jsenv.js")).To(BeNil())
+			})
+		})
+
+		Context("when the deployment skips the JS environment", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("skip_js_env", true).Error).To(BeNil())
+			})
+
+			It("does not write the environment", func() {
+				doWork()
+
+				Expect(uploadedContent(webroot + "jsenv.js")).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when the project has JS environment generation disabled", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("js_env_disabled", true).Error).To(BeNil())
+			})
+
+			It("does not write the environment", func() {
+				doWork()
+
+				Expect(uploadedContent(webroot + "jsenv.js")).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+	})
+
+	Describe("invalidation", func() {
+		var (
+			stagingDomain string
+			nextDepl      *deployment.Deployment
+		)
+
+		// invalidationMessage returns the next invalidation message published.
+		invalidationMessage := func() *messages.V1InvalidationMessageData {
+			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+			Expect(d).NotTo(BeNil())
+
+			m := &messages.V1InvalidationMessageData{}
+			Expect(json.Unmarshal(d.Body, m)).To(BeNil())
+			return m
+		}
+
+		deploy := func(d *deployment.Deployment) {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, d.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		BeforeEach(func() {
+			stagingDomain = "pubstorm-www--staging." + shared.DefaultDomain
+			nextDepl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+		})
+
+		Context("when the project has no active deployment", func() {
+			It("invalidates the domains in full", func() {
+				deploy(depl)
+
+				m := invalidationMessage()
+				Expect(m.Domains).To(ConsistOf(stagingDomain, "pubstorm-www."+shared.DefaultDomain, "www.pubstorm.com"))
+				Expect(m.Paths).To(BeEmpty())
+			})
+
+			It("records the invalidated domains on the deployment", func() {
+				deploy(depl)
+				invalidationMessage()
+
+				domainNames, err := proj.DomainNames(db)
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.InvalidationSkipped).To(BeFalse())
+
+				invalidated, err := depl.InvalidatedDomainNames()
+				Expect(err).To(BeNil())
+				Expect(invalidated).To(ConsistOf(append(domainNames, stagingDomain)))
+			})
+		})
+
+		Describe("acknowledgements", func() {
+			ack := func(errorMessage string) {
+				data, err := json.Marshal(&messages.V1InvalidationAckMessageData{
+					DeploymentID: depl.ID,
+					Domains:      []string{"www.pubstorm.com"},
+					Error:        errorMessage,
+				})
+				Expect(err).To(BeNil())
+				Expect(deployer.WorkInvalidationAck(data)).To(BeNil())
+			}
+
+			invalidationStatus := func() *string {
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				return depl.InvalidationStatus
+			}
+
+			BeforeEach(func() {
+				deploy(depl)
+			})
+
+			It("asks edges to acknowledge the invalidation of the deployment", func() {
+				m := invalidationMessage()
+				Expect(m.DeploymentID).To(Equal(depl.ID))
+			})
+
+			It("completes the pending invalidation once an edge acknowledges it", func() {
+				Expect(invalidationStatus()).NotTo(BeNil())
+				Expect(*invalidationStatus()).To(Equal(deployment.InvalidationPending))
+
+				ack("")
+
+				Expect(invalidationStatus()).NotTo(BeNil())
+				Expect(*invalidationStatus()).To(Equal(deployment.InvalidationComplete))
+			})
+
+			It("fails the invalidation if an edge could not invalidate its cache", func() {
+				ack("Unexpected error on making invalidation request")
+
+				Expect(invalidationStatus()).NotTo(BeNil())
+				Expect(*invalidationStatus()).To(Equal(deployment.InvalidationFailed))
+
+				// A failure is not undone by another edge succeeding.
+				ack("")
+
+				Expect(invalidationStatus()).NotTo(BeNil())
+				Expect(*invalidationStatus()).To(Equal(deployment.InvalidationFailed))
+			})
+		})
+
+		Context("when invalidation is skipped", func() {
+			It("records that no domains were invalidated", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz",
+					"skip_invalidation": true
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(testhelper.ConsumeQueue(mq, invalidationQueueName)).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.InvalidationSkipped).To(BeTrue())
+
+				invalidated, err := depl.InvalidatedDomainNames()
+				Expect(err).To(BeNil())
+				Expect(invalidated).To(BeEmpty())
+				Expect(depl.InvalidationStatus).To(BeNil())
+			})
+		})
+
+		Context("when the active deployment has a manifest", func() {
+			BeforeEach(func() {
+				deploy(depl)
+				invalidationMessage()
+
+				// Make it look as if the active deployment has an older
+				// js/app.js, a file that has since been removed and no
+				// css/app.css.
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				manifest, err := depl.ParsedManifest()
+				Expect(err).To(BeNil())
+				manifest["js/app.js"] = &deployment.ManifestEntry{Size: 1, ETag: "stale"}
+				manifest["old.html"] = &deployment.ManifestEntry{Size: 1, ETag: "removed"}
+				delete(manifest, "css/app.css")
+				Expect(depl.UpdateManifest(db, manifest)).To(BeNil())
+			})
+
+			It("invalidates only the changed paths on the project's domains", func() {
+				deploy(nextDepl)
+
+				m := invalidationMessage()
+				Expect(m.Domains).To(ConsistOf(stagingDomain))
+				Expect(m.Paths).To(BeEmpty())
+
+				m = invalidationMessage()
+				Expect(m.Domains).To(ConsistOf("pubstorm-www."+shared.DefaultDomain, "www.pubstorm.com"))
+				Expect(m.Paths).To(ConsistOf("/css/app.css", "/js/app.js", "/old.html", "/jsenv.js"))
+			})
+
+			Context("when the active deployment has no manifest", func() {
+				BeforeEach(func() {
+					Expect(db.Model(depl).Update("manifest", []byte("{}")).Error).To(BeNil())
+				})
+
+				It("invalidates the domains in full", func() {
+					deploy(nextDepl)
+
+					m := invalidationMessage()
+					Expect(m.Domains).To(ConsistOf(stagingDomain, "pubstorm-www."+shared.DefaultDomain, "www.pubstorm.com"))
+					Expect(m.Paths).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Describe("canary", func() {
+		var canary *deployment.Deployment
+
+		BeforeEach(func() {
+			canary = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:   "c4n4ry",
+				State:    deployment.StateUnpublished,
+				Variants: []byte(`{"index.html": ["identity", "gzip"]}`),
+			})
+			Expect(db.Model(proj).Updates(map[string]interface{}{
+				"canary_deployment_id": canary.ID,
+				"canary_percent":       10,
+			}).Error).To(BeNil())
+		})
+
+		It("includes the canary in the meta of the project's domains", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			for _, domain := range []string{"pubstorm-www." + shared.DefaultDomain, "www.pubstorm.com"} {
+				m := &meta.Meta{}
+				Expect(json.Unmarshal(uploadedContent(meta.Path(domain)), m)).To(BeNil())
+				Expect(m.Prefix).To(Equal(depl.PrefixID()))
+				Expect(m.Canary).To(Equal(&meta.Canary{
+					Prefix:   canary.PrefixID(),
+					Percent:  10,
+					Variants: map[string][]string{"index.html": {"identity", "gzip"}},
+				}))
+			}
+
+			// The deployment's own preview is not split.
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent(meta.Path(depl.PreviewDomainName())), m)).To(BeNil())
+			Expect(m.Canary).To(BeNil())
+		})
+
+		Context("when the canary is promoted", func() {
+			It("makes it the sole active deployment", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true
+				}`, canary.ID)))
+				Expect(err).To(BeNil())
+
+				m := &meta.Meta{}
+				Expect(json.Unmarshal(uploadedContent(meta.Path("www.pubstorm.com")), m)).To(BeNil())
+				Expect(m.Prefix).To(Equal(canary.PrefixID()))
+				Expect(m.Canary).To(BeNil())
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(*proj.ActiveDeploymentID).To(Equal(canary.ID))
+				Expect(proj.CanaryDeploymentID).To(BeNil())
+				Expect(proj.CanaryPercent).To(BeZero())
+			})
+		})
+	})
+
+	Describe("projects with many domains", func() {
+		var (
+			origMetaUploadConcurrency int
+			domainNames               []string
+		)
+
+		BeforeEach(func() {
+			origMetaUploadConcurrency = deployer.MetaUploadConcurrency
+			deployer.MetaUploadConcurrency = 4
+
+			domainNames = []string{"pubstorm-www." + shared.DefaultDomain, "www.pubstorm.com"}
+			for i := 0; i < 30; i++ {
+				name := fmt.Sprintf("www.pubstorm-%d.com", i)
+				factories.Domain(db, proj, name)
+				domainNames = append(domainNames, name)
+			}
+		})
+
+		AfterEach(func() {
+			deployer.MetaUploadConcurrency = origMetaUploadConcurrency
+		})
+
+		It("uploads the complete meta.json of every domain", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			// Each domain must get the whole meta.json, which would not be the
+			// case if the uploads shared a reader.
+			expected := uploadedContent("domains/pubstorm-www." + shared.DefaultDomain + "/meta.json")
+			Expect(expected).NotTo(BeEmpty())
+			for _, name := range domainNames {
+				Expect(uploadedContent("domains/"+name+"/meta.json")).To(MatchJSON(expected), name)
+			}
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+			failed, err := depl.FailedMetaDomainNames()
+			Expect(err).To(BeNil())
+			Expect(failed).To(BeEmpty())
+		})
+
+		It("records the domains whose meta.json failed to upload", func() {
+			fakeS3.UploadKeyErrors = map[string][]error{}
+			for _, name := range []string{"www.pubstorm-3.com", "www.pubstorm-17.com"} {
+				var errs []error
+				for i := 0; i < deployer.UploadAttempts; i++ {
+					errs = append(errs, errors.New("service unavailable"))
+				}
+				fakeS3.UploadKeyErrors["domains/"+name+"/meta.json"] = errs
+			}
+
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			failed, err := depl.FailedMetaDomainNames()
+			Expect(err).To(BeNil())
+			Expect(failed).To(ConsistOf("www.pubstorm-3.com", "www.pubstorm-17.com"))
+		})
+	})
+
+	Describe("internal link checking", func() {
+		BeforeEach(func() {
+			files := []struct {
+				name    string
+				content string
+			}{
+				{"index.html", `<html><head><link href="/css/app.css" rel="stylesheet"><script src="jsenv.js"></script></head>` +
+					`<body><a href="about/">About</a> <a href='missing.html#top'>Gone</a> <a href="https://example.com/missing.html">Out</a>` +
+					` <a href="#top">Top</a> <a href="mailto:hi@example.com">Mail</a></body></html>`},
+				{"css/app.css", "body { color: red; }"},
+				{"about/index.html", `<html><body><a href="../index.html">Home</a><img src=../images/logo.png></body></html>`},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, f := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content))})).To(BeNil())
+				_, err = tw.Write([]byte(f.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("does not check links by default", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			warnings, err := depl.WarningMessages()
+			Expect(err).To(BeNil())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		Context("when the project has internal link checking on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("check_internal_links", true).Error).To(BeNil())
+			})
+
+			It("records links to missing files as warnings without failing the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				warnings, err := depl.WarningMessages()
+				Expect(err).To(BeNil())
+				Expect(warnings).To(Equal([]string{
+					"about/index.html links to missing file images/logo.png",
+					"index.html links to missing file missing.html",
+				}))
+			})
+		})
+	})
+
+	Describe("asset manifest", func() {
+		var files []struct {
+			name    string
+			content string
+		}
+
+		BeforeEach(func() {
+			files = []struct {
+				name    string
+				content string
+			}{
+				{"index.html", `<html><script src="/js/app.js"></script></html>`},
+				{"js/app.js", `console.log("hello");`},
+				{"asset-manifest.json", `{"files": {"/stale.js": {}}}`},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, f := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content))})).To(BeNil())
+				_, err = tw.Write([]byte(f.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("does not upload an asset manifest by default", func() {
+			doWork()
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			Expect(uploadedContent(webroot + "asset-manifest.json")).To(MatchJSON(files[2].content))
+		})
+
+		Context("when the project has the asset manifest on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("asset_manifest", true).Error).To(BeNil())
+			})
+
+			It("uploads an asset manifest listing the path, hash and size of every deployed file", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				// Pages are listed as uploaded, e.g. with the watermark.
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				page := uploadedContent(webroot + "index.html")
+				Expect(page).NotTo(BeNil())
+
+				md5Hex := func(content []byte) string {
+					sum := md5.Sum(content)
+					return hex.EncodeToString(sum[:])
+				}
+
+				Expect(uploadedContent(webroot + "asset-manifest.json")).To(MatchJSON(fmt.Sprintf(`{
+					"files": {
+						"/index.html": {"hash": "%s", "size": %d},
+						"/js/app.js": {"hash": "%s", "size": %d}
+					}
+				}`, md5Hex(page), len(page),
+					md5Hex([]byte(files[1].content)), len(files[1].content))))
+			})
+		})
+	})
+
+	Describe("accessibility checks", func() {
+		BeforeEach(func() {
+			files := []struct {
+				name    string
+				content string
+			}{
+				{"index.html", `<html lang="en"><body><img src="/images/logo.png"><img src="/images/hero.png" alt="">` +
+					`<a href="/about/"><img src="/images/about.png" alt="About us"></a> <a href="/"></a>` +
+					`<a href="/contact/" aria-label="Contact"><i class="icon"></i></a></body></html>`},
+				{"about/index.html", `<html><body><!-- <img src="/images/draft.png"> --><a href="/">Home</a></body></html>`},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, f := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content))})).To(BeNil())
+				_, err = tw.Write([]byte(f.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("does not check accessibility by default", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			warnings, err := depl.WarningMessages()
+			Expect(err).To(BeNil())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		Context("when the project has accessibility checks on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("accessibility_check", true).Error).To(BeNil())
+			})
+
+			It("records accessibility problems as warnings without failing the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				warnings, err := depl.WarningMessages()
+				Expect(err).To(BeNil())
+				Expect(warnings).To(Equal([]string{
+					"about/index.html has no lang attribute on <html>",
+					"index.html has a link without text: /",
+					"index.html has an image without alt text: /images/logo.png",
+				}))
+			})
+		})
+	})
+
+	Describe("mixed content checks", func() {
+		BeforeEach(func() {
+			files := []struct {
+				name    string
+				content string
+			}{
+				{"index.html", `<html lang="en"><head><link rel="stylesheet" href="/css/app.css"></head>` +
+					`<body><img src="http://example.com/logo.png" alt="Logo"><img src="https://example.com/hero.png" alt="Hero">` +
+					`<a href="http://example.com/">Example</a></body></html>`},
+				{"css/app.css", `body { background: url("http://example.com/bg.png"); }`},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, f := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content))})).To(BeNil())
+				_, err = tw.Write([]byte(f.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("does not check for mixed content by default", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			warnings, err := depl.WarningMessages()
+			Expect(err).To(BeNil())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		Context("when the project has mixed content checks on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("check_mixed_content", true).Error).To(BeNil())
+			})
+
+			It("records resources loaded over insecure HTTP as warnings without failing the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				warnings, err := depl.WarningMessages()
+				Expect(err).To(BeNil())
+				Expect(warnings).To(Equal([]string{
+					"css/app.css loads insecure resource http://example.com/bg.png",
+					"index.html loads insecure resource http://example.com/logo.png",
+				}))
+			})
+		})
+
+		Context("when the project has strict mixed content checks on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("strict_mixed_content", true).Error).To(BeNil())
+			})
+
+			It("fails the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(Equal("Deployment has mixed content: " +
+					"css/app.css loads insecure resource http://example.com/bg.png, " +
+					"index.html loads insecure resource http://example.com/logo.png"))
+			})
+		})
+	})
+
+	Describe("S3 key prefix", func() {
+		var origS3KeyPrefix string
+
+		BeforeEach(func() {
+			origS3KeyPrefix = shared.S3KeyPrefix
+			shared.S3KeyPrefix = "staging/"
+		})
+
+		AfterEach(func() {
+			shared.S3KeyPrefix = origS3KeyPrefix
+		})
+
+		It("prepends the prefix to the keys of all files it downloads and uploads", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+			Expect(fakeS3.DownloadCalls.Count()).To(Equal(1))
+			Expect(fakeS3.DownloadCalls.NthCall(1).Arguments[2]).To(Equal("staging/deployments/" + depl.PrefixID() + "/raw-bundle.tar.gz"))
+
+			var keys []string
+			for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+				keys = append(keys, fakeS3.UploadCalls.NthCall(i).Arguments[2].(string))
+			}
+			Expect(keys).To(ContainElement("staging/deployments/" + depl.PrefixID() + "/webroot/index.html"))
+			Expect(keys).To(ContainElement("staging/domains/www.pubstorm.com/meta.json"))
+			for _, key := range keys {
+				Expect(key).To(HavePrefix("staging/"))
+			}
+		})
+	})
+
+	Describe("local file transfer backend", func() {
+		var (
+			dir   string
+			local *filetransfer.Local
+		)
+
+		BeforeEach(func() {
+			dir, err = ioutil.TempDir("", "deployer-local")
+			Expect(err).To(BeNil())
+
+			local = filetransfer.NewLocal(dir)
+			deployer.S3 = local
+
+			bundle, err := ioutil.ReadFile("../../testhelper/fixtures/website.tar.gz")
+			Expect(err).To(BeNil())
+			Expect(local.Upload(s3client.BucketRegion, s3client.BucketName, "deployments/"+depl.PrefixID()+"/raw-bundle.tar.gz", bytes.NewReader(bundle), "", "private")).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("deploys the bundle to the local filesystem", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+			target := deployer.Targets[0]
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			keys, err := local.List(target.Region, target.Bucket, webroot)
+			Expect(err).To(BeNil())
+			Expect(keys).To(ContainElement(webroot + "index.html"))
+			Expect(keys).To(ContainElement(webroot + "js/app.js"))
+
+			manifest, err := depl.ParsedManifest()
+			Expect(err).To(BeNil())
+			Expect(manifest).To(HaveKey("index.html"))
+
+			etag, err := local.ETag(target.Region, target.Bucket, webroot+"index.html")
+			Expect(err).To(BeNil())
+			Expect(manifest["index.html"].ETag).To(Equal(etag))
+
+			metaJSON, err := ioutil.ReadFile(filepath.Join(dir, target.Bucket, "domains", "www.pubstorm.com", "meta.json"))
+			Expect(err).To(BeNil())
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(metaJSON, m)).To(BeNil())
+			Expect(m.Prefix).To(Equal(depl.PrefixID()))
+		})
+	})
+
+	Describe("_headers file", func() {
+		setBundle := func(headersFile string) {
+			files := []struct{ name, content string }{
+				{"index.html", "<html><body>Hello</body></html>"},
+				{"assets/app.js", "var app = {};"},
+				{"_headers", headersFile},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, file := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})).To(BeNil())
+				_, err = tw.Write([]byte(file.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		}
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		domainMeta := func() *meta.Meta {
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			return m
+		}
+
+		Context("when the bundle has a valid _headers file", func() {
+			BeforeEach(func() {
+				setBundle(`# Headers for every page
+/*
+  X-Frame-Options: DENY
+  link: </assets/app.js>; rel=preload
+  Link: </style.css>; rel=preload
+
+/assets/*
+  Cache-Control: public, max-age=31536000
+`)
+			})
+
+			It("adds the parsed rules to meta.json as path headers", func() {
+				doWork()
+
+				Expect(domainMeta().PathHeaders).To(Equal([]deployment.PathHeaders{
+					{
+						Path: "/*",
+						Headers: map[string]string{
+							"X-Frame-Options": "DENY",
+							"Link":            "</assets/app.js>; rel=preload, </style.css>; rel=preload",
+						},
+					},
+					{
+						Path: "/assets/*",
+						Headers: map[string]string{
+							"Cache-Control": "public, max-age=31536000",
+						},
+					},
+				}))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+
+			It("does not upload the _headers file", func() {
+				doWork()
+
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				Expect(uploadedContent(webroot + "index.html")).NotTo(BeNil())
+				Expect(uploadedContent(webroot + "assets/app.js")).NotTo(BeNil())
+				Expect(uploadedContent(webroot + "_headers")).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				manifest, err := depl.ParsedManifest()
+				Expect(err).To(BeNil())
+				Expect(manifest).NotTo(HaveKey("_headers"))
+			})
+		})
+
+		Context("when the bundle has an invalid _headers file", func() {
+			BeforeEach(func() {
+				setBundle("/*\n  Content-Length: 0\n")
+			})
+
+			It("fails the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(Equal("Invalid _headers file: line 2: Content-Length header cannot be set"))
+
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(BeNil())
+			})
+		})
+
+		Context("when the _headers file sets headers for too many paths", func() {
+			var origMaxPathHeaderRules int
+
+			BeforeEach(func() {
+				origMaxPathHeaderRules = deployer.MaxPathHeaderRules
+				deployer.MaxPathHeaderRules = 1
+
+				setBundle("/a\n  X-Foo: bar\n/b\n  X-Foo: baz\n")
+			})
+
+			AfterEach(func() {
+				deployer.MaxPathHeaderRules = origMaxPathHeaderRules
+			})
+
+			It("fails the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(*depl.ErrorMessage).To(Equal("Invalid _headers file: headers can be set for at most 1 paths"))
+			})
+		})
+	})
+
+	Describe("preload hints", func() {
+		setBundle := func(extra ...struct{ name, content string }) {
+			files := append([]struct{ name, content string }{
+				{"index.html", `<html><head>
+<link rel="stylesheet" href="/css/app.css">
+<link rel="stylesheet" href="https://cdn.example.com/lib.css">
+<link rel="icon" href="/favicon.ico">
+<link href="fonts/brand.woff2">
+<!-- <script src="/js/old.js"></script> -->
+<script src="js/app.js"></script>
+<script async src="/js/analytics.js"></script>
+</head><body>Hello</body></html>`},
+				{"about.html", `<html><head><link rel="stylesheet" href="/css/about.css"></head></html>`},
+				{"css/app.css", "body { color: red; }"},
+				{"js/app.js", "var app = {};"},
+			}, extra...)
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, file := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})).To(BeNil())
+				_, err = tw.Write([]byte(file.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		}
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		domainMeta := func() *meta.Meta {
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			return m
+		}
+
+		const hints = "</css/app.css>; rel=preload; as=style, " +
+			"</fonts/brand.woff2>; rel=preload; as=font; crossorigin, " +
+			"</js/app.js>; rel=preload; as=script"
+
+		It("does not add preload hints by default", func() {
+			setBundle()
+			doWork()
+
+			Expect(domainMeta().PathHeaders).To(BeNil())
+		})
+
+		Context("when the project has preload hints on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("preload_critical_assets", true).Error).To(BeNil())
+			})
+
+			It("adds Link headers preloading the critical resources of the main page to meta.json", func() {
+				setBundle()
+				doWork()
+
+				Expect(domainMeta().PathHeaders).To(Equal([]deployment.PathHeaders{
+					{Path: "/", Headers: map[string]string{"Link": hints}},
+					{Path: "/index.html", Headers: map[string]string{"Link": hints}},
+				}))
+			})
+
+			It("adds the hints after the Link header that the _headers file sets", func() {
+				setBundle(struct{ name, content string }{"_headers", "/\n  Link: </hero.png>; rel=preload; as=image\n  X-Frame-Options: DENY\n"})
+				doWork()
+
+				Expect(domainMeta().PathHeaders).To(Equal([]deployment.PathHeaders{
+					{
+						Path: "/",
+						Headers: map[string]string{
+							"Link":            "</hero.png>; rel=preload; as=image, " + hints,
+							"X-Frame-Options": "DENY",
+						},
+					},
+					{Path: "/index.html", Headers: map[string]string{"Link": hints}},
+				}))
+			})
+		})
+	})
+
+	Describe("favicons", func() {
+		var faviconContent []byte
+
+		BeforeEach(func() {
+			img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+			for x := 0; x < 64; x++ {
+				for y := 0; y < 64; y++ {
+					img.Set(x, y, color.RGBA{0x33, 0x66, 0x99, 0xff})
+				}
+			}
+			buf := new(bytes.Buffer)
+			Expect(png.Encode(buf, img)).To(BeNil())
+			faviconContent = buf.Bytes()
+		})
+
+		setBundle := func(files ...struct{ name, content string }) {
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, file := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})).To(BeNil())
+				_, err = tw.Write([]byte(file.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		}
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		webrootContent := func(fileName string) []byte {
+			return uploadedContent("deployments/" + depl.PrefixID() + "/webroot/" + fileName)
+		}
+
+		const page = `<html><head><title>Hello</title></head><body>Hello</body></html>`
+
+		It("does not generate favicons by default", func() {
+			setBundle(
+				struct{ name, content string }{"index.html", page},
+				struct{ name, content string }{"favicon.png", string(faviconContent)},
+			)
+			doWork()
+
+			Expect(webrootContent("favicon-32x32.png")).To(BeNil())
+			Expect(string(webrootContent("index.html"))).To(Equal(page))
+		})
+
+		Context("when the project has favicon generation on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("generate_favicons", true).Error).To(BeNil())
+			})
+
+			It("uploads the favicon variants generated from the source favicon", func() {
+				setBundle(
+					struct{ name, content string }{"index.html", page},
+					struct{ name, content string }{"favicon.png", string(faviconContent)},
+				)
+				doWork()
+
+				for fileName, size := range map[string]int{
+					"favicon-16x16.png":    16,
+					"favicon-32x32.png":    32,
+					"apple-touch-icon.png": 180,
+				} {
+					b := webrootContent(fileName)
+					Expect(b).NotTo(BeNil(), fileName)
+
+					img, err := png.Decode(bytes.NewReader(b))
+					Expect(err).To(BeNil())
+					Expect(img.Bounds()).To(Equal(image.Rect(0, 0, size, size)))
+
+					r, g, b2, a := img.At(size/2, size/2).RGBA()
+					Expect([]uint32{r >> 8, g >> 8, b2 >> 8, a >> 8}).To(Equal([]uint32{0x33, 0x66, 0x99, 0xff}))
+				}
+				Expect(webrootContent("favicon.png")).To(Equal(faviconContent))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				manifest, err := depl.ParsedManifest()
+				Expect(err).To(BeNil())
+				Expect(manifest).To(HaveKey("favicon-16x16.png"))
+				Expect(manifest).To(HaveKey("apple-touch-icon.png"))
+			})
+
+			It("links to the variants from the head of pages", func() {
+				setBundle(
+					struct{ name, content string }{"index.html", page},
+					struct{ name, content string }{"favicon.png", string(faviconContent)},
+				)
+				doWork()
+
+				Expect(string(webrootContent("index.html"))).To(Equal(`<html><head><title>Hello</title>` +
+					`<link rel="icon" type="image/png" sizes="16x16" href="/favicon-16x16.png">` +
+					`<link rel="icon" type="image/png" sizes="32x32" href="/favicon-32x32.png">` +
+					`<link rel="apple-touch-icon" sizes="180x180" href="/apple-touch-icon.png">` +
+					`</head><body>Hello</body></html>`))
+			})
+
+			It("does not replace the variants that the bundle already has", func() {
+				setBundle(
+					struct{ name, content string }{"index.html", page},
+					struct{ name, content string }{"favicon.png", string(faviconContent)},
+					struct{ name, content string }{"apple-touch-icon.png", "custom icon"},
+				)
+				doWork()
+
+				Expect(string(webrootContent("apple-touch-icon.png"))).To(Equal("custom icon"))
+				Expect(webrootContent("favicon-16x16.png")).NotTo(BeNil())
+				Expect(string(webrootContent("index.html"))).NotTo(ContainSubstring("apple-touch-icon"))
+			})
+
+			It("does nothing when the bundle has no favicon", func() {
+				setBundle(struct{ name, content string }{"index.html", page})
+				doWork()
+
+				Expect(webrootContent("favicon-32x32.png")).To(BeNil())
+				Expect(string(webrootContent("index.html"))).To(Equal(page))
+			})
+
+			It("skips generation when the favicon cannot be decoded", func() {
+				setBundle(
+					struct{ name, content string }{"index.html", page},
+					struct{ name, content string }{"favicon.ico", "not an icon"},
+				)
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+				Expect(webrootContent("favicon-32x32.png")).To(BeNil())
+				Expect(string(webrootContent("index.html"))).To(Equal(page))
+			})
+		})
+	})
+
+	Describe("security.txt", func() {
+		setBundle := func(files ...struct{ name, content string }) {
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, file := range append([]struct{ name, content string }{{"index.html", "<html></html>"}}, files...) {
+				Expect(tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})).To(BeNil())
+				_, err = tw.Write([]byte(file.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		}
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		securityTxtKey := func() string {
+			return "deployments/" + depl.PrefixID() + "/webroot/.well-known/security.txt"
+		}
+
+		It("does not generate a security.txt by default", func() {
+			setBundle()
+			doWork()
+
+			Expect(uploadedContent(securityTxtKey())).To(BeNil())
+		})
+
+		Context("when the project has a security contact", func() {
+			BeforeEach(func() {
+				contact := "mailto:security@example.com"
+				Expect(db.Model(proj).Update("security_contact", &contact).Error).To(BeNil())
+			})
+
+			It("generates a security.txt with the contact when the bundle has none", func() {
+				setBundle()
+				doWork()
+
+				content := uploadedContent(securityTxtKey())
+				Expect(content).NotTo(BeNil())
+
+				lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+				Expect(lines).To(HaveLen(2))
+				Expect(lines[0]).To(Equal("Contact: mailto:security@example.com"))
+				Expect(lines[1]).To(HavePrefix("Expires: "))
+
+				expires, err := time.Parse(time.RFC3339, strings.TrimPrefix(lines[1], "Expires: "))
+				Expect(err).To(BeNil())
+				Expect(expires).To(BeTemporally("~", time.Now().Add(deployer.SecurityTxtExpiry), time.Minute))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				manifest, err := depl.ParsedManifest()
+				Expect(err).To(BeNil())
+				Expect(manifest).To(HaveKey(".well-known/security.txt"))
+			})
+
+			It("does not overwrite the security.txt of the bundle", func() {
+				setBundle(struct{ name, content string }{".well-known/security.txt", "Contact: mailto:bundle@example.com\n"})
+				doWork()
+
+				Expect(string(uploadedContent(securityTxtKey()))).To(Equal("Contact: mailto:bundle@example.com\n"))
+			})
+
+			It("does not generate one when the bundle has a security.txt at its root", func() {
+				setBundle(struct{ name, content string }{"security.txt", "Contact: mailto:bundle@example.com\n"})
+				doWork()
+
+				Expect(uploadedContent(securityTxtKey())).To(BeNil())
+			})
+		})
+	})
+
+	Describe("concurrent S3 downloads", func() {
+		var (
+			origMaxConcurrentS3Downloads int
+			depl2                        *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			origMaxConcurrentS3Downloads = deployer.MaxConcurrentS3Downloads
+			fakeS3.DownloadDelay = 200 * time.Millisecond
+
+			proj2 := factories.Project(db, u, "pubstorm-docs")
+			depl2 = factories.Deployment(db, proj2, u, deployment.StatePendingDeploy)
+		})
+
+		AfterEach(func() {
+			deployer.MaxConcurrentS3Downloads = origMaxConcurrentS3Downloads
+		})
+
+		workConcurrently := func() {
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			for i, d := range []*deployment.Deployment{depl, depl2} {
+				wg.Add(1)
+				go func(i int, id uint) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					errs[i] = deployer.Work([]byte(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"use_raw_bundle": true,
+						"archive_format": "tar.gz"
+					}`, id)))
+				}(i, d.ID)
+			}
+			wg.Wait()
+
+			Expect(errs).To(Equal([]error{nil, nil}))
+			Expect(fakeS3.DownloadCalls.Count()).To(Equal(2))
+		}
+
+		Context("when the limit is 1", func() {
+			BeforeEach(func() {
+				deployer.MaxConcurrentS3Downloads = 1
+			})
+
+			It("downloads the bundles of concurrent jobs one at a time", func() {
+				workConcurrently()
+				Expect(fakeS3.MaxConcurrentDownloads()).To(Equal(1))
+			})
+		})
+
+		Context("when there is no limit", func() {
+			BeforeEach(func() {
+				deployer.MaxConcurrentS3Downloads = 0
+			})
+
+			It("downloads the bundles of concurrent jobs at the same time", func() {
+				workConcurrently()
+				Expect(fakeS3.MaxConcurrentDownloads()).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("queue wait", func() {
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		BeforeEach(func() {
+			Expect(db.Model(depl).Update("created_at", time.Now().Add(-time.Minute)).Error).To(BeNil())
+		})
+
+		It("records when the deployment was picked up", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.DeployStartedAt).NotTo(BeNil())
+
+			wait := depl.QueueWait()
+			Expect(wait).NotTo(BeNil())
+			Expect(*wait).To(BeNumerically(">=", time.Minute))
+			Expect(*wait).To(BeNumerically("<", 2*time.Minute))
+		})
+
+		It("includes the queue wait in the 'Project Deployed' event props", func() {
+			doWork()
+
+			Eventually(func() *fake.Call { return fakeTracker.TrackCalls.NthCall(1) }).ShouldNot(BeNil())
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["queueWaitInSeconds"]).To(BeNumerically(">=", 60))
+		})
+
+		Context("when the deployment was picked up before", func() {
+			var startedAt time.Time
+
+			BeforeEach(func() {
+				startedAt = time.Now().Add(-30 * time.Second).Truncate(time.Second)
+				Expect(db.Model(depl).Update("deploy_started_at", startedAt).Error).To(BeNil())
+			})
+
+			It("keeps the time it was first picked up", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.DeployStartedAt).NotTo(BeNil())
+				Expect(depl.DeployStartedAt.Unix()).To(Equal(startedAt.Unix()))
+			})
+		})
+	})
+
+	Describe("deploy order", func() {
+		var nextDepl *deployment.Deployment
+
+		deploy := func(d *deployment.Deployment) error {
+			return deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, d.ID)))
+		}
+
+		var origEarlierPendingDelay time.Duration
+
+		BeforeEach(func() {
+			nextDepl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			origEarlierPendingDelay = deployer.EarlierPendingDelay
+			deployer.EarlierPendingDelay = 200 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			deployer.EarlierPendingDelay = origEarlierPendingDelay
+		})
+
+		It("publishes deployments in the order they were created", func() {
+			// The later deployment is picked up first, by the only worker.
+			Expect(deploy(nextDepl)).To(BeNil())
+
+			Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+			Expect(nextDepl.State).To(Equal(deployment.StatePendingDeploy))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.LockedAt).To(BeNil())
+
+			// It is put back at the end of the queue rather than at its head,
+			// so the earlier deployment is picked up next.
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			Expect(deploy(depl)).To(BeNil())
+
+			var d *amqp.Delivery
+			Eventually(func() *amqp.Delivery {
+				d = testhelper.ConsumeQueue(mq, queues.Deploy)
+				return d
+			}, 2*time.Second).ShouldNot(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": false,
+				"skip_invalidation": false,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, nextDepl.ID)))
+			Expect(deployer.Work(d.Body)).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+			Expect(nextDepl.State).To(Equal(deployment.StateDeployed))
+			Expect(nextDepl.DeployedAt.After(*depl.DeployedAt)).To(BeTrue())
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.ActiveDeploymentID).NotTo(BeNil())
+			Expect(*proj.ActiveDeploymentID).To(Equal(nextDepl.ID))
+		})
+
+		Context("when the later deployment has high priority", func() {
+			It("is put back at the end of the priority queue", func() {
+				Expect(deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz",
+					"priority": "high"
+				}`, nextDepl.ID)))).To(BeNil())
+
+				Eventually(func() *amqp.Delivery {
+					return testhelper.ConsumeQueue(mq, queues.DeployPriority)
+				}, 2*time.Second).ShouldNot(BeNil())
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			})
+		})
+
+		It("does not wait for earlier deployments that failed", func() {
+			Expect(depl.UpdateState(db, deployment.StateDeployFailed)).To(BeNil())
+
+			Expect(deploy(nextDepl)).To(BeNil())
+
+			Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+			Expect(nextDepl.State).To(Equal(deployment.StateDeployed))
+		})
+
+		It("does not wait for earlier deployments that have been pending for too long", func() {
+			Expect(db.Model(depl).Update("created_at", time.Now().Add(-deployer.MaxDeployOrderWait-time.Minute)).Error).To(BeNil())
+
+			Expect(deploy(nextDepl)).To(BeNil())
+
+			Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+			Expect(nextDepl.State).To(Equal(deployment.StateDeployed))
+		})
+	})
+
+	Describe("include and exclude globs", func() {
+		BeforeEach(func() {
+			files := []struct{ name, content string }{
+				{"README.md", "# PubStorm"},
+				{"src/app.js", "var app = {};"},
+				{"dist/index.html", "<html><body>Hello</body></html>"},
+				{"dist/js/app.js", "var app={};"},
+				{"dist/js/app.js.map", `{"version": 3}`},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, file := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})).To(BeNil())
+				_, err = tw.Write([]byte(file.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		webrootFile := func(name string) []byte {
+			return uploadedContent("deployments/" + depl.PrefixID() + "/webroot/" + name)
+		}
+
+		It("deploys every file by default", func() {
+			doWork()
+
+			for _, name := range []string{"README.md", "src/app.js", "dist/index.html", "dist/js/app.js", "dist/js/app.js.map"} {
+				Expect(webrootFile(name)).NotTo(BeNil(), name)
+			}
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			skipped, err := depl.SkippedFilePaths()
+			Expect(err).To(BeNil())
+			Expect(skipped).To(BeEmpty())
+		})
+
+		Context("when the project has include and exclude globs", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Updates(map[string]interface{}{
+					"include_globs": []byte(`["dist/**"]`),
+					"exclude_globs": []byte(`["*.map"]`),
+				}).Error).To(BeNil())
+			})
+
+			It("only deploys the included files that are not excluded, and records the rest as skipped", func() {
+				doWork()
+
+				Expect(webrootFile("dist/index.html")).NotTo(BeNil())
+				Expect(webrootFile("dist/js/app.js")).NotTo(BeNil())
+
+				Expect(webrootFile("dist/js/app.js.map")).To(BeNil())
+				Expect(webrootFile("src/app.js")).To(BeNil())
+				Expect(webrootFile("README.md")).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				skipped, err := depl.SkippedFilePaths()
+				Expect(err).To(BeNil())
+				Expect(skipped).To(Equal([]string{"README.md", "dist/js/app.js.map", "src/app.js"}))
+
+				manifest, err := depl.ParsedManifest()
+				Expect(err).To(BeNil())
+				Expect(manifest).To(HaveKey("dist/index.html"))
+				Expect(manifest).NotTo(HaveKey("dist/js/app.js.map"))
+			})
+		})
+	})
+
+	Describe("syntax validation", func() {
+		BeforeEach(func() {
+			files := []struct{ name, content string }{
+				{"index.html", "<html><body>Hello</body></html>"},
+				{"data/valid.json", `{"name": "pubstorm"}`},
+				{"data/broken.json", "{\n  \"name\": \"pubstorm\",\n}"},
+				{"js/broken.js", "function hello() {\n  return 'hello;\n}"},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, file := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})).To(BeNil())
+				_, err = tw.Write([]byte(file.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("does not validate files by default", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			warnings, err := depl.WarningMessages()
+			Expect(err).To(BeNil())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		Context("when the project has JSON validation on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("validate_json", true).Error).To(BeNil())
+			})
+
+			It("records syntax errors of JSON files as warnings without failing the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				warnings, err := depl.WarningMessages()
+				Expect(err).To(BeNil())
+				Expect(warnings).To(Equal([]string{
+					"data/broken.json: line 3: invalid character '}' looking for beginning of object key string",
+				}))
+
+				Expect(string(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/data/broken.json"))).To(Equal("{\n  \"name\": \"pubstorm\",\n}"))
+			})
+		})
+
+		Context("when the project has JS validation on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("validate_js", true).Error).To(BeNil())
+			})
+
+			It("records syntax errors of JS files as warnings", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				warnings, err := depl.WarningMessages()
+				Expect(err).To(BeNil())
+				Expect(warnings).To(Equal([]string{
+					"js/broken.js: line 2: unterminated string",
+				}))
+			})
+		})
+	})
+
+	Describe("CSP nonces", func() {
+		BeforeEach(func() {
+			Expect(db.Model(proj).Update("watermark", false).Error).To(BeNil())
+
+			content := `<html><head><script src="/app.js"></script><style>body { color: red; }</style></head>` +
+				`<body><script>window.loaded = true;</script></body></html>`
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			Expect(tw.WriteHeader(&tar.Header{Name: "index.html", Mode: 0644, Size: int64(len(content))})).To(BeNil())
+			_, err = tw.Write([]byte(content))
+			Expect(err).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("does not add nonces by default", func() {
+			doWork()
+
+			Expect(string(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/index.html"))).NotTo(ContainSubstring("nonce"))
+
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			Expect(m.Headers).To(BeNil())
+		})
+
+		Context("when the project has CSP nonces on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("csp_nonces", true).Error).To(BeNil())
+			})
+
+			It("adds a nonce to inline scripts and styles that the CSP header allows", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.CSPNonce).NotTo(BeNil())
+				nonce := *depl.CSPNonce
+
+				Expect(string(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/index.html"))).To(Equal(
+					`<html><head><script src="/app.js"></script><style nonce="` + nonce + `">body { color: red; }</style></head>` +
+						`<body><script nonce="` + nonce + `">window.loaded = true;</script></body></html>`))
+
+				m := &meta.Meta{}
+				Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+				Expect(m.Headers).To(Equal(map[string]string{
+					"Content-Security-Policy": "script-src 'self' 'nonce-" + nonce + "'; style-src 'self' 'nonce-" + nonce + "'",
+				}))
+			})
+
+			It("keeps the nonce of a previous attempt", func() {
+				nonce := "cHJldmlvdXMgYXR0ZW1wdA=="
+				Expect(db.Model(depl).Update("csp_nonce", nonce).Error).To(BeNil())
+
+				doWork()
+
+				Expect(string(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/index.html"))).To(ContainSubstring(`<script nonce="` + nonce + `">`))
+			})
+		})
+	})
+
+	Describe("opaque prefixes", func() {
+		BeforeEach(func() {
+			// The deployment created for every test would otherwise have to
+			// be deployed first.
+			Expect(db.Delete(depl).Error).To(BeNil())
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:        deployment.StatePendingDeploy,
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+			})
+		})
+
+		It("leaves the deployment ID out of the webroot and the preview domain", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(uploadedContent("deployments/9f86d081884c7d65/webroot/index.html")).NotTo(BeNil())
+			Expect(uploadedContent("domains/9f86d081884c7d65." + shared.PublicBaseDomain + "/meta.json")).NotTo(BeNil())
+
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			Expect(m.Prefix).To(Equal("9f86d081884c7d65"))
+		})
+	})
+
+	Describe("shared webroots", func() {
+		var nextDepl *deployment.Deployment
+
+		deploy := func(d *deployment.Deployment) {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, d.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		BeforeEach(func() {
+			Expect(db.Model(proj).Update("csp_nonces", true).Error).To(BeNil())
+
+			// Both deployments are of an identical bundle with a content
+			// hash prefix.
+			Expect(db.Model(depl).Updates(map[string]interface{}{
+				"prefix":        "9f86d081884c7d65",
+				"opaque_prefix": true,
+			}).Error).To(BeNil())
+			nextDepl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:        deployment.StatePendingDeploy,
+				Prefix:       "9f86d081884c7d65",
+				OpaquePrefix: true,
+			})
+
+			deploy(depl)
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+		})
+
+		It("reuses the webroot of the deployment of the identical bundle instead of uploading it again", func() {
+			uploads := fakeS3.UploadCalls.Count()
+			deploy(nextDepl)
+
+			webroot := "deployments/9f86d081884c7d65/webroot/"
+			for i := uploads + 1; i <= fakeS3.UploadCalls.Count(); i++ {
+				Expect(fakeS3.UploadCalls.NthCall(i).Arguments[2]).NotTo(HavePrefix(webroot))
+			}
+			Expect(fakeS3.CopyCalls.Count()).To(Equal(0))
+
+			Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+			Expect(nextDepl.State).To(Equal(deployment.StateDeployed))
+			Expect(nextDepl.CSPNonce).NotTo(BeNil())
+			Expect(*nextDepl.CSPNonce).To(Equal(*depl.CSPNonce))
+			Expect(nextDepl.Manifest).To(MatchJSON(depl.Manifest))
+			Expect(nextDepl.Variants).To(MatchJSON(depl.Variants))
+
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			Expect(m.Prefix).To(Equal("9f86d081884c7d65"))
+			Expect(m.Headers["Content-Security-Policy"]).To(ContainSubstring("'nonce-" + *depl.CSPNonce + "'"))
+		})
+
+		Context("when the deployment of the identical bundle failed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("state", deployment.StateDeployFailed).Error).To(BeNil())
+			})
+
+			It("uploads the webroot", func() {
+				uploads := fakeS3.UploadCalls.Count()
+				deploy(nextDepl)
+
+				var uploaded bool
+				for i := uploads + 1; i <= fakeS3.UploadCalls.Count(); i++ {
+					if fakeS3.UploadCalls.NthCall(i).Arguments[2] == "deployments/9f86d081884c7d65/webroot/index.html" {
+						uploaded = true
+					}
+				}
+				Expect(uploaded).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("custom certs", func() {
+		var ct *cert.Cert
+
+		BeforeEach(func() {
+			dom := &domain.Domain{}
+			Expect(db.Where("name = ?", "www.pubstorm.com").First(dom).Error).To(BeNil())
+			ct = factories.Cert(db, dom)
+
+			factories.Domain(db, proj, "pubstorm.com")
+		})
+
+		It("adds the ID of the cert of each domain that has one to its meta.json", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			Expect(m.CertID).To(Equal(ct.ID))
+			Expect(m.Prefix).To(Equal(depl.PrefixID()))
+
+			for _, name := range []string{"pubstorm.com", "pubstorm-www." + shared.DefaultDomain} {
+				m := &meta.Meta{}
+				Expect(json.Unmarshal(uploadedContent("domains/"+name+"/meta.json"), m)).To(BeNil())
+				Expect(m.CertID).To(BeZero(), name)
+				Expect(m.Prefix).To(Equal(depl.PrefixID()), name)
+			}
+		})
+	})
+
+	Describe("paused deploys", func() {
+		BeforeEach(func() {
+			Expect(db.Model(proj).Update("deploys_paused", true).Error).To(BeNil())
+		})
+
+		It("fails the deployment without uploading anything", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+			Expect(*depl.ErrorMessage).To(Equal("Deploys of the project are paused"))
+		})
+
+		It("still uploads the meta.json of the domains of jobs that skip the webroot upload", func() {
+			Expect(depl.UpdateState(db, deployment.StateDeployed)).To(BeNil())
+
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": true
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).NotTo(BeNil())
+		})
+	})
+
+	Describe("fingerprinting", func() {
+		BeforeEach(func() {
+			Expect(db.Model(proj).Update("watermark", false).Error).To(BeNil())
+
+			files := []struct{ name, content string }{
+				{"index.html", `<html><head><link rel="stylesheet" href="/css/app.css"></head><body><img src="img/logo.png?v=1"><a href="about.html">About</a></body></html>`},
+				{"css/app.css", `body { background: url("../img/logo.png"); }`},
+				{"img/logo.png", "png"},
+			}
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			for _, file := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})).To(BeNil())
+				_, err = tw.Write([]byte(file.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("does not fingerprint assets by default", func() {
+			doWork()
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			Expect(string(uploadedContent(webroot + "index.html"))).To(ContainSubstring(`href="/css/app.css"`))
+			Expect(uploadedContent(webroot + "img/logo.8f8cbb7dcf.png")).To(BeNil())
+		})
+
+		Context("when the project has fingerprinting on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("fingerprint", true).Error).To(BeNil())
+			})
+
+			It("uploads assets under their fingerprinted names and rewrites references to them", func() {
+				doWork()
+
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				Expect(string(uploadedContent(webroot + "index.html"))).To(Equal(
+					`<html><head><link rel="stylesheet" href="/css/app.0c1a016261.css"></head><body><img src="img/logo.8f8cbb7dcf.png?v=1"><a href="about.html">About</a></body></html>`))
+
+				Expect(string(uploadedContent(webroot + "img/logo.8f8cbb7dcf.png"))).To(Equal("png"))
+				Expect(string(uploadedContent(webroot + "img/logo.png"))).To(Equal("png"))
+
+				rewrittenCSS := `body { background: url("../img/logo.8f8cbb7dcf.png"); }`
+				Expect(string(uploadedContent(webroot + "css/app.0c1a016261.css"))).To(Equal(rewrittenCSS))
+				Expect(string(uploadedContent(webroot + "css/app.css"))).To(Equal(rewrittenCSS))
+			})
+		})
+	})
+
+	Describe("tombstones", func() {
+		var nextDepl *deployment.Deployment
+
+		deploy := func(d *deployment.Deployment) {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, d.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		domainMeta := func() *meta.Meta {
+			m := &meta.Meta{}
+			Expect(json.Unmarshal(uploadedContent("domains/www.pubstorm.com/meta.json"), m)).To(BeNil())
+			return m
+		}
+
+		BeforeEach(func() {
+			nextDepl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			deploy(depl)
+
+			// Make it look as if the active deployment had pages that have
+			// since been removed, and a page that was removed before it.
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			manifest, err := depl.ParsedManifest()
+			Expect(err).To(BeNil())
+			manifest["about.html"] = &deployment.ManifestEntry{Size: 1, ETag: "removed"}
+			manifest["blog/index.html"] = &deployment.ManifestEntry{Size: 1, ETag: "removed"}
+			manifest["images/old.png"] = &deployment.ManifestEntry{Size: 1, ETag: "removed"}
+			Expect(depl.UpdateManifest(db, manifest)).To(BeNil())
+			Expect(db.Model(depl).Update("tombstones", []byte(`["/gone.html", "/index.html"]`)).Error).To(BeNil())
+		})
+
+		It("does not add tombstones to meta.json by default", func() {
+			deploy(nextDepl)
+
+			Expect(domainMeta().Tombstones).To(BeNil())
+		})
+
+		Context("when the project tombstones removed paths", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("tombstone_removed_paths", true).Error).To(BeNil())
+			})
+
+			It("adds the pages removed since the active deployment to meta.json as tombstones", func() {
+				deploy(nextDepl)
+
+				Expect(domainMeta().Tombstones).To(Equal([]string{
+					"/about.html",
+					"/blog/",
+					"/blog/index.html",
+					"/gone.html",
+				}))
+
+				Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+				paths, err := nextDepl.TombstonePaths()
+				Expect(err).To(BeNil())
+				Expect(paths).To(HaveLen(4))
+			})
+
+			Context("when there are more removed paths than are kept", func() {
+				var origMaxTombstones int
+
+				BeforeEach(func() {
+					origMaxTombstones = deployer.MaxTombstones
+					deployer.MaxTombstones = 3
+				})
+
+				AfterEach(func() {
+					deployer.MaxTombstones = origMaxTombstones
+				})
+
+				It("drops the paths that were removed longest ago", func() {
+					deploy(nextDepl)
+
+					Expect(domainMeta().Tombstones).To(Equal([]string{
+						"/about.html",
+						"/blog/",
+						"/blog/index.html",
+					}))
+
+					// The list stays bounded as further deploys remove pages.
+					Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+					Expect(db.Model(proj).Update("active_deployment_id", nextDepl.ID).Error).To(BeNil())
+					manifest, err := nextDepl.ParsedManifest()
+					Expect(err).To(BeNil())
+					manifest["contact.html"] = &deployment.ManifestEntry{Size: 1, ETag: "removed"}
+					Expect(nextDepl.UpdateManifest(db, manifest)).To(BeNil())
+
+					lastDepl := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+					deploy(lastDepl)
+
+					Expect(db.First(lastDepl, lastDepl.ID).Error).To(BeNil())
+					paths, err := lastDepl.TombstonePaths()
+					Expect(err).To(BeNil())
+					Expect(paths).To(Equal([]string{
+						"/contact.html",
+						"/about.html",
+						"/blog/",
+					}))
+				})
+			})
+		})
+	})
+
+	Describe("incremental deploys", func() {
+		var nextDepl *deployment.Deployment
+
+		files := []string{"index.html", "js/app.js", "css/app.css", "images/rick-astley.jpg", "images/astley.jpg"}
+
+		deploy := func(d *deployment.Deployment, forceFullUpload bool) {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz",
+				"force_full_upload": %t
+			}`, d.ID, forceFullUpload)))
+			Expect(err).To(BeNil())
+		}
+
+		// uploadedFiles returns the files of the bundle that were uploaded to
+		// the webroot of nextDepl.
+		uploadedFiles := func() []string {
+			webroot := "deployments/" + nextDepl.PrefixID() + "/webroot/"
+			var uploaded []string
+			for _, fileName := range files {
+				if uploadedContent(webroot+fileName) != nil {
+					uploaded = append(uploaded, fileName)
+				}
+			}
+			return uploaded
+		}
+
+		// copies returns the keys that were copied, by the key they were
+		// copied to.
+		copies := func() map[string]string {
+			keys := map[string]string{}
+			for i := 1; i <= fakeS3.CopyCalls.Count(); i++ {
+				call := fakeS3.CopyCalls.NthCall(i)
+				Expect(call.Arguments[4]).To(Equal("public-read"))
+				keys[call.Arguments[3].(string)] = call.Arguments[2].(string)
+			}
+			return keys
+		}
+
+		BeforeEach(func() {
+			nextDepl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			deploy(depl, false)
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(*proj.ActiveDeploymentID).To(Equal(depl.ID))
+		})
+
+		It("copies the files that have not changed from the active deployment instead of uploading them", func() {
+			deploy(nextDepl, false)
+
+			Expect(uploadedFiles()).To(BeEmpty())
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			nextWebroot := "deployments/" + nextDepl.PrefixID() + "/webroot/"
+			Expect(copies()).To(Equal(map[string]string{
+				nextWebroot + "index.html":               webroot + "index.html",
+				nextWebroot + ".variants/gz/index.html":  webroot + ".variants/gz/index.html",
+				nextWebroot + "js/app.js":                webroot + "js/app.js",
+				nextWebroot + ".variants/gz/js/app.js":   webroot + ".variants/gz/js/app.js",
+				nextWebroot + "css/app.css":              webroot + "css/app.css",
+				nextWebroot + ".variants/gz/css/app.css": webroot + ".variants/gz/css/app.css",
+				nextWebroot + "images/rick-astley.jpg":   webroot + "images/rick-astley.jpg",
+				nextWebroot + "images/astley.jpg":        webroot + "images/astley.jpg",
+			}))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			manifest, err := depl.ParsedManifest()
+			Expect(err).To(BeNil())
+
+			Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+			Expect(nextDepl.State).To(Equal(deployment.StateDeployed))
+			nextManifest, err := nextDepl.ParsedManifest()
+			Expect(err).To(BeNil())
+			Expect(nextManifest).To(Equal(manifest))
+			for _, fileName := range files {
+				Expect(nextManifest[fileName].SHA256).NotTo(BeEmpty())
+			}
+		})
+
+		Context("when a file has changed since the active deployment", func() {
+			BeforeEach(func() {
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				manifest, err := depl.ParsedManifest()
+				Expect(err).To(BeNil())
+				manifest["js/app.js"].SHA256 = "changed"
+				Expect(depl.UpdateManifest(db, manifest)).To(BeNil())
+			})
+
+			It("only uploads the file that has changed", func() {
+				deploy(nextDepl, false)
+
+				Expect(uploadedFiles()).To(Equal([]string{"js/app.js"}))
+				Expect(copies()).To(HaveLen(6))
+			})
+		})
+
+		Context("when the job forces a full upload", func() {
+			It("uploads every file again", func() {
+				deploy(nextDepl, true)
+
+				Expect(uploadedFiles()).To(Equal(files))
+				Expect(fakeS3.CopyCalls.Count()).To(Equal(0))
+			})
+		})
+
+		Context("when the files cannot be copied from the active deployment", func() {
+			BeforeEach(func() {
+				fakeS3.CopyError = awserr.NewRequestFailure(awserr.New("NoSuchKey", "The specified key does not exist.", nil), 404, "req-1")
+			})
+
+			It("uploads them instead", func() {
+				deploy(nextDepl, false)
+
+				Expect(uploadedFiles()).To(Equal(files))
+
+				Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+				Expect(nextDepl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+	})
+
+	Describe("last modified times", func() {
+		modTime := time.Date(2016, 6, 1, 12, 30, 0, 0, time.UTC)
+
+		BeforeEach(func() {
+			Expect(db.Model(proj).Update("watermark", false).Error).To(BeNil())
+
+			content := "<html><body>Hello</body></html>"
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			Expect(tw.WriteHeader(&tar.Header{Name: "index.html", Mode: 0644, Size: int64(len(content)), ModTime: modTime})).To(BeNil())
+			_, err = tw.Write([]byte(content))
+			Expect(err).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		It("uploads files with the time they were last modified in the bundle in their metadata", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			uploaded := map[string]map[string]string{}
+			for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+				call := fakeS3.UploadCalls.NthCall(i)
+				metadata, _ := call.SideEffects["metadata"].(map[string]string)
+				uploaded[call.Arguments[2].(string)] = metadata
+			}
+
+			lastModified := map[string]string{meta.LastModifiedMetadataKey: "Wed, 01 Jun 2016 12:30:00 GMT"}
+			Expect(uploaded).To(HaveKeyWithValue(webroot+"index.html", lastModified))
+			Expect(uploaded).To(HaveKeyWithValue(webroot+".variants/gz/index.html", lastModified))
+			Expect(uploaded["domains/www.pubstorm.com/meta.json"]).To(BeNil())
+		})
+	})
+
+	Describe("Slack notifications", func() {
+		var (
+			slack         *httptest.Server
+			slackMessages []map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			slackMessages = nil
+
+			slack = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				message := map[string]interface{}{}
+				json.NewDecoder(r.Body).Decode(&message)
+				slackMessages = append(slackMessages, message)
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			webhookURL := slack.URL
+			Expect(db.Model(proj).Update("slack_webhook_url", &webhookURL).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			slack.Close()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("posts a message to Slack when the deployment is deployed", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+			domainNames, err := proj.DomainNamesWithProtocol(db)
+			Expect(err).To(BeNil())
+			url := domainNames[0]
+
+			Expect(slackMessages).To(HaveLen(1))
+			Expect(slackMessages[0]).To(Equal(map[string]interface{}{
+				"text": fmt.Sprintf("pubstorm-www v%d was deployed to %s", depl.Version, url),
+				"attachments": []interface{}{
+					map[string]interface{}{
+						"fallback":   fmt.Sprintf("pubstorm-www v%d was deployed to %s", depl.Version, url),
+						"color":      "good",
+						"title":      fmt.Sprintf("pubstorm-www v%d", depl.Version),
+						"title_link": url,
+						"fields": []interface{}{
+							map[string]interface{}{"title": "Project", "value": "pubstorm-www", "short": true},
+							map[string]interface{}{"title": "Version", "value": fmt.Sprintf("v%d", depl.Version), "short": true},
+							map[string]interface{}{"title": "Status", "value": "Deployed", "short": true},
+						},
+					},
+				},
+			}))
+		})
+
+		Context("when the deployment fails", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("deploys_paused", true).Error).To(BeNil())
+			})
+
+			It("posts a message to Slack with the error", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+
+				domainNames, err := proj.DomainNamesWithProtocol(db)
+				Expect(err).To(BeNil())
+				url := domainNames[0]
+
+				Expect(slackMessages).To(HaveLen(1))
+				Expect(slackMessages[0]).To(Equal(map[string]interface{}{
+					"text": fmt.Sprintf("pubstorm-www v%d failed to deploy", depl.Version),
+					"attachments": []interface{}{
+						map[string]interface{}{
+							"fallback":   fmt.Sprintf("pubstorm-www v%d failed to deploy", depl.Version),
+							"color":      "danger",
+							"title":      fmt.Sprintf("pubstorm-www v%d", depl.Version),
+							"title_link": url,
+							"text":       "Deploys of the project are paused",
+							"fields": []interface{}{
+								map[string]interface{}{"title": "Project", "value": "pubstorm-www", "short": true},
+								map[string]interface{}{"title": "Version", "value": fmt.Sprintf("v%d", depl.Version), "short": true},
+								map[string]interface{}{"title": "Status", "value": "Failed", "short": true},
+							},
+						},
+					},
+				}))
+			})
+		})
+
+		Context("when the deployment does not finish", func() {
+			It("does not post a message to Slack", func() {
+				Expect(db.Model(depl).Update("state", deployment.StatePendingUpload).Error).To(BeNil())
+
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).NotTo(BeNil())
+
+				Expect(slackMessages).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("subscriptions", func() {
+		type delivery struct {
+			path  string
+			event map[string]interface{}
+		}
+
+		var (
+			subscriber *httptest.Server
+			mu         sync.Mutex
+			deliveries []delivery
+		)
+
+		BeforeEach(func() {
+			deliveries = nil
+
+			subscriber = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event := map[string]interface{}{}
+				json.NewDecoder(r.Body).Decode(&event)
+
+				mu.Lock()
+				deliveries = append(deliveries, delivery{r.URL.Path, event})
+				mu.Unlock()
+
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			factories.Subscription(db, proj, subscription.EventDeploySucceeded, subscriber.URL+"/succeeded")
+			factories.Subscription(db, proj, subscription.EventDeployFailed, subscriber.URL+"/failed")
+			factories.Subscription(db, nil, subscription.EventDeploySucceeded, subscriber.URL+"/other-project")
+		})
+
+		AfterEach(func() {
+			subscriber.Close()
+		})
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+		}
+
+		It("posts the deploy_succeeded event to the subscriptions to it when the deployment is deployed", func() {
+			doWork()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+			Expect(deliveries).To(HaveLen(1))
+			Expect(deliveries[0].path).To(Equal("/succeeded"))
+			Expect(deliveries[0].event["event"]).To(Equal("deploy_succeeded"))
+			Expect(deliveries[0].event["project_name"]).To(Equal("pubstorm-www"))
+
+			deplJSON, ok := deliveries[0].event["deployment"].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(deplJSON["id"]).To(Equal(float64(depl.ID)))
+			Expect(deplJSON["state"]).To(Equal(deployment.StateDeployed))
+		})
+
+		Context("when the deployment fails", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("deploys_paused", true).Error).To(BeNil())
+			})
+
+			It("posts the deploy_failed event to the subscriptions to it", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+
+				Expect(deliveries).To(HaveLen(1))
+				Expect(deliveries[0].path).To(Equal("/failed"))
+				Expect(deliveries[0].event["event"]).To(Equal("deploy_failed"))
+
+				deplJSON, ok := deliveries[0].event["deployment"].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(deplJSON["error_message"]).To(Equal("Deploys of the project are paused"))
+			})
+		})
+
+		Context("when a subscriber fails", func() {
+			BeforeEach(func() {
+				factories.Subscription(db, proj, subscription.EventDeploySucceeded, "http://127.0.0.1:1/unreachable")
+			})
+
+			It("still posts the event to the other subscriptions", func() {
+				doWork()
+
+				Expect(deliveries).To(HaveLen(1))
+				Expect(deliveries[0].path).To(Equal("/succeeded"))
+			})
+		})
+	})
+
+	Describe("corrupt bundles", func() {
+		gzipped := func(content []byte) []byte {
+			b := new(bytes.Buffer)
+			gw := gzip.NewWriter(b)
+			_, err := gw.Write(content)
+			Expect(err).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			return b.Bytes()
+		}
+
+		assertFailedForGood := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(Equal(deployer.ErrCorruptBundle))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+			Expect(depl.ErrorMessage).NotTo(BeNil())
+			Expect(*depl.ErrorMessage).To(Equal("bundle is corrupt"))
+			Expect(depl.ErrorCode).NotTo(BeNil())
+			Expect(*depl.ErrorCode).To(Equal(deployment.ErrorCodeCorruptBundle))
+		}
+
+		It("fails the deployment if the bundle is not gzipped", func() {
+			fakeS3.DownloadContent = []byte("this is not a bundle")
+			assertFailedForGood()
+		})
+
+		It("fails the deployment if the bundle is truncated", func() {
+			fakeS3.DownloadContent = gzipped([]byte("this is not a bundle"))[:5]
+			assertFailedForGood()
+		})
+
+		It("fails the deployment if the bundle is not a tarball", func() {
+			fakeS3.DownloadContent = gzipped([]byte("this is not a bundle"))
+			assertFailedForGood()
+		})
+	})
+
+	Describe("zip bundles", func() {
+		BeforeEach(func() {
+			Expect(db.Model(proj).Update("watermark", false).Error).To(BeNil())
+
+			bundle := new(bytes.Buffer)
+			zw := zip.NewWriter(bundle)
+			for name, content := range map[string]string{
+				"index.html":  "<html><body>Hello from zip</body></html>",
+				"css/app.css": "body { color: red; }",
+			} {
+				w, err := zw.Create(name)
+				Expect(err).To(BeNil())
+				_, err = w.Write([]byte(content))
+				Expect(err).To(BeNil())
+			}
+			Expect(zw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+		})
+
+		assertUploaded := func() {
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			Expect(string(uploadedContent(webroot + "index.html"))).To(Equal("<html><body>Hello from zip</body></html>"))
+			Expect(string(uploadedContent(webroot + "css/app.css"))).To(Equal("body { color: red; }"))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+		}
+
+		It("uploads the files of the bundle to the webroot", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "zip"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			assertUploaded()
+		})
+
+		Context("when the bundle was uploaded as a tar.gz", func() {
+			It("detects that it is a zip and uploads its files to the webroot", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				assertUploaded()
+			})
+		})
+
+		Context("when the bundle has files with invalid names", func() {
+			BeforeEach(func() {
+				bundle := new(bytes.Buffer)
+				zw := zip.NewWriter(bundle)
+				for name, content := range map[string]string{
+					"index.html":         "<html><body>Hello from zip</body></html>",
+					"css/app.css":        "body { color: red; }",
+					"bad name?.html":     "<html><body>Bad</body></html>",
+					"images/b&w/pic.png": "not really a png",
+				} {
+					w, err := zw.Create(name)
+					Expect(err).To(BeNil())
+					_, err = w.Write([]byte(content))
+					Expect(err).To(BeNil())
+				}
+				Expect(zw.Close()).To(BeNil())
+				fakeS3.DownloadContent = bundle.Bytes()
+			})
+
+			It("skips them, as it does for tarballs", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "zip"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				assertUploaded()
+
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				Expect(uploadedContent(webroot + "bad name?.html")).To(BeNil())
+				Expect(uploadedContent(webroot + "images/b&w/pic.png")).To(BeNil())
+			})
+		})
+	})
+
+	Describe("multiple targets", func() {
+		var origTargets []s3client.Target
+
+		BeforeEach(func() {
+			origTargets = deployer.Targets
+			deployer.Targets = []s3client.Target{
+				{Region: "us-west-2", Bucket: "bucket-usw2"},
+				{Region: "eu-west-1", Bucket: "bucket-euw1"},
+			}
+		})
+
+		AfterEach(func() {
+			deployer.Targets = origTargets
+		})
+
+		bucketsUploadedTo := func(key string) []string {
+			var buckets []string
+			for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+				call := fakeS3.UploadCalls.NthCall(i)
+				if call.Arguments[2] == key && call.ReturnValues[0] == nil {
+					buckets = append(buckets, call.Arguments[0].(string)+"/"+call.Arguments[1].(string))
+				}
+			}
+			return buckets
+		}
+
+		doWork := func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		}
+
+		It("uploads every file to all the targets", func() {
+			doWork()
+			Expect(err).To(BeNil())
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			for _, key := range []string{
+				webroot + "index.html",
+				webroot + ".variants/gz/index.html",
+				webroot + "images/astley.jpg",
+				webroot + "jsenv.js",
+				"domains/www.pubstorm.com/meta.json",
+			} {
+				Expect(bucketsUploadedTo(key)).To(ConsistOf("us-west-2/bucket-usw2", "eu-west-1/bucket-euw1"))
+			}
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+		})
+
+		Context("when uploads to one of the targets fail", func() {
+			BeforeEach(func() {
+				fakeS3.UploadErrors = map[string]error{
+					"bucket-euw1": errors.New("region unavailable"),
+				}
+			})
+
+			It("still deploys to the other target", func() {
+				doWork()
+				Expect(err).To(BeNil())
+
+				Expect(bucketsUploadedTo("domains/www.pubstorm.com/meta.json")).To(ConsistOf("us-west-2/bucket-usw2"))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+
+			It("records the failed uploads and schedules a job to repair them", func() {
+				doWork()
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+
+				var failed []map[string]interface{}
+				Expect(json.Unmarshal(depl.FailedUploads, &failed)).To(BeNil())
+
+				var failedKeys []string
+				for _, f := range failed {
+					Expect(f["region"]).To(Equal("eu-west-1"))
+					Expect(f["bucket"]).To(Equal("bucket-euw1"))
+					failedKeys = append(failedKeys, f["key"].(string))
+				}
+
+				webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+				Expect(failedKeys).To(ContainElement(webroot + "index.html"))
+				Expect(failedKeys).To(ContainElement(webroot + "images/astley.jpg"))
+				Expect(failedKeys).To(ContainElement("domains/www.pubstorm.com/meta.json"))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": false,
+					"skip_invalidation": false,
+					"use_raw_bundle": false,
+					"repair_uploads": true
+				}`, depl.ID)))
+			})
+
+			Context("when the job before timed out with uploads still going on", func() {
+				var origUploadTimeout time.Duration
+
+				BeforeEach(func() {
+					origUploadTimeout = deployer.UploadTimeout
+					deployer.UploadTimeout = 20 * time.Millisecond
+					fakeS3.UploadTimeout = 50 * time.Millisecond
+				})
+
+				AfterEach(func() {
+					deployer.UploadTimeout = origUploadTimeout
+				})
+
+				It("does not record the failed uploads of that job", func() {
+					doWork()
+					Expect(err).To(Equal(deployer.ErrTimeout))
+
+					deployer.UploadTimeout = origUploadTimeout
+					nextDepl := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+					Expect(deployer.Work([]byte(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"use_raw_bundle": true,
+						"archive_format": "tar.gz"
+					}`, nextDepl.ID)))).To(BeNil())
+
+					Expect(db.First(nextDepl, nextDepl.ID).Error).To(BeNil())
+
+					var failed []map[string]interface{}
+					Expect(json.Unmarshal(nextDepl.FailedUploads, &failed)).To(BeNil())
+					Expect(failed).NotTo(BeEmpty())
+					for _, f := range failed {
+						Expect(f["key"]).NotTo(ContainSubstring(depl.PrefixID()))
+					}
+				})
+			})
+		})
+
+		Context("when nothing fails to upload", func() {
+			It("does not schedule a repair job", func() {
+				doWork()
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.FailedUploads).To(MatchJSON(`[]`))
+
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			})
+		})
+
+		Describe("repairing failed uploads", func() {
+			var key string
+
+			BeforeEach(func() {
+				key = "deployments/" + depl.PrefixID() + "/webroot/index.html"
+				Expect(db.Model(depl).Updates(map[string]interface{}{
+					"state": deployment.StateDeployed,
+					"failed_uploads": []byte(fmt.Sprintf(`[{
+						"region": "eu-west-1",
+						"bucket": "bucket-euw1",
+						"key": %q,
+						"content_type": "text/html"
+					}]`, key)),
+				}).Error).To(BeNil())
+
+				fakeS3.DownloadContent = []byte("<h1>hello</h1>")
+			})
+
+			var origRepairRetryDelay time.Duration
+
+			BeforeEach(func() {
+				origRepairRetryDelay = deployer.RepairRetryDelay
+				deployer.RepairRetryDelay = 200 * time.Millisecond
+			})
+
+			AfterEach(func() {
+				deployer.RepairRetryDelay = origRepairRetryDelay
+			})
+
+			doRepair := func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"repair_uploads": true
+				}`, depl.ID)))
+			}
+
+			It("copies the files over from the targets they were uploaded to", func() {
+				doRepair()
+				Expect(err).To(BeNil())
+
+				Expect(fakeS3.DownloadCalls.Count()).To(Equal(1))
+				call := fakeS3.DownloadCalls.NthCall(1)
+				Expect(call.Arguments[0]).To(Equal("us-west-2"))
+				Expect(call.Arguments[1]).To(Equal("bucket-usw2"))
+				Expect(call.Arguments[2]).To(Equal(key))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+				call = fakeS3.UploadCalls.NthCall(1)
+				Expect(call.Arguments[0]).To(Equal("eu-west-1"))
+				Expect(call.Arguments[1]).To(Equal("bucket-euw1"))
+				Expect(call.Arguments[2]).To(Equal(key))
+				Expect(call.Arguments[4]).To(Equal("text/html"))
+				Expect(call.Arguments[5]).To(Equal("public-read"))
+				Expect(call.SideEffects["uploaded_content"]).To(Equal([]byte("<h1>hello</h1>")))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.FailedUploads).To(MatchJSON(`[]`))
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+
+			Context("when the files still fail to upload", func() {
+				BeforeEach(func() {
+					fakeS3.UploadErrors = map[string]error{
+						"bucket-euw1": errors.New("region unavailable"),
+					}
+				})
+
+				It("keeps them and retries the job after a delay", func() {
+					doRepair()
+					Expect(err).To(BeNil())
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+
+					var failed []map[string]interface{}
+					Expect(json.Unmarshal(depl.FailedUploads, &failed)).To(BeNil())
+					Expect(failed).To(HaveLen(1))
+					Expect(failed[0]["key"]).To(Equal(key))
+
+					Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+					var d *amqp.Delivery
+					Eventually(func() *amqp.Delivery {
+						d = testhelper.ConsumeQueue(mq, queues.Deploy)
+						return d
+					}, 2*time.Second).ShouldNot(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": false,
+						"skip_invalidation": false,
+						"use_raw_bundle": false,
+						"repair_uploads": true,
+						"repair_attempt": 1
+					}`, depl.ID)))
+				})
+
+				Context("when the job has run out of attempts", func() {
+					It("keeps them without retrying the job", func() {
+						err = deployer.Work([]byte(fmt.Sprintf(`{
+							"deployment_id": %d,
+							"repair_uploads": true,
+							"repair_attempt": %d
+						}`, depl.ID, deployer.MaxRepairAttempts-1)))
+						Expect(err).To(BeNil())
+
+						Expect(db.First(depl, depl.ID).Error).To(BeNil())
+
+						var failed []map[string]interface{}
+						Expect(json.Unmarshal(depl.FailedUploads, &failed)).To(BeNil())
+						Expect(failed).To(HaveLen(1))
+
+						Expect(testhelper.ConsumeQueue(mq, queues.DeployRepairDelayed)).To(BeNil())
+					})
+				})
+			})
+
+			Context("when the project is locked", func() {
+				BeforeEach(func() {
+					acquired, err := proj.Lock(db)
+					Expect(err).To(BeNil())
+					Expect(acquired).To(BeTrue())
+				})
+
+				It("does not repair anything", func() {
+					doRepair()
+					Expect(err).To(Equal(deployer.ErrProjectLocked))
+
+					Expect(fakeS3.DownloadCalls.Count()).To(Equal(0))
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+				})
+			})
+
+			Context("when the meta.json of a domain of the project failed to upload", func() {
+				var metaKey string
+
+				BeforeEach(func() {
+					metaKey = "domains/www.pubstorm.com/meta.json"
+					Expect(db.Model(depl).Update("failed_uploads", []byte(fmt.Sprintf(`[{
+						"region": "eu-west-1",
+						"bucket": "bucket-euw1",
+						"key": %q,
+						"content_type": "application/json",
+						"recorded_at": %q
+					}]`, metaKey, time.Now().Add(-time.Minute).Format(time.RFC3339Nano)))).Error).To(BeNil())
+
+					fakeS3.DownloadContent = []byte(`{"prefix": "old"}`)
+				})
+
+				It("copies it over from the targets it was uploaded to", func() {
+					doRepair()
+					Expect(err).To(BeNil())
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+					call := fakeS3.UploadCalls.NthCall(1)
+					Expect(call.Arguments[1]).To(Equal("bucket-euw1"))
+					Expect(call.Arguments[2]).To(Equal(metaKey))
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+					Expect(depl.FailedUploads).To(MatchJSON(`[]`))
+				})
+
+				Context("when another deployment of the project has been deployed since", func() {
+					BeforeEach(func() {
+						factories.Deployment(db, proj, u, deployment.StateDeployed)
+					})
+
+					It("does not copy it over, as it may have been rewritten", func() {
+						doRepair()
+						Expect(err).To(BeNil())
+
+						Expect(fakeS3.DownloadCalls.Count()).To(Equal(0))
+						Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+						Expect(db.First(depl, depl.ID).Error).To(BeNil())
+						Expect(depl.FailedUploads).To(MatchJSON(`[]`))
+					})
+				})
+			})
+		})
+
+		Context("when uploads to a majority of the targets fail", func() {
+			BeforeEach(func() {
+				deployer.Targets = append(deployer.Targets, s3client.Target{Region: "ap-southeast-1", Bucket: "bucket-apse1"})
+				fakeS3.UploadErrors = map[string]error{
+					"bucket-euw1":  errors.New("region unavailable"),
+					"bucket-apse1": errors.New("region unavailable"),
+				}
+			})
+
+			It("aborts the deploy", func() {
+				doWork()
+				Expect(err).NotTo(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).NotTo(Equal(deployment.StateDeployed))
+			})
+		})
+	})
+
+	Describe("image optimization", func() {
+		var (
+			pngContent []byte
+			pngKey     string
+		)
+
+		BeforeEach(func() {
+			// A poorly compressed PNG that recompresses well.
+			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			for x := 0; x < 100; x++ {
+				for y := 0; y < 100; y++ {
+					img.Set(x, y, color.RGBA{0x33, 0x66, 0x99, 0xff})
+				}
+			}
+			buf := new(bytes.Buffer)
+			enc := &png.Encoder{CompressionLevel: png.NoCompression}
+			Expect(enc.Encode(buf, img)).To(BeNil())
+			pngContent = buf.Bytes()
+
+			bundle := new(bytes.Buffer)
+			gw := gzip.NewWriter(bundle)
+			tw := tar.NewWriter(gw)
+			Expect(tw.WriteHeader(&tar.Header{Name: "logo.png", Mode: 0644, Size: int64(len(pngContent))})).To(BeNil())
+			_, err = tw.Write(pngContent)
+			Expect(err).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = bundle.Bytes()
+
+			pngKey = "deployments/" + depl.PrefixID() + "/webroot/logo.png"
+		})
+
+		doWork := func() error {
+			return deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		}
+
+		It("uploads images untouched", func() {
+			Expect(doWork()).To(BeNil())
+			Expect(uploadedContent(pngKey)).To(Equal(pngContent))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.ImageBytesSaved).To(BeZero())
+		})
+
+		Context("when the project has image optimization on", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("optimize_images", true).Error).To(BeNil())
+			})
+
+			It("uploads recompressed images and records the bytes saved", func() {
+				Expect(doWork()).To(BeNil())
+
+				optimized := uploadedContent(pngKey)
+				Expect(len(optimized)).To(BeNumerically("<", len(pngContent)))
+
+				img, err := png.Decode(bytes.NewReader(optimized))
+				Expect(err).To(BeNil())
+				Expect(img.Bounds()).To(Equal(image.Rect(0, 0, 100, 100)))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.ImageBytesSaved).To(Equal(int64(len(pngContent) - len(optimized))))
+			})
+
+			Context("when the image is too small to be worth optimizing", func() {
+				var origMinImageSizeToOptimize int64
+
+				BeforeEach(func() {
+					origMinImageSizeToOptimize = deployer.MinImageSizeToOptimize
+					deployer.MinImageSizeToOptimize = int64(len(pngContent)) + 1
+				})
+
+				AfterEach(func() {
+					deployer.MinImageSizeToOptimize = origMinImageSizeToOptimize
+				})
+
+				It("uploads it untouched", func() {
+					Expect(doWork()).To(BeNil())
+					Expect(uploadedContent(pngKey)).To(Equal(pngContent))
+				})
+			})
+		})
+	})
+
+	Describe("disk space", func() {
+		var origFreeTempSpace func() (uint64, error)
+
+		BeforeEach(func() {
+			origFreeTempSpace = deployer.FreeTempSpace
+			deployer.FreeTempSpace = func() (uint64, error) {
+				return uint64(len(fakeS3.DownloadContent)) - 1, nil
+			}
+		})
+
+		AfterEach(func() {
+			deployer.FreeTempSpace = origFreeTempSpace
+		})
+
+		Context("when there is not enough temp space to download the bundle", func() {
+			It("fails the deploy without downloading the bundle", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(Equal(deployer.ErrNoDiskSpace))
+
+				Expect(fakeS3.SizeCalls.Count()).To(Equal(1))
+				Expect(fakeS3.DownloadCalls.Count()).To(Equal(0))
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(ContainSubstring("Insufficient disk space"))
+
+				// The deployment has been failed, so the job is not requeued.
+				Expect(deployer.Retriable(err)).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Retriable()", func() {
+		It("returns false for errors that retrying cannot fix", func() {
+			Expect(deployer.Retriable(deployer.ErrTimeout)).To(BeFalse())
+			Expect(deployer.Retriable(deployer.ErrRecordNotFound)).To(BeFalse())
+			Expect(deployer.Retriable(deployer.ErrCorruptBundle)).To(BeFalse())
+			Expect(deployer.Retriable(deployer.ErrNoDiskSpace)).To(BeFalse())
+		})
+
+		It("returns true for other errors", func() {
+			Expect(deployer.Retriable(deployer.ErrProjectLocked)).To(BeTrue())
+			Expect(deployer.Retriable(errors.New("connection reset by peer"))).To(BeTrue())
+		})
+	})
+
+	Describe("temp dir", func() {
+		var (
+			origTempDir string
+			tmpDir      string
+		)
+
+		BeforeEach(func() {
+			origTempDir = deployer.TempDir
+
+			tmpDir, err = ioutil.TempDir("", "deployer-test-")
+			Expect(err).To(BeNil())
+			deployer.TempDir = filepath.Join(tmpDir, "bundles")
+		})
+
+		AfterEach(func() {
+			deployer.TempDir = origTempDir
+			os.RemoveAll(tmpDir)
+		})
+
+		It("creates the configured temp dir if it does not exist", func() {
+			Expect(deployer.PrepareTempDir()).To(BeNil())
+
+			fi, err := os.Stat(deployer.TempDir)
+			Expect(err).To(BeNil())
+			Expect(fi.IsDir()).To(BeTrue())
+		})
+
+		It("downloads the bundle into the configured temp dir", func() {
+			Expect(deployer.PrepareTempDir()).To(BeNil())
+
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			downloadCall := fakeS3.DownloadCalls.NthCall(1)
+			Expect(downloadCall).NotTo(BeNil())
+
+			f, ok := downloadCall.Arguments[3].(*os.File)
+			Expect(ok).To(BeTrue())
+			Expect(filepath.Dir(f.Name())).To(Equal(deployer.TempDir))
+		})
+	})
+
+	Describe("meta upload failures", func() {
+		var defaultDomain string
+
+		BeforeEach(func() {
+			factories.Domain(db, proj, "pubstorm.com")
+			defaultDomain = proj.DefaultDomainName()
+		})
+
+		failMetaUploads := func(domain string) {
+			var errs []error
+			for i := 0; i < deployer.UploadAttempts; i++ {
+				errs = append(errs, errors.New("service unavailable"))
+			}
+			fakeS3.UploadKeyErrors = map[string][]error{
+				"domains/" + domain + "/meta.json": errs,
+			}
+		}
+
+		doWork := func() error {
+			return deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		}
+
+		Context("when the meta of one of the other domains fails to upload", func() {
+			BeforeEach(func() {
+				failMetaUploads("www.pubstorm.com")
+			})
+
+			It("completes the deploy and records the domain whose meta failed", func() {
+				Expect(doWork()).To(BeNil())
+
+				Expect(uploadedContent("domains/" + defaultDomain + "/meta.json")).NotTo(BeNil())
+				Expect(uploadedContent("domains/pubstorm.com/meta.json")).NotTo(BeNil())
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+				failed, err := depl.FailedMetaDomainNames()
+				Expect(err).To(BeNil())
+				Expect(failed).To(Equal([]string{"www.pubstorm.com"}))
+
+				Expect(invalidatedDomains()).NotTo(ContainElement("www.pubstorm.com"))
+			})
+		})
+
+		Context("when the meta of the primary domain fails to upload", func() {
+			BeforeEach(func() {
+				failMetaUploads(defaultDomain)
+			})
+
+			It("fails the deploy", func() {
+				Expect(doWork()).NotTo(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+			})
+		})
+	})
+
+	Describe("retrying uploads", func() {
+		var indexKey string
+
+		BeforeEach(func() {
+			indexKey = "deployments/" + depl.PrefixID() + "/webroot/index.html"
+		})
+
+		doWork := func() error {
+			return deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		}
+
+		uploadAttempts := func(key string) int {
+			var attempts int
+			for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+				if fakeS3.UploadCalls.NthCall(i).Arguments[2] == key {
+					attempts++
+				}
+			}
+			return attempts
+		}
+
+		Context("when the upload of a file fails fewer times than allowed", func() {
+			BeforeEach(func() {
+				fakeS3.UploadKeyErrors = map[string][]error{
+					indexKey: {errors.New("connection reset by peer"), errors.New("connection reset by peer")},
+				}
+			})
+
+			It("retries the upload of the file and completes the deploy", func() {
+				Expect(doWork()).To(BeNil())
+
+				Expect(uploadAttempts(indexKey)).To(Equal(3))
+				Expect(uploadedContent(indexKey)).NotTo(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when the upload of a file keeps failing", func() {
+			BeforeEach(func() {
+				var errs []error
+				for i := 0; i < deployer.UploadAttempts; i++ {
+					errs = append(errs, errors.New("connection reset by peer"))
+				}
+				fakeS3.UploadKeyErrors = map[string][]error{indexKey: errs}
+			})
+
+			It("aborts the deploy", func() {
+				Expect(doWork()).NotTo(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+			})
+		})
+
+		Context("when S3 fails the upload of a file with a server error", func() {
+			BeforeEach(func() {
+				fakeS3.UploadKeyErrors = map[string][]error{
+					indexKey: {awserr.NewRequestFailure(awserr.New("InternalError", "We encountered an internal error. Please try again.", nil), 500, "req-1")},
+				}
+			})
+
+			It("retries the upload of the file and completes the deploy", func() {
+				Expect(doWork()).To(BeNil())
+				Expect(uploadAttempts(indexKey)).To(Equal(2))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when S3 throttles the upload of a file", func() {
+			BeforeEach(func() {
+				fakeS3.UploadKeyErrors = map[string][]error{
+					indexKey: {
+						awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), 503, "req-1"),
+						awserr.NewRequestFailure(awserr.New("RequestTimeout", "Your socket connection to the server was not read from or written to within the timeout period.", nil), 400, "req-2"),
+					},
+				}
+			})
+
+			It("retries the upload of the file and completes the deploy", func() {
+				Expect(doWork()).To(BeNil())
+				Expect(uploadAttempts(indexKey)).To(Equal(3))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when S3 denies the upload of a file", func() {
+			BeforeEach(func() {
+				fakeS3.UploadKeyErrors = map[string][]error{
+					indexKey: {awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "req-1")},
+				}
+			})
+
+			It("does not retry the upload and aborts the deploy", func() {
+				Expect(doWork()).NotTo(BeNil())
+				Expect(uploadAttempts(indexKey)).To(Equal(1))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+			})
+		})
+	})
+
+	Describe("retrying downloads", func() {
+		doWork := func() error {
+			return deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		}
+
+		Context("when the download of the bundle fails fewer times than allowed", func() {
+			BeforeEach(func() {
+				fakeS3.DownloadErrors = []error{
+					errors.New("connection reset by peer"),
+					awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "Service Unavailable", nil), 503, "req-1"),
+				}
+			})
+
+			It("retries the download and completes the deploy", func() {
+				Expect(doWork()).To(BeNil())
+				Expect(fakeS3.DownloadCalls.Count()).To(Equal(3))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployed))
+			})
+		})
+
+		Context("when the bundle does not exist", func() {
+			BeforeEach(func() {
+				fakeS3.DownloadErrors = []error{
+					awserr.NewRequestFailure(awserr.New("NoSuchKey", "The specified key does not exist.", nil), 404, "req-1"),
+				}
+			})
+
+			It("does not retry the download and aborts the deploy", func() {
+				Expect(doWork()).NotTo(BeNil())
+				Expect(fakeS3.DownloadCalls.Count()).To(Equal(1))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+			})
+		})
+	})
+
+	Describe("concurrent uploads", func() {
+		var origUploadConcurrency int
+
+		BeforeEach(func() {
+			origUploadConcurrency = deployer.UploadConcurrency
+			fakeS3.UploadTimeout = 50 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			deployer.UploadConcurrency = origUploadConcurrency
+		})
+
+		doWork := func() error {
+			return deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		}
+
+		// uploadIndex returns the index of the last upload call to the given key,
+		// or 0 if nothing was uploaded to it.
+		uploadIndex := func(key string) int {
+			index := 0
+			for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+				if fakeS3.UploadCalls.NthCall(i).Arguments[2] == key {
+					index = i
+				}
+			}
+			return index
+		}
+
+		It("uploads the files of the bundle in parallel", func() {
+			deployer.UploadConcurrency = 4
+
+			Expect(doWork()).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+			Expect(fakeS3.MaxConcurrentUploads()).To(BeNumerically(">", 1))
+			Expect(fakeS3.MaxConcurrentUploads()).To(BeNumerically("<=", 4*len(deployer.Targets)))
+
+			manifest, err := depl.ParsedManifest()
+			Expect(err).To(BeNil())
+			Expect(manifest).To(HaveLen(5))
+		})
+
+		It("uploads jsenv.js and meta.json only after all of the files", func() {
+			deployer.UploadConcurrency = 4
+
+			Expect(doWork()).To(BeNil())
+
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+			jsenvIndex := uploadIndex(webroot + "jsenv.js")
+			metaIndex := uploadIndex("domains/www.pubstorm.com/meta.json")
+			Expect(jsenvIndex).NotTo(BeZero())
+			Expect(metaIndex).NotTo(BeZero())
+
+			for _, fileName := range []string{"index.html", "js/app.js", "css/app.css", "images/astley.jpg", "images/rick-astley.jpg"} {
+				i := uploadIndex(webroot + fileName)
+				Expect(i).NotTo(BeZero())
+				Expect(i).To(BeNumerically("<", jsenvIndex))
+				Expect(i).To(BeNumerically("<", metaIndex))
+			}
+		})
+
+		It("uploads one file at a time when the concurrency is 1", func() {
+			deployer.UploadConcurrency = 1
+
+			Expect(doWork()).To(BeNil())
+
+			Expect(fakeS3.MaxConcurrentUploads()).To(Equal(len(deployer.Targets)))
+		})
+
+		Context("when an upload fails", func() {
+			BeforeEach(func() {
+				deployer.UploadConcurrency = 4
+
+				key := "deployments/" + depl.PrefixID() + "/webroot/index.html"
+				fakeS3.UploadKeyErrors = map[string][]error{}
+				for i := 0; i < deployer.UploadAttempts; i++ {
+					fakeS3.UploadKeyErrors[key] = append(fakeS3.UploadKeyErrors[key], errors.New("connection reset by peer"))
+				}
+			})
+
+			It("fails the deploy without uploading jsenv.js or meta.json", func() {
+				Expect(doWork()).NotTo(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+				Expect(uploadedContent("deployments/" + depl.PrefixID() + "/webroot/jsenv.js")).To(BeNil())
+				Expect(uploadedContent("domains/www.pubstorm.com/meta.json")).To(BeNil())
+			})
+		})
+	})
+
+	Describe("resuming a failed upload", func() {
+		var allFiles []string
+
+		BeforeEach(func() {
+			allFiles = []string{
+				"index.html",
+				"js/app.js",
+				"css/app.css",
+				"images/astley.jpg",
+				"images/rick-astley.jpg",
+			}
+
+			// Fail after a few of the files have been uploaded.
+			fakeS3.FailUploadsAfter = 3
+			fakeS3.UploadErrorAfterLimit = errors.New("connection reset by peer")
+		})
+
+		doWork := func() error {
+			return deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		}
+
+		// webrootFilesUploaded returns the files of the bundle that were uploaded
+		// from the nth upload call onwards.
+		webrootFilesUploaded := func(from int) []string {
+			webroot := "deployments/" + depl.PrefixID() + "/webroot/"
+
+			var files []string
+			for i := from; i <= fakeS3.UploadCalls.Count(); i++ {
+				call := fakeS3.UploadCalls.NthCall(i)
+				key := call.Arguments[2].(string)
+				if call.ReturnValues[0] != nil || !strings.HasPrefix(key, webroot) ||
+					strings.HasPrefix(key, webroot+".variants/") || strings.HasSuffix(key, "/jsenv.js") {
+					continue
+				}
+				files = append(files, strings.TrimPrefix(key, webroot))
+			}
+			return files
+		}
+
+		It("records the uploaded files and only uploads the remaining files on retry", func() {
+			Expect(doWork()).NotTo(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+			manifest, err := depl.ParsedManifest()
+			Expect(err).To(BeNil())
+			Expect(manifest).NotTo(BeEmpty())
+			Expect(len(manifest)).To(BeNumerically("<", len(allFiles)))
+
+			var uploadedFirst []string
+			for fileName := range manifest {
+				uploadedFirst = append(uploadedFirst, fileName)
+				Expect(webrootFilesUploaded(1)).To(ContainElement(fileName))
+			}
+
+			// Retry
+			fakeS3.FailUploadsAfter = 0
+			retryFrom := fakeS3.UploadCalls.Count() + 1
+
+			Expect(doWork()).To(BeNil())
+
+			uploadedOnRetry := webrootFilesUploaded(retryFrom)
+			for _, fileName := range uploadedFirst {
+				Expect(uploadedOnRetry).NotTo(ContainElement(fileName))
+			}
+			Expect(append(uploadedFirst, uploadedOnRetry...)).To(ConsistOf(allFiles))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployed))
+
+			manifest, err = depl.ParsedManifest()
+			Expect(err).To(BeNil())
+			Expect(manifest).To(HaveLen(len(allFiles)))
+		})
+
+		It("uploads a file again if it has changed since it was uploaded", func() {
+			Expect(doWork()).NotTo(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			manifest, err := depl.ParsedManifest()
+			Expect(err).To(BeNil())
+
+			var tamperedFile string
+			for fileName, entry := range manifest {
+				tamperedFile = fileName
+				entry.ETag = "d41d8cd98f00b204e9800998ecf8427e"
+				break
+			}
+			Expect(depl.UpdateManifest(db, manifest)).To(BeNil())
+
+			fakeS3.FailUploadsAfter = 0
+			retryFrom := fakeS3.UploadCalls.Count() + 1
+
+			Expect(doWork()).To(BeNil())
+			Expect(webrootFilesUploaded(retryFrom)).To(ContainElement(tamperedFile))
+		})
+	})
+})
+
+// slowTracker is a fake tracker that takes a while to track events.
+type slowTracker struct {
+	*fake.Tracker
+	delay time.Duration
+}
+
+func (t *slowTracker) Track(userID, event, anonymousID string, props, context map[string]interface{}) error {
+	time.Sleep(t.delay)
+	return t.Tracker.Track(userID, event, anonymousID, props, context)
+}
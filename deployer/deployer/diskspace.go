@@ -0,0 +1,33 @@
+package deployer
+
+import (
+	"syscall"
+
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// FreeTempSpace returns the number of bytes available in the directory that
+// bundles are downloaded to.
+var FreeTempSpace = func() (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(tempDir(), &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// hasSpaceForBundle returns whether there is enough temp space to download a
+// bundle at the given path.
+func hasSpaceForBundle(bundlePath string) (bool, error) {
+	size, err := S3.Size(s3client.BucketRegion, s3client.BucketName, bundlePath)
+	if err != nil {
+		return false, err
+	}
+
+	free, err := FreeTempSpace()
+	if err != nil {
+		return false, err
+	}
+
+	return uint64(size) <= free, nil
+}
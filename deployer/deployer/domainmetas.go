@@ -0,0 +1,87 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/nitrous-io/rise-server/shared/meta"
+)
+
+// MetaUploadConcurrency is the maximum number of meta.json files of domains
+// that are uploaded at the same time.
+var MetaUploadConcurrency = 10
+
+// domainMetaJSONs returns the meta.json of each of the given domains, in the
+// order of domainNames. Each is m, plus the ID of the cert of the domain if
+// certIDs has one for it.
+func domainMetaJSONs(domainNames []string, certIDs map[string]uint, m *meta.Meta) ([][]byte, error) {
+	metaJSON, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	metaJSONs := make([][]byte, len(domainNames))
+	for i, domain := range domainNames {
+		certID, ok := certIDs[domain]
+		if !ok {
+			metaJSONs[i] = metaJSON
+			continue
+		}
+
+		withCert := *m
+		withCert.CertID = certID
+		if metaJSONs[i], err = json.Marshal(&withCert); err != nil {
+			return nil, err
+		}
+	}
+
+	return metaJSONs, nil
+}
+
+// uploadDomainMetas uploads metaJSONs as the meta.json of each of the given
+// domains and returns the error of each upload, in the order of domainNames.
+// The first domain is uploaded before the others, so that the others are left
+// alone if it fails. The rest are uploaded MetaUploadConcurrency at a time.
+func uploadDomainMetas(failures *uploadFailures, domainNames []string, metaJSONs [][]byte) []error {
+	errs := make([]error, len(domainNames))
+	if len(domainNames) == 0 {
+		return errs
+	}
+
+	upload := func(i int) error {
+		// Each upload gets its own reader, as readers can't be shared between
+		// goroutines.
+		return uploadToTargets(failures, meta.Path(domainNames[i]), bytes.NewReader(metaJSONs[i]), "application/json")
+	}
+
+	if errs[0] = upload(0); errs[0] != nil {
+		return errs
+	}
+
+	concurrency := MetaUploadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+
+	for i := 1; i < len(domainNames); i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			errs[i] = upload(i)
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
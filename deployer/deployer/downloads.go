@@ -0,0 +1,54 @@
+package deployer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// MaxConcurrentS3Downloads is the maximum number of files that are downloaded
+// from S3 at the same time, to bound the bandwidth and disk I/O used by
+// parallel deploys. It only limits the downloads of one deployer process, not
+// those of all the deployers. Downloads are not limited if it is 0 or less.
+var MaxConcurrentS3Downloads, _ = strconv.Atoi(os.Getenv("DEPLOYER_MAX_CONCURRENT_S3_DOWNLOADS"))
+
+// DownloadAttempts is the number of times downloading a file is attempted
+// before giving up, in case of transient errors.
+var DownloadAttempts = 3
+
+var (
+	downloadsMu   sync.Mutex
+	downloadsCond = sync.NewCond(&downloadsMu)
+	downloads     int
+)
+
+// downloadBundle downloads the bundle at bundlePath to out.
+func downloadBundle(bundlePath string, out io.WriterAt) error {
+	return download(s3client.BucketRegion, s3client.BucketName, bundlePath, out)
+}
+
+// download downloads a file from S3 to out, once fewer than
+// MaxConcurrentS3Downloads other downloads are in progress.
+func download(region, bucket, key string, out io.WriterAt) error {
+	downloadsMu.Lock()
+	for MaxConcurrentS3Downloads > 0 && downloads >= MaxConcurrentS3Downloads {
+		downloadsCond.Wait()
+	}
+	downloads++
+	downloadsMu.Unlock()
+
+	defer func() {
+		downloadsMu.Lock()
+		downloads--
+		downloadsMu.Unlock()
+		downloadsCond.Signal()
+	}()
+
+	return retry(DownloadAttempts, fmt.Sprintf("download %q from %s in %s", key, bucket, region), func() error {
+		return S3.Download(region, bucket, key, out)
+	})
+}
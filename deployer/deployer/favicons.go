@@ -0,0 +1,226 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"regexp"
+)
+
+// faviconSources are the favicons at the root of a bundle that the other
+// favicons are generated from, in order of preference.
+var faviconSources = []string{"favicon.png", "favicon.ico"}
+
+// faviconVariant is a favicon that is generated from the source favicon of a
+// bundle, along with the tag that pages link to it with.
+type faviconVariant struct {
+	name string
+	size int
+	tag  string
+}
+
+var faviconVariants = []faviconVariant{
+	{"favicon-16x16.png", 16, `<link rel="icon" type="image/png" sizes="16x16" href="/favicon-16x16.png">`},
+	{"favicon-32x32.png", 32, `<link rel="icon" type="image/png" sizes="32x32" href="/favicon-32x32.png">`},
+	{"apple-touch-icon.png", 180, `<link rel="apple-touch-icon" sizes="180x180" href="/apple-touch-icon.png">`},
+}
+
+var (
+	// headCloseRe matches the closing tag of the head of an HTML page.
+	headCloseRe = regexp.MustCompile(`(?i)</head\s*>`)
+
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	errUnsupportedICO = errors.New("only icons with PNG images are supported")
+	errInvalidICO     = errors.New("invalid icon")
+	errEmptyFavicon   = errors.New("favicon has no pixels")
+)
+
+// favicons are the favicons generated for a deployment, by name.
+type favicons map[string][]byte
+
+// faviconsFromArchive generates the favicon variants from the source favicon
+// of the bundle in the archive at archivePath. Variants that the bundle
+// already has are not generated. It returns nil if the bundle has no source
+// favicon, or one that cannot be decoded.
+func faviconsFromArchive(archivePath, archiveFormat string) (favicons, error) {
+	sources := map[string][]byte{}
+	existing := map[string]bool{}
+
+	err := walkArchive(archivePath, archiveFormat, func(fileName string, rdr io.Reader) error {
+		for _, v := range faviconVariants {
+			if fileName == v.name {
+				existing[fileName] = true
+			}
+		}
+		for _, s := range faviconSources {
+			if fileName == s {
+				b, err := ioutil.ReadAll(rdr)
+				if err != nil {
+					return err
+				}
+				sources[fileName] = b
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range faviconSources {
+		b, ok := sources[s]
+		if !ok {
+			continue
+		}
+		fvs, err := generateFavicons(b, existing)
+		if err != nil {
+			log.Printf("failed to generate favicons from %s, skipping, err: %v", s, err)
+			return nil, nil
+		}
+		return fvs, nil
+	}
+	return nil, nil
+}
+
+// generateFavicons decodes a PNG or ICO favicon and scales it to the sizes of
+// the favicon variants that are not in skip.
+func generateFavicons(src []byte, skip map[string]bool) (favicons, error) {
+	img, err := decodeFavicon(src)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Empty() {
+		return nil, errEmptyFavicon
+	}
+
+	fvs := favicons{}
+	for _, v := range faviconVariants {
+		if skip[v.name] {
+			continue
+		}
+		buf := new(bytes.Buffer)
+		enc := &png.Encoder{CompressionLevel: png.BestCompression}
+		if err := enc.Encode(buf, scaleSquare(img, v.size)); err != nil {
+			return nil, err
+		}
+		fvs[v.name] = buf.Bytes()
+	}
+	return fvs, nil
+}
+
+// inject adds the tags linking to the generated favicons to the head of the
+// HTML page read from rdr, leaving out those the page already links to.
+func (fvs favicons) inject(rdr io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := headCloseRe.FindIndex(b)
+	if loc == nil {
+		return bytes.NewReader(b), nil
+	}
+
+	var tags []byte
+	for _, v := range faviconVariants {
+		if _, ok := fvs[v.name]; !ok || bytes.Contains(b, []byte("/"+v.name)) {
+			continue
+		}
+		tags = append(tags, v.tag...)
+	}
+
+	out := make([]byte, 0, len(b)+len(tags))
+	out = append(out, b[:loc[0]]...)
+	out = append(out, tags...)
+	out = append(out, b[loc[0]:]...)
+	return bytes.NewReader(out), nil
+}
+
+// decodeFavicon decodes a PNG favicon, or the largest image of an ICO
+// favicon. Only ICO files whose images are PNG-encoded are supported.
+func decodeFavicon(b []byte) (image.Image, error) {
+	if bytes.HasPrefix(b, pngSignature) {
+		return png.Decode(bytes.NewReader(b))
+	}
+
+	// An ICO file starts with a 6-byte header that has the type (1 for icons)
+	// and the number of images, followed by a 16-byte directory entry for each
+	// image.
+	if len(b) < 6 || binary.LittleEndian.Uint16(b[2:4]) != 1 {
+		return nil, errInvalidICO
+	}
+	n := int(binary.LittleEndian.Uint16(b[4:6]))
+
+	best, bestWidth := -1, 0
+	for i := 0; i < n; i++ {
+		e := 6 + 16*i
+		if e+16 > len(b) {
+			return nil, errInvalidICO
+		}
+		// A width of 0 means 256 pixels.
+		w := int(b[e])
+		if w == 0 {
+			w = 256
+		}
+		if w > bestWidth {
+			best, bestWidth = e, w
+		}
+	}
+	if best < 0 {
+		return nil, errInvalidICO
+	}
+
+	size := uint64(binary.LittleEndian.Uint32(b[best+8 : best+12]))
+	offset := uint64(binary.LittleEndian.Uint32(b[best+12 : best+16]))
+	if offset+size > uint64(len(b)) {
+		return nil, errInvalidICO
+	}
+	data := b[offset : offset+size]
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errUnsupportedICO
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// scaleSquare scales the centered square of img to size by size pixels,
+// averaging the pixels that each pixel of the result covers.
+func scaleSquare(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0, y0 := b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0, sy1 := y0+y*side/size, y0+(y+1)*side/size
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < size; x++ {
+			sx0, sx1 := x0+x*side/size, x0+(x+1)*side/size
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
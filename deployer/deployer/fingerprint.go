@@ -0,0 +1,233 @@
+package deployer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// fingerprintLength is the number of hex characters of the content hash that
+// is added to the names of fingerprinted assets.
+const fingerprintLength = 10
+
+var (
+	// fingerprintExts are the extensions of the assets that are fingerprinted.
+	// Pages are not, as they are visited by their names.
+	fingerprintExts = map[string]bool{
+		".css":   true,
+		".js":    true,
+		".png":   true,
+		".jpg":   true,
+		".jpeg":  true,
+		".gif":   true,
+		".svg":   true,
+		".webp":  true,
+		".ico":   true,
+		".woff":  true,
+		".woff2": true,
+		".ttf":   true,
+		".otf":   true,
+		".eot":   true,
+	}
+
+	// cssURLRe matches the url() references of stylesheets, capturing the
+	// quoted or unquoted value.
+	cssURLRe = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^\s"')]+))\s*\)`)
+)
+
+// fingerprints maps the paths of the assets of a deployment to their
+// fingerprinted paths, which have a hash of their content before the
+// extension, e.g. "css/app.css" to "css/app.0123456789.css".
+type fingerprints map[string]string
+
+// fingerprintArchive returns the fingerprints of the assets in the archive at
+// archivePath. Stylesheets are hashed after their references to other assets
+// are rewritten, so that they get a new fingerprint when an asset they use
+// changes.
+func fingerprintArchive(archivePath, archiveFormat string) (fingerprints, error) {
+	fps := fingerprints{}
+	stylesheets := map[string][]byte{}
+
+	err := walkArchive(archivePath, archiveFormat, func(fileName string, rdr io.Reader) error {
+		ext := strings.ToLower(path.Ext(fileName))
+		if !fingerprintExts[ext] {
+			return nil
+		}
+
+		if ext == ".css" {
+			b, err := ioutil.ReadAll(rdr)
+			if err != nil {
+				return err
+			}
+			stylesheets[fileName] = b
+			return nil
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, rdr); err != nil {
+			return err
+		}
+		fps[fileName] = fingerprintedPath(fileName, h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cssFps := map[string]string{}
+	for fileName, b := range stylesheets {
+		sum := sha256.Sum256(fps.rewrite(fileName, "text/css", b))
+		cssFps[fileName] = fingerprintedPath(fileName, sum[:])
+	}
+	for fileName, fp := range cssFps {
+		fps[fileName] = fp
+	}
+
+	return fps, nil
+}
+
+// rewrite returns the content of the HTML page or stylesheet at filePath with
+// its references to assets changed to their fingerprinted names. References
+// from stylesheets to other stylesheets are left alone, as the fingerprints
+// of stylesheets are taken after rewriting.
+func (fps fingerprints) rewrite(filePath, contentType string, b []byte) []byte {
+	re := linkAttrRe
+	if contentType == "text/css" {
+		re = cssURLRe
+	}
+
+	var (
+		out  []byte
+		last int
+	)
+	for _, m := range re.FindAllSubmatchIndex(b, -1) {
+		// Only one of the groups of the quoted or unquoted value matches.
+		start, end := -1, -1
+		for i := 2; i < len(m); i += 2 {
+			if m[i] >= 0 {
+				start, end = m[i], m[i+1]
+				break
+			}
+		}
+		if start < 0 {
+			continue
+		}
+
+		link, ok := fps.rewriteLink(filePath, contentType, string(b[start:end]))
+		if !ok {
+			continue
+		}
+
+		out = append(out, b[last:start]...)
+		out = append(out, link...)
+		last = end
+	}
+
+	if out == nil {
+		return b
+	}
+	return append(out, b[last:]...)
+}
+
+// rewriteLink returns the link with the name of the asset it points to changed
+// to its fingerprinted name, or false if it does not point to an asset.
+func (fps fingerprints) rewriteLink(filePath, contentType, link string) (string, bool) {
+	target, ok := resolveLink(filePath, link)
+	if !ok {
+		return "", false
+	}
+
+	fp, ok := fps[target]
+	if !ok || (contentType == "text/css" && strings.ToLower(path.Ext(target)) == ".css") {
+		return "", false
+	}
+
+	// Only the name of the file changes, so the directory, query and fragment
+	// of the link are kept as they are.
+	end := len(link)
+	if i := strings.IndexAny(link, "?#"); i != -1 {
+		end = i
+	}
+
+	name := path.Base(target)
+	if !strings.HasSuffix(link[:end], name) {
+		return "", false
+	}
+
+	return link[:end-len(name)] + path.Base(fp) + link[end:], true
+}
+
+// fingerprintedPath returns the path with the hex-encoded sum inserted before
+// its extension.
+func fingerprintedPath(p string, sum []byte) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + hex.EncodeToString(sum)[:fingerprintLength] + ext
+}
+
+// walkArchive calls fn with the path and content of each file in the "tar.gz"
+// or "zip" archive at archivePath.
+func walkArchive(archivePath, archiveFormat string, fn func(fileName string, rdr io.Reader) error) error {
+	if archiveFormat == "zip" {
+		r, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return ErrCorruptBundle
+		}
+		defer r.Close()
+
+		for _, file := range r.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+
+			rc, err := file.Open()
+			if err != nil {
+				return corruptBundleError(err)
+			}
+			err = fn(path.Clean(file.Name), rc)
+			rc.Close()
+			if err != nil {
+				return corruptBundleError(err)
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return ErrCorruptBundle
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return corruptBundleError(err)
+		}
+
+		if hdr.FileInfo().IsDir() {
+			continue
+		}
+
+		if err := fn(path.Clean(hdr.Name), tr); err != nil {
+			return corruptBundleError(err)
+		}
+	}
+}
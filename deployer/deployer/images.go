@@ -0,0 +1,66 @@
+package deployer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// Only images within this size range are optimized. Smaller images have
+// little to gain, and larger ones take too long to recompress.
+var (
+	MinImageSizeToOptimize int64 = 1024             // in bytes
+	MaxImageSizeToOptimize int64 = 10 * 1000 * 1000 // in bytes
+
+	// JPEGQuality is the quality JPEG images are recompressed at. PNG images
+	// are recompressed losslessly.
+	JPEGQuality = 85
+)
+
+// isOptimizableImage returns whether an image of the given content type and
+// size should be recompressed when the project has image optimization on.
+func isOptimizableImage(contentType string, size int64) bool {
+	if contentType != "image/png" && contentType != "image/jpeg" {
+		return false
+	}
+	return size >= MinImageSizeToOptimize && size <= MaxImageSizeToOptimize
+}
+
+// optimizeImage recompresses a PNG or JPEG image. The original image is
+// returned if it cannot be decoded or if recompressing it does not make it
+// smaller, e.g. because it has already been optimized.
+func optimizeImage(in io.Reader, contentType string) ([]byte, error) {
+	original, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		log.Printf("failed to decode %s image, skipping optimization, err: %v", contentType, err)
+		return original, nil
+	}
+
+	buf := new(bytes.Buffer)
+	switch contentType {
+	case "image/png":
+		enc := &png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(buf, img)
+	case "image/jpeg":
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: JPEGQuality})
+	default:
+		return original, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(original) {
+		return original, nil
+	}
+	return buf.Bytes(), nil
+}
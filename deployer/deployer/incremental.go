@@ -0,0 +1,101 @@
+package deployer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/meta"
+)
+
+// activeWebroot is the webroot of the active deployment of a project, whose
+// unchanged files are copied to the webroot of a new deployment rather than
+// uploaded again.
+type activeWebroot struct {
+	webroot  string
+	manifest deployment.Manifest
+}
+
+// findActiveWebroot returns the webroot of the active deployment of the
+// project, or nil if there is none other than depl, or if it does not have a
+// manifest to compare files against.
+func findActiveWebroot(db *gorm.DB, proj *project.Project, depl *deployment.Deployment) (*activeWebroot, error) {
+	if proj.ActiveDeploymentID == nil || *proj.ActiveDeploymentID == depl.ID {
+		return nil, nil
+	}
+
+	active := &deployment.Deployment{}
+	if err := db.First(active, *proj.ActiveDeploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	manifest, err := active.ParsedManifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest) == 0 {
+		return nil, nil
+	}
+
+	return &activeWebroot{
+		webroot:  shared.DeploymentKey(active.PrefixID(), "webroot"),
+		manifest: manifest,
+	}, nil
+}
+
+// reuse copies the file at fileName in the active webroot to fileName in
+// webroot, along with its variants, if it has the same content and would have
+// the same variants. It returns the manifest entry of the copy, or nil if the file has
+// to be uploaded. Files that cannot be copied, e.g. because they are no longer
+// in the active webroot, are uploaded instead.
+func (w *activeWebroot) reuse(webroot, fileName string, content []byte, contentType string) *deployment.ManifestEntry {
+	prev, ok := w.manifest[fileName]
+	if !ok || prev.SHA256 == "" || prev.SHA256 != sha256Hex(content) {
+		return nil
+	}
+
+	compressible := compressibleContentTypes[contentType] && int64(len(content)) <= MaxFileSizeToCompress
+	var prevCompressed bool
+	for _, enc := range prev.Encodings {
+		if enc == meta.EncodingGzip {
+			prevCompressed = true
+		}
+	}
+	if compressible != prevCompressed {
+		return nil
+	}
+
+	keys := map[string]string{w.webroot + "/" + fileName: webroot + "/" + fileName}
+	if compressible {
+		keys[meta.VariantPath(w.webroot, fileName, meta.EncodingGzip)] = meta.VariantPath(webroot, fileName, meta.EncodingGzip)
+	}
+
+	for _, t := range Targets {
+		for src, dest := range keys {
+			err := retry(UploadAttempts, fmt.Sprintf("copy %q to %q in %s in %s", src, dest, t.Bucket, t.Region), func() error {
+				return S3.Copy(t.Region, t.Bucket, src, dest, "public-read")
+			})
+			if err != nil {
+				log.Printf("failed to copy unchanged file %q from the active deployment, uploading it instead, err: %v", fileName, err)
+				return nil
+			}
+		}
+	}
+
+	entry := *prev
+	return &entry
+}
+
+// sha256Hex returns the hex-encoded SHA-256 checksum of b.
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
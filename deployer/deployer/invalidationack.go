@@ -0,0 +1,32 @@
+package deployer
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/shared/messages"
+)
+
+// WorkInvalidationAck records an edge's acknowledgement of the cache
+// invalidation of a deployment, which completes the invalidation, or fails it
+// if the edge could not invalidate its cache.
+func WorkInvalidationAck(data []byte) error {
+	m := &messages.V1InvalidationAckMessageData{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return err
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		return err
+	}
+
+	succeeded := m.Error == ""
+	if !succeeded {
+		log.Printf("edge failed to invalidate deployment %d, err: %s", m.DeploymentID, m.Error)
+	}
+
+	return deployment.RecordInvalidationAck(db, m.DeploymentID, succeeded)
+}
@@ -0,0 +1,136 @@
+package deployer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// maxBrokenLinkWarnings is the maximum number of broken links that are
+// recorded on a deployment individually.
+const maxBrokenLinkWarnings = 50
+
+// linkAttrRe matches the href and src attributes of HTML elements, capturing
+// the quoted or unquoted value.
+var linkAttrRe = regexp.MustCompile(`(?i)\s(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// linkChecker collects the links between the files of a deployment as its HTML
+// pages are uploaded, so that links to files that are not in the deployment
+// can be reported once all files are uploaded.
+type linkChecker struct {
+	mu sync.Mutex
+	// links maps the paths of linked files to the pages that link to them.
+	links map[string][]string
+}
+
+func newLinkChecker() *linkChecker {
+	return &linkChecker{links: map[string][]string{}}
+}
+
+// collect records the internal links of the HTML page read from rdr, and
+// returns a reader of the page for it to be uploaded.
+func (lc *linkChecker) collect(pagePath string, rdr io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	for _, target := range internalLinks(pagePath, b) {
+		lc.links[target] = append(lc.links[target], pagePath)
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// brokenLinks returns a warning for each collected link to a file that is
+// neither in the manifest nor one of the extra paths, sorted by page.
+func (lc *linkChecker) brokenLinks(m deployment.Manifest, extraPaths ...string) []string {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	exists := func(p string) bool {
+		if _, ok := m[p]; ok {
+			return true
+		}
+		for _, extra := range extraPaths {
+			if p == extra {
+				return true
+			}
+		}
+		return false
+	}
+
+	warnings := []string{}
+	for target, pages := range lc.links {
+		if exists(target) || exists(path.Join(target, "index.html")) {
+			continue
+		}
+		for _, page := range pages {
+			warnings = append(warnings, fmt.Sprintf("%s links to missing file %s", page, target))
+		}
+	}
+	sort.Strings(warnings)
+
+	if len(warnings) > maxBrokenLinkWarnings {
+		more := len(warnings) - maxBrokenLinkWarnings
+		warnings = append(warnings[:maxBrokenLinkWarnings], fmt.Sprintf("and %d more missing files", more))
+	}
+
+	return warnings
+}
+
+// internalLinks returns the paths in the webroot that an HTML page links to.
+// Links to other hosts, links with a scheme (e.g. "mailto:") and links to
+// fragments of the same page are left out.
+func internalLinks(pagePath string, html []byte) []string {
+	var targets []string
+	seen := map[string]bool{}
+
+	for _, match := range linkAttrRe.FindAllSubmatch(html, -1) {
+		target, ok := resolveLink(pagePath, string(bytes.Join(match[1:], nil)))
+		if !ok {
+			continue
+		}
+
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
+
+// resolveLink returns the path in the webroot that a link in the file at
+// filePath points to. It returns false for links to other hosts, links with a
+// scheme and links to fragments of the same file.
+func resolveLink(filePath, link string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" || u.Path == "" {
+		return "", false
+	}
+
+	var target string
+	if strings.HasPrefix(u.Path, "/") {
+		target = path.Clean(u.Path)[1:]
+	} else {
+		target = path.Join(path.Dir(filePath), u.Path)
+	}
+	if target == "" || target == "." {
+		target = "index.html"
+	}
+
+	return target, true
+}
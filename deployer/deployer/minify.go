@@ -0,0 +1,239 @@
+package deployer
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Elements whose content is copied verbatim when minifying HTML, since
+// whitespace is significant in them.
+var verbatimElements = map[string]bool{
+	"pre":      true,
+	"textarea": true,
+	"script":   true,
+	"style":    true,
+}
+
+// minifyHTML returns a reader that yields the HTML read from in with runs of
+// whitespace collapsed and comments stripped. The content of elements in
+// verbatimElements and conditional comments are left as they are. The HTML is
+// minified as it is read rather than buffered in its entirety. The returned
+// reader must be closed if it is not read to the end.
+func minifyHTML(in io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		w := bufio.NewWriter(pw)
+		err := (&htmlMinifier{r: bufio.NewReader(in), w: w}).run()
+		if err == nil {
+			err = w.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+type htmlMinifier struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (m *htmlMinifier) run() error {
+	for {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch {
+		case b == '<':
+			if err := m.markup(); err != nil {
+				return err
+			}
+		case isHTMLSpace(b):
+			if err := m.whitespace(b); err != nil {
+				return err
+			}
+		default:
+			if err := m.w.WriteByte(b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// whitespace collapses a run of whitespace that starts with b into a single
+// newline if the run spans lines, or a single space otherwise.
+func (m *htmlMinifier) whitespace(b byte) error {
+	newline := b == '\n'
+	for {
+		next, err := m.r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+		if !isHTMLSpace(next) {
+			if err := m.r.UnreadByte(); err != nil {
+				return err
+			}
+			break
+		}
+		if next == '\n' {
+			newline = true
+		}
+	}
+
+	if newline {
+		return m.w.WriteByte('\n')
+	}
+	return m.w.WriteByte(' ')
+}
+
+// markup handles what follows a '<', which is either a comment, a tag or
+// just a '<' in text.
+func (m *htmlMinifier) markup() error {
+	if p, _ := m.r.Peek(3); string(p) == "!--" {
+		return m.comment()
+	}
+
+	p, err := m.r.Peek(1)
+	if err != nil || !isTagStart(p[0]) {
+		return m.w.WriteByte('<')
+	}
+
+	tag, err := m.tag()
+	if err != nil {
+		return err
+	}
+	if _, err := m.w.Write(tag); err != nil {
+		return err
+	}
+
+	name := tagName(tag)
+	if verbatimElements[name] && !bytes.HasSuffix(tag, []byte("/>")) {
+		return m.verbatim(name)
+	}
+	return nil
+}
+
+// comment strips a comment, unless it is a conditional comment.
+func (m *htmlMinifier) comment() error {
+	var buf bytes.Buffer
+	buf.WriteByte('<')
+	for {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			// Leave unterminated comments as they are.
+			_, err := m.w.Write(buf.Bytes())
+			return err
+		}
+		buf.WriteByte(b)
+		if b == '>' && bytes.HasSuffix(buf.Bytes(), []byte("-->")) && buf.Len() >= len("<!---->") {
+			break
+		}
+	}
+
+	comment := buf.Bytes()
+	if bytes.Contains(comment, []byte("[if ")) || bytes.Contains(comment, []byte("[endif]")) {
+		_, err := m.w.Write(comment)
+		return err
+	}
+	return nil
+}
+
+// tag reads the rest of a tag, including the leading '<'.
+func (m *htmlMinifier) tag() ([]byte, error) {
+	var (
+		buf   bytes.Buffer
+		quote byte
+	)
+	buf.WriteByte('<')
+	for {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return buf.Bytes(), nil
+			}
+			return nil, err
+		}
+		buf.WriteByte(b)
+
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '>':
+			return buf.Bytes(), nil
+		}
+	}
+}
+
+// verbatim copies the content of an element up to and including its closing
+// tag.
+func (m *htmlMinifier) verbatim(name string) error {
+	closing := "/" + name
+	for {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if b == '<' {
+			p, _ := m.r.Peek(len(closing))
+			if strings.EqualFold(string(p), closing) {
+				tag, err := m.tag()
+				if err != nil {
+					return err
+				}
+				_, err = m.w.Write(tag)
+				return err
+			}
+		}
+
+		if err := m.w.WriteByte(b); err != nil {
+			return err
+		}
+	}
+}
+
+// tagName returns the lowercased name of an opening tag, or an empty string
+// if tag is not an opening tag.
+func tagName(tag []byte) string {
+	name := tag[1:]
+	for i, b := range name {
+		if !isLetter(b) && !(i > 0 && b >= '0' && b <= '9') {
+			name = name[:i]
+			break
+		}
+	}
+	return strings.ToLower(string(name))
+}
+
+func isTagStart(b byte) bool {
+	return isLetter(b) || b == '/' || b == '!' || b == '?'
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isHTMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
@@ -0,0 +1,88 @@
+package deployer
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxMixedContentWarnings is the maximum number of insecure resource URLs
+// that are recorded on a deployment individually.
+const maxMixedContentWarnings = 50
+
+var (
+	// resourceTagRe matches the opening tags of HTML elements that load a
+	// resource into the page, capturing their attributes. Links to other
+	// pages are left out, as following them is not mixed content.
+	resourceTagRe = regexp.MustCompile(`(?i)<(?:img|script|link|iframe|frame|source|video|audio|track|embed|object|input)(\s[^>]*)?>`)
+	// resourceAttrRe matches the attributes that hold the URL of a resource,
+	// capturing the quoted or unquoted value.
+	resourceAttrRe = regexp.MustCompile(`(?i)\s(src|srcset|href|data|poster)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	// insecureCSSURLRe matches the url() and @import references of a
+	// stylesheet to insecure URLs.
+	insecureCSSURLRe = regexp.MustCompile(`(?i)(?:url\(\s*["']?|@import\s+["'])(http://[^"')\s]+)`)
+)
+
+// mixedContent returns the insecure http:// URLs of the resources that an
+// HTML page or stylesheet loads, which browsers block or warn about when the
+// page is served over HTTPS.
+func mixedContent(contentType string, b []byte) []string {
+	var urls []string
+	seen := map[string]bool{}
+	add := func(u string) {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	if contentType == "text/html" {
+		b = commentRe.ReplaceAll(b, nil)
+
+		for _, tag := range resourceTagRe.FindAllSubmatch(b, -1) {
+			for _, attr := range resourceAttrRe.FindAllSubmatch(tag[1], -1) {
+				value := string(bytes.Join(attr[2:], nil))
+
+				// srcset is a comma-separated list of URLs, each followed
+				// by an optional descriptor.
+				candidates := []string{value}
+				if strings.EqualFold(string(attr[1]), "srcset") {
+					candidates = strings.Split(value, ",")
+				}
+
+				for _, c := range candidates {
+					if fields := strings.Fields(c); len(fields) > 0 && isInsecureURL(fields[0]) {
+						add(fields[0])
+					}
+				}
+			}
+		}
+	}
+
+	// Stylesheets are also inlined into pages in style elements and
+	// attributes.
+	for _, match := range insecureCSSURLRe.FindAllSubmatch(b, -1) {
+		add(string(match[1]))
+	}
+
+	return urls
+}
+
+func isInsecureURL(u string) bool {
+	return len(u) > len("http://") && strings.EqualFold(u[:len("http://")], "http://")
+}
+
+// mixedContentWarnings sorts the insecure resource URLs found in the files of
+// a deployment and caps their number.
+func mixedContentWarnings(found []string) []string {
+	sort.Strings(found)
+
+	if len(found) > maxMixedContentWarnings {
+		more := len(found) - maxMixedContentWarnings
+		found = append(found[:maxMixedContentWarnings], fmt.Sprintf("and %d more insecure resources", more))
+	}
+
+	return found
+}
@@ -0,0 +1,113 @@
+package deployer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// HeadersFileName is the name of the file at the root of a bundle that sets
+// headers per path. It is parsed into the meta.json of the deployment instead
+// of being served.
+const HeadersFileName = "_headers"
+
+var (
+	// MaxHeadersFileSize is the maximum size of a _headers file, in bytes.
+	MaxHeadersFileSize int64 = 64 * 1024
+
+	// MaxPathHeaderRules is the maximum number of paths a _headers file may
+	// set headers for.
+	MaxPathHeaderRules = 100
+
+	// MaxHeadersPerPath is the maximum number of headers a _headers file may
+	// set for a single path.
+	MaxHeadersPerPath = 20
+)
+
+var headerNameRegexp = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// unsettableHeaders are the headers that edges manage themselves.
+var unsettableHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// parseHeadersFile parses a _headers file into header rules. Each rule starts
+// with a path on a line of its own, followed by its headers as indented
+// "Name: value" lines. Lines starting with "#" are comments. Values of a
+// header that is set more than once for a path are joined with commas.
+func parseHeadersFile(r io.Reader) ([]deployment.PathHeaders, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxHeadersFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > MaxHeadersFileSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", MaxHeadersFileSize)
+	}
+
+	rules := []deployment.PathHeaders{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Paths are not indented, headers are.
+		if line == strings.TrimLeft(line, " \t") {
+			if !strings.HasPrefix(trimmed, "/") || strings.ContainsAny(trimmed, " \t") {
+				return nil, fmt.Errorf("line %d: %q is not a valid path", lineNum, trimmed)
+			}
+			if len(rules) == MaxPathHeaderRules {
+				return nil, fmt.Errorf("headers can be set for at most %d paths", MaxPathHeaderRules)
+			}
+			rules = append(rules, deployment.PathHeaders{Path: trimmed, Headers: map[string]string{}})
+			continue
+		}
+
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("line %d: header is not under a path", lineNum)
+		}
+		rule := &rules[len(rules)-1]
+
+		i := strings.Index(trimmed, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: %q is not a valid header", lineNum, trimmed)
+		}
+		name := strings.TrimSpace(trimmed[:i])
+		value := strings.TrimSpace(trimmed[i+1:])
+		if !headerNameRegexp.MatchString(name) || value == "" {
+			return nil, fmt.Errorf("line %d: %q is not a valid header", lineNum, trimmed)
+		}
+
+		name = http.CanonicalHeaderKey(name)
+		if unsettableHeaders[name] {
+			return nil, fmt.Errorf("line %d: %s header cannot be set", lineNum, name)
+		}
+
+		if prev, ok := rule.Headers[name]; ok {
+			rule.Headers[name] = prev + ", " + value
+			continue
+		}
+		if len(rule.Headers) == MaxHeadersPerPath {
+			return nil, fmt.Errorf("line %d: at most %d headers can be set for a path", lineNum, MaxHeadersPerPath)
+		}
+		rule.Headers[name] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,66 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// The pre-deploy hook holds up the deploy while it is called, so it is not
+// retried unless PreDeployHookAttempts is raised.
+var (
+	PreDeployHookTimeout       = 5 * time.Second
+	PreDeployHookAttempts      = 1
+	PreDeployHookRetryInterval = 2 * time.Second
+)
+
+type preDeployHookRequest struct {
+	ProjectName       string  `json:"project_name"`
+	DeploymentID      uint    `json:"deployment_id"`
+	DeploymentVersion int64   `json:"deployment_version"`
+	Label             *string `json:"label,omitempty"`
+	Branch            *string `json:"branch,omitempty"`
+	CommitSHA         *string `json:"commit_sha,omitempty"`
+}
+
+// callPreDeployHook calls the pre-deploy hook of a project once the bundle of
+// a deployment is downloaded, before any of its files are uploaded. It
+// returns an error describing why the deployment should not go ahead, or nil
+// if the hook allows it.
+func callPreDeployHook(proj *project.Project, depl *deployment.Deployment) error {
+	reqBody, err := json.Marshal(&preDeployHookRequest{
+		ProjectName:       proj.Name,
+		DeploymentID:      depl.ID,
+		DeploymentVersion: depl.Version,
+		Label:             depl.Label,
+		Branch:            depl.Branch,
+		CommitSHA:         depl.CommitSHA,
+	})
+	if err != nil {
+		return err
+	}
+
+	client := common.HTTPClient()
+	client.Timeout = PreDeployHookTimeout
+
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Post(*proj.PreDeployHookURL, "application/json", bytes.NewReader(reqBody))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("pre-deploy hook responded with %d", resp.StatusCode)
+		}
+
+		if attempt >= PreDeployHookAttempts {
+			return err
+		}
+		time.Sleep(PreDeployHookRetryInterval)
+	}
+}
@@ -0,0 +1,118 @@
+package deployer
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// preloadPage is the page whose critical resources are preloaded, if the
+// project has preload hints on.
+const preloadPage = "index.html"
+
+var (
+	// criticalTagRe matches the opening <link> and <script> tags of an HTML
+	// page, capturing the tag name and its attributes.
+	criticalTagRe = regexp.MustCompile(`(?i)<(link|script)(\s[^>]*)?>`)
+	// tagAttrRe matches the attributes of a tag, capturing the name and the
+	// quoted or unquoted value.
+	tagAttrRe = regexp.MustCompile(`(?i)\s([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	// asyncAttrRe matches the async attribute of a script, which need not
+	// have a value.
+	asyncAttrRe = regexp.MustCompile(`(?i)\sasync(?:\s*=|[\s/]|$)`)
+)
+
+// fontExts are the extensions of the font files that pages link to.
+var fontExts = map[string]bool{
+	".woff2": true,
+	".woff":  true,
+	".ttf":   true,
+	".otf":   true,
+}
+
+// preloadHints returns the values of the Link header that preloads the
+// critical resources of an HTML page: its stylesheets, the scripts it loads
+// synchronously and the fonts it links to. Resources on other hosts are left
+// out.
+func preloadHints(pagePath string, html []byte) []string {
+	var hints []string
+	seen := map[string]bool{}
+
+	for _, tag := range criticalTagRe.FindAllSubmatch(commentRe.ReplaceAll(html, nil), -1) {
+		attrs := map[string]string{}
+		for _, attr := range tagAttrRe.FindAllSubmatch(tag[2], -1) {
+			attrs[strings.ToLower(string(attr[1]))] = string(bytes.Join(attr[2:], nil))
+		}
+
+		var link, as string
+		if strings.EqualFold(string(tag[1]), "script") {
+			if asyncAttrRe.Match(tag[2]) {
+				continue
+			}
+			link, as = attrs["src"], "script"
+		} else {
+			link = attrs["href"]
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				if rel == "stylesheet" {
+					as = "style"
+				}
+			}
+			if as == "" && fontExts[strings.ToLower(path.Ext(link))] {
+				as = "font"
+			}
+		}
+
+		if as == "" {
+			continue
+		}
+		target, ok := resolveLink(pagePath, link)
+		if !ok || seen[target] {
+			continue
+		}
+		seen[target] = true
+
+		hint := "</" + target + ">; rel=preload; as=" + as
+		// Fonts are always fetched in CORS mode, so they are only preloaded
+		// for use if the hint is too.
+		if as == "font" {
+			hint += "; crossorigin"
+		}
+		hints = append(hints, hint)
+	}
+
+	return hints
+}
+
+// addPreloadHints adds the Link header that preloads the given resources to
+// the rules for the root of the deployment, after any Link header that the
+// _headers file of the bundle sets for it.
+func addPreloadHints(rules []deployment.PathHeaders, hints []string) []deployment.PathHeaders {
+	if len(hints) == 0 {
+		return rules
+	}
+	value := strings.Join(hints, ", ")
+
+	for _, p := range []string{"/", "/" + preloadPage} {
+		found := false
+		for _, rule := range rules {
+			if rule.Path != p {
+				continue
+			}
+			found = true
+			if prev, ok := rule.Headers["Link"]; ok {
+				rule.Headers["Link"] = prev + ", " + value
+			} else {
+				rule.Headers["Link"] = value
+			}
+		}
+
+		if !found {
+			rules = append(rules, deployment.PathHeaders{Path: p, Headers: map[string]string{"Link": value}})
+		}
+	}
+
+	return rules
+}
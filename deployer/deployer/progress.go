@@ -0,0 +1,84 @@
+package deployer
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// uploadProgress keeps track of the files that have been uploaded to the
+// webroot of a deployment. It is saved when an upload fails, so that a retry
+// of the deploy can skip the files that have already been uploaded.
+type uploadProgress struct {
+	mu       sync.Mutex
+	manifest deployment.Manifest
+}
+
+func (p *uploadProgress) add(path string, entry *deployment.ManifestEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.manifest[path] = entry
+}
+
+func (p *uploadProgress) save(db *gorm.DB, depl *deployment.Deployment) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return depl.UpdateManifest(db, p.manifest)
+}
+
+// isUploaded returns whether a file described by a manifest entry from a
+// previous attempt is still intact on every target.
+func isUploaded(remotePath string, entry *deployment.ManifestEntry) (bool, error) {
+	if entry.ETag == "" {
+		return false, nil
+	}
+
+	for _, t := range Targets {
+		etag, err := S3.ETag(t.Region, t.Bucket, remotePath)
+		if err != nil {
+			return false, err
+		}
+		if etag != entry.ETag {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// checksumReader computes the size and the MD5 and SHA-256 checksums of what
+// is read through it.
+type checksumReader struct {
+	io.Reader
+	hash   hash.Hash
+	sha256 hash.Hash
+	size   int64
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{Reader: r, hash: md5.New(), sha256: sha256.New()}
+}
+
+func (r *checksumReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.hash.Write(p[:n])
+	r.sha256.Write(p[:n])
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *checksumReader) manifestEntry() *deployment.ManifestEntry {
+	return &deployment.ManifestEntry{
+		Size:   r.size,
+		ETag:   hex.EncodeToString(r.hash.Sum(nil)),
+		SHA256: hex.EncodeToString(r.sha256.Sum(nil)),
+	}
+}
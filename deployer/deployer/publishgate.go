@@ -0,0 +1,60 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+var (
+	PublishGateTimeout       = 10 * time.Second
+	PublishGateAttempts      = 3
+	PublishGateRetryInterval = 2 * time.Second
+)
+
+type publishGateRequest struct {
+	ProjectName       string `json:"project_name"`
+	DeploymentID      uint   `json:"deployment_id"`
+	DeploymentVersion int64  `json:"deployment_version"`
+	PreviewURL        string `json:"preview_url"`
+}
+
+// checkPublishGate asks the publish gate of a project whether a deployment may
+// be published. The deployment is previewable through the staging alias while
+// the gate is checked. It returns an error describing why the deployment
+// should not be published, or nil if the gate passes.
+func checkPublishGate(proj *project.Project, depl *deployment.Deployment) error {
+	reqBody, err := json.Marshal(&publishGateRequest{
+		ProjectName:       proj.Name,
+		DeploymentID:      depl.ID,
+		DeploymentVersion: depl.Version,
+		PreviewURL:        "https://" + proj.AliasDomainName(project.StagingAlias),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := common.HTTPClient()
+	client.Timeout = PublishGateTimeout
+
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Post(*proj.PublishGateURL, "application/json", bytes.NewReader(reqBody))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("publish gate responded with %d", resp.StatusCode)
+		}
+
+		if attempt >= PublishGateAttempts {
+			return err
+		}
+		time.Sleep(PublishGateRetryInterval)
+	}
+}
@@ -0,0 +1,27 @@
+package deployer
+
+import (
+	"sort"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// missingRequiredFiles returns the sorted paths of the files that the project
+// requires but that are not in the manifest of uploaded files.
+func missingRequiredFiles(proj *project.Project, uploaded deployment.Manifest) ([]string, error) {
+	paths, err := proj.RequiredFilePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, p := range paths {
+		if _, ok := uploaded[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing, nil
+}
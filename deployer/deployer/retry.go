@@ -0,0 +1,67 @@
+package deployer
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// retry calls fn until it succeeds, up to attempts times. Attempts that fail
+// with a transient error are retried after a delay that doubles with every
+// attempt, starting at UploadRetryInterval; other errors are returned right
+// away. desc describes what fn does for the log.
+func retry(attempts int, desc string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= attempts || !isTransientError(err) {
+			return err
+		}
+		log.Printf("failed to %s (attempt %d of %d), retrying, err: %v", desc, attempt, attempts, err)
+		time.Sleep(retryDelay(attempt))
+	}
+}
+
+// retryDelay returns how long to wait before retrying after the given
+// attempt. It is jittered so that the files that failed together, e.g. when
+// S3 throttles, are not retried together.
+func retryDelay(attempt int) time.Duration {
+	d := UploadRetryInterval << uint(attempt-1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isTransientError returns whether a transfer that failed with err may
+// succeed if it is retried. Requests that S3 rejected as invalid or
+// unauthorized are not, unless S3 only asked for them to be slowed down.
+// Errors that did not come from S3, e.g. network errors, are assumed to be
+// transient.
+func isTransientError(err error) bool {
+	for err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() != 0 {
+			switch status := reqErr.StatusCode(); {
+			case status >= 500, status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+				return true
+			case status >= 400:
+				code := reqErr.Code()
+				return code == "RequestTimeout" || code == "SlowDown" || code == "Throttling"
+			}
+			return true
+		}
+
+		awsErr, ok := err.(awserr.Error)
+		if !ok {
+			return true
+		}
+		err = awsErr.OrigErr()
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+package deployer
+
+import "time"
+
+// securityTxtPaths are where a bundle may have a security.txt, the first being
+// where one is generated if it has none.
+var securityTxtPaths = []string{".well-known/security.txt", "security.txt"}
+
+// SecurityTxtExpiry is how long after a deploy the security.txt generated for
+// it expires. Projects are expected to deploy again before then.
+var SecurityTxtExpiry = 365 * 24 * time.Hour
+
+// securityTxt returns a security.txt with the given contact that expires
+// SecurityTxtExpiry after now.
+func securityTxt(contact string, now time.Time) []byte {
+	return []byte("Contact: " + contact + "\n" +
+		"Expires: " + now.Add(SecurityTxtExpiry).UTC().Format(time.RFC3339) + "\n")
+}
@@ -0,0 +1,40 @@
+package deployer
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// shareWebroot makes a deployment serve the webroot that owner uploaded for
+// an identical bundle, by taking over what was recorded about its files.
+func shareWebroot(db *gorm.DB, depl, owner *deployment.Deployment) error {
+	depl.Manifest = owner.Manifest
+	depl.Variants = owner.Variants
+	depl.CSPNonce = owner.CSPNonce
+	depl.PathHeaders = owner.PathHeaders
+	depl.Tombstones = owner.Tombstones
+	depl.Warnings = owner.Warnings
+	depl.SkippedFiles = owner.SkippedFiles
+	depl.ImageBytesSaved = owner.ImageBytesSaved
+	depl.UncompressedBytes = owner.UncompressedBytes
+	depl.CompressedBytes = owner.CompressedBytes
+
+	if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Updates(map[string]interface{}{
+		"manifest":           depl.Manifest,
+		"variants":           depl.Variants,
+		"csp_nonce":          depl.CSPNonce,
+		"path_headers":       depl.PathHeaders,
+		"tombstones":         depl.Tombstones,
+		"warnings":           depl.Warnings,
+		"skipped_files":      depl.SkippedFiles,
+		"image_bytes_saved":  depl.ImageBytesSaved,
+		"uncompressed_bytes": depl.UncompressedBytes,
+		"compressed_bytes":   depl.CompressedBytes,
+	}).Error; err != nil {
+		return err
+	}
+
+	return depl.AppendLog(db, fmt.Sprintf("Reused the files of version %d, which has an identical bundle", owner.Version))
+}
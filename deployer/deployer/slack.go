@@ -0,0 +1,101 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// SlackTimeout is how long posting a deploy notification to Slack may take.
+var SlackTimeout = 5 * time.Second
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link"`
+	Text      string       `json:"text,omitempty"`
+	Fields    []slackField `json:"fields"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// newSlackMessage returns the message that notifies of a deployment having
+// been deployed, or having failed to deploy, at url.
+func newSlackMessage(proj *project.Project, depl *deployment.Deployment, url string) *slackMessage {
+	text := fmt.Sprintf("%s v%d was deployed to %s", proj.Name, depl.Version, url)
+	color, status := "good", "Deployed"
+	if depl.State == deployment.StateDeployFailed {
+		text = fmt.Sprintf("%s v%d failed to deploy", proj.Name, depl.Version)
+		color, status = "danger", "Failed"
+	}
+
+	attachment := slackAttachment{
+		Fallback:  text,
+		Color:     color,
+		Title:     fmt.Sprintf("%s v%d", proj.Name, depl.Version),
+		TitleLink: url,
+		Fields: []slackField{
+			{Title: "Project", Value: proj.Name, Short: true},
+			{Title: "Version", Value: fmt.Sprintf("v%d", depl.Version), Short: true},
+			{Title: "Status", Value: status, Short: true},
+		},
+	}
+	if depl.State == deployment.StateDeployFailed && depl.ErrorMessage != nil {
+		attachment.Text = *depl.ErrorMessage
+	}
+
+	return &slackMessage{
+		Text:        text,
+		Attachments: []slackAttachment{attachment},
+	}
+}
+
+// notifySlack posts a message about the outcome of a deployment to the Slack
+// incoming webhook of the project. The URL in the message is that of the
+// project's primary domain, or the preview of the deployment if the project
+// has no domains.
+func notifySlack(db *gorm.DB, proj *project.Project, depl *deployment.Deployment) error {
+	url := "https://" + depl.PreviewDomainName()
+	domainNames, err := proj.DomainNamesWithProtocol(db)
+	if err != nil {
+		return err
+	}
+	if len(domainNames) > 0 {
+		url = domainNames[0]
+	}
+
+	reqBody, err := json.Marshal(newSlackMessage(proj, depl, url))
+	if err != nil {
+		return err
+	}
+
+	client := common.HTTPClient()
+	client.Timeout = SlackTimeout
+
+	resp, err := client.Post(*proj.SlackWebhookURL, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("slack responded with %d", resp.StatusCode)
+	}
+	return nil
+}
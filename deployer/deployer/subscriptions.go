@@ -0,0 +1,87 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/subscription"
+)
+
+// SubscriptionTimeout is how long posting an event to a subscription may
+// take.
+var SubscriptionTimeout = 5 * time.Second
+
+type subscriptionEvent struct {
+	Event       string           `json:"event"`
+	ProjectName string           `json:"project_name"`
+	Deployment  *deployment.JSON `json:"deployment"`
+}
+
+// deploymentEvent returns the event that a deployment having reached its
+// state is, or an empty string if subscriptions are not told of the state.
+func deploymentEvent(depl *deployment.Deployment) string {
+	switch depl.State {
+	case deployment.StateDeployed:
+		return subscription.EventDeploySucceeded
+	case deployment.StateDeployFailed:
+		return subscription.EventDeployFailed
+	}
+	return ""
+}
+
+// notifySubscriptions posts the event of the outcome of a deployment to every
+// subscription of the project to it, at the same time. Failed posts are
+// logged and not retried.
+func notifySubscriptions(db *gorm.DB, proj *project.Project, depl *deployment.Deployment) error {
+	event := deploymentEvent(depl)
+	if event == "" {
+		return nil
+	}
+
+	subs, err := subscription.ForEvent(db, proj.ID, event)
+	if err != nil || len(subs) == 0 {
+		return err
+	}
+
+	reqBody, err := json.Marshal(&subscriptionEvent{
+		Event:       event,
+		ProjectName: proj.Name,
+		Deployment:  depl.AsJSON(),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := common.HTTPClient()
+	client.Timeout = SubscriptionTimeout
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func(sub *subscription.Subscription) {
+			defer wg.Done()
+
+			resp, err := client.Post(sub.TargetURL, "application/json", bytes.NewReader(reqBody))
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+					err = fmt.Errorf("subscriber responded with %d", resp.StatusCode)
+				}
+			}
+			if err != nil {
+				log.Printf("failed to post %s event of deployment %d to subscription %d, err: %v", event, depl.ID, sub.ID, err)
+			}
+		}(sub)
+	}
+	wg.Wait()
+
+	return nil
+}
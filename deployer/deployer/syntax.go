@@ -0,0 +1,283 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// jsKeywordsBeforeExpr are the keywords that can be followed by an
+// expression, and so by a regular expression literal rather than a division.
+var jsKeywordsBeforeExpr = map[string]bool{
+	"return":     true,
+	"typeof":     true,
+	"instanceof": true,
+	"in":         true,
+	"of":         true,
+	"new":        true,
+	"delete":     true,
+	"void":       true,
+	"throw":      true,
+	"case":       true,
+	"do":         true,
+	"else":       true,
+	"yield":      true,
+	"await":      true,
+}
+
+// checkSyntax returns the syntax error of the file at filePath, if it is a
+// JS file and validateJS is set, or a JSON file and validateJSON is set.
+func checkSyntax(filePath string, b []byte, validateJS, validateJSON bool) error {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".js":
+		if validateJS {
+			return checkJSSyntax(b)
+		}
+	case ".json":
+		if validateJSON {
+			return checkJSONSyntax(b)
+		}
+	}
+	return nil
+}
+
+// checkJSONSyntax returns an error describing where the JSON document is
+// malformed, if it is.
+func checkJSONSyntax(b []byte) error {
+	var v interface{}
+	err := json.Unmarshal(b, &v)
+	if serr, ok := err.(*json.SyntaxError); ok {
+		offset := int(serr.Offset)
+		if offset > len(b) {
+			offset = len(b)
+		}
+		return fmt.Errorf("line %d: %v", 1+bytes.Count(b[:offset], []byte("\n")), serr)
+	}
+	return err
+}
+
+// checkJSSyntax returns an error describing where the JS source is
+// malformed, if it is. It only tokenizes the source, so it catches
+// unterminated strings, comments, template and regular expression literals,
+// and unbalanced brackets, but not every syntax error a parser would.
+func checkJSSyntax(src []byte) error {
+	s := &jsScanner{src: src, line: 1, regexOK: true}
+	return s.scan()
+}
+
+type jsScanner struct {
+	src  []byte
+	pos  int
+	line int
+
+	// stack holds the open brackets, "`" for template literals and "$" for
+	// the expressions in them.
+	stack []byte
+
+	// regexOK is whether a "/" would start a regular expression literal
+	// rather than be a division.
+	regexOK bool
+}
+
+func (s *jsScanner) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: "+format, append([]interface{}{line}, args...)...)
+}
+
+func (s *jsScanner) peek(n int) byte {
+	if s.pos+n < len(s.src) {
+		return s.src[s.pos+n]
+	}
+	return 0
+}
+
+func (s *jsScanner) top() byte {
+	if len(s.stack) == 0 {
+		return 0
+	}
+	return s.stack[len(s.stack)-1]
+}
+
+func (s *jsScanner) scan() error {
+	for s.pos < len(s.src) {
+		if s.top() == '`' {
+			if err := s.template(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		c := s.src[s.pos]
+		switch {
+		case c == '\n':
+			s.line++
+			s.pos++
+
+		case c == ' ' || c == '\t' || c == '\r':
+			s.pos++
+
+		case c == '/' && s.peek(1) == '/':
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+
+		case c == '/' && s.peek(1) == '*':
+			end := bytes.Index(s.src[s.pos+2:], []byte("*/"))
+			if end == -1 {
+				return s.errorf(s.line, "unterminated comment")
+			}
+			comment := s.src[s.pos : s.pos+2+end+2]
+			s.line += bytes.Count(comment, []byte("\n"))
+			s.pos += len(comment)
+
+		case c == '"' || c == '\'':
+			if err := s.string(c); err != nil {
+				return err
+			}
+
+		case c == '`':
+			s.stack = append(s.stack, '`')
+			s.pos++
+
+		case c == '/' && s.regexOK:
+			if err := s.regex(); err != nil {
+				return err
+			}
+
+		case c == '(' || c == '[' || c == '{':
+			s.stack = append(s.stack, c)
+			s.pos++
+			s.regexOK = true
+
+		case c == ')' || c == ']' || c == '}':
+			if err := s.close(c); err != nil {
+				return err
+			}
+
+		case isJSWordByte(c):
+			start := s.pos
+			for s.pos < len(s.src) && isJSWordByte(s.src[s.pos]) {
+				s.pos++
+			}
+			s.regexOK = jsKeywordsBeforeExpr[string(s.src[start:s.pos])]
+
+		case (c == '+' || c == '-') && s.peek(1) == c && !s.regexOK:
+			// A postfix increment or decrement still ends a value.
+			s.pos += 2
+
+		default:
+			s.pos++
+			s.regexOK = true
+		}
+	}
+
+	switch s.top() {
+	case 0:
+		return nil
+	case '`', '$':
+		return s.errorf(s.line, "unterminated template literal")
+	default:
+		return s.errorf(s.line, "unexpected end of input, %q is not closed", s.top())
+	}
+}
+
+func (s *jsScanner) close(c byte) error {
+	closers := map[byte]byte{'(': ')', '[': ']', '{': '}', '$': '}'}
+
+	open := s.top()
+	if open == 0 {
+		return s.errorf(s.line, "unexpected %q", c)
+	}
+	if closers[open] != c {
+		return s.errorf(s.line, "unexpected %q, expected %q", c, closers[open])
+	}
+
+	s.stack = s.stack[:len(s.stack)-1]
+	s.pos++
+	s.regexOK = c == '}'
+	return nil
+}
+
+func (s *jsScanner) string(quote byte) error {
+	line := s.line
+	for s.pos++; s.pos < len(s.src); s.pos++ {
+		switch s.src[s.pos] {
+		case '\\':
+			s.pos++
+			if s.peek(0) == '\n' {
+				s.line++
+			}
+		case quote:
+			s.pos++
+			s.regexOK = false
+			return nil
+		case '\n':
+			return s.errorf(line, "unterminated string")
+		}
+	}
+	return s.errorf(line, "unterminated string")
+}
+
+// template scans the text of a template literal up to its end or the start of
+// an expression in it.
+func (s *jsScanner) template() error {
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case c == '\\':
+			s.pos++
+			if s.peek(0) == '\n' {
+				s.line++
+			}
+			s.pos++
+		case c == '`':
+			s.stack = s.stack[:len(s.stack)-1]
+			s.pos++
+			s.regexOK = false
+			return nil
+		case c == '$' && s.peek(1) == '{':
+			s.stack = append(s.stack, '$')
+			s.pos += 2
+			s.regexOK = true
+			return nil
+		case c == '\n':
+			s.line++
+			s.pos++
+		default:
+			s.pos++
+		}
+	}
+	return s.errorf(s.line, "unterminated template literal")
+}
+
+func (s *jsScanner) regex() error {
+	inClass := false
+	for s.pos++; s.pos < len(s.src); s.pos++ {
+		switch s.src[s.pos] {
+		case '\\':
+			s.pos++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if inClass {
+				continue
+			}
+			// Skip the flags.
+			for s.pos++; s.pos < len(s.src) && isJSWordByte(s.src[s.pos]); s.pos++ {
+			}
+			s.regexOK = false
+			return nil
+		case '\n':
+			return s.errorf(s.line, "unterminated regular expression")
+		}
+	}
+	return s.errorf(s.line, "unterminated regular expression")
+}
+
+func isJSWordByte(c byte) bool {
+	return c == '_' || c == '$' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c >= 0x80
+}
@@ -0,0 +1,312 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/meta"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// Targets are the buckets that webroots and meta files are uploaded to.
+var Targets = s3client.WebrootTargets
+
+// Uploads of a file to a target are retried, so that a transient failure on
+// one file does not fail the whole deploy. The interval doubles with every
+// attempt.
+var (
+	UploadAttempts      = 3
+	UploadRetryInterval = 1 * time.Second
+)
+
+// Failed uploads are repaired by jobs of their own, which are retried after
+// RepairRetryDelay until MaxRepairAttempts have been made.
+var (
+	MaxRepairAttempts = 5
+	RepairRetryDelay  = 5 * time.Minute
+)
+
+// failedUpload is a file that could not be uploaded to one of the targets.
+type failedUpload struct {
+	Region      string            `json:"region"`
+	Bucket      string            `json:"bucket"`
+	Key         string            `json:"key"`
+	ContentType string            `json:"content_type"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	RecordedAt  time.Time         `json:"recorded_at"`
+}
+
+// uploadFailures collects the uploads of a job that failed on a minority of
+// the targets. Every job has its own, as uploads of a job that timed out may
+// still be going on while the next job is worked on.
+type uploadFailures struct {
+	mu      sync.Mutex
+	uploads []failedUpload
+}
+
+func (u *uploadFailures) add(failed ...failedUpload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.uploads = append(u.uploads, failed...)
+}
+
+func (u *uploadFailures) list() []failedUpload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]failedUpload(nil), u.uploads...)
+}
+
+// uploadToTargets uploads a public file to every target in parallel. The
+// upload only fails if it fails on a majority of the targets; failures on
+// fewer targets are added to failures, so that the file can be repaired from
+// the other targets once the deployment is out.
+func uploadToTargets(failures *uploadFailures, key string, body io.Reader, contentType string) error {
+	return uploadToTargetsWithMetadata(failures, key, body, contentType, nil)
+}
+
+// uploadToTargetsWithMetadata is uploadToTargets with S3 metadata for the
+// file.
+func uploadToTargetsWithMetadata(failures *uploadFailures, key string, body io.Reader, contentType string, metadata map[string]string) error {
+	// Every attempt to upload to every target needs its own reader of the
+	// content.
+	content := new(bytes.Buffer)
+	if _, err := io.Copy(content, body); err != nil {
+		return err
+	}
+
+	if len(Targets) == 1 {
+		return uploadToTarget(Targets[0], key, content.Bytes(), contentType, metadata)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []error
+		failed []failedUpload
+	)
+
+	for _, t := range Targets {
+		wg.Add(1)
+		go func(t s3client.Target) {
+			defer wg.Done()
+
+			if err := uploadToTarget(t, key, content.Bytes(), contentType, metadata); err != nil {
+				log.Printf("failed to upload %q to %s in %s, err: %v", key, t.Bucket, t.Region, err)
+
+				mu.Lock()
+				errs = append(errs, err)
+				failed = append(failed, failedUpload{
+					Region:      t.Region,
+					Bucket:      t.Bucket,
+					Key:         key,
+					ContentType: contentType,
+					Metadata:    metadata,
+				})
+				mu.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	if len(errs) > len(Targets)/2 {
+		return fmt.Errorf("failed to upload %q to %d of %d targets, last error: %v", key, len(errs), len(Targets), errs[len(errs)-1])
+	}
+
+	failures.add(failed...)
+
+	return nil
+}
+
+// uploadToTarget uploads a public file to a target, retrying up to
+// UploadAttempts times.
+func uploadToTarget(t s3client.Target, key string, content []byte, contentType string, metadata map[string]string) error {
+	return retry(UploadAttempts, fmt.Sprintf("upload %q to %s in %s", key, t.Bucket, t.Region), func() error {
+		return S3.UploadWithMetadata(t.Region, t.Bucket, key, bytes.NewReader(content), contentType, "public-read", metadata)
+	})
+}
+
+// recordFailedUploads adds the uploads that failed on a minority of the
+// targets to those of the deployment, and schedules a job to repair them.
+func recordFailedUploads(db *gorm.DB, depl *deployment.Deployment, failures *uploadFailures) error {
+	failed := failures.list()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for i := range failed {
+		failed[i].RecordedAt = now
+	}
+
+	if len(depl.FailedUploads) > 0 {
+		var existing []failedUpload
+		if err := json.Unmarshal(depl.FailedUploads, &existing); err != nil {
+			return err
+		}
+		failed = append(existing, failed...)
+	}
+
+	failedJSON, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("failed_uploads", failedJSON).Error; err != nil {
+		return err
+	}
+	depl.FailedUploads = failedJSON
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:  depl.ID,
+		RepairUploads: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return j.Enqueue()
+}
+
+// repairUploads copies the files that could not be uploaded to some of the
+// targets over from the targets they were uploaded to. The files that still
+// could not be repaired are kept, and the job is retried after a delay until
+// it runs out of attempts. It must be called with the project locked.
+func repairUploads(db *gorm.DB, proj *project.Project, depl *deployment.Deployment, d *messages.DeployJobData) error {
+	if len(depl.FailedUploads) == 0 {
+		return nil
+	}
+
+	var failed []failedUpload
+	if err := json.Unmarshal(depl.FailedUploads, &failed); err != nil {
+		return err
+	}
+
+	remaining := []failedUpload{}
+	for _, f := range failed {
+		rewritten, err := isRewritten(db, proj, depl, f)
+		if err != nil {
+			return err
+		}
+		if rewritten {
+			log.Printf("not repairing %q in %s in %s, it may have been rewritten since", f.Key, f.Bucket, f.Region)
+			continue
+		}
+
+		if err := repairUpload(f, failed); err != nil {
+			log.Printf("failed to repair %q in %s in %s, err: %v", f.Key, f.Bucket, f.Region, err)
+			remaining = append(remaining, f)
+		}
+	}
+
+	remainingJSON, err := json.Marshal(remaining)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(deployment.Deployment{}).Where("id = ?", depl.ID).Update("failed_uploads", remainingJSON).Error; err != nil {
+		return err
+	}
+
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	// The job is not requeued at the head of the queue like other failed
+	// jobs, as a target that is down would keep it failing ahead of deploys.
+	if d.RepairAttempt+1 >= MaxRepairAttempts {
+		log.Printf("gave up repairing %d of %d uploads of deployment %d after %d attempts", len(remaining), len(failed), depl.ID, d.RepairAttempt+1)
+		return nil
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:  depl.ID,
+		RepairUploads: true,
+		RepairAttempt: d.RepairAttempt + 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	return j.EnqueueDelayed(queues.DeployRepairDelayed, RepairRetryDelay)
+}
+
+// isRewritten returns whether the file of a failed upload may have been
+// rewritten by another deployment of the project since the upload failed, so
+// that it must not be repaired from the other targets. Files under the
+// webroot of the deployment and the meta.json of its own preview domains are
+// only written by the deployment itself, but the meta.json of the project's
+// domains and staging alias are rewritten by later deploys.
+func isRewritten(db *gorm.DB, proj *project.Project, depl *deployment.Deployment, f failedUpload) (bool, error) {
+	if strings.HasPrefix(f.Key, shared.DeploymentKey(depl.PrefixID(), "")) ||
+		f.Key == meta.Path(depl.PreviewDomainName()) ||
+		f.Key == meta.SubpathPath(shared.PublicBaseDomain, depl.VanityPath(proj.Name)) {
+		return false, nil
+	}
+
+	var count int
+	if err := db.Model(deployment.Deployment{}).Where("project_id = ? AND id <> ? AND updated_at > ?", depl.ProjectID, depl.ID, f.RecordedAt).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// repairUpload downloads the file from a target it was uploaded to, and
+// uploads it to the target it failed on.
+func repairUpload(f failedUpload, failed []failedUpload) error {
+	var src *s3client.Target
+	for i, t := range Targets {
+		if !hasFailedUpload(failed, t, f.Key) {
+			src = &Targets[i]
+			break
+		}
+	}
+
+	if src == nil {
+		return fmt.Errorf("%q was not uploaded to any of the targets", f.Key)
+	}
+
+	tmpFile, err := ioutil.TempFile(tempDir(), "repair-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}()
+
+	if err := download(src.Region, src.Bucket, f.Key, tmpFile); err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(tmpFile.Name())
+	if err != nil {
+		return err
+	}
+
+	return uploadToTarget(s3client.Target{Region: f.Region, Bucket: f.Bucket}, f.Key, content, f.ContentType, f.Metadata)
+}
+
+func hasFailedUpload(failed []failedUpload, t s3client.Target, key string) bool {
+	for _, f := range failed {
+		if f.Region == t.Region && f.Bucket == t.Bucket && f.Key == key {
+			return true
+		}
+	}
+	return false
+}
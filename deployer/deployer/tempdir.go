@@ -0,0 +1,35 @@
+package deployer
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// TempDir is the directory bundles and other intermediate files are written
+// to. The system default temp directory is used if it is empty.
+var TempDir = os.Getenv("DEPLOYER_TMP_DIR")
+
+// tempDir returns the directory temp files should be created in.
+func tempDir() string {
+	if TempDir == "" {
+		return os.TempDir()
+	}
+	return TempDir
+}
+
+// PrepareTempDir creates the temp directory if it does not exist yet and
+// checks that it is writable, so that a misconfigured deployer fails at
+// startup rather than on its first deploy.
+func PrepareTempDir() error {
+	dir := tempDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "deployer-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,94 @@
+package deployer
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// MaxTombstones is the maximum number of removed paths that are kept as
+// tombstones, so that meta.json does not grow without bound over the life of
+// a project. The paths that were removed longest ago are dropped first.
+var MaxTombstones = 1000
+
+// tombstones returns the paths of the pages that are not in the manifest of
+// the deployment but were in the active deployment of the project, or had
+// been removed before it, so that pages stay gone across deploys. The pages
+// removed by the deployment come first, followed by the ones removed before.
+func tombstones(db *gorm.DB, proj *project.Project, depl *deployment.Deployment, manifest deployment.Manifest) ([]string, error) {
+	paths := []string{}
+	if proj.ActiveDeploymentID == nil || *proj.ActiveDeploymentID == depl.ID {
+		return paths, nil
+	}
+
+	active := &deployment.Deployment{}
+	if err := db.First(active, *proj.ActiveDeploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return paths, nil
+		}
+		return nil, err
+	}
+
+	prevManifest, err := active.ParsedManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for fileName := range prevManifest {
+		if isPage(fileName) {
+			removed = append(removed, servedPaths(fileName)...)
+		}
+	}
+	sort.Strings(removed)
+
+	prevRemoved, err := active.TombstonePaths()
+	if err != nil {
+		return nil, err
+	}
+	removed = append(removed, prevRemoved...)
+
+	served := map[string]bool{}
+	for fileName := range manifest {
+		for _, p := range servedPaths(fileName) {
+			served[p] = true
+		}
+	}
+
+	for _, p := range removed {
+		if len(paths) >= MaxTombstones {
+			break
+		}
+
+		if !served[p] {
+			// Marked as served so that it is only added once.
+			served[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	return paths, nil
+}
+
+// isPage returns whether the file is an HTML page.
+func isPage(fileName string) bool {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// servedPaths returns the paths that edges serve the file at.
+func servedPaths(fileName string) []string {
+	paths := []string{"/" + fileName}
+	// Directory indexes are also served at the directory's own path.
+	if path.Base(fileName) == "index.html" {
+		paths = append(paths, "/"+strings.TrimSuffix(fileName, "index.html"))
+	}
+	return paths
+}
@@ -0,0 +1,68 @@
+package deployer
+
+import (
+	"log"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+)
+
+// TrackTimeout is how long tracking an event may take before it is given up
+// on.
+var TrackTimeout = 10 * time.Second
+
+// trackQueueSize is the number of events that can be waiting to be tracked.
+// Events are dropped rather than queued once it is reached.
+const trackQueueSize = 100
+
+type trackEvent struct {
+	tracker tracker.Trackable
+
+	userID  string
+	event   string
+	props   map[string]interface{}
+	context map[string]interface{}
+}
+
+var trackQueue = make(chan *trackEvent, trackQueueSize)
+
+func init() {
+	go trackEvents()
+}
+
+// track queues an event to be tracked in the background so that a slow
+// analytics service does not hold up deploys.
+func track(userID, event string, props, context map[string]interface{}) {
+	e := &trackEvent{
+		tracker: common.Tracker,
+		userID:  userID,
+		event:   event,
+		props:   props,
+		context: context,
+	}
+
+	select {
+	case trackQueue <- e:
+	default:
+		log.Printf("dropped %q event for user ID %s, too many events are waiting to be tracked", event, userID)
+	}
+}
+
+func trackEvents() {
+	for e := range trackQueue {
+		errCh := make(chan error, 1)
+		go func(e *trackEvent) {
+			errCh <- e.tracker.Track(e.userID, e.event, "", e.props, e.context)
+		}(e)
+
+		select {
+		case err := <-errCh:
+			if err != nil {
+				log.Printf("failed to track %q event for user ID %s, err: %v", e.event, e.userID, err)
+			}
+		case <-time.After(TrackTimeout):
+			log.Printf("timed out tracking %q event for user ID %s", e.event, e.userID)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package deployer
+
+import "sync"
+
+// UploadConcurrency is the maximum number of files of a bundle that are
+// processed and uploaded to the webroot at the same time.
+var UploadConcurrency = 8
+
+// uploadPool runs the uploads of the files of a bundle in a bounded number of
+// goroutines. Once an upload fails, uploads that have not started yet are not
+// run.
+type uploadPool struct {
+	wg  sync.WaitGroup
+	sem chan struct{}
+
+	once   sync.Once
+	err    error
+	failed chan struct{}
+}
+
+func newUploadPool(concurrency int) *uploadPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &uploadPool{
+		sem:    make(chan struct{}, concurrency),
+		failed: make(chan struct{}),
+	}
+}
+
+// submit runs fn in a goroutine of the pool, blocking while all of them are
+// busy. It returns false without running fn if the pool has failed.
+func (p *uploadPool) submit(fn func() error) bool {
+	select {
+	case p.sem <- struct{}{}:
+	case <-p.failed:
+		return false
+	}
+
+	select {
+	case <-p.failed:
+		<-p.sem
+		return false
+	default:
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+
+		if err := fn(); err != nil {
+			p.fail(err)
+		}
+	}()
+	return true
+}
+
+// fail stops the pool from running any more uploads. Only the first error is
+// kept.
+func (p *uploadPool) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.failed)
+	})
+}
+
+// wait waits for the running uploads to finish, and returns the error the
+// pool failed with, if any.
+func (p *uploadPool) wait() error {
+	p.wg.Wait()
+	return p.err
+}
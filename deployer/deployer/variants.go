@@ -0,0 +1,84 @@
+package deployer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/shared/meta"
+)
+
+// Text assets smaller than this are also uploaded gzipped so that edges can
+// serve whichever variant the client accepts.
+var MaxFileSizeToCompress int64 = 10 * 1000 * 1000 // in bytes
+
+var compressibleContentTypes = map[string]bool{
+	"text/html":              true,
+	"text/xhtml+xml":         true,
+	"text/css":               true,
+	"text/plain":             true,
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// uploadWithVariants uploads an asset to fileName in webroot with the given
+// metadata, along with a gzipped variant if the asset is compressible. It
+// returns the manifest entry of the asset, which lists the encodings it is
+// available in if it has variants.
+func uploadWithVariants(failures *uploadFailures, webroot, fileName string, in io.Reader, size int64, contentType string, metadata map[string]string) (*deployment.ManifestEntry, error) {
+	remotePath := webroot + "/" + fileName
+	if !compressibleContentTypes[contentType] || size > MaxFileSizeToCompress {
+		hr := newChecksumReader(in)
+		if err := uploadToTargetsWithMetadata(failures, remotePath, hr, contentType, metadata); err != nil {
+			return nil, err
+		}
+		return hr.manifestEntry(), nil
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, in); err != nil {
+		return nil, err
+	}
+
+	gzBuf := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzBuf)
+	if _, err := gw.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	compressedSize := int64(gzBuf.Len())
+
+	hr := newChecksumReader(buf)
+	if err := uploadToTargetsWithMetadata(failures, remotePath, hr, contentType, metadata); err != nil {
+		return nil, err
+	}
+
+	if err := uploadToTargetsWithMetadata(failures, meta.VariantPath(webroot, fileName, meta.EncodingGzip), gzBuf, contentType, metadata); err != nil {
+		return nil, err
+	}
+
+	entry := hr.manifestEntry()
+	entry.Encodings = []string{meta.EncodingIdentity, meta.EncodingGzip}
+	entry.CompressedSize = compressedSize
+	return entry, nil
+}
+
+// reservedFileWarnings returns the warnings about the files of a bundle that
+// were left out because variants are stored where they are.
+func reservedFileWarnings(fileNames []string) []string {
+	sort.Strings(fileNames)
+
+	var warnings []string
+	for _, fileName := range fileNames {
+		warnings = append(warnings, fmt.Sprintf("%s was not deployed, as %s/ is reserved for compressed variants", fileName, meta.VariantsDir))
+	}
+	return warnings
+}
@@ -9,6 +9,8 @@ import (
 	"net/url"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/pkg/pubsub"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/messages"
 )
 
@@ -22,9 +24,29 @@ func Work(data []byte) error {
 		return err
 	}
 
+	err := invalidate(j)
+
+	// Invalidations of deployments are acknowledged whether they succeeded or
+	// not, so that the status of the deployment's invalidation is tracked.
+	if j.DeploymentID != 0 {
+		if ackErr := ack(j, err); ackErr != nil {
+			log.Errorf("Failed to acknowledge invalidation of deployment %d: %v", j.DeploymentID, ackErr)
+		}
+	}
+
+	return err
+}
+
+func invalidate(j *messages.V1InvalidationMessageData) error {
+	// Without paths, everything cached for the domains is invalidated.
+	params := url.Values{}
+	for _, path := range j.Paths {
+		params.Add("path", path)
+	}
+
 	for _, domain := range j.Domains {
 		invalidateURL := fmt.Sprintf("%s/invalidate/%s", APIHost, domain)
-		res, err := http.PostForm(invalidateURL, url.Values{})
+		res, err := http.PostForm(invalidateURL, params)
 		if err != nil {
 			return err
 		}
@@ -43,3 +65,20 @@ func Work(data []byte) error {
 
 	return nil
 }
+
+func ack(j *messages.V1InvalidationMessageData, invalidateErr error) error {
+	data := &messages.V1InvalidationAckMessageData{
+		DeploymentID: j.DeploymentID,
+		Domains:      j.Domains,
+	}
+	if invalidateErr != nil {
+		data.Error = invalidateErr.Error()
+	}
+
+	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1InvalidationAck, data)
+	if err != nil {
+		return err
+	}
+
+	return m.Publish()
+}
@@ -1,10 +1,17 @@
 package invalidator_test
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/url"
 	"testing"
 
 	"github.com/nitrous-io/rise-server/edged/invalidator"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
@@ -55,5 +62,81 @@ var _ = Describe("Invalidator", func() {
 			Expect(server.ReceivedRequests()).To(HaveLen(2))
 			Expect(err).To(BeNil())
 		})
+
+		It("limits the invalidation to the given paths", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/invalidate/www.foo-bar-express.com"),
+					ghttp.VerifyForm(url.Values{"path": {"/index.html", "/js/app.js"}}),
+					ghttp.RespondWith(http.StatusOK, `{ "invalidated": true }`),
+				),
+			)
+
+			err := invalidator.Work([]byte(`{
+				"domains": ["www.foo-bar-express.com"],
+				"paths": ["/index.html", "/js/app.js"]
+			}`))
+
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+			Expect(err).To(BeNil())
+		})
+
+		Context("when the invalidation is for a deployment", func() {
+			var ackQueueName string
+
+			BeforeEach(func() {
+				mq, err := mqconn.MQ()
+				Expect(err).To(BeNil())
+				testhelper.DeleteQueue(mq, queues.All...)
+				testhelper.DeleteExchange(mq, exchanges.All...)
+
+				ackQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1InvalidationAck)
+			})
+
+			ackMessage := func() *messages.V1InvalidationAckMessageData {
+				mq, err := mqconn.MQ()
+				Expect(err).To(BeNil())
+
+				d := testhelper.ConsumeQueue(mq, ackQueueName)
+				Expect(d).NotTo(BeNil())
+
+				m := &messages.V1InvalidationAckMessageData{}
+				Expect(json.Unmarshal(d.Body, m)).To(BeNil())
+				return m
+			}
+
+			It("acknowledges the invalidation once it is done", func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, `{ "invalidated": true }`),
+				)
+
+				err := invalidator.Work([]byte(`{
+					"domains": ["www.foo-bar-express.com"],
+					"deployment_id": 123
+				}`))
+				Expect(err).To(BeNil())
+
+				Expect(ackMessage()).To(Equal(&messages.V1InvalidationAckMessageData{
+					DeploymentID: 123,
+					Domains:      []string{"www.foo-bar-express.com"},
+				}))
+			})
+
+			It("acknowledges the invalidation with an error if it fails", func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusInternalServerError, `{ "error": "internal_server_error" }`),
+				)
+
+				err := invalidator.Work([]byte(`{
+					"domains": ["www.foo-bar-express.com"],
+					"deployment_id": 123
+				}`))
+				Expect(err).NotTo(BeNil())
+
+				m := ackMessage()
+				Expect(m.DeploymentID).To(Equal(uint(123)))
+				Expect(m.Error).To(Equal(err.Error()))
+			})
+		})
 	})
 })
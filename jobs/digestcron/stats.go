@@ -4,12 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/stat"
@@ -21,7 +21,7 @@ type Stats struct {
 }
 
 func getStats(url string) (*Stats, error) {
-	resp, err := http.Get(url)
+	resp, err := common.HTTPClient().Get(url)
 	if err != nil {
 		return nil, err
 	}
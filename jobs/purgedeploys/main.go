@@ -12,6 +12,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
@@ -20,7 +21,11 @@ const jobName = "purge-deleted-deploys"
 var fields = log.Fields{"job": jobName}
 
 var (
-	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+	S3 filetransfer.FileTransfer = s3client.S3
+
+	// GracePeriod is how long a deployment stays soft-deleted before its
+	// files are purged, during which it can still be restored.
+	GracePeriod time.Duration // PURGE_GRACE_PERIOD - e.g. "168h", purged right away if unset
 )
 
 func init() {
@@ -30,11 +35,19 @@ func init() {
 		os.Setenv("RISE_ENV", riseEnv)
 	}
 
-	if riseEnv != "test" {
+	if riseEnv != "test" && s3client.Backend == "s3" {
 		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
 			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
 		}
 	}
+
+	if gracePeriodEnv := os.Getenv("PURGE_GRACE_PERIOD"); gracePeriodEnv != "" {
+		d, err := time.ParseDuration(gracePeriodEnv)
+		if err != nil || d < 0 {
+			log.Fatalf("PURGE_GRACE_PERIOD %q is not a valid duration", gracePeriodEnv)
+		}
+		GracePeriod = d
+	}
 }
 
 func main() {
@@ -82,18 +95,10 @@ func main() {
 	log.WithFields(fields).WithField("event", "completed").Infof("Successfully purged %d deployments", len(depls))
 }
 
+// findSoftDeletedDeployments returns the soft-deleted deployments whose grace
+// period is over and that have not been purged yet.
 func findSoftDeletedDeployments(db *gorm.DB) ([]*deployment.Deployment, error) {
-	depls := []*deployment.Deployment{}
-	err := db.Unscoped().
-		Where("deleted_at IS NOT NULL").
-		Where("purged_at IS NULL").
-		Where("state = ?", deployment.StateDeployed).
-		Find(&depls).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return depls, nil
+	return deployment.SoftDeletedBefore(db, time.Now().Add(-GracePeriod))
 }
 
 func purger(db *gorm.DB, wg *sync.WaitGroup, jobs chan *deployment.Deployment) {
@@ -109,12 +114,21 @@ func purger(db *gorm.DB, wg *sync.WaitGroup, jobs chan *deployment.Deployment) {
 }
 
 func purge(db *gorm.DB, depl *deployment.Deployment) error {
-	prefix := "deployments/" + depl.PrefixID()
-	if err := S3.DeleteAll(s3client.BucketRegion, s3client.BucketName, prefix); err != nil {
+	// Deployments of identical bundles with content hash prefixes share their
+	// files, which are only removed along with the last of them.
+	inUse, err := depl.SharesFiles(db)
+	if err != nil {
 		return err
 	}
 
-	if err := db.Model(depl).Unscoped().UpdateColumn("purged_at", time.Now()).Error; err != nil {
+	if !inUse {
+		prefix := shared.S3KeyPrefix + "deployments/" + depl.PrefixID()
+		if err := S3.DeleteAll(s3client.BucketRegion, s3client.BucketName, prefix); err != nil {
+			return err
+		}
+	}
+
+	if err := depl.MarkPurged(db); err != nil {
 		return err
 	}
 
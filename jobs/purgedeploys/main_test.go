@@ -31,9 +31,9 @@ var _ = Describe("purgedeploys", func() {
 
 		db *gorm.DB
 
-		u                          *user.User
-		proj1, proj2               *project.Project
-		depl1, depl2, depl3, depl4 *deployment.Deployment
+		u                   *user.User
+		proj1, proj2        *project.Project
+		depl2, depl3, depl4 *deployment.Deployment
 	)
 
 	BeforeEach(func() {
@@ -51,7 +51,7 @@ var _ = Describe("purgedeploys", func() {
 		_ = factories.Domain(db, proj1, "www.foo-bar-express.com")
 
 		// Deployed, non-deleted deployment.
-		depl1 = factories.DeploymentWithAttrs(db, proj1, u, deployment.Deployment{
+		factories.DeploymentWithAttrs(db, proj1, u, deployment.Deployment{
 			Prefix: "p1-a",
 			State:  deployment.StateDeployed,
 		})
@@ -100,6 +100,48 @@ var _ = Describe("purgedeploys", func() {
 			}
 			Expect(ids).To(ConsistOf(depl2.ID, depl4.ID))
 		})
+
+		Context("when there is a grace period", func() {
+			var origGracePeriod time.Duration
+
+			BeforeEach(func() {
+				origGracePeriod = GracePeriod
+				GracePeriod = 24 * time.Hour
+
+				err = db.Model(depl2).Unscoped().UpdateColumn("deleted_at", time.Now().Add(-25*time.Hour)).Error
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				GracePeriod = origGracePeriod
+			})
+
+			It("only returns deployments soft-deleted before the grace period", func() {
+				depls, err := findSoftDeletedDeployments(db)
+				Expect(err).To(BeNil())
+
+				Expect(depls).To(HaveLen(1))
+				Expect(depls[0].ID).To(Equal(depl2.ID))
+			})
+
+			It("purges the files of deployments past the grace period from S3", func() {
+				depls, err := findSoftDeletedDeployments(db)
+				Expect(err).To(BeNil())
+
+				for _, depl := range depls {
+					Expect(purge(db, depl)).To(BeNil())
+				}
+
+				Expect(fakeS3.DeleteAllCalls.Count()).To(Equal(1))
+				deleteCall := fakeS3.DeleteAllCalls.NthCall(1)
+				Expect(deleteCall).NotTo(BeNil())
+				Expect(deleteCall.Arguments[2]).To(Equal("deployments/" + depl2.PrefixID()))
+
+				err = db.Unscoped().First(depl4, depl4.ID).Error
+				Expect(err).To(BeNil())
+				Expect(depl4.PurgedAt).To(BeNil())
+			})
+		})
 	})
 
 	Describe("purge()", func() {
@@ -116,6 +158,36 @@ var _ = Describe("purgedeploys", func() {
 			Expect(deleteCall.ReturnValues[0]).To(BeNil())
 		})
 
+		Context("when another deployment has the same content hash prefix", func() {
+			var depl5 *deployment.Deployment
+
+			BeforeEach(func() {
+				depl5 = factories.DeploymentWithAttrs(db, proj1, u, deployment.Deployment{
+					Prefix:       "9f86d081884c7d65",
+					OpaquePrefix: true,
+					State:        deployment.StateDeployed,
+				})
+				Expect(db.Delete(depl5).Error).To(BeNil())
+
+				factories.DeploymentWithAttrs(db, proj1, u, deployment.Deployment{
+					Prefix:       "9f86d081884c7d65",
+					OpaquePrefix: true,
+					State:        deployment.StateDeployed,
+				})
+			})
+
+			It("leaves the shared files in S3 but sets purged_at", func() {
+				err := purge(db, depl5)
+				Expect(err).To(BeNil())
+
+				Expect(fakeS3.DeleteAllCalls.Count()).To(Equal(0))
+
+				err = db.Unscoped().First(depl5, depl5.ID).Error
+				Expect(err).To(BeNil())
+				Expect(depl5.PurgedAt).NotTo(BeNil())
+			})
+		})
+
 		It("sets purged_at", func() {
 			Expect(depl2.PurgedAt).To(BeNil())
 
@@ -64,7 +64,7 @@ var _ = Describe("AESEncrypter", func() {
 
 		Expect(d1).To(Equal(data))
 		Expect(d2).To(Equal(data))
-		Expect(d2).To(Equal(data))
+		Expect(d3).To(Equal(data))
 	})
 
 	Context("when a key shorter than 24 bytes (192-bit) is given", func() {
@@ -22,8 +22,12 @@ type CertInfo struct {
 var (
 	ErrInvalidCert       = errors.New("invalid cert")
 	ErrInvalidCommonName = errors.New("invalid common name")
+	ErrCertExpired       = errors.New("cert has expired")
 )
 
+// Now returns the current time, against which the expiry of certs is checked.
+var Now = time.Now
+
 func GetInfo(cert, pKey []byte, domainName string) (*CertInfo, error) {
 	certificate, err := tls.X509KeyPair(cert, pKey)
 	if err != nil {
@@ -31,6 +35,14 @@ func GetInfo(cert, pKey []byte, domainName string) (*CertInfo, error) {
 	}
 
 	x509Cert, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, ErrInvalidCert
+	}
+
+	if Now().After(x509Cert.NotAfter) {
+		return nil, ErrCertExpired
+	}
+
 	if err := x509Cert.VerifyHostname(domainName); err != nil {
 		return nil, ErrInvalidCommonName
 	}
@@ -2,6 +2,7 @@ package certhelper_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/nitrous-io/rise-server/pkg/certhelper"
 	. "github.com/onsi/ginkgo"
@@ -18,9 +19,16 @@ var _ = Describe("CertHelper", func() {
 		var (
 			certificate []byte
 			privateKey  []byte
+
+			origNow func() time.Time
 		)
 
 		BeforeEach(func() {
+			origNow = certhelper.Now
+			certhelper.Now = func() time.Time {
+				return time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+			}
+
 			certificate = []byte(`-----BEGIN CERTIFICATE-----
 MIIDqzCCApOgAwIBAgIJAMh/Miyzn6vjMA0GCSqGSIb3DQEBCwUAMGwxCzAJBgNV
 BAYTAlVTMRMwEQYDVQQIDApDYWxpZm9ybmlhMRYwFAYDVQQHDA1TYW4gRnJhbmNp
@@ -72,6 +80,10 @@ nqz5zr68zkEgxlfrZnBxifOvcdmlfGdhM3KSIGAzQOuyUaiblA+cqg==
 -----END RSA PRIVATE KEY-----`)
 		})
 
+		AfterEach(func() {
+			certhelper.Now = origNow
+		})
+
 		It("returns cert info if it is valid", func() {
 			cm, err := certhelper.GetInfo(certificate, privateKey, "www.n2odev.com")
 			Expect(err).To(BeNil())
@@ -89,6 +101,16 @@ nqz5zr68zkEgxlfrZnBxifOvcdmlfGdhM3KSIGAzQOuyUaiblA+cqg==
 			Expect(cm).To(BeNil())
 		})
 
+		It("returns an error if cert has expired", func() {
+			certhelper.Now = func() time.Time {
+				return time.Date(2019, 9, 15, 18, 18, 36, 0, time.UTC)
+			}
+
+			cm, err := certhelper.GetInfo(certificate, privateKey, "www.n2odev.com")
+			Expect(err).To(Equal(certhelper.ErrCertExpired))
+			Expect(cm).To(BeNil())
+		})
+
 		It("returns an error if wrong cert is given", func() {
 			cm, err := certhelper.GetInfo([]byte("hello"), []byte("world"), "www.n2odev.com")
 			Expect(err).To(Equal(certhelper.ErrInvalidCert))
@@ -7,10 +7,18 @@ import (
 
 type FileTransfer interface {
 	Upload(region, bucket, key string, body io.Reader, contentType, acl string) error
+	UploadWithMetadata(region, bucket, key string, body io.Reader, contentType, acl string, metadata map[string]string) error
+	// UploadWithConcurrency uploads a file that may be large, uploading up to
+	// concurrency parts of it at a time. Backends that do not upload in parts
+	// ignore concurrency.
+	UploadWithConcurrency(region, bucket, key string, body io.Reader, contentType, acl string, concurrency int) error
 	Download(region, bucket, key string, out io.WriterAt) error
 	Delete(region, bucket string, keys ...string) error
 	DeleteAll(region, bucket, prefix string) error
-	Copy(region, bucket, srcKey, destKey string) error
+	Copy(region, bucket, srcKey, destKey, acl string) error
 	Exists(region, bucket, key string) (bool, error)
+	ETag(region, bucket, key string) (string, error)
+	Size(region, bucket, key string) (int64, error)
+	List(region, bucket, prefix string) ([]string, error)
 	PresignedURL(region, bucket, key string, expireTime time.Duration) (string, error)
 }
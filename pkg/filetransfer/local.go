@@ -0,0 +1,249 @@
+package filetransfer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidKey is returned by Local for keys that are outside of its bucket.
+var ErrInvalidKey = errors.New("filetransfer: invalid key")
+
+func init() {
+	Register("local", func() (FileTransfer, error) {
+		root := os.Getenv("FILE_TRANSFER_LOCAL_DIR")
+		if root == "" {
+			root = filepath.Join(os.TempDir(), "rise-filetransfer")
+		}
+		return NewLocal(root), nil
+	})
+}
+
+// Local stores files on the local filesystem, under a directory per bucket in
+// its root directory, for development without S3. Regions, content types,
+// ACLs and metadata are ignored.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// path returns the path of the file of the object at key in bucket.
+func (l *Local) path(bucket, key string) (string, error) {
+	dir := filepath.Join(l.root, bucket)
+	p := filepath.Join(dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+	return p, nil
+}
+
+func (l *Local) Upload(region, bucket, key string, body io.Reader, contentType, acl string) error {
+	return l.UploadWithMetadata(region, bucket, key, body, contentType, acl, nil)
+}
+
+// UploadWithConcurrency uploads a file as a whole, as files are not uploaded
+// in parts.
+func (l *Local) UploadWithConcurrency(region, bucket, key string, body io.Reader, contentType, acl string, concurrency int) error {
+	return l.UploadWithMetadata(region, bucket, key, body, contentType, acl, nil)
+}
+
+func (l *Local) UploadWithMetadata(region, bucket, key string, body io.Reader, contentType, acl string, metadata map[string]string) error {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	// The file is written in full before it replaces the previous one, as
+	// objects on S3 are.
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (l *Local) Download(region, bucket, key string, out io.WriterAt) error {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	var off int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, err := out.WriteAt(buf[:n], off); err != nil {
+				return err
+			}
+			off += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (l *Local) Delete(region, bucket string, keys ...string) error {
+	for _, key := range keys {
+		p, err := l.path(bucket, key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Local) DeleteAll(region, bucket, prefix string) error {
+	keys, err := l.List(region, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	return l.Delete(region, bucket, keys...)
+}
+
+func (l *Local) Copy(region, bucket, srcKey, destKey, acl string) error {
+	p, err := l.path(bucket, srcKey)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return l.Upload(region, bucket, destKey, f, "", acl)
+}
+
+func (l *Local) Exists(region, bucket, key string) (bool, error) {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ETag returns the MD5 checksum of an object, which is what S3 reports as the
+// ETag of objects uploaded in a single part, or an empty string if the object
+// does not exist.
+func (l *Local) ETag(region, bucket, key string) (string, error) {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Size returns the size of an object in bytes.
+func (l *Local) Size(region, bucket, key string) (int64, error) {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// List returns the keys of all the objects whose keys begin with prefix, in
+// lexicographical order.
+func (l *Local) List(region, bucket, prefix string) ([]string, error) {
+	dir := filepath.Join(l.root, bucket)
+
+	var keys []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PresignedURL returns a file URL of an object, which does not expire.
+func (l *Local) PresignedURL(region, bucket, key string, expireTime time.Duration) (string, error) {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(p), nil
+}
@@ -0,0 +1,124 @@
+package filetransfer_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "filetransfer")
+}
+
+var _ = Describe("Local", func() {
+	const (
+		region = "us-west-2"
+		bucket = "rise-test"
+	)
+
+	var (
+		dir   string
+		local *filetransfer.Local
+		err   error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "filetransfer")
+		Expect(err).To(BeNil())
+		local = filetransfer.NewLocal(dir)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	upload := func(key, content string) {
+		Expect(local.Upload(region, bucket, key, bytes.NewBufferString(content), "text/plain", "public-read")).To(BeNil())
+	}
+
+	download := func(key string) string {
+		f, err := ioutil.TempFile("", "filetransfer-download")
+		Expect(err).To(BeNil())
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+
+		Expect(local.Download(region, bucket, key, f)).To(BeNil())
+		b, err := ioutil.ReadFile(f.Name())
+		Expect(err).To(BeNil())
+		return string(b)
+	}
+
+	It("downloads uploaded files", func() {
+		upload("deployments/a1b2-1/webroot/index.html", "<html></html>")
+		Expect(download("deployments/a1b2-1/webroot/index.html")).To(Equal("<html></html>"))
+
+		upload("deployments/a1b2-1/webroot/index.html", "<html>v2</html>")
+		Expect(download("deployments/a1b2-1/webroot/index.html")).To(Equal("<html>v2</html>"))
+	})
+
+	It("reports whether files exist, and their sizes and ETags", func() {
+		upload("a/b.txt", "hello")
+
+		exists, err := local.Exists(region, bucket, "a/b.txt")
+		Expect(err).To(BeNil())
+		Expect(exists).To(BeTrue())
+
+		exists, err = local.Exists(region, bucket, "a/c.txt")
+		Expect(err).To(BeNil())
+		Expect(exists).To(BeFalse())
+
+		size, err := local.Size(region, bucket, "a/b.txt")
+		Expect(err).To(BeNil())
+		Expect(size).To(Equal(int64(5)))
+
+		etag, err := local.ETag(region, bucket, "a/b.txt")
+		Expect(err).To(BeNil())
+		Expect(etag).To(Equal("5d41402abc4b2a76b9719d911017c592"))
+
+		etag, err = local.ETag(region, bucket, "a/c.txt")
+		Expect(err).To(BeNil())
+		Expect(etag).To(Equal(""))
+	})
+
+	It("lists, copies and deletes files", func() {
+		upload("deployments/a1b2-1/webroot/index.html", "1")
+		upload("deployments/a1b2-1/webroot/css/app.css", "2")
+		upload("deployments/c3d4-2/webroot/index.html", "3")
+
+		keys, err := local.List(region, bucket, "deployments/a1b2-1/")
+		Expect(err).To(BeNil())
+		Expect(keys).To(Equal([]string{
+			"deployments/a1b2-1/webroot/css/app.css",
+			"deployments/a1b2-1/webroot/index.html",
+		}))
+
+		Expect(local.Copy(region, bucket, "deployments/a1b2-1/webroot/index.html", "deployments/e5f6-3/webroot/index.html", "public-read")).To(BeNil())
+		Expect(download("deployments/e5f6-3/webroot/index.html")).To(Equal("1"))
+
+		Expect(local.Delete(region, bucket, "deployments/c3d4-2/webroot/index.html", "missing.html")).To(BeNil())
+		Expect(local.DeleteAll(region, bucket, "deployments/a1b2-1/")).To(BeNil())
+
+		keys, err = local.List(region, bucket, "")
+		Expect(err).To(BeNil())
+		Expect(keys).To(Equal([]string{"deployments/e5f6-3/webroot/index.html"}))
+	})
+
+	It("lists nothing in a bucket that has no files", func() {
+		keys, err := local.List(region, bucket, "")
+		Expect(err).To(BeNil())
+		Expect(keys).To(BeEmpty())
+	})
+
+	It("does not allow keys outside of the bucket", func() {
+		err := local.Upload(region, bucket, "../other-bucket/index.html", bytes.NewBufferString("x"), "", "")
+		Expect(err).To(Equal(filetransfer.ErrInvalidKey))
+	})
+})
@@ -0,0 +1,55 @@
+package filetransfer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory creates a FileTransfer backend.
+type Factory func() (FileTransfer, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a backend available by name to New. It panics if a backend
+// is registered twice under the same name, or if factory is nil.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("filetransfer: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("filetransfer: Register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates a FileTransfer with the backend registered under name.
+func New(name string) (FileTransfer, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("filetransfer: unknown backend %q", name)
+	}
+	return factory()
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,41 @@
+package filetransfer_test
+
+import (
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	It("creates backends by the name they are registered under", func() {
+		local := filetransfer.NewLocal("/tmp/rise-registry-test")
+		filetransfer.Register("registry-test", func() (filetransfer.FileTransfer, error) {
+			return local, nil
+		})
+
+		Expect(filetransfer.Backends()).To(ContainElement("registry-test"))
+
+		ft, err := filetransfer.New("registry-test")
+		Expect(err).To(BeNil())
+		Expect(ft).To(BeIdenticalTo(local))
+	})
+
+	It("registers the local backend", func() {
+		ft, err := filetransfer.New("local")
+		Expect(err).To(BeNil())
+		Expect(ft).To(BeAssignableToTypeOf(&filetransfer.Local{}))
+	})
+
+	It("returns an error for unknown backends", func() {
+		_, err := filetransfer.New("gcs")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("does not allow registering a backend twice", func() {
+		Expect(func() {
+			filetransfer.Register("local", func() (filetransfer.FileTransfer, error) {
+				return nil, nil
+			})
+		}).To(Panic())
+	})
+})
@@ -3,6 +3,7 @@ package filetransfer
 import (
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,6 +16,10 @@ import (
 type S3 struct {
 	partSize       int64
 	maxUploadParts int
+
+	// config, if set, is merged into the config of every session, e.g. to
+	// point S3 at another endpoint.
+	config *aws.Config
 }
 
 func NewS3(partSize int64, maxUploadParts int) *S3 {
@@ -25,7 +30,24 @@ func NewS3(partSize int64, maxUploadParts int) *S3 {
 }
 
 func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl string) error {
-	sess := session.New(&aws.Config{Region: aws.String(region)})
+	return s.UploadWithMetadata(region, bucket, key, body, contentType, acl, nil)
+}
+
+// UploadWithMetadata uploads a file with the given user-defined metadata,
+// which S3 returns in x-amz-meta-* headers.
+func (s *S3) UploadWithMetadata(region, bucket, key string, body io.Reader, contentType, acl string, metadata map[string]string) error {
+	return s.upload(region, bucket, key, body, contentType, acl, metadata, 0)
+}
+
+// UploadWithConcurrency uploads a file, uploading up to concurrency of its
+// parts at a time if it is larger than a part. A concurrency of 0 uses the
+// uploader's default.
+func (s *S3) UploadWithConcurrency(region, bucket, key string, body io.Reader, contentType, acl string, concurrency int) error {
+	return s.upload(region, bucket, key, body, contentType, acl, nil, concurrency)
+}
+
+func (s *S3) upload(region, bucket, key string, body io.Reader, contentType, acl string, metadata map[string]string, concurrency int) error {
+	sess := s.session(region)
 	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
 		if s.partSize != 0 {
 			u.PartSize = s.partSize
@@ -33,6 +55,9 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 		if s.maxUploadParts != 0 {
 			u.MaxUploadParts = s.maxUploadParts
 		}
+		if concurrency > 0 {
+			u.Concurrency = concurrency
+		}
 	})
 
 	if contentType == "" {
@@ -49,12 +74,13 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 		Body:        body,
 		ACL:         aws.String(acl),
 		ContentType: aws.String(contentType),
+		Metadata:    aws.StringMap(metadata),
 	})
 	return err
 }
 
 func (s *S3) Download(region, bucket, key string, out io.WriterAt) error {
-	sess := session.New(&aws.Config{Region: aws.String(region)})
+	sess := s.session(region)
 	downloader := s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
 		if s.partSize != 0 {
 			d.PartSize = s.partSize
@@ -69,7 +95,7 @@ func (s *S3) Download(region, bucket, key string, out io.WriterAt) error {
 }
 
 func (s *S3) Delete(region, bucket string, keys ...string) error {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+	svc := s3.New(s.session(region))
 
 	var objects []*s3.ObjectIdentifier
 	for _, key := range keys {
@@ -91,7 +117,7 @@ func (s *S3) Delete(region, bucket string, keys ...string) error {
 }
 
 func (s *S3) DeleteAll(region, bucket, prefix string) error {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+	svc := s3.New(s.session(region))
 
 	listInput := &s3.ListObjectsInput{
 		Bucket: aws.String(bucket),
@@ -136,21 +162,21 @@ func (s *S3) DeleteAll(region, bucket, prefix string) error {
 	return nil
 }
 
-func (s *S3) Copy(region, bucket, srcKey, destKey string) error {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+func (s *S3) Copy(region, bucket, srcKey, destKey, acl string) error {
+	svc := s3.New(s.session(region))
 
 	_, err := svc.CopyObject(&s3.CopyObjectInput{
 		Bucket:     aws.String(bucket),
 		Key:        aws.String(destKey),
 		CopySource: aws.String(bucket + "/" + srcKey),
-		ACL:        aws.String("private"),
+		ACL:        aws.String(acl),
 	})
 
 	return err
 }
 
 func (s *S3) Exists(region, bucket, key string) (bool, error) {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+	svc := s3.New(s.session(region))
 
 	_, err := svc.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
@@ -168,8 +194,68 @@ func (s *S3) Exists(region, bucket, key string) (bool, error) {
 	return true, nil
 }
 
+// ETag returns the ETag of an object without the surrounding quotes, or an
+// empty string if the object does not exist.
+func (s *S3) ETag(region, bucket, key string) (string, error) {
+	svc := s3.New(s.session(region))
+
+	out, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.RequestFailure); ok {
+			if e.StatusCode() == http.StatusNotFound {
+				return "", nil
+			}
+		}
+		return "", err
+	}
+
+	return strings.Trim(aws.StringValue(out.ETag), `"`), nil
+}
+
+// Size returns the size of an object in bytes.
+func (s *S3) Size(region, bucket, key string) (int64, error) {
+	svc := s3.New(s.session(region))
+
+	out, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return aws.Int64Value(out.ContentLength), nil
+}
+
+// List returns the keys of all the objects whose keys begin with prefix, in
+// lexicographical order.
+func (s *S3) List(region, bucket, prefix string) ([]string, error) {
+	svc := s3.New(s.session(region))
+
+	listInput := &s3.ListObjectsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var keys []string
+	err := svc.ListObjectsPages(listInput, func(res *s3.ListObjectsOutput, lastPage bool) (shouldContinue bool) {
+		for _, obj := range res.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
 func (s *S3) PresignedURL(region, bucket, key string, expireTime time.Duration) (string, error) {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+	svc := s3.New(s.session(region))
 
 	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(bucket),
@@ -183,3 +269,8 @@ func (s *S3) PresignedURL(region, bucket, key string, expireTime time.Duration)
 
 	return url, nil
 }
+
+// session returns a session for the region.
+func (s *S3) session(region string) *session.Session {
+	return session.New(&aws.Config{Region: aws.String(region)}, s.config)
+}
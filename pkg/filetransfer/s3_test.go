@@ -0,0 +1,97 @@
+package filetransfer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("S3", func() {
+	const (
+		partSize   = int64(5 * 1024 * 1024)
+		partsCount = 6
+	)
+
+	var (
+		server *httptest.Server
+		s      *S3
+
+		mu           sync.Mutex
+		parts        int
+		uploading    int
+		maxUploading int
+		received     int64
+	)
+
+	BeforeEach(func() {
+		parts, uploading, maxUploading, received = 0, 0, 0, 0
+
+		// server implements just enough of the multipart upload API of S3.
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			switch {
+			case r.Method == "POST" && q.Get("uploadId") != "":
+				fmt.Fprint(w, `<CompleteMultipartUploadResult><Location>`+server.URL+`/bucket/bundle.tar.gz</Location><Bucket>bucket</Bucket><Key>bundle.tar.gz</Key></CompleteMultipartUploadResult>`)
+
+			case r.Method == "POST":
+				fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>bundle.tar.gz</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+
+			case r.Method == "PUT" && q.Get("partNumber") != "":
+				mu.Lock()
+				parts++
+				uploading++
+				if uploading > maxUploading {
+					maxUploading = uploading
+				}
+				mu.Unlock()
+
+				n, _ := ioutil.ReadAll(r.Body)
+				time.Sleep(50 * time.Millisecond)
+
+				mu.Lock()
+				uploading--
+				received += int64(len(n))
+				mu.Unlock()
+
+				w.Header().Set("ETag", fmt.Sprintf(`"etag-%s"`, q.Get("partNumber")))
+
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+
+		s = NewS3(partSize, 100)
+		s.config = &aws.Config{
+			Endpoint:         aws.String(server.URL),
+			S3ForcePathStyle: aws.Bool(true),
+			Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("UploadWithConcurrency()", func() {
+		It("uploads the parts of a large file with the given concurrency", func() {
+			body := bytes.NewReader(make([]byte, partsCount*partSize))
+
+			Expect(s.UploadWithConcurrency("us-west-2", "bucket", "bundle.tar.gz", body, "", "private", 3)).To(BeNil())
+
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(parts).To(Equal(partsCount))
+			Expect(received).To(Equal(partsCount * partSize))
+			Expect(maxUploading).To(Equal(3))
+		})
+	})
+})
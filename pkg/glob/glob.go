@@ -0,0 +1,81 @@
+package glob
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// ErrBadPattern is returned for patterns that can never match a path.
+var ErrBadPattern = errors.New("glob pattern is malformed")
+
+// Validate returns ErrBadPattern if pattern is malformed.
+func Validate(pattern string) error {
+	if pattern == "" || strings.HasPrefix(pattern, "/") {
+		return ErrBadPattern
+	}
+
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == "" {
+			return ErrBadPattern
+		}
+		if seg == "**" {
+			continue
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return ErrBadPattern
+		}
+	}
+	return nil
+}
+
+// Match returns whether the file at the slash-separated path name matches
+// pattern. Patterns are those of path.Match, except that a "**" segment
+// matches any number of path segments, including none, and that a pattern
+// without a "/" matches the base name of the file, e.g. "*.map" matches
+// source maps in any directory. Malformed patterns match nothing.
+func Match(pattern, name string) bool {
+	if Validate(pattern) != nil {
+		return false
+	}
+
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// MatchAny returns whether the file at name matches any of the patterns.
+func MatchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(patSegs, nameSegs []string) bool {
+	for len(patSegs) > 0 {
+		if patSegs[0] == "**" {
+			// Try every number of segments the "**" could stand for.
+			for i := 0; i <= len(nameSegs); i++ {
+				if matchSegments(patSegs[1:], nameSegs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(nameSegs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patSegs[0], nameSegs[0]); !ok {
+			return false
+		}
+		patSegs, nameSegs = patSegs[1:], nameSegs[1:]
+	}
+	return len(nameSegs) == 0
+}
@@ -0,0 +1,60 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/glob"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "glob")
+}
+
+var _ = Describe("Glob", func() {
+	DescribeTable("Validate()",
+		func(pattern string, valid bool) {
+			if valid {
+				Expect(glob.Validate(pattern)).To(BeNil())
+			} else {
+				Expect(glob.Validate(pattern)).To(Equal(glob.ErrBadPattern))
+			}
+		},
+
+		Entry("allows file names", "index.html", true),
+		Entry("allows wildcards", "*.map", true),
+		Entry("allows double stars", "dist/**", true),
+		Entry("allows character classes", "img/[a-c]*.png", true),
+		Entry("disallows empty patterns", "", false),
+		Entry("disallows absolute patterns", "/dist/**", false),
+		Entry("disallows empty segments", "dist//*.js", false),
+		Entry("disallows unclosed character classes", "img/[a-c.png", false),
+	)
+
+	DescribeTable("Match()",
+		func(pattern, name string, matches bool) {
+			Expect(glob.Match(pattern, name)).To(Equal(matches))
+		},
+
+		Entry("matches base names in any directory", "*.map", "dist/js/app.js.map", true),
+		Entry("matches base names at the root", "*.map", "app.js.map", true),
+		Entry("does not match other base names", "*.map", "dist/js/app.js", false),
+		Entry("matches paths", "dist/*.js", "dist/app.js", true),
+		Entry("does not match paths in subdirectories with a single star", "dist/*.js", "dist/js/app.js", false),
+		Entry("matches any depth with a double star", "dist/**", "dist/js/vendor/app.js", true),
+		Entry("matches no depth with a double star", "dist/**/*.js", "dist/app.js", true),
+		Entry("does not match other directories with a double star", "dist/**", "src/app.js", false),
+		Entry("does not match with malformed patterns", "[", "[", false),
+	)
+
+	Describe("MatchAny()", func() {
+		It("returns whether any of the patterns matches", func() {
+			Expect(glob.MatchAny([]string{"*.map", "dist/**"}, "dist/app.js")).To(BeTrue())
+			Expect(glob.MatchAny([]string{"*.map", "dist/**"}, "src/app.js")).To(BeFalse())
+			Expect(glob.MatchAny(nil, "src/app.js")).To(BeFalse())
+		})
+	})
+})
@@ -11,6 +11,7 @@ type Reader struct {
 	io.Reader
 	hashWriter hash.Hash
 	sum        []byte
+	size       int64
 }
 
 func NewReader(reader io.Reader) *Reader {
@@ -21,6 +22,7 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 	n, err = r.Reader.Read(p)
 
 	r.hashWriter.Write(p[:n])
+	r.size += int64(n)
 
 	return n, err
 }
@@ -28,3 +30,8 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 func (r *Reader) Checksum() string {
 	return hex.EncodeToString(r.hashWriter.Sum(nil))
 }
+
+// Size returns the number of bytes read so far.
+func (r *Reader) Size() int64 {
+	return r.size
+}
@@ -2,6 +2,7 @@ package job
 
 import (
 	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
@@ -62,3 +63,60 @@ func (j *Job) Enqueue() error {
 		},
 	)
 }
+
+// EnqueueDelayed enqueues the job to the back of its queue once delay has
+// passed. The job is held in delayQueue, which dead-letters expired jobs to
+// the job's queue. Jobs only leave a delay queue in order, so it must only
+// hold jobs of one queue that are delayed for the same time.
+func (j *Job) EnqueueDelayed(delayQueue string, delay time.Duration) error {
+	mq, err := mqconn.MQ()
+	if err != nil {
+		return err
+	}
+
+	ch, err := mq.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(
+		j.QueueName,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // noWait
+		nil,
+	); err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		delayQueue,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // noWait
+		amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": j.QueueName,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return ch.Publish(
+		"",     // exchange
+		q.Name, // routing key
+		false,  // mandatory
+		false,  // immediate
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "text/plain",
+			Body:         []byte(j.Data),
+			Timestamp:    time.Now(),
+			Expiration:   strconv.FormatInt(int64(delay/time.Millisecond), 10),
+		},
+	)
+}
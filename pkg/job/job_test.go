@@ -2,6 +2,7 @@ package job_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
@@ -60,4 +61,38 @@ var _ = Describe("Job", func() {
 			Expect(string(d.Body)).To(Equal("bar"))
 		})
 	})
+
+	Describe("EnqueueDelayed()", func() {
+		var (
+			mq  *amqp.Connection
+			j   *job.Job
+			err error
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, "fooq", "fooq-delayed")
+			j = job.New("fooq", []byte("bar"))
+		})
+
+		AfterEach(func() {
+			testhelper.DeleteQueue(mq, "fooq-delayed")
+		})
+
+		It("enqueues job to queue once the delay has passed", func() {
+			err := j.EnqueueDelayed("fooq-delayed", 500*time.Millisecond)
+			Expect(err).To(BeNil())
+
+			Expect(testhelper.ConsumeQueue(mq, "fooq")).To(BeNil())
+
+			var d *amqp.Delivery
+			Eventually(func() *amqp.Delivery {
+				d = testhelper.ConsumeQueue(mq, "fooq")
+				return d
+			}, 2*time.Second).ShouldNot(BeNil())
+			Expect(string(d.Body)).To(Equal("bar"))
+		})
+	})
 })
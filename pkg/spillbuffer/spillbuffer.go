@@ -0,0 +1,98 @@
+package spillbuffer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Buffer holds the content read from a reader, in memory if it fits within
+// the memory limit, or in a temp file otherwise, so that buffering large
+// uploads does not use more memory than the limit.
+type Buffer struct {
+	mem  *bytes.Reader
+	file *os.File
+	size int64
+}
+
+// New reads r to the end into a Buffer. Content larger than memLimit bytes is
+// written to a temp file, which is removed when the Buffer is closed.
+func New(r io.Reader, memLimit int64) (*Buffer, error) {
+	var buf bytes.Buffer
+	// Reading one byte past the limit tells whether the content fits.
+	n, err := io.Copy(&buf, io.LimitReader(r, memLimit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if n <= memLimit {
+		return &Buffer{mem: bytes.NewReader(buf.Bytes()), size: n}, nil
+	}
+
+	f, err := ioutil.TempFile("", "spillbuffer")
+	if err != nil {
+		return nil, err
+	}
+	b := &Buffer{file: f}
+
+	if b.size, err = io.Copy(f, io.MultiReader(&buf, r)); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Buffer) Read(p []byte) (int, error) {
+	if b.file != nil {
+		return b.file.Read(p)
+	}
+	return b.mem.Read(p)
+}
+
+func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	if b.file != nil {
+		return b.file.ReadAt(p, off)
+	}
+	return b.mem.ReadAt(p, off)
+}
+
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	if b.file != nil {
+		return b.file.Seek(offset, whence)
+	}
+	return b.mem.Seek(offset, whence)
+}
+
+// Size returns the size of the content.
+func (b *Buffer) Size() int64 {
+	return b.size
+}
+
+// InMemory returns the number of bytes of the content held in memory.
+func (b *Buffer) InMemory() int64 {
+	if b.file != nil {
+		return 0
+	}
+	return b.size
+}
+
+// Close removes the temp file the content was written to, if any.
+func (b *Buffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+
+	name := b.file.Name()
+	if err := b.file.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return os.Remove(name)
+}
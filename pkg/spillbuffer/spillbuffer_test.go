@@ -0,0 +1,99 @@
+package spillbuffer_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/spillbuffer"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "spillbuffer")
+}
+
+var _ = Describe("Buffer", func() {
+	const memLimit = 1024
+
+	var (
+		buf *spillbuffer.Buffer
+		err error
+	)
+
+	AfterEach(func() {
+		if buf != nil {
+			Expect(buf.Close()).To(BeNil())
+		}
+	})
+
+	tempFiles := func() []string {
+		infos, err := ioutil.ReadDir(os.TempDir())
+		Expect(err).To(BeNil())
+
+		var names []string
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name(), "spillbuffer") {
+				names = append(names, info.Name())
+			}
+		}
+		return names
+	}
+
+	Context("when the content fits within the memory limit", func() {
+		content := bytes.Repeat([]byte("a"), memLimit)
+
+		BeforeEach(func() {
+			buf, err = spillbuffer.New(bytes.NewReader(content), memLimit)
+			Expect(err).To(BeNil())
+		})
+
+		It("holds the content in memory", func() {
+			Expect(buf.Size()).To(Equal(int64(memLimit)))
+			Expect(buf.InMemory()).To(Equal(int64(memLimit)))
+
+			b, err := ioutil.ReadAll(buf)
+			Expect(err).To(BeNil())
+			Expect(b).To(Equal(content))
+		})
+	})
+
+	Context("when the content is larger than the memory limit", func() {
+		content := bytes.Repeat([]byte("0123456789"), 10*memLimit)
+
+		var filesBefore []string
+
+		BeforeEach(func() {
+			filesBefore = tempFiles()
+
+			buf, err = spillbuffer.New(bytes.NewReader(content), memLimit)
+			Expect(err).To(BeNil())
+		})
+
+		It("writes the content to a temp file instead of holding it in memory", func() {
+			Expect(buf.Size()).To(Equal(int64(len(content))))
+			Expect(buf.InMemory()).To(Equal(int64(0)))
+			Expect(tempFiles()).To(HaveLen(len(filesBefore) + 1))
+
+			b, err := ioutil.ReadAll(buf)
+			Expect(err).To(BeNil())
+			Expect(b).To(Equal(content))
+
+			p := make([]byte, 10)
+			_, err = buf.ReadAt(p, 5)
+			Expect(err).To(BeNil())
+			Expect(string(p)).To(Equal("5678901234"))
+		})
+
+		It("removes the temp file when closed", func() {
+			Expect(buf.Close()).To(BeNil())
+			buf = nil
+
+			Expect(tempFiles()).To(HaveLen(len(filesBefore)))
+		})
+	})
+})
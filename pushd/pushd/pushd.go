@@ -27,13 +27,14 @@ import (
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/githubapi"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
 var (
-	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+	S3 filetransfer.FileTransfer = s3client.S3
 
 	ErrUnexpectedDeploymentState  = errors.New("deployment is in an unexpected state")
 	ErrProjectConfigNotFound      = errors.New("GitHub Contents API response not HTTP 200")
@@ -132,7 +133,7 @@ func Work(data []byte) error {
 		return err
 	}
 
-	uploadKey := fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())
+	uploadKey := shared.DeploymentKey(depl.PrefixID(), "raw-bundle.tar.gz")
 	if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, uploadKey, tarball, "", "private"); err != nil {
 		return err
 	}
@@ -176,7 +177,8 @@ func fetchProjectPath(pl *githubapi.PushPayload) (string, error) {
 	if common.GitHubAPIToken != "" {
 		req.Header.Set("Authorization", "token "+common.GitHubAPIToken)
 	}
-	cl := &http.Client{Timeout: 2 * time.Second}
+	cl := common.HTTPClient()
+	cl.Timeout = 2 * time.Second
 	res, err := cl.Do(req)
 	if err != nil {
 		return "", err
@@ -207,7 +209,9 @@ func fetchProjectPath(pl *githubapi.PushPayload) (string, error) {
 //   4. echo build/ >> .git/info/sparse-checkout
 //   5. git pull origin master
 func fetchAndUnpackArchive(url, dst, subdir string) error {
-	cl := &http.Client{Timeout: 10 * time.Second}
+	// Archives are uploaded as raw bundles, so they are limited to the size
+	// of those rather than that of API responses.
+	cl := common.NewHTTPClient(10*time.Second, s3client.MaxUploadSize)
 	req, err := http.NewRequest("GET", url, nil)
 	if common.GitHubAPIToken != "" {
 		req.Header.Set("Authorization", "token "+common.GitHubAPIToken)
@@ -13,4 +13,8 @@ var All = []string{
 // routes
 const (
 	RouteV1Invalidation = "v1.invalidation"
+
+	// RouteV1InvalidationAck is where edges acknowledge the invalidations of
+	// deployments.
+	RouteV1InvalidationAck = "v1.invalidation_ack"
 )
@@ -1,16 +1,48 @@
 package messages
 
+import "github.com/nitrous-io/rise-server/shared/queues"
+
+// Priorities of deploys. Jobs without a priority have normal priority.
+const (
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
 type DeployJobData struct {
 	DeploymentID      uint   `json:"deployment_id"`
-	SkipWebrootUpload bool   `json:"skip_webroot_upload"`      // if true, uploading of webroot will be skipped and only meta.json for domains will be deployed
-	SkipInvalidation  bool   `json:"skip_invalidation"`        // if true, prefix cache invalidation message will not be published
-	UseRawBundle      bool   `json:"use_raw_bundle"`           // if true, it uses raw bundle to deploy instead of optimized bundle
-	ArchiveFormat     string `json:"archive_format,omitempty"` // "zip" or "tar.gz"
+	SkipWebrootUpload bool   `json:"skip_webroot_upload"`         // if true, uploading of webroot will be skipped and only meta.json for domains will be deployed
+	SkipInvalidation  bool   `json:"skip_invalidation"`           // if true, prefix cache invalidation message will not be published
+	UseRawBundle      bool   `json:"use_raw_bundle"`              // if true, it uses raw bundle to deploy instead of optimized bundle
+	ArchiveFormat     string `json:"archive_format,omitempty"`    // "zip" or "tar.gz"
+	Priority          string `json:"priority,omitempty"`          // "high" or empty
+	ForceFullUpload   bool   `json:"force_full_upload,omitempty"` // if true, files that have not changed since the active deployment are uploaded again rather than copied
+	RepairUploads     bool   `json:"repair_uploads,omitempty"`    // if true, only the files that failed to upload to some of the targets are copied over from the others
+	RepairAttempt     int    `json:"repair_attempt,omitempty"`    // how many times repairing the failed uploads was attempted before
+}
+
+// QueueName returns the name of the queue the job should be enqueued to.
+func (d *DeployJobData) QueueName() string {
+	if d.Priority == PriorityHigh {
+		return queues.DeployPriority
+	}
+	return queues.Deploy
+}
+
+// DelayedQueueName returns the name of the queue the job is held in when it
+// is enqueued with a delay.
+func (d *DeployJobData) DelayedQueueName() string {
+	if d.Priority == PriorityHigh {
+		return queues.DeployPriorityDelayed
+	}
+	return queues.DeployDelayed
 }
 
 type BuildJobData struct {
-	DeploymentID  uint   `json:"deployment_id"`
-	ArchiveFormat string `json:"archive_format,omitempty"` // "zip" or "tar.gz"
+	DeploymentID     uint   `json:"deployment_id"`
+	ArchiveFormat    string `json:"archive_format,omitempty"`    // "zip" or "tar.gz"
+	Priority         string `json:"priority,omitempty"`          // priority of the deploy job that follows the build
+	SkipInvalidation bool   `json:"skip_invalidation,omitempty"` // passed on to the deploy job that follows the build
+	ForceFullUpload  bool   `json:"force_full_upload,omitempty"` // passed on to the deploy job that follows the build
 }
 
 type PushJobData struct {
@@ -19,4 +51,18 @@ type PushJobData struct {
 
 type V1InvalidationMessageData struct {
 	Domains []string `json:"domains"`
+	// Paths, if any, limits the invalidation to the given paths on the domains
+	// instead of everything cached for them.
+	Paths []string `json:"paths,omitempty"`
+	// DeploymentID, if set, is the deployment the invalidation is for. Edges
+	// acknowledge such invalidations once they are done.
+	DeploymentID uint `json:"deployment_id,omitempty"`
+}
+
+// V1InvalidationAckMessageData is published by an edge once it has worked on
+// the invalidation of a deployment. Error is set if it failed.
+type V1InvalidationAckMessageData struct {
+	DeploymentID uint     `json:"deployment_id"`
+	Domains      []string `json:"domains"`
+	Error        string   `json:"error,omitempty"`
 }
@@ -0,0 +1,303 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/shared"
+)
+
+// Encodings an asset can be available in.
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+)
+
+// VariantsDir is the directory of the webroot of a deployment that the
+// pre-compressed variants of its assets are stored under. See VariantPath.
+const VariantsDir = ".variants"
+
+// LastModifiedMetadataKey is the key of the S3 metadata of an asset that holds
+// the time it was last modified in its bundle, in the format of the
+// Last-Modified header. Edges serve it as the asset's Last-Modified.
+const LastModifiedMetadataKey = "Last-Modified"
+
+// Meta is the content of the meta.json file that is uploaded for each domain
+// of a project. Edges use it to figure out how to serve the domain.
+// The metadata file is publicly readable, do not put sensitive data in it.
+type Meta struct {
+	Prefix            string  `json:"prefix"`
+	ForceHTTPS        bool    `json:"force_https,omitempty"`
+	BasicAuthUsername *string `json:"basic_auth_username,omitempty"`
+	BasicAuthPassword *string `json:"basic_auth_password,omitempty"`
+
+	// Variants maps the path of each asset that has pre-compressed variants to
+	// the encodings it is available in, so that edges can negotiate them
+	// against Accept-Encoding without asking S3 whether they exist. See
+	// VariantPath for where variants are stored.
+	Variants map[string][]string `json:"variants,omitempty"`
+
+	// ETags maps the path of each asset to its ETag, the MD5 checksum of the
+	// asset as uploaded, so that edges can answer conditional requests without
+	// asking S3. Variants of an asset have the same ETag, which edges have to
+	// qualify with the encoding they serve.
+	ETags map[string]string `json:"etags,omitempty"`
+
+	// Headers are added by edges to the responses of the deployment.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// PathHeaders are added by edges to the responses for the paths they
+	// match, in addition to Headers. They come from the _headers file of the
+	// deployment.
+	PathHeaders []deployment.PathHeaders `json:"path_headers,omitempty"`
+
+	// Tombstones are the paths of pages that were removed from the project,
+	// which edges respond to with 410 Gone instead of 404 Not Found.
+	Tombstones []string `json:"tombstones,omitempty"`
+
+	// CertID is the ID of the custom SSL cert of the domain, if it has one.
+	// Edges terminate TLS for the domain with it.
+	CertID uint `json:"cert_id,omitempty"`
+
+	// Canary, if set, is served for a percentage of the requests instead.
+	Canary *Canary `json:"canary,omitempty"`
+}
+
+// Canary describes a deployment that edges send a percentage of the traffic
+// to a domain to.
+type Canary struct {
+	Prefix   string              `json:"prefix"`
+	Percent  uint                `json:"percent"`
+	Variants map[string][]string `json:"variants,omitempty"`
+	ETags    map[string]string   `json:"etags,omitempty"`
+	Headers  map[string]string   `json:"headers,omitempty"`
+
+	PathHeaders []deployment.PathHeaders `json:"path_headers,omitempty"`
+}
+
+// New returns the meta of a deployment of a project.
+func New(proj *project.Project, depl *deployment.Deployment) (*Meta, error) {
+	return NewWithSettings(proj.Settings(), depl)
+}
+
+// NewWithSettings returns the meta of a deployment of a project with the
+// given settings.
+func NewWithSettings(settings *project.Settings, depl *deployment.Deployment) (*Meta, error) {
+	m := &Meta{
+		Prefix:            depl.PrefixID(),
+		ForceHTTPS:        settings.ForceHTTPS,
+		BasicAuthUsername: settings.BasicAuthUsername,
+		BasicAuthPassword: settings.EncryptedBasicAuthPassword,
+		Headers:           headers(depl),
+	}
+
+	if len(depl.Variants) > 0 {
+		if err := json.Unmarshal(depl.Variants, &m.Variants); err != nil {
+			return nil, err
+		}
+	}
+
+	tags, err := etags(depl)
+	if err != nil {
+		return nil, err
+	}
+	m.ETags = tags
+
+	pathHeaders, err := depl.PathHeaderRules()
+	if err != nil {
+		return nil, err
+	}
+	if len(pathHeaders) > 0 {
+		m.PathHeaders = pathHeaders
+	}
+
+	tombstones, err := depl.TombstonePaths()
+	if err != nil {
+		return nil, err
+	}
+	if len(tombstones) > 0 {
+		m.Tombstones = tombstones
+	}
+
+	return m, nil
+}
+
+// NewCanary returns the canary meta of a deployment that is served for the
+// given percentage of requests.
+func NewCanary(depl *deployment.Deployment, percent uint) (*Canary, error) {
+	c := &Canary{
+		Prefix:  depl.PrefixID(),
+		Percent: percent,
+		Headers: headers(depl),
+	}
+
+	if len(depl.Variants) > 0 {
+		if err := json.Unmarshal(depl.Variants, &c.Variants); err != nil {
+			return nil, err
+		}
+	}
+
+	tags, err := etags(depl)
+	if err != nil {
+		return nil, err
+	}
+	c.ETags = tags
+
+	pathHeaders, err := depl.PathHeaderRules()
+	if err != nil {
+		return nil, err
+	}
+	if len(pathHeaders) > 0 {
+		c.PathHeaders = pathHeaders
+	}
+
+	return c, nil
+}
+
+// etags returns the ETags of the assets in the manifest of a deployment by
+// path, or nil if it has none, e.g. because it was deployed before manifests
+// were kept.
+func etags(depl *deployment.Deployment) (map[string]string, error) {
+	manifest, err := depl.ParsedManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags map[string]string
+	for path, entry := range manifest {
+		if entry.ETag == "" {
+			continue
+		}
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags[path] = entry.ETag
+	}
+	return tags, nil
+}
+
+// headers returns the headers that edges add to the responses of a
+// deployment, or nil if there are none.
+func headers(depl *deployment.Deployment) map[string]string {
+	if depl.CSPNonce == nil {
+		return nil
+	}
+
+	// Only inline scripts and styles that carry the nonce of the deployment
+	// are allowed to run, along with those loaded from the same origin.
+	nonce := *depl.CSPNonce
+	return map[string]string{
+		"Content-Security-Policy": fmt.Sprintf("script-src 'self' 'nonce-%s'; style-src 'self' 'nonce-%s'", nonce, nonce),
+	}
+}
+
+// Snapshotted returns the meta of a deployment built from the project
+// settings snapshotted onto the deployment. The current settings of the
+// project are used for deployments that have no snapshot.
+func Snapshotted(proj *project.Project, depl *deployment.Deployment) (*Meta, error) {
+	settings, err := project.SnapshottedSettings(depl)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings == nil {
+		return New(proj, depl)
+	}
+	return NewWithSettings(settings, depl)
+}
+
+// Change is a difference between two metas.
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// Diff returns the fields that differ between two metas, sorted by field.
+func Diff(from, to *Meta) ([]*Change, error) {
+	fromFields, err := fields(from)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := fields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range fromFields {
+		names[name] = true
+	}
+	for name := range toFields {
+		names[name] = true
+	}
+
+	changes := []*Change{}
+	for name := range names {
+		if !reflect.DeepEqual(fromFields[name], toFields[name]) {
+			changes = append(changes, &Change{
+				Field: name,
+				From:  fromFields[name],
+				To:    toFields[name],
+			})
+		}
+	}
+	sort.Sort(byField(changes))
+
+	return changes, nil
+}
+
+// fields returns the fields of a meta as they appear in meta.json.
+func fields(m *Meta) (map[string]interface{}, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	f := map[string]interface{}{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type byField []*Change
+
+func (c byField) Len() int           { return len(c) }
+func (c byField) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byField) Less(i, j int) bool { return c[i].Field < c[j].Field }
+
+// Path returns the S3 key of the meta.json of a domain.
+func Path(domainName string) string {
+	return shared.DomainKey(domainName, "meta.json")
+}
+
+// SubpathPath returns the S3 key of the meta.json of a path on a domain that
+// is served separately from the rest of the domain, e.g. a deployment served
+// at "/foo-bar-express/a1b2-123" on the public base domain.
+func SubpathPath(domainName, subpath string) string {
+	return shared.DomainKey(domainName, "paths"+subpath+"/meta.json")
+}
+
+// VariantPath returns the path of the variant of an asset of a webroot in the
+// given encoding, e.g. "<webroot>/.variants/gz/index.html" for a gzipped
+// "index.html". Variants are kept apart from the assets, so that they cannot
+// collide with files of the bundle.
+func VariantPath(webroot, fileName, encoding string) string {
+	switch encoding {
+	case EncodingGzip:
+		return webroot + "/" + VariantsDir + "/gz/" + fileName
+	}
+	return webroot + "/" + fileName
+}
+
+// IsVariantPath returns whether a file of a bundle is in the directory that
+// variants are stored under, so it would collide with them.
+func IsVariantPath(fileName string) bool {
+	return fileName == VariantsDir || strings.HasPrefix(fileName, VariantsDir+"/")
+}
@@ -5,6 +5,24 @@ const (
 	Deploy = "deploy"
 	Build  = "build"
 	Push   = "push"
+
+	// DeployPriority is consumed by deployers reserved for high priority
+	// deploys, so that they do not wait behind bulk deploys.
+	DeployPriority = "deploy-priority"
+
+	// Deploy jobs that have to wait are held in these until their delay has
+	// passed, and then moved to the back of Deploy and DeployPriority.
+	DeployDelayed         = "deploy-delayed"
+	DeployPriorityDelayed = "deploy-priority-delayed"
+
+	// DeployRepairDelayed holds the jobs that repair failed uploads until they
+	// are retried. They are kept apart from the other delayed jobs as they
+	// are delayed for longer.
+	DeployRepairDelayed = "deploy-repair-delayed"
+
+	// InvalidationAck is bound to the edges exchange to collect edges'
+	// acknowledgements of the invalidations of deployments.
+	InvalidationAck = "invalidation-ack"
 )
 
 // make sure to add the queue here too so testhelper can clean it
@@ -12,4 +30,9 @@ var All = []string{
 	Deploy,
 	Build,
 	Push,
+	DeployPriority,
+	DeployDelayed,
+	DeployPriorityDelayed,
+	DeployRepairDelayed,
+	InvalidationAck,
 }
@@ -2,8 +2,10 @@ package s3client
 
 import (
 	"io"
+	"log"
 	"math"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
@@ -18,10 +20,39 @@ var (
 
 	MaxUploadParts = int(math.Ceil(float64(MaxUploadSize) / float64(PartSize)))
 
-	S3 filetransfer.FileTransfer = filetransfer.NewS3(PartSize, MaxUploadParts)
+	// Backend is the name of the file transfer backend that S3 is created
+	// with, set with FILE_TRANSFER_BACKEND. It is "s3" by default; "local"
+	// stores files on the local filesystem for development.
+	Backend = os.Getenv("FILE_TRANSFER_BACKEND")
+
+	S3 filetransfer.FileTransfer
+
+	// WebrootTargets are the buckets that webroots and meta files are deployed
+	// to. It is the bucket above, unless S3_WEBROOT_TARGETS is set to a
+	// comma-separated list of "region:bucket" pairs.
+	WebrootTargets []Target
 )
 
+// Target is a bucket in a region.
+type Target struct {
+	Region string
+	Bucket string
+}
+
 func init() {
+	filetransfer.Register("s3", func() (filetransfer.FileTransfer, error) {
+		return filetransfer.NewS3(PartSize, MaxUploadParts), nil
+	})
+
+	if Backend == "" {
+		Backend = "s3"
+	}
+
+	var err error
+	if S3, err = filetransfer.New(Backend); err != nil {
+		log.Fatalf("failed to create file transfer backend, err: %v, registered backends: %v", err, filetransfer.Backends())
+	}
+
 	if BucketRegion == "" {
 		BucketRegion = "us-west-2"
 	}
@@ -29,12 +60,41 @@ func init() {
 	if BucketName == "" {
 		BucketName = "rise-development-usw2"
 	}
+
+	WebrootTargets = parseTargets(os.Getenv("S3_WEBROOT_TARGETS"))
+	if len(WebrootTargets) == 0 {
+		WebrootTargets = []Target{{Region: BucketRegion, Bucket: BucketName}}
+	}
+}
+
+func parseTargets(s string) []Target {
+	var targets []Target
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+
+		parts := strings.SplitN(t, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Printf("ignoring invalid S3 target %q, expected region:bucket", t)
+			continue
+		}
+		targets = append(targets, Target{Region: parts[0], Bucket: parts[1]})
+	}
+	return targets
 }
 
 func Upload(path string, body io.Reader, contentType, acl string) error {
 	return S3.Upload(BucketRegion, BucketName, path, body, contentType, acl)
 }
 
+// UploadWithConcurrency uploads a file that may be large, uploading up to
+// concurrency parts of it at a time.
+func UploadWithConcurrency(path string, body io.Reader, contentType, acl string, concurrency int) error {
+	return S3.UploadWithConcurrency(BucketRegion, BucketName, path, body, contentType, acl, concurrency)
+}
+
 func Download(path string, out io.WriterAt) error {
 	return S3.Download(BucketRegion, BucketName, path, out)
 }
@@ -44,7 +104,7 @@ func Delete(path ...string) error {
 }
 
 func Copy(src, dest string) error {
-	return S3.Copy(BucketRegion, BucketName, src, dest)
+	return S3.Copy(BucketRegion, BucketName, src, dest, "private")
 }
 
 func Exists(path string) (bool, error) {
@@ -3,15 +3,66 @@ package shared
 import (
 	"os"
 	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 var (
 	DefaultDomain        = os.Getenv("DEFAULT_DOMAIN") // default domain (e.g. rise.cloud)
+	PublicBaseDomain     = publicBaseDomain()          // PUBLIC_BASE_DOMAIN - base domain of deployment preview URLs
 	MaxDomainsPerProject = 5                           // MAX_DOMAINS - max # of custom domains per project
+
+	OpaqueDeploymentPrefixes = opaqueDeploymentPrefixes() // OPAQUE_DEPLOYMENT_PREFIXES - leave deployment IDs out of preview URLs
+
+	S3KeyPrefix = s3KeyPrefix() // S3_KEY_PREFIX - prepended to the S3 keys of deployments and domains, so that environments can share a bucket
 )
 
+const defaultPublicBaseDomain = "pubstorm.site"
+
+func publicBaseDomain() string {
+	if d := os.Getenv("PUBLIC_BASE_DOMAIN"); d != "" {
+		return d
+	}
+	return defaultPublicBaseDomain
+}
+
+func opaqueDeploymentPrefixes() bool {
+	v := os.Getenv("OPAQUE_DEPLOYMENT_PREFIXES")
+	if v == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warn("Ignoring OPAQUE_DEPLOYMENT_PREFIXES, not a valid boolean value!")
+		return false
+	}
+	return b
+}
+
+// s3KeyPrefix returns the S3 key prefix with a trailing slash, or an empty
+// string if it is not set.
+func s3KeyPrefix() string {
+	p := strings.Trim(os.Getenv("S3_KEY_PREFIX"), "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+// DeploymentKey returns the S3 key of a file of a deployment, e.g.
+// "deployments/a1b2-123/webroot/index.html" for "webroot/index.html".
+func DeploymentKey(prefixID, name string) string {
+	return S3KeyPrefix + "deployments/" + prefixID + "/" + name
+}
+
+// DomainKey returns the S3 key of a file of a domain, e.g.
+// "domains/www.example.com/meta.json" for "meta.json".
+func DomainKey(domainName, name string) string {
+	return S3KeyPrefix + "domains/" + domainName + "/" + name
+}
+
 func init() {
 	if DefaultDomain == "" {
 		DefaultDomain = "risecloud.dev"
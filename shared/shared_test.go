@@ -0,0 +1,110 @@
+package shared
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "shared")
+}
+
+var _ = Describe("Shared", func() {
+	Describe("publicBaseDomain()", func() {
+		var origEnv string
+
+		BeforeEach(func() {
+			origEnv = os.Getenv("PUBLIC_BASE_DOMAIN")
+		})
+
+		AfterEach(func() {
+			os.Setenv("PUBLIC_BASE_DOMAIN", origEnv)
+		})
+
+		It("returns the configured base domain", func() {
+			os.Setenv("PUBLIC_BASE_DOMAIN", "preview.example.com")
+			Expect(publicBaseDomain()).To(Equal("preview.example.com"))
+		})
+
+		It("falls back to the default base domain when unset", func() {
+			os.Setenv("PUBLIC_BASE_DOMAIN", "")
+			Expect(publicBaseDomain()).To(Equal("pubstorm.site"))
+		})
+	})
+
+	Describe("opaqueDeploymentPrefixes()", func() {
+		var origEnv string
+
+		BeforeEach(func() {
+			origEnv = os.Getenv("OPAQUE_DEPLOYMENT_PREFIXES")
+		})
+
+		AfterEach(func() {
+			os.Setenv("OPAQUE_DEPLOYMENT_PREFIXES", origEnv)
+		})
+
+		It("returns true when enabled", func() {
+			os.Setenv("OPAQUE_DEPLOYMENT_PREFIXES", "true")
+			Expect(opaqueDeploymentPrefixes()).To(BeTrue())
+		})
+
+		It("returns false when unset or invalid", func() {
+			os.Setenv("OPAQUE_DEPLOYMENT_PREFIXES", "")
+			Expect(opaqueDeploymentPrefixes()).To(BeFalse())
+
+			os.Setenv("OPAQUE_DEPLOYMENT_PREFIXES", "sometimes")
+			Expect(opaqueDeploymentPrefixes()).To(BeFalse())
+		})
+	})
+
+	Describe("s3KeyPrefix()", func() {
+		var origEnv string
+
+		BeforeEach(func() {
+			origEnv = os.Getenv("S3_KEY_PREFIX")
+		})
+
+		AfterEach(func() {
+			os.Setenv("S3_KEY_PREFIX", origEnv)
+		})
+
+		It("returns the configured prefix with a trailing slash", func() {
+			os.Setenv("S3_KEY_PREFIX", "/staging/")
+			Expect(s3KeyPrefix()).To(Equal("staging/"))
+
+			os.Setenv("S3_KEY_PREFIX", "envs/staging")
+			Expect(s3KeyPrefix()).To(Equal("envs/staging/"))
+		})
+
+		It("returns an empty prefix when unset", func() {
+			os.Setenv("S3_KEY_PREFIX", "")
+			Expect(s3KeyPrefix()).To(Equal(""))
+		})
+	})
+
+	Describe("DeploymentKey() and DomainKey()", func() {
+		var origS3KeyPrefix string
+
+		BeforeEach(func() {
+			origS3KeyPrefix = S3KeyPrefix
+		})
+
+		AfterEach(func() {
+			S3KeyPrefix = origS3KeyPrefix
+		})
+
+		It("returns the keys under the S3 key prefix", func() {
+			S3KeyPrefix = ""
+			Expect(DeploymentKey("a1b2-123", "webroot/index.html")).To(Equal("deployments/a1b2-123/webroot/index.html"))
+			Expect(DomainKey("www.example.com", "meta.json")).To(Equal("domains/www.example.com/meta.json"))
+
+			S3KeyPrefix = "staging/"
+			Expect(DeploymentKey("a1b2-123", "raw-bundle.tar.gz")).To(Equal("staging/deployments/a1b2-123/raw-bundle.tar.gz"))
+			Expect(DomainKey("www.example.com", "meta.json")).To(Equal("staging/domains/www.example.com/meta.json"))
+		})
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	. "github.com/onsi/gomega"
 )
@@ -25,3 +26,18 @@ func AuthTrio(db *gorm.DB) (u *user.User, oc *oauthclient.OauthClient, t *oautht
 
 	return u, oc, t
 }
+
+func DeployToken(db *gorm.DB, t *oauthtoken.OauthToken, proj *project.Project) *oauthtoken.OauthToken {
+	scope := oauthtoken.ScopeDeploy
+	dt := &oauthtoken.OauthToken{
+		UserID:        t.UserID,
+		OauthClientID: t.OauthClientID,
+		ProjectID:     &proj.ID,
+		Scope:         &scope,
+	}
+
+	err := db.Create(dt).Error
+	Expect(err).To(BeNil())
+
+	return dt
+}
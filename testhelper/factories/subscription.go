@@ -0,0 +1,25 @@
+package factories
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/subscription"
+
+	. "github.com/onsi/gomega"
+)
+
+func Subscription(db *gorm.DB, proj *project.Project, eventType, targetURL string) *subscription.Subscription {
+	if proj == nil {
+		proj = Project(db, nil)
+	}
+
+	sub := &subscription.Subscription{
+		ProjectID: proj.ID,
+		UserID:    proj.UserID,
+		EventType: eventType,
+		TargetURL: targetURL,
+	}
+	Expect(db.Create(sub).Error).To(BeNil())
+
+	return sub
+}
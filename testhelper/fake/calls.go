@@ -1,5 +1,7 @@
 package fake
 
+import "sync"
+
 type List []interface{}
 type Map map[string]interface{}
 
@@ -10,10 +12,14 @@ type Call struct {
 }
 
 type Calls struct {
+	mu    sync.Mutex
 	calls []Call
 }
 
 func (c *Calls) Add(arguments, returnValues List, sideEffects Map) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.calls = append(c.calls, Call{
 		Arguments:    arguments,
 		ReturnValues: returnValues,
@@ -22,10 +28,16 @@ func (c *Calls) Add(arguments, returnValues List, sideEffects Map) {
 }
 
 func (c *Calls) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return len(c.calls)
 }
 
 func (c *Calls) NthCall(n int) *Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if n > 0 && n <= len(c.calls) {
 		return &c.calls[n-1]
 	}
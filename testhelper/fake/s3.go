@@ -1,8 +1,13 @@
 package fake
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,6 +18,9 @@ type S3 struct {
 	DeleteAllCalls    Calls
 	CopyCalls         Calls
 	ExistsCalls       Calls
+	ETagCalls         Calls
+	SizeCalls         Calls
+	ListCalls         Calls
 	PresignedURLCalls Calls
 
 	UploadError       error
@@ -21,36 +29,121 @@ type S3 struct {
 	DeleteAllError    error
 	CopyError         error
 	ExistsError       error
+	ETagError         error
+	SizeError         error
+	ListError         error
 	PresignedURLError error
 
+	// UploadErrors, keyed by bucket, makes uploads to specific buckets fail.
+	UploadErrors map[string]error
+
+	// UploadKeyErrors, keyed by key, makes successive uploads to specific keys
+	// fail with the listed errors in order, after which they succeed.
+	UploadKeyErrors map[string][]error
+
+	// DownloadErrors makes successive downloads fail with the listed errors in
+	// order, after which they succeed.
+	DownloadErrors []error
+
+	// If FailUploadsAfter is non-zero, uploads fail with UploadErrorAfterLimit
+	// once that many uploads have succeeded.
+	FailUploadsAfter      int
+	UploadErrorAfterLimit error
+
+	mu              sync.Mutex
+	uploadSucceeded int
+
+	uploading    int
+	maxUploading int
+
+	downloading    int
+	maxDownloading int
+
 	ExistsReturn       bool
 	PresignedURLReturn string
 
+	// ExistingKeys, if set, overrides ExistsReturn with whether each key is in
+	// the map.
+	ExistingKeys map[string]bool
+
 	UploadTimeout time.Duration
+	// UploadReadDelay simulates an upload that only reads the body after a
+	// while.
+	UploadReadDelay time.Duration
 
 	DownloadContent []byte
+	// DownloadDelay simulates slow downloading.
+	DownloadDelay time.Duration
+}
+
+func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl string) error {
+	return s.UploadWithMetadata(region, bucket, key, body, contentType, acl, nil)
+}
+
+func (s *S3) UploadWithMetadata(region, bucket, key string, body io.Reader, contentType, acl string, metadata map[string]string) error {
+	return s.upload(region, bucket, key, body, contentType, acl, metadata, 0)
+}
+
+// UploadWithConcurrency records concurrency in the "concurrency" side effect
+// of the upload call.
+func (s *S3) UploadWithConcurrency(region, bucket, key string, body io.Reader, contentType, acl string, concurrency int) error {
+	return s.upload(region, bucket, key, body, contentType, acl, nil, concurrency)
 }
 
-func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl string) (err error) {
+func (s *S3) upload(region, bucket, key string, body io.Reader, contentType, acl string, metadata map[string]string, concurrency int) (err error) {
 	var content []byte
 
-	if s.UploadError == nil {
+	s.mu.Lock()
+	s.uploading++
+	if s.uploading > s.maxUploading {
+		s.maxUploading = s.uploading
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.uploading--
+		s.mu.Unlock()
+	}()
+
+	uploadError := s.UploadError
+	if uploadError == nil {
+		uploadError = s.UploadErrors[bucket]
+	}
+
+	s.mu.Lock()
+	if uploadError == nil && len(s.UploadKeyErrors[key]) > 0 {
+		uploadError = s.UploadKeyErrors[key][0]
+		s.UploadKeyErrors[key] = s.UploadKeyErrors[key][1:]
+	}
+	if uploadError == nil && s.FailUploadsAfter > 0 && s.uploadSucceeded >= s.FailUploadsAfter {
+		uploadError = s.UploadErrorAfterLimit
+	}
+	if uploadError == nil {
+		s.uploadSucceeded++
+	}
+	s.mu.Unlock()
+
+	if uploadError == nil {
+		time.Sleep(s.UploadReadDelay)
+
 		// If io.Reader is from file, the position could be the middle of file content.
 		// To make sure it reads all content from the file, we need to change the position to the beginning of the file.
-		seeker, ok := body.(io.Seeker)
-		if ok {
-			if _, err := seeker.Seek(0, 0); err != nil {
-				return err
-			}
+		if seeker, ok := body.(io.Seeker); ok {
+			_, err = seeker.Seek(0, 0)
 		}
 
-		content, err = ioutil.ReadAll(body)
+		if err == nil {
+			content, err = ioutil.ReadAll(body)
+		}
 	} else {
-		err = s.UploadError
+		err = uploadError
 	}
 
 	s.UploadCalls.Add(List{region, bucket, key, body, contentType, acl}, List{err}, Map{
 		"uploaded_content": content,
+		"metadata":         metadata,
+		"concurrency":      concurrency,
 	})
 
 	// This is to simulate slow uploading.
@@ -60,10 +153,33 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 }
 
 func (s *S3) Download(region, bucket, key string, out io.WriterAt) (err error) {
-	if s.DownloadError == nil {
+	s.mu.Lock()
+	s.downloading++
+	if s.downloading > s.maxDownloading {
+		s.maxDownloading = s.downloading
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.downloading--
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(s.DownloadDelay)
+
+	downloadError := s.DownloadError
+	s.mu.Lock()
+	if downloadError == nil && len(s.DownloadErrors) > 0 {
+		downloadError = s.DownloadErrors[0]
+		s.DownloadErrors = s.DownloadErrors[1:]
+	}
+	s.mu.Unlock()
+
+	if downloadError == nil {
 		_, err = out.WriteAt(s.DownloadContent, 0)
 	} else {
-		err = s.DownloadError
+		err = downloadError
 	}
 
 	s.DownloadCalls.Add(List{region, bucket, key, out}, List{err}, nil)
@@ -90,9 +206,9 @@ func (s *S3) DeleteAll(region, bucket, prefix string) error {
 	return err
 }
 
-func (s *S3) Copy(region, bucket, srcKey, destKey string) error {
+func (s *S3) Copy(region, bucket, srcKey, destKey, acl string) error {
 	err := s.CopyError
-	argList := List{region, bucket, srcKey, destKey}
+	argList := List{region, bucket, srcKey, destKey, acl}
 
 	s.CopyCalls.Add(argList, List{err}, nil)
 	return err
@@ -110,6 +226,81 @@ func (s *S3) Exists(region, bucket, key string) (bool, error) {
 	err := s.ExistsError
 	argList := List{region, bucket, key}
 
-	s.ExistsCalls.Add(argList, List{s.ExistsReturn, err}, nil)
-	return s.ExistsReturn, err
+	exists := s.ExistsReturn
+	if s.ExistingKeys != nil {
+		exists = s.ExistingKeys[key]
+	}
+
+	s.ExistsCalls.Add(argList, List{exists, err}, nil)
+	return exists, err
+}
+
+// ETag returns the MD5 checksum of the content last successfully uploaded to
+// the key, like S3 does for objects uploaded in a single part.
+func (s *S3) ETag(region, bucket, key string) (string, error) {
+	var etag string
+
+	err := s.ETagError
+	if err == nil {
+		for i := s.UploadCalls.Count(); i > 0; i-- {
+			call := s.UploadCalls.NthCall(i)
+			if call.Arguments[1] == bucket && call.Arguments[2] == key && call.ReturnValues[0] == nil {
+				content, _ := call.SideEffects["uploaded_content"].([]byte)
+				sum := md5.Sum(content)
+				etag = hex.EncodeToString(sum[:])
+				break
+			}
+		}
+	}
+
+	s.ETagCalls.Add(List{region, bucket, key}, List{etag, err}, nil)
+	return etag, err
+}
+
+// MaxConcurrentUploads returns the largest number of uploads that were in
+// progress at the same time.
+func (s *S3) MaxConcurrentUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxUploading
+}
+
+// MaxConcurrentDownloads returns the largest number of downloads that were
+// in progress at the same time.
+func (s *S3) MaxConcurrentDownloads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxDownloading
+}
+
+// Size returns the size of DownloadContent, which is what Download writes.
+func (s *S3) Size(region, bucket, key string) (int64, error) {
+	err := s.SizeError
+	size := int64(len(s.DownloadContent))
+
+	s.SizeCalls.Add(List{region, bucket, key}, List{size, err}, nil)
+	return size, err
+}
+
+// List returns the keys in the bucket that content was successfully uploaded
+// to and that begin with prefix, in lexicographical order.
+func (s *S3) List(region, bucket, prefix string) ([]string, error) {
+	var keys []string
+
+	err := s.ListError
+	if err == nil {
+		seen := map[string]bool{}
+		for i := 1; i <= s.UploadCalls.Count(); i++ {
+			call := s.UploadCalls.NthCall(i)
+			key, _ := call.Arguments[2].(string)
+			if call.Arguments[1] == bucket && strings.HasPrefix(key, prefix) && call.ReturnValues[0] == nil && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+	}
+
+	s.ListCalls.Add(List{region, bucket, prefix}, List{keys, err}, nil)
+	return keys, err
 }